@@ -3,23 +3,51 @@
 // @description This is a sample server for a WebSocket chat application.
 // @host localhost:8080
 // @BasePath /
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"log/slog"
 	"net/http"
+	"new-websocket-chat/internal/blob"
+	blobFactory "new-websocket-chat/internal/blob/factory"
+	"new-websocket-chat/internal/blob/janitor"
+	brokerFactory "new-websocket-chat/internal/broker/factory"
 	"new-websocket-chat/internal/config"
+	"new-websocket-chat/internal/http_server/handlers/attachment/download"
+	"new-websocket-chat/internal/http_server/handlers/attachment/upload"
+	"new-websocket-chat/internal/http_server/handlers/auth/login"
+	"new-websocket-chat/internal/http_server/handlers/auth/logout"
 	refresh "new-websocket-chat/internal/http_server/handlers/jwt"
+	"new-websocket-chat/internal/http_server/handlers/message/search"
+	"new-websocket-chat/internal/http_server/handlers/room/edits"
+	"new-websocket-chat/internal/http_server/handlers/room/history"
+	"new-websocket-chat/internal/http_server/handlers/room/thread"
+	"new-websocket-chat/internal/http_server/handlers/room/unread"
 	"new-websocket-chat/internal/http_server/handlers/user/delete"
+	"new-websocket-chat/internal/http_server/handlers/user/mentions"
+	"new-websocket-chat/internal/http_server/handlers/user/presence"
 	"new-websocket-chat/internal/http_server/handlers/user/save"
 	mwLogger "new-websocket-chat/internal/http_server/middleware/logger"
+	mwRateLimit "new-websocket-chat/internal/http_server/middleware/ratelimit"
 	jwt "new-websocket-chat/internal/lib/jwt"
 	jwtAuth "new-websocket-chat/internal/lib/jwt/middleware"
 	"new-websocket-chat/internal/lib/logger/sl"
-	"new-websocket-chat/internal/storage/postgres"
+	"new-websocket-chat/internal/lib/ratelimit"
+	rateLimitFactory "new-websocket-chat/internal/lib/ratelimit/factory"
+	"new-websocket-chat/internal/storage"
+	"new-websocket-chat/internal/storage/factory"
 	ws "new-websocket-chat/internal/websocket/handlers"
 	_ "new-websocket-chat/docs"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	httpSwagger "github.com/swaggo/http-swagger"
 	"github.com/go-chi/chi/v5"
@@ -38,13 +66,157 @@ func main() {
 	log.Info("starting websocket-chat", slog.String("env", cfg.Env))
 	log.Debug("debug messages are enabled")
 
-	storage, err := postgres.New(cfg.User, cfg.Password, cfg.DBname, cfg.Hostname, cfg.Port)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(log, cfg.Database, os.Args[2:]); err != nil {
+			log.Error("failed to migrate", sl.Err(err))
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "moderator" {
+		if err := runModerator(log, cfg.Database, os.Args[2:]); err != nil {
+			log.Error("failed to change room moderator", sl.Err(err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	storage, err := factory.New(cfg.Database)
 	if err != nil {
 		log.Error("failed to init storage", sl.Err(err))
 		os.Exit(1)
 	}
-	_ = storage
 
+	blobs, err := blobFactory.New(cfg.Attachments)
+	if err != nil {
+		log.Error("failed to init blob store", sl.Err(err))
+		os.Exit(1)
+	}
+
+	limits, err := rateLimitFactory.New(cfg.AuthLimits)
+	if err != nil {
+		log.Error("failed to init rate limit store", sl.Err(err))
+		os.Exit(1)
+	}
+
+	broker, err := brokerFactory.New(log, cfg.Broker)
+	if err != nil {
+		log.Error("failed to init broker", sl.Err(err))
+		os.Exit(1)
+	}
+
+	jwtAuthService := jwt.NewJWTAuthService(storage)
+
+	policies := ws.SlowConsumerPolicies{
+		Message: ws.SlowPolicy(cfg.SlowMessagePolicy),
+		Event:   ws.SlowPolicy(cfg.SlowEventPolicy),
+		Typing:  ws.SlowPolicy(cfg.SlowTypingPolicy),
+	}
+	if err := policies.Validate(); err != nil {
+		log.Error("invalid websocket config", sl.Err(err))
+		os.Exit(1)
+	}
+
+	hub := ws.NewHub(log, storage, blobs, ws.RateLimits{
+		Connection:      ratelimit.Limit{Rate: cfg.MessageRate, Burst: cfg.MessageBurst},
+		User:            ratelimit.Limit{Rate: cfg.UserMessageRate, Burst: cfg.UserMessageBurst},
+		MaxViolations:   cfg.MaxRateViolations,
+		ViolationWindow: cfg.RateViolationWindow,
+	}, policies, broker)
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
+
+	expvar.Publish("websocket", expvar.Func(func() any { return hub.Metrics() }))
+
+	log.Info("websocket hub was created", slog.Any("hub: ", hub))
+
+	router := newRouter(log, cfg, storage, blobs, limits, jwtAuthService, hub)
+
+	log.Info("starting server", slog.String("address", cfg.Address))
+
+	srv := &http.Server{
+		Addr:         cfg.Address,
+		Handler:      router,
+		ReadTimeout:  cfg.HttpServer.Timeout,
+		WriteTimeout: cfg.HttpServer.Timeout,
+		IdleTimeout:  cfg.HttpServer.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go janitor.New(log, storage, blobs, cfg.Attachments.UnattachedTTL).Run(ctx, cfg.Attachments.SweepInterval)
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("failed to start server", sl.Err(err))
+			stop()
+		}
+	}()
+
+	// the metrics have their own listener, so that they aren't exposed with the API
+	var metricsSrv *http.Server
+	if cfg.MetricsAddress != "" {
+		log.Info("starting metrics server", slog.String("address", cfg.MetricsAddress))
+
+		metrics := http.NewServeMux()
+		metrics.Handle("/debug/vars", expvar.Handler())
+		metricsSrv = &http.Server{Addr: cfg.MetricsAddress, Handler: metrics, ReadTimeout: cfg.HttpServer.Timeout}
+
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error("failed to start metrics server", sl.Err(err))
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	log.Info("stopping server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HttpServer.ShutdownTimeout)
+	defer cancel()
+
+	// websocket connections are hijacked, so Shutdown waits only for regular requests
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Error("failed to stop server gracefully", sl.Err(err))
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+			log.Error("failed to stop metrics server", sl.Err(err))
+		}
+	}
+
+	stopHub()
+	if err := hub.Wait(shutdownCtx); err != nil {
+		log.Error("failed to drain websocket clients", sl.Err(err))
+	}
+
+	if err := broker.Close(); err != nil {
+		log.Error("failed to close broker", sl.Err(err))
+	}
+
+	if err := storage.Close(); err != nil {
+		log.Error("failed to close storage", sl.Err(err))
+	}
+
+	log.Info("server stopped")
+}
+
+// userIDByLogin resolves the login of the account rate limit to the ID of the user.
+func userIDByLogin(storage storage.Storage) func(login string) (int64, bool) {
+	return func(login string) (int64, bool) {
+		user, err := storage.GetUserByLogin(login)
+		if err != nil {
+			return 0, false
+		}
+
+		return user.ID, true
+	}
+}
+
+// newRouter registers every handler of the server.
+func newRouter(log *slog.Logger, cfg config.Config, storage storage.Storage, blobs blob.Store, limits ratelimit.Store, jwtAuthService *jwt.JWTAuthService, hub *ws.Hub) http.Handler {
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
 	router.Use(middleware.Logger)
@@ -52,43 +224,76 @@ func main() {
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.URLFormat)
 
-	jwtAuthService := &jwt.JWTAuthService{} // Add error handling if not initialized
-
-	hub := ws.NewHub()
-	go hub.Run()
+	router.Get("/swagger/*", httpSwagger.Handler(
+		httpSwagger.URL("http://localhost:8080/swagger/doc.json"), // The url pointing to API definition
+	))
 
-	log.Info("websocket hub was created", slog.Any("hub: ", hub))
+	// the auth endpoints share the limit of an IP address, every account has its own limit on each of them
+	ipLimit := ratelimit.Limit{Rate: cfg.IPRate, Burst: cfg.IPBurst}
+	accountLimit := ratelimit.Limit{Rate: cfg.AccountRate, Burst: cfg.AccountBurst}
+	byIP := mwRateLimit.New(log, limits, "auth_ip", ipLimit, mwRateLimit.ByIP)
+	loginGuard := ratelimit.NewGuard(limits, ratelimit.Lockout{
+		Threshold: cfg.LockoutThreshold,
+		Base:      cfg.LockoutBase,
+		Max:       cfg.LockoutMax,
+		Window:    cfg.LockoutWindow,
+	})
 
-	router.Get("/swagger/*", httpSwagger.Handler(
-        httpSwagger.URL("http://localhost:8080/swagger/doc.json"), // The url pointing to API definition
-    ))
-	router.Post("/user", save.New(log, storage))
-	router.Post("/api/jwt/refresh", refresh.New(log, jwtAuthService))
+	router.With(byIP, mwRateLimit.New(log, limits, "register", accountLimit, mwRateLimit.ByJSONField("username"))).
+		Post("/user", save.New(log, storage, jwtAuthService))
+	router.With(byIP, mwRateLimit.New(log, limits, "login", accountLimit, mwRateLimit.ByAccount(mwRateLimit.JSONField("login"), userIDByLogin(storage)))).
+		Post("/auth/login", login.New(log, storage, jwtAuthService, loginGuard))
+	router.With(byIP).Post("/auth/logout", logout.New(log, jwtAuthService))
+	router.With(byIP, mwRateLimit.New(log, limits, "refresh", accountLimit, mwRateLimit.ByAccount(refreshSubject, userIDBySubject))).
+		Post("/api/jwt/refresh", refresh.New(log, jwtAuthService))
 	router.Delete("/user/delete", delete.New(log, storage))
 	router.Group(func(r chi.Router) {
 		r.Use(jwtAuth.TokenAuthMiddleware)
 		r.HandleFunc("/ws", ws.ServeWs(log, hub))
+		r.Get("/rooms/{room}/messages", history.New(log, storage))
+		r.Get("/rooms/{room}/messages/{id}/edits", edits.New(log, storage))
+		r.Get("/rooms/{room}/messages/{id}/replies", thread.New(log, storage))
+		r.Get("/rooms/unread", unread.New(log, storage))
+		r.Post("/rooms/{room}/attachments", upload.New(log, storage, blobs, cfg.Attachments.MaxSize))
+		r.Get("/attachments/{id}", download.New(log, storage, blobs))
+		r.Get("/attachments/{id}/thumbnail", download.NewThumbnail(log, storage, blobs))
+		r.Get("/messages/search", search.New(log, storage))
+		r.Post("/auth/logout/all", logout.NewAll(log, jwtAuthService, hub))
+		r.Get("/users/presence", presence.New(log, hub, storage))
+		r.Get("/users/me/mentions", mentions.New(log, storage))
 	})
 	//router.Group(func(r chi.Router) {
 	//	r.Use(jwtAuth.TokenAuthMiddleware)
 	//	// r.Get("/Auth", auth.New(log, storage))
 	//})
 
-	log.Info("starting server", slog.String("address", cfg.Address))
+	return router
+}
 
-	srv := &http.Server{
-		Addr:         cfg.Address,
-		Handler:      router,
-		ReadTimeout:  cfg.HttpServer.Timeout,
-		WriteTimeout: cfg.HttpServer.Timeout,
-		IdleTimeout:  cfg.HttpServer.IdleTimeout,
+// refreshSubject returns the user ID the refresh token of the request was issued to, empty if the token isn't valid,
+// so the requests with tokens that can't be parsed are limited by the IP address only.
+func refreshSubject(r *http.Request) string {
+	token, err := jwt.ExtractToken(r)
+	if err != nil {
+		return ""
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
-		log.Error("failed to start server")
+	claims, err := jwt.ValidateToken(token)
+	if err != nil || claims.TokenType != jwt.TokenTypeRefresh {
+		return ""
+	}
+
+	return claims.Subject
+}
+
+// userIDBySubject resolves the subject of a refresh token to the ID of the user.
+func userIDBySubject(subject string) (int64, bool) {
+	userID, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil || userID <= 0 {
+		return 0, false
 	}
 
-	log.Error("server stopped") // we shouldn't reach this point
+	return userID, true
 }
 
 func setupLogger(env string) *slog.Logger {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/blob/local"
+	brokerMemory "new-websocket-chat/internal/broker/memory"
+	"new-websocket-chat/internal/config"
+	"new-websocket-chat/internal/http_server/handlers/attachment/upload"
+	"new-websocket-chat/internal/http_server/handlers/room/history"
+	"new-websocket-chat/internal/http_server/handlers/user/save"
+	jwt "new-websocket-chat/internal/lib/jwt"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/lib/ratelimit"
+	"new-websocket-chat/internal/storage/memory"
+	ws "new-websocket-chat/internal/websocket/handlers"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServer runs the whole server on the in-memory storage.
+func TestServer(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New()
+	blobs, err := local.New(t.TempDir())
+	require.NoError(t, err)
+
+	hub := ws.NewHub(log, storage, blobs, ws.RateLimits{}, ws.SlowConsumerPolicies{}, brokerMemory.New())
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go hub.Run(ctx)
+
+	cfg := config.Config{Attachments: config.Attachments{MaxSize: 1 << 20}}
+	server := httptest.NewServer(newRouter(log, cfg, storage, blobs, ratelimit.NewMemoryStore(), jwt.NewJWTAuthService(storage), hub))
+	defer server.Close()
+
+	var registered save.Response
+	postJSON(t, server.URL+"/user", `{"username": "alice", "email": "alice@example.com", "password": "password!"}`, &registered)
+	require.Equal(t, "OK", registered.Status, registered.Error)
+	require.NotEmpty(t, registered.JWTAccessToken)
+
+	header := http.Header{"Authorization": {"Bearer " + registered.JWTAccessToken}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "join", "room": "general"}`)))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "message", "room": "general", "payload": {"text": "hello"}}`)))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	var env ws.Envelope
+	require.NoError(t, conn.ReadJSON(&env))
+	require.Equal(t, ws.TypeMessage, env.Type)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/rooms/general/messages", nil)
+	require.NoError(t, err)
+	req.Header = header
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	var messages history.Response
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&messages))
+	require.Len(t, messages.Messages, 1)
+	require.Equal(t, "hello", messages.Messages[0].Body)
+
+	// files are uploaded to a joined room and downloaded by its members
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "notes.txt")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("remember the milk"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req, err = http.NewRequest(http.MethodPost, server.URL+"/rooms/general/attachments", &body)
+	require.NoError(t, err)
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	var uploaded upload.Response
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&uploaded))
+	require.Equal(t, "OK", uploaded.Status, uploaded.Error)
+
+	req, err = http.NewRequest(http.MethodGet, server.URL+"/attachments/"+strconv.FormatInt(uploaded.Attachment.ID, 10), nil)
+	require.NoError(t, err)
+	req.Header = header
+
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	contents, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, "remember the milk", string(contents))
+}
+
+func postJSON(t *testing.T, url string, body string, v any) {
+	res, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.NoError(t, json.NewDecoder(res.Body).Decode(v))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"new-websocket-chat/internal/config"
+	"new-websocket-chat/internal/storage/factory"
+	"new-websocket-chat/internal/storage/postgres"
+	"strconv"
+)
+
+const migrateUsage = "usage: websocket-chat migrate up [n] | down [n] | version"
+
+// runMigrate handles `websocket-chat migrate ...`. Without n, up applies every pending migration
+// and down reverts only the latest one, so a typo can't drop the whole schema.
+func runMigrate(log *slog.Logger, cfg config.Database, args []string) error {
+	if len(args) == 0 || len(args) > 2 || (args[0] == "version" && len(args) != 1) {
+		return errors.New(migrateUsage)
+	}
+	if args[0] != "up" && args[0] != "down" && args[0] != "version" {
+		return errors.New(migrateUsage)
+	}
+
+	steps := 0
+	if args[0] == "down" {
+		steps = 1
+	}
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of migrations %q: %s", args[1], migrateUsage)
+		}
+		steps = n
+	}
+
+	if cfg.Driver != factory.DriverPostgres {
+		return fmt.Errorf("migrations are only supported by the postgres driver, got %q", cfg.Driver)
+	}
+
+	storage, err := postgres.New(cfg.User, cfg.Password, cfg.DBname, cfg.Hostname, cfg.Port)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := storage.MigrateUp(steps)
+		log.Info("migrations applied", slog.Any("versions", applied))
+		if err != nil {
+			return err
+		}
+	case "down":
+		reverted, err := storage.MigrateDown(steps)
+		log.Info("migrations reverted", slog.Any("versions", reverted))
+		if err != nil {
+			return err
+		}
+	case "version":
+		version, err := storage.Version()
+		if err != nil {
+			return err
+		}
+		log.Info("schema version", slog.Int("version", version))
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"new-websocket-chat/internal/config"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunMigrateArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "No command", args: nil},
+		{name: "Unknown command", args: []string{"sideways"}},
+		{name: "Invalid steps", args: []string{"up", "many"}},
+		{name: "Negative steps", args: []string{"down", "-1"}},
+		{name: "Version with steps", args: []string{"version", "1"}},
+		{name: "Too many arguments", args: []string{"up", "1", "2"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			// the arguments are checked before connecting, so the unreachable database is never used
+			cfg := config.Database{Driver: "postgres", Hostname: "invalid.invalid"}
+			require.ErrorContains(t, runMigrate(slogdiscard.NewDiscardLogger(), cfg, test.args), migrateUsage)
+		})
+	}
+}
+
+func TestRunMigrateMemoryDriver(t *testing.T) {
+	err := runMigrate(slogdiscard.NewDiscardLogger(), config.Database{Driver: "memory"}, []string{"up"})
+	require.ErrorContains(t, err, "only supported by the postgres driver")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"new-websocket-chat/internal/config"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/storage/factory"
+	"new-websocket-chat/internal/storage/postgres"
+)
+
+const moderatorUsage = "usage: websocket-chat moderator grant <room> <username> | revoke <room>"
+
+// runModerator handles `websocket-chat moderator ...`. A room has at most one moderator, granting the room
+// to another user replaces the previous one.
+func runModerator(log *slog.Logger, cfg config.Database, args []string) error {
+	if len(args) == 0 || (args[0] == "grant" && len(args) != 3) || (args[0] == "revoke" && len(args) != 2) {
+		return errors.New(moderatorUsage)
+	}
+	if args[0] != "grant" && args[0] != "revoke" {
+		return errors.New(moderatorUsage)
+	}
+
+	room := args[1]
+	if room == "" || len(room) > 64 {
+		return fmt.Errorf("invalid room %q: %s", room, moderatorUsage)
+	}
+	if dm.IsDirect(room) {
+		return fmt.Errorf("direct rooms have no moderator, got %q", room)
+	}
+
+	// the memory driver keeps its data in the server process, out of reach of this command
+	if cfg.Driver != factory.DriverPostgres {
+		return fmt.Errorf("moderators can only be granted with the postgres driver, got %q", cfg.Driver)
+	}
+
+	storage, err := postgres.New(cfg.User, cfg.Password, cfg.DBname, cfg.Hostname, cfg.Port)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	switch args[0] {
+	case "grant":
+		username := args[2]
+		ids, err := storage.GetUserIDs([]string{username})
+		if err != nil {
+			return err
+		}
+		userID, ok := ids[username]
+		if !ok {
+			return fmt.Errorf("user %q is not found", username)
+		}
+
+		if err := storage.SetRoomModerator(room, userID); err != nil {
+			return err
+		}
+		log.Info("room moderator granted", slog.String("room", room), slog.String("username", username))
+	case "revoke":
+		if err := storage.RemoveRoomModerator(room); err != nil {
+			return err
+		}
+		log.Info("room moderator revoked", slog.String("room", room))
+	}
+
+	return nil
+}
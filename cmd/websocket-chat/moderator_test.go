@@ -0,0 +1,38 @@
+package main
+
+import (
+	"new-websocket-chat/internal/config"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunModeratorArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{name: "No command", args: nil, err: moderatorUsage},
+		{name: "Unknown command", args: []string{"promote", "general", "alice"}, err: moderatorUsage},
+		{name: "Grant without user", args: []string{"grant", "general"}, err: moderatorUsage},
+		{name: "Revoke with user", args: []string{"revoke", "general", "alice"}, err: moderatorUsage},
+		{name: "Empty room", args: []string{"grant", "", "alice"}, err: moderatorUsage},
+		{name: "Direct room", args: []string{"grant", "dm:1:2", "alice"}, err: "direct rooms have no moderator"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			// the arguments are checked before connecting, so the unreachable database is never used
+			cfg := config.Database{Driver: "postgres", Hostname: "invalid.invalid"}
+			require.ErrorContains(t, runModerator(slogdiscard.NewDiscardLogger(), cfg, test.args), test.err)
+		})
+	}
+}
+
+func TestRunModeratorMemoryDriver(t *testing.T) {
+	err := runModerator(slogdiscard.NewDiscardLogger(), config.Database{Driver: "memory"}, []string{"grant", "general", "alice"})
+	require.ErrorContains(t, err, "only be granted with the postgres driver")
+}
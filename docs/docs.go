@@ -17,7 +17,7 @@ const docTemplate = `{
     "paths": {
         "/api/jwt/refresh": {
             "post": {
-                "description": "Refreshes the JWT access and refresh tokens for a user.",
+                "description": "Refreshes the JWT access and refresh tokens for a user. The presented refresh token is revoked,\npresenting it again revokes every token issued since the login.",
                 "consumes": [
                     "application/json"
                 ],
@@ -50,6 +50,157 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "429": {
+                        "description": "Too many requests, retry after the Retry-After header",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/attachments/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the contents of an attachment. Attachments can be downloaded by the users who joined their room, attachments of direct rooms only by the two users of the room.\nErrors are returned as JSON like with the other endpoints.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "attachment"
+                ],
+                "summary": "Download attachment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Attachment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Contents of the attachment",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/new-websocket-chat_internal_lib_api_response.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/attachments/{id}/thumbnail": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the thumbnail of an image attachment, at most 256x256 pixels: JPEG for JPEG images, PNG otherwise. Only attachments with thumbnail set to true have one.",
+                "produces": [
+                    "image/jpeg",
+                    "image/png"
+                ],
+                "tags": [
+                    "attachment"
+                ],
+                "summary": "Download attachment thumbnail",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Attachment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Thumbnail of the attachment",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/new-websocket-chat_internal_lib_api_response.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/auth/login": {
+            "post": {
+                "description": "Verifies the password of the user found by username or email and issues a new pair of JWT tokens.\nLogins failing too many times in a row are locked out for a time doubling with every further failure.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Log in",
+                "parameters": [
+                    {
+                        "description": "User Credentials",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_auth_login.Request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully logged in and generated JWT tokens",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_auth_login.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_auth_login.Response"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many failed logins, retry after the Retry-After header",
+                        "schema": {
+                            "$ref": "#/definitions/new-websocket-chat_internal_lib_api_response.Response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -59,6 +210,429 @@ const docTemplate = `{
                 }
             }
         },
+        "/auth/logout": {
+            "post": {
+                "description": "Ends the session of the presented refresh token, every refresh token issued since the login is revoked.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Log out",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Refresh Token",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully logged out",
+                        "schema": {
+                            "$ref": "#/definitions/new-websocket-chat_internal_lib_api_response.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid refresh token",
+                        "schema": {
+                            "$ref": "#/definitions/new-websocket-chat_internal_lib_api_response.Response"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests, retry after the Retry-After header",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/auth/logout/all": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revokes every refresh token of the user and closes all of the user's websocket connections. Issued access tokens stay valid until they expire.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Log out of all devices",
+                "responses": {
+                    "200": {
+                        "description": "Successfully logged out of all devices",
+                        "schema": {
+                            "$ref": "#/definitions/new-websocket-chat_internal_lib_api_response.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/search": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the messages containing every word of the query, newest first. Pass nextCursor of the previous page as before to load older results.\nOnly the rooms the user joined at least once and the direct rooms of the user are searched, deleted messages are never returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "message"
+                ],
+                "summary": "Search messages",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Words to search for, 200 characters at most",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Search only this room",
+                        "name": "room",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Search only the messages of this user",
+                        "name": "sender",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Search messages created at or after this time (RFC 3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Search messages created before this time (RFC 3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Return messages with ID less than this one",
+                        "name": "before",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 20 by default, 100 at most",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Page of the matching messages",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_message_search.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_message_search.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/rooms/unread": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the number of messages of other users after the last read message, for every room the user received or read messages in.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "room"
+                ],
+                "summary": "Unread counts",
+                "responses": {
+                    "200": {
+                        "description": "Unread counts of the rooms",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_room_unread.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_room_unread.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/rooms/{room}/attachments": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Uploads a file to a room the user joined, or to a direct room of the user, as the multipart form field \"file\".\nThe type is detected from the contents: JPEG, PNG, GIF and WebP images, PDF, ZIP and plain text files are allowed. JPEG, PNG and GIF images get a thumbnail.\nSend the ID of the attachment in the attachments of a websocket message to share the file.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "attachment"
+                ],
+                "summary": "Upload attachment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Room name",
+                        "name": "room",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "File to upload",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Uploaded attachment",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_attachment_upload.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_attachment_upload.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/rooms/{room}/messages": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of the room messages in chronological order. Pass nextCursor of the previous page as before to load older messages.\nRooms can only be read by the users who joined them at least once, direct message rooms (dm:\u003cuserID\u003e:\u003cuserID\u003e) by their two users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "room"
+                ],
+                "summary": "Room history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Room name",
+                        "name": "room",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Return messages with ID less than this one",
+                        "name": "before",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 50 by default, 100 at most",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Page of the room messages",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_room_history.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_room_history.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/rooms/{room}/messages/{id}/edits": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the previous bodies of an edited message, oldest first. The history of a deleted message is removed with its body.\nRooms can only be read by the users who joined them at least once, direct message rooms (dm:\u003cuserID\u003e:\u003cuserID\u003e) by their two users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "room"
+                ],
+                "summary": "Message edit history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Room name",
+                        "name": "room",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Message ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Edit history of the message",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_room_edits.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_room_edits.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/rooms/{room}/messages/{id}/replies": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the first message of a thread and a page of its replies in chronological order. Pass nextCursor of the previous page as before to load older replies.\nRooms can only be read by the users who joined them at least once, direct message rooms (dm:\u003cuserID\u003e:\u003cuserID\u003e) by their two users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "room"
+                ],
+                "summary": "Thread replies",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Room name",
+                        "name": "room",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID of the first message of the thread",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Return replies with ID less than this one",
+                        "name": "before",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 50 by default, 100 at most",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Page of the thread replies",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_room_thread.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_room_thread.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/user": {
             "post": {
                 "description": "Create a new user in the system.",
@@ -96,6 +670,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/internal_http_server_handlers_user_save.Response"
                         }
                     },
+                    "429": {
+                        "description": "Too many requests, retry after the Retry-After header",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -131,25 +711,122 @@ const docTemplate = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successfully deleted user",
+                        "description": "Successfully deleted user",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_user_delete.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_user_delete.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/mentions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of the messages mentioning the user with @username, newest first. Pass nextCursor of the previous page as before to load older mentions.\nA mention is read once the user read the room up to the message with a read envelope.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "My mentions",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Return mentions in messages with ID less than this one",
+                        "name": "before",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 50 by default, 100 at most",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Page of the mentions",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_user_mentions.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request with details",
+                        "schema": {
+                            "$ref": "#/definitions/internal_http_server_handlers_user_mentions.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/presence": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the live status of the users and, for the offline ones, the time they were last seen.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Users presence",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Comma separated user IDs, 100 at most",
+                        "name": "ids",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Presence of the users",
                         "schema": {
-                            "$ref": "#/definitions/internal_http_server_handlers_user_delete.Response"
+                            "$ref": "#/definitions/internal_http_server_handlers_user_presence.Response"
                         }
                     },
                     "400": {
                         "description": "Bad Request with details",
                         "schema": {
-                            "$ref": "#/definitions/internal_http_server_handlers_user_delete.Response"
-                        }
-                    },
-                    "404": {
-                        "description": "User not found",
-                        "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/internal_http_server_handlers_user_presence.Response"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "type": "string"
                         }
@@ -159,6 +836,67 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "internal_http_server_handlers_attachment_upload.Response": {
+            "type": "object",
+            "properties": {
+                "attachment": {
+                    "description": "Uploaded file, send its ID in the attachments of a message",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/new-websocket-chat_internal_storage.Attachment"
+                        }
+                    ]
+                },
+                "error": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "internal_http_server_handlers_auth_login.Request": {
+            "type": "object",
+            "required": [
+                "login",
+                "password"
+            ],
+            "properties": {
+                "login": {
+                    "description": "Username or email of the user",
+                    "type": "string",
+                    "maxLength": 254
+                },
+                "password": {
+                    "description": "Password of the user",
+                    "type": "string",
+                    "maxLength": 72
+                }
+            }
+        },
+        "internal_http_server_handlers_auth_login.Response": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "jwtAccessToken": {
+                    "description": "Access JWT token for the user",
+                    "type": "string"
+                },
+                "jwtRefreshToken": {
+                    "description": "Refresh JWT token for the user",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "username": {
+                    "description": "Username of the logged in user",
+                    "type": "string"
+                }
+            }
+        },
         "internal_http_server_handlers_jwt.Response": {
             "type": "object",
             "properties": {
@@ -176,6 +914,128 @@ const docTemplate = `{
                 }
             }
         },
+        "internal_http_server_handlers_message_search.Response": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "nextCursor": {
+                    "description": "Value of the before parameter to load older results, empty if there are no more results",
+                    "type": "integer"
+                },
+                "results": {
+                    "description": "Matching messages, newest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal_http_server_handlers_message_search.Result"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "internal_http_server_handlers_message_search.Result": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "$ref": "#/definitions/new-websocket-chat_internal_storage.Message"
+                },
+                "snippet": {
+                    "description": "HTML escaped part of the body around the first match, matches wrapped in \u003cmark\u003e",
+                    "type": "string"
+                }
+            }
+        },
+        "internal_http_server_handlers_room_edits.Response": {
+            "type": "object",
+            "properties": {
+                "edits": {
+                    "description": "Previous bodies of the message, oldest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/new-websocket-chat_internal_storage.MessageEdit"
+                    }
+                },
+                "error": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "internal_http_server_handlers_room_history.Response": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "messages": {
+                    "description": "Messages of the room in chronological order",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/new-websocket-chat_internal_storage.Message"
+                    }
+                },
+                "nextCursor": {
+                    "description": "Value of the before parameter to load older messages, empty if there are no more messages",
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "internal_http_server_handlers_room_thread.Response": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "nextCursor": {
+                    "description": "Value of the before parameter to load older replies, empty if there are no more replies",
+                    "type": "integer"
+                },
+                "parent": {
+                    "description": "First message of the thread with the reply count",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/new-websocket-chat_internal_storage.Message"
+                        }
+                    ]
+                },
+                "replies": {
+                    "description": "Replies of the thread in chronological order",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/new-websocket-chat_internal_storage.Message"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "internal_http_server_handlers_room_unread.Response": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "rooms": {
+                    "description": "Unread counts of the rooms the user received or read messages in",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/new-websocket-chat_internal_storage.UnreadCount"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
         "internal_http_server_handlers_user_delete.DeleteRequest": {
             "type": "object",
             "required": [
@@ -210,6 +1070,67 @@ const docTemplate = `{
                 }
             }
         },
+        "internal_http_server_handlers_user_mentions.Response": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "mentions": {
+                    "description": "Messages mentioning the user, newest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/new-websocket-chat_internal_storage.Mention"
+                    }
+                },
+                "nextCursor": {
+                    "description": "Value of the before parameter to load older mentions, empty if there are no more mentions",
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "unread": {
+                    "description": "Number of unread mentions of the user",
+                    "type": "integer"
+                }
+            }
+        },
+        "internal_http_server_handlers_user_presence.Response": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "users": {
+                    "description": "Presence of the requested users in the requested order",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal_http_server_handlers_user_presence.UserPresence"
+                    }
+                }
+            }
+        },
+        "internal_http_server_handlers_user_presence.UserPresence": {
+            "type": "object",
+            "properties": {
+                "lastSeen": {
+                    "description": "Time the user went offline, empty for connected users and users who never connected",
+                    "type": "string"
+                },
+                "status": {
+                    "description": "online, away, dnd or offline",
+                    "type": "string"
+                },
+                "userId": {
+                    "description": "ID of the user",
+                    "type": "integer"
+                }
+            }
+        },
         "internal_http_server_handlers_user_save.Request": {
             "type": "object",
             "required": [
@@ -258,6 +1179,170 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "new-websocket-chat_internal_lib_api_response.Response": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "new-websocket-chat_internal_storage.Attachment": {
+            "type": "object",
+            "properties": {
+                "contentType": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "messageId": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "room": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "thumbnail": {
+                    "description": "the thumbnail is kept under blob.ThumbnailKey(BlobKey)",
+                    "type": "boolean"
+                },
+                "uploaderId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "new-websocket-chat_internal_storage.Mention": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "$ref": "#/definitions/new-websocket-chat_internal_storage.Message"
+                },
+                "read": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "new-websocket-chat_internal_storage.Message": {
+            "type": "object",
+            "properties": {
+                "attachments": {
+                    "description": "set by GetRoomMessages, GetThreadReplies and TakePendingMessages only",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/new-websocket-chat_internal_storage.Attachment"
+                    }
+                },
+                "body": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "deletedAt": {
+                    "type": "string"
+                },
+                "editedAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "lastReplyAt": {
+                    "type": "string"
+                },
+                "parentId": {
+                    "type": "integer"
+                },
+                "reactions": {
+                    "description": "set by GetRoomMessages and GetThreadReplies only",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/new-websocket-chat_internal_storage.Reaction"
+                    }
+                },
+                "replyCount": {
+                    "type": "integer"
+                },
+                "room": {
+                    "type": "string"
+                },
+                "senderId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "new-websocket-chat_internal_storage.MessageEdit": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "editedAt": {
+                    "type": "string"
+                },
+                "editedBy": {
+                    "description": "0 if the user was deleted",
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "messageId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "new-websocket-chat_internal_storage.Reaction": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "emoji": {
+                    "type": "string"
+                },
+                "userIds": {
+                    "description": "in the order the users reacted",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "new-websocket-chat_internal_storage.UnreadCount": {
+            "type": "object",
+            "properties": {
+                "lastReadId": {
+                    "type": "integer"
+                },
+                "room": {
+                    "type": "string"
+                },
+                "unread": {
+                    "type": "integer"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
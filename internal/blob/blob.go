@@ -0,0 +1,43 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotFound   = errors.New("blob is not found")
+	ErrInvalidKey = errors.New("blob key is not valid")
+)
+
+// Store keeps the contents of the uploaded files by key, the metadata lives in the storage.
+// The local filesystem is the only backend for now, an S3-compatible one only has to implement Store.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Put writes the blob, replacing the blob with the same key. Readers never see a partially written blob.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens the blob for reading, it returns ErrNotFound if there is no blob with the key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob, deleting a missing blob isn't an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey reports whether the key can be used by every backend: 2 to 128 letters, digits, dashes and underscores.
+func ValidKey(key string) bool {
+	if len(key) < 2 || len(key) > 128 {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ThumbnailKey returns the key of the thumbnail of the blob with the key.
+func ThumbnailKey(key string) string {
+	return key + "_thumb"
+}
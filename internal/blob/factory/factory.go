@@ -0,0 +1,28 @@
+package factory
+
+import (
+	"fmt"
+	"new-websocket-chat/internal/blob"
+	"new-websocket-chat/internal/blob/local"
+	"new-websocket-chat/internal/config"
+)
+
+const (
+	StoreLocal = "local"
+)
+
+// New creates the blob store selected by the attachments config.
+func New(cfg config.Attachments) (blob.Store, error) {
+	const op = "blob.factory.New"
+
+	switch cfg.Store {
+	case StoreLocal:
+		s, err := local.New(cfg.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("%s: unknown blob store %q", op, cfg.Store)
+	}
+}
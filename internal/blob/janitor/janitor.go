@@ -0,0 +1,80 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"new-websocket-chat/internal/blob"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"time"
+)
+
+// AttachmentDeleter removes the attachments no message refers to.
+type AttachmentDeleter interface {
+	DeleteUnattachedAttachments(uploadedBefore time.Time) ([]storage.Attachment, error)
+}
+
+// Janitor deletes the uploads that were never attached to a message, with their blobs. Files are uploaded
+// before the message referring to them is sent, so only the uploads older than maxAge are deleted.
+type Janitor struct {
+	log         *slog.Logger
+	attachments AttachmentDeleter
+	blobs       blob.Store
+	maxAge      time.Duration
+}
+
+func New(log *slog.Logger, attachments AttachmentDeleter, blobs blob.Store, maxAge time.Duration) *Janitor {
+	return &Janitor{
+		log:         log.With(slog.String("op", "blob.janitor")),
+		attachments: attachments,
+		blobs:       blobs,
+		maxAge:      maxAge,
+	}
+}
+
+// Run sweeps every interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := j.Sweep(ctx, now); err != nil {
+				j.log.Error("failed to delete unattached uploads", sl.Err(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sweep deletes the uploads older than maxAge at now that no message refers to. The rows go first,
+// so a blob that fails to be deleted is only left on disk, never referred to.
+func (j *Janitor) Sweep(ctx context.Context, now time.Time) error {
+	const op = "blob.janitor.Sweep"
+
+	attachments, err := j.attachments.DeleteUnattachedAttachments(now.Add(-j.maxAge))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, attachment := range attachments {
+		keys := []string{attachment.BlobKey}
+		if attachment.Thumbnail {
+			keys = append(keys, blob.ThumbnailKey(attachment.BlobKey))
+		}
+
+		for _, key := range keys {
+			if err := j.blobs.Delete(ctx, key); err != nil {
+				j.log.Error("failed to delete blob", slog.String("key", key), sl.Err(err))
+			}
+		}
+	}
+	if len(attachments) > 0 {
+		j.log.Info("deleted unattached uploads", slog.Int("count", len(attachments)))
+	}
+
+	return nil
+}
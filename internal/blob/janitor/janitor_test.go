@@ -0,0 +1,63 @@
+package janitor_test
+
+import (
+	"context"
+	"new-websocket-chat/internal/blob"
+	"new-websocket-chat/internal/blob/janitor"
+	"new-websocket-chat/internal/blob/local"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"new-websocket-chat/internal/storage/memory"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJanitorSweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	s := memory.New()
+	alice, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	blobs, err := local.New(t.TempDir())
+	require.NoError(t, err)
+
+	upload := func(key string, createdAt time.Time) int64 {
+		for _, k := range []string{key, blob.ThumbnailKey(key)} {
+			require.NoError(t, blobs.Put(ctx, k, strings.NewReader("cat")))
+		}
+		id, err := s.SaveAttachment(storage.Attachment{Room: "general", UploaderID: alice, Name: "cat.png", BlobKey: key, Thumbnail: true, CreatedAt: createdAt})
+		require.NoError(t, err)
+		return id
+	}
+	abandoned := upload("abandoned", now.Add(-2*time.Hour))
+	attached := upload("attached", now.Add(-2*time.Hour))
+	recent := upload("recent", now.Add(-time.Minute))
+
+	messageID, err := s.SaveMessage("general", alice, "cats", now)
+	require.NoError(t, err)
+	require.NoError(t, s.AttachToMessage(messageID, []int64{attached}))
+
+	require.NoError(t, janitor.New(slogdiscard.NewDiscardLogger(), s, blobs, time.Hour).Sweep(ctx, now))
+
+	_, err = s.GetAttachment(abandoned)
+	require.ErrorIs(t, err, storage.ErrAttachmentNotFound)
+	for _, key := range []string{"abandoned", blob.ThumbnailKey("abandoned")} {
+		_, err := blobs.Get(ctx, key)
+		require.ErrorIs(t, err, blob.ErrNotFound)
+	}
+
+	// attached files and the ones a message may still refer to stay
+	for _, id := range []int64{attached, recent} {
+		_, err := s.GetAttachment(id)
+		require.NoError(t, err)
+	}
+	for _, key := range []string{"attached", "recent"} {
+		r, err := blobs.Get(ctx, key)
+		require.NoError(t, err)
+		r.Close()
+	}
+}
@@ -0,0 +1,117 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"new-websocket-chat/internal/blob"
+	"os"
+	"path/filepath"
+)
+
+// Storage keeps the blobs as files of a directory, in subdirectories named after the first two characters
+// of the key so no directory grows too big.
+type Storage struct {
+	dir string
+}
+
+func New(dir string) (*Storage, error) {
+	const op = "blob.local.New"
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("%s: create directory: %w", op, err)
+	}
+
+	return &Storage{dir: dir}, nil
+}
+
+// Put writes the blob to a temporary file and renames it, so readers never see a partially written blob.
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader) error {
+	const op = "blob.local.Put"
+
+	path, err := s.path(key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("%s: create directory: %w", op, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+key+"-*")
+	if err != nil {
+		return fmt.Errorf("%s: create file: %w", op, err)
+	}
+	defer os.Remove(tmp.Name()) // fails after the rename, which is fine
+
+	if _, err := io.Copy(tmp, contextReader{ctx: ctx, r: r}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: write file: %w", op, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s: close file: %w", op, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("%s: rename file: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	const op = "blob.local.Get"
+
+	path, err := s.path(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", op, blob.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: open file: %w", op, err)
+	}
+
+	return f, nil
+}
+
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	const op = "blob.local.Delete"
+
+	path, err := s.path(key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: remove file: %w", op, err)
+	}
+
+	return nil
+}
+
+// path returns the file of the blob. Valid keys can't point outside of the directory.
+func (s *Storage) path(key string) (string, error) {
+	if !blob.ValidKey(key) {
+		return "", blob.ErrInvalidKey
+	}
+
+	return filepath.Join(s.dir, key[:2], key), nil
+}
+
+// contextReader stops reading once the context is done, so a cancelled upload doesn't keep writing.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
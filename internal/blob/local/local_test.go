@@ -0,0 +1,44 @@
+package local_test
+
+import (
+	"context"
+	"io"
+	"new-websocket-chat/internal/blob"
+	"new-websocket-chat/internal/blob/local"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := local.New(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put(ctx, "abc123", strings.NewReader("hello")))
+	require.NoError(t, s.Put(ctx, "abc123", strings.NewReader("hello again")))
+
+	r, err := s.Get(ctx, "abc123")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "hello again", string(data))
+
+	require.NoError(t, s.Delete(ctx, "abc123"))
+	require.NoError(t, s.Delete(ctx, "abc123"))
+	_, err = s.Get(ctx, "abc123")
+	require.ErrorIs(t, err, blob.ErrNotFound)
+
+	for _, key := range []string{"../etc", "a", "ab/cd", ""} {
+		require.ErrorIs(t, s.Put(ctx, key, strings.NewReader("x")), blob.ErrInvalidKey, key)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, s.Put(cancelled, "def456", strings.NewReader("hello")), context.Canceled)
+	_, err = s.Get(ctx, "def456")
+	require.ErrorIs(t, err, blob.ErrNotFound)
+}
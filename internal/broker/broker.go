@@ -0,0 +1,21 @@
+package broker
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrClosed = errors.New("broker is closed")
+
+// Broker relays messages between the instances of the server: a message published to a topic
+// is delivered to every subscription of the topic, including the ones of the publishing instance.
+// Delivery is at most once, messages published while a subscription reconnects can be lost.
+type Broker interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+
+	// Subscribe delivers the messages of the topic to the channel until ctx is done or the broker is closed,
+	// then the channel is closed.
+	Subscribe(ctx context.Context, topic string) (<-chan []byte, error)
+
+	Close() error
+}
@@ -0,0 +1,29 @@
+package factory
+
+import (
+	"fmt"
+	"log/slog"
+	"new-websocket-chat/internal/broker"
+	"new-websocket-chat/internal/broker/memory"
+	"new-websocket-chat/internal/broker/redis"
+	"new-websocket-chat/internal/config"
+)
+
+const (
+	DriverMemory = "memory"
+	DriverRedis  = "redis"
+)
+
+// New creates the broker selected by the broker config.
+func New(log *slog.Logger, cfg config.Broker) (broker.Broker, error) {
+	const op = "broker.factory.New"
+
+	switch cfg.BrokerDriver {
+	case DriverMemory:
+		return memory.New(), nil
+	case DriverRedis:
+		return redis.New(log, cfg.BrokerAddress, cfg.BrokerPassword, cfg.BrokerPrefix), nil
+	default:
+		return nil, fmt.Errorf("%s: unknown broker driver %q", op, cfg.BrokerDriver)
+	}
+}
@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"new-websocket-chat/internal/broker"
+	"sync"
+)
+
+// bufferSize is the number of messages a subscription buffers before Publish waits for it.
+const bufferSize = 256
+
+// Broker relays the messages within the process, for a single instance and for tests.
+type Broker struct {
+	mu            sync.Mutex
+	subscriptions map[string]map[*subscription]bool
+	closed        bool
+	done          chan struct{}
+}
+
+type subscription struct {
+	ch   chan []byte
+	done chan struct{}
+}
+
+func New() *Broker {
+	return &Broker{
+		subscriptions: make(map[string]map[*subscription]bool),
+		done:          make(chan struct{}),
+	}
+}
+
+// Publish waits until every subscription of the topic buffered the message or ctx is done.
+func (b *Broker) Publish(ctx context.Context, topic string, data []byte) error {
+	const op = "broker.memory.Publish"
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return fmt.Errorf("%s: %w", op, broker.ErrClosed)
+	}
+	subscriptions := make([]*subscription, 0, len(b.subscriptions[topic]))
+	for s := range b.subscriptions[topic] {
+		subscriptions = append(subscriptions, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range subscriptions {
+		select {
+		case s.ch <- data:
+		case <-s.done:
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		}
+	}
+
+	return nil
+}
+
+func (b *Broker) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	const op = "broker.memory.Subscribe"
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, fmt.Errorf("%s: %w", op, broker.ErrClosed)
+	}
+
+	s := &subscription{ch: make(chan []byte, bufferSize), done: make(chan struct{})}
+	if b.subscriptions[topic] == nil {
+		b.subscriptions[topic] = make(map[*subscription]bool)
+	}
+	b.subscriptions[topic][s] = true
+
+	// s.ch is never closed as a Publish may still hold the subscription, out is closed once it ends
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		defer b.unsubscribe(topic, s)
+
+		for {
+			select {
+			case data := <-s.ch:
+				select {
+				case out <- data:
+				case <-ctx.Done():
+					return
+				case <-b.done:
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-b.done:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+
+	return nil
+}
+
+func (b *Broker) unsubscribe(topic string, s *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	close(s.done)
+	delete(b.subscriptions[topic], s)
+	if len(b.subscriptions[topic]) == 0 {
+		delete(b.subscriptions, topic)
+	}
+}
@@ -0,0 +1,69 @@
+package memory_test
+
+import (
+	"context"
+	"new-websocket-chat/internal/broker"
+	"new-websocket-chat/internal/broker/memory"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroker(t *testing.T) {
+	b := memory.New()
+	ctx := context.Background()
+
+	first, err := b.Subscribe(ctx, "hub")
+	require.NoError(t, err)
+	second, err := b.Subscribe(ctx, "hub")
+	require.NoError(t, err)
+	other, err := b.Subscribe(ctx, "other")
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(ctx, "hub", []byte("hello")))
+	require.Equal(t, "hello", string(receive(t, first)))
+	require.Equal(t, "hello", string(receive(t, second)))
+
+	select {
+	case data := <-other:
+		t.Fatalf("unexpected message %q", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the subscription ends with its context
+	subCtx, cancel := context.WithCancel(ctx)
+	cancelled, err := b.Subscribe(subCtx, "hub")
+	require.NoError(t, err)
+	cancel()
+	requireClosed(t, cancelled)
+	require.NoError(t, b.Publish(ctx, "hub", []byte("again")))
+	require.Equal(t, "again", string(receive(t, first)))
+
+	// and with the broker
+	require.NoError(t, b.Close())
+	requireClosed(t, first)
+	require.ErrorIs(t, b.Publish(ctx, "hub", []byte("closed")), broker.ErrClosed)
+	_, err = b.Subscribe(ctx, "hub")
+	require.ErrorIs(t, err, broker.ErrClosed)
+}
+
+func receive(t *testing.T, ch <-chan []byte) []byte {
+	select {
+	case data, ok := <-ch:
+		require.True(t, ok, "subscription ended")
+		return data
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message")
+		return nil
+	}
+}
+
+func requireClosed(t *testing.T, ch <-chan []byte) {
+	select {
+	case _, ok := <-ch:
+		require.False(t, ok)
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription didn't end")
+	}
+}
@@ -0,0 +1,266 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"new-websocket-chat/internal/broker"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/lib/redisproto"
+	"sync"
+	"time"
+)
+
+const (
+	// Time allowed for a command when the context has no deadline.
+	commandTimeout = 5 * time.Second
+
+	// Waits between the attempts to restore a subscription, doubled up to the max.
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// Broker relays the messages through the pub/sub of a Redis server, or of any server speaking its protocol.
+// Topics are published to the channels named prefix + topic, so deployments can share a server.
+type Broker struct {
+	log      *slog.Logger
+	addr     string
+	password string
+	prefix   string
+	dialer   net.Dialer
+
+	// Connection of the publishes, they are sent one at a time.
+	mu   sync.Mutex
+	conn *conn
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func New(log *slog.Logger, addr string, password string, prefix string) *Broker {
+	return &Broker{
+		log:      log.With(slog.String("op", "broker.redis"), slog.String("addr", addr)),
+		addr:     addr,
+		password: password,
+		prefix:   prefix,
+		done:     make(chan struct{}),
+	}
+}
+
+func (b *Broker) Publish(ctx context.Context, topic string, data []byte) error {
+	const op = "broker.redis.Publish"
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return fmt.Errorf("%s: %w", op, broker.ErrClosed)
+	default:
+	}
+
+	if b.conn == nil {
+		c, err := b.dial(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		b.conn = c
+	}
+
+	if _, err := b.conn.do(ctx, []byte("PUBLISH"), []byte(b.prefix+topic), data); err != nil {
+		var replyErr redisproto.Error
+		if !errors.As(err, &replyErr) {
+			// the state of the connection is unknown, the next publish dials a new one
+			b.conn.Close()
+			b.conn = nil
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Subscribe restores the subscription when the connection breaks, the messages published meanwhile are lost.
+func (b *Broker) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	const op = "broker.redis.Subscribe"
+
+	// the subscription ends with the broker as well
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-b.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c, err := b.subscribe(ctx, topic)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	out := make(chan []byte)
+	go b.receive(ctx, cancel, topic, c, out)
+
+	return out, nil
+}
+
+func (b *Broker) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+
+	return nil
+}
+
+// receive forwards the messages of the subscription to out until ctx is done, reconnecting when the connection breaks.
+func (b *Broker) receive(ctx context.Context, cancel context.CancelFunc, topic string, c *conn, out chan<- []byte) {
+	defer close(out)
+	defer cancel()
+
+	for {
+		err := b.forward(ctx, c, out)
+		c.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		b.log.Error("subscription broke, reconnecting", slog.String("topic", topic), sl.Err(err))
+
+		for backoff := minBackoff; ; backoff = min(2*backoff, maxBackoff) {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+
+			c, err = b.subscribe(ctx, topic)
+			if err == nil {
+				b.log.Info("subscription restored", slog.String("topic", topic))
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			b.log.Error("failed to restore subscription", slog.String("topic", topic), sl.Err(err))
+		}
+	}
+}
+
+// forward reads the messages pushed to the subscribed connection.
+func (b *Broker) forward(ctx context.Context, c *conn, out chan<- []byte) error {
+	const op = "broker.redis.forward"
+
+	// a closed connection makes the read return
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+
+	for {
+		v, err := redisproto.Read(c.r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		// pushes are ["message", channel, data], subscription confirmations are skipped
+		push, ok := v.([]any)
+		if !ok || len(push) != 3 {
+			continue
+		}
+		kind, _ := push[0].([]byte)
+		data, _ := push[2].([]byte)
+		if string(kind) != "message" || data == nil {
+			continue
+		}
+
+		select {
+		case out <- data:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// subscribe opens a connection subscribed to the channel of the topic.
+func (b *Broker) subscribe(ctx context.Context, topic string) (*conn, error) {
+	const op = "broker.redis.subscribe"
+
+	c, err := b.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	reply, err := c.do(ctx, []byte("SUBSCRIBE"), []byte(b.prefix+topic))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if confirmation, ok := reply.([]any); !ok || len(confirmation) != 3 {
+		c.Close()
+		return nil, fmt.Errorf("%s: %w", op, redisproto.ErrProtocol)
+	}
+
+	// pushes come whenever a message is published
+	c.SetDeadline(time.Time{})
+
+	return c, nil
+}
+
+// dial opens a connection, authenticated if the broker has a password.
+func (b *Broker) dial(ctx context.Context) (*conn, error) {
+	const op = "broker.redis.dial"
+
+	nc, err := b.dialer.DialContext(ctx, "tcp", b.addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	if b.password != "" {
+		if _, err := c.do(ctx, []byte("AUTH"), []byte(b.password)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return c, nil
+}
+
+// do sends the command and reads the reply, an error reply is returned as a redisproto.Error.
+func (c *conn) do(ctx context.Context, args ...[]byte) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(commandTimeout)
+	}
+	c.SetDeadline(deadline)
+
+	if err := redisproto.WriteCommand(c.w, args...); err != nil {
+		return nil, err
+	}
+
+	reply, err := redisproto.Read(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := reply.(redisproto.Error); ok {
+		return nil, replyErr
+	}
+
+	return reply, nil
+}
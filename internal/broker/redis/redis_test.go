@@ -0,0 +1,103 @@
+package redis_test
+
+import (
+	"context"
+	"new-websocket-chat/internal/broker"
+	"new-websocket-chat/internal/broker/redis"
+	"new-websocket-chat/internal/broker/redis/redistest"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroker(t *testing.T) {
+	server := redistest.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	publisher := redis.New(slogdiscard.NewDiscardLogger(), server.Addr, "", "chat:")
+	defer publisher.Close()
+	subscriber := redis.New(slogdiscard.NewDiscardLogger(), server.Addr, "", "chat:")
+	defer subscriber.Close()
+
+	messages, err := subscriber.Subscribe(ctx, "hub")
+	require.NoError(t, err)
+
+	require.NoError(t, publisher.Publish(ctx, "hub", []byte("hello\r\nworld")))
+	require.Equal(t, "hello\r\nworld", string(receive(t, messages)))
+
+	// deployments with other prefixes don't see the messages
+	other := redis.New(slogdiscard.NewDiscardLogger(), server.Addr, "", "other:")
+	defer other.Close()
+	require.NoError(t, other.Publish(ctx, "hub", []byte("elsewhere")))
+	require.NoError(t, publisher.Publish(ctx, "hub", []byte("here")))
+	require.Equal(t, "here", string(receive(t, messages)))
+
+	// both connections are restored after they break
+	server.CloseClientConnections()
+	require.Eventually(t, func() bool {
+		if err := publisher.Publish(ctx, "hub", []byte("restored")); err != nil {
+			return false
+		}
+		select {
+		case data := <-messages:
+			return string(data) == "restored"
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the subscription ends with the broker
+	require.NoError(t, subscriber.Close())
+	for range messages {
+	}
+	_, err = subscriber.Subscribe(ctx, "hub")
+	require.Error(t, err)
+	require.ErrorIs(t, subscriber.Publish(ctx, "hub", []byte("closed")), broker.ErrClosed)
+}
+
+func TestBrokerAuth(t *testing.T) {
+	server := redistest.NewAuthServer("secret")
+	defer server.Close()
+
+	ctx := context.Background()
+
+	wrong := redis.New(slogdiscard.NewDiscardLogger(), server.Addr, "guess", "")
+	defer wrong.Close()
+	require.Error(t, wrong.Publish(ctx, "hub", []byte("hello")))
+	_, err := wrong.Subscribe(ctx, "hub")
+	require.Error(t, err)
+
+	b := redis.New(slogdiscard.NewDiscardLogger(), server.Addr, "secret", "")
+	defer b.Close()
+	messages, err := b.Subscribe(ctx, "hub")
+	require.NoError(t, err)
+	require.NoError(t, b.Publish(ctx, "hub", []byte("hello")))
+	require.Equal(t, "hello", string(receive(t, messages)))
+}
+
+func TestBrokerUnavailable(t *testing.T) {
+	server := redistest.NewServer()
+	addr := server.Addr
+	server.Close()
+
+	b := redis.New(slogdiscard.NewDiscardLogger(), addr, "", "")
+	defer b.Close()
+
+	require.Error(t, b.Publish(context.Background(), "hub", []byte("hello")))
+	_, err := b.Subscribe(context.Background(), "hub")
+	require.Error(t, err)
+}
+
+func receive(t *testing.T, ch <-chan []byte) []byte {
+	select {
+	case data, ok := <-ch:
+		require.True(t, ok, "subscription ended")
+		return data
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message")
+		return nil
+	}
+}
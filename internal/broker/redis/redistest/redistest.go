@@ -0,0 +1,204 @@
+// Package redistest runs a server speaking the pub/sub subset of the Redis protocol, for tests without Redis.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"new-websocket-chat/internal/lib/redisproto"
+	"strings"
+	"sync"
+)
+
+// Server supports PING, AUTH, PUBLISH, SUBSCRIBE and UNSUBSCRIBE.
+type Server struct {
+	Addr string
+
+	// Password required by AUTH before other commands, none if empty.
+	password string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	clients map[*client]bool
+}
+
+type client struct {
+	conn     net.Conn
+	authed   bool
+	channels map[string]bool
+
+	// Writes of the replies and of the pushes of other clients' publishes.
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// NewServer starts a server on a local port, it panics if it can't listen.
+func NewServer() *Server {
+	return NewAuthServer("")
+}
+
+// NewAuthServer starts a server that requires the password.
+func NewAuthServer(password string) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("redistest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		password: password,
+		listener: listener,
+		clients:  make(map[*client]bool),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// Close stops the server and closes every connection.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.CloseClientConnections()
+	s.wg.Wait()
+}
+
+// CloseClientConnections closes the connections of the clients, the server keeps accepting new ones.
+func (s *Server) CloseClientConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &client{conn: conn, authed: s.password == "", channels: make(map[string]bool), w: bufio.NewWriter(conn)}
+		s.mu.Lock()
+		s.clients[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c *client) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	r := bufio.NewReader(c.conn)
+next:
+	for {
+		v, err := redisproto.Read(r)
+		if err != nil {
+			return
+		}
+
+		values, ok := v.([]any)
+		if !ok || len(values) == 0 {
+			c.write("-ERR invalid command\r\n")
+			continue
+		}
+		args := make([]string, 0, len(values))
+		for _, value := range values {
+			arg, ok := value.([]byte)
+			if !ok {
+				c.write("-ERR invalid command\r\n")
+				continue next
+			}
+			args = append(args, string(arg))
+		}
+
+		s.command(c, strings.ToUpper(args[0]), args[1:])
+	}
+}
+
+func (s *Server) command(c *client, name string, args []string) {
+	if name == "AUTH" {
+		if len(args) != 1 || args[0] != s.password {
+			c.write("-WRONGPASS invalid password\r\n")
+			return
+		}
+		c.authed = true
+		c.write("+OK\r\n")
+		return
+	}
+	if !c.authed {
+		c.write("-NOAUTH Authentication required.\r\n")
+		return
+	}
+
+	switch name {
+	case "PING":
+		c.write("+PONG\r\n")
+	case "PUBLISH":
+		if len(args) != 2 {
+			c.write("-ERR wrong number of arguments for 'publish' command\r\n")
+			return
+		}
+		c.write(fmt.Sprintf(":%d\r\n", s.publish(args[0], args[1])))
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		if len(args) == 0 {
+			c.write(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(name)))
+			return
+		}
+		s.mu.Lock()
+		for _, channel := range args {
+			if name == "SUBSCRIBE" {
+				c.channels[channel] = true
+			} else {
+				delete(c.channels, channel)
+			}
+			c.write(fmt.Sprintf("*3\r\n%s%s:%d\r\n", bulk(strings.ToLower(name)), bulk(channel), len(c.channels)))
+		}
+		s.mu.Unlock()
+	default:
+		c.write(fmt.Sprintf("-ERR unknown command '%s'\r\n", name))
+	}
+}
+
+// publish pushes the message to the subscribers of the channel and returns their number.
+func (s *Server) publish(channel string, message string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for c := range s.clients {
+		if c.channels[channel] {
+			c.write("*3\r\n" + bulk("message") + bulk(channel) + bulk(message))
+			n++
+		}
+	}
+
+	return n
+}
+
+func (c *client) write(reply string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.w.WriteString(reply)
+	c.w.Flush()
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
@@ -8,23 +8,85 @@ import (
 )
 
 type Config struct {
-	Env string `yaml:"env" env-default:"local"`
-	HttpServer
-	Database
+	Env         string `yaml:"env" env-default:"local"`
+	HttpServer  `yaml:"http_server"`
+	Database    `yaml:"database"`
+	Attachments `yaml:"attachments"`
+	Websocket   `yaml:"websocket"`
+	AuthLimits  `yaml:"auth_limits"`
+	Broker      `yaml:"broker"`
 }
 
 type HttpServer struct {
 	Address     string        `yaml:"address" env-default:"localhost:8080"`
 	Timeout     time.Duration `yaml:"timeout" env-default:"4s"`
 	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"60s"`
+	// Time given to in-flight requests and websocket clients to finish on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"10s"`
+	// Address serving the metrics at /debug/vars, keep it private. Empty disables it.
+	MetricsAddress string `yaml:"metrics_address" env-default:"localhost:9090"`
 }
 
 type Database struct {
+	Driver   string `yaml:"driver" env-default:"postgres"` // postgres or memory
 	User     string `yaml:"user" env-default:"postgres"`
 	Password string `yaml:"password" env-default:"Abdrahman"`
 	DBname   string `yaml:"dbname" env-default:"gowebsocket"`
 	Hostname string `yaml:"hostname" env-default:"localhost"`
 	Port     int    `yaml:"port" env-default:"5432"`
+	// Apply pending migrations on start, otherwise run `websocket-chat migrate up` before deploying.
+	AutoMigrate bool `yaml:"auto_migrate" env-default:"true"`
+}
+
+type Attachments struct {
+	Store   string `yaml:"store" env-default:"local"` // local, S3-compatible stores can be added behind blob.Store
+	Dir     string `yaml:"dir" env-default:"./data/attachments"`
+	MaxSize int64  `yaml:"max_size" env-default:"10485760"` // in bytes
+	// Uploads no message refers to after this long are deleted, checked every sweep interval.
+	UnattachedTTL time.Duration `yaml:"unattached_ttl" env-default:"24h"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env-default:"1h"`
+}
+
+type Websocket struct {
+	// Envelopes a connection can send per second and in a burst, 0 disables the limit.
+	MessageRate  float64 `yaml:"message_rate" env-default:"10"`
+	MessageBurst int     `yaml:"message_burst" env-default:"20"`
+	// Envelopes all connections of a user can send together per second and in a burst, 0 disables the limit.
+	UserMessageRate  float64 `yaml:"user_message_rate" env-default:"20"`
+	UserMessageBurst int     `yaml:"user_message_burst" env-default:"40"`
+	// Connections exceeding the limits this many times within the window are closed with code 1008, 0 never closes them.
+	MaxRateViolations   int           `yaml:"max_rate_violations" env-default:"10"`
+	RateViolationWindow time.Duration `yaml:"rate_violation_window" env-default:"30s"`
+	// What happens to a chat message, an event or a typing event when the send buffer of a connection is full:
+	// drop_oldest, drop_newest or disconnect (close code 1013).
+	SlowMessagePolicy string `yaml:"slow_message_policy" env-default:"disconnect"`
+	SlowEventPolicy   string `yaml:"slow_event_policy" env-default:"disconnect"`
+	SlowTypingPolicy  string `yaml:"slow_typing_policy" env-default:"drop_newest"`
+}
+
+type AuthLimits struct {
+	Store string `yaml:"store" env-default:"memory"` // memory, a shared store keeps the limits across instances
+	// Requests to the auth endpoints an IP address can send per second and in a burst, 0 disables the limit.
+	IPRate  float64 `yaml:"ip_rate" env-default:"1"`
+	IPBurst int     `yaml:"ip_burst" env-default:"20"`
+	// Requests for the same account (login, username or refresh token) per second and in a burst, 0 disables the limit.
+	AccountRate  float64 `yaml:"account_rate" env-default:"0.2"`
+	AccountBurst int     `yaml:"account_burst" env-default:"5"`
+	// Logins failing this many times in a row are locked out for the base duration, doubled with every
+	// further failure up to the max. Failures are forgotten after the window, 0 never locks out.
+	LockoutThreshold int           `yaml:"lockout_threshold" env-default:"5"`
+	LockoutBase      time.Duration `yaml:"lockout_base" env-default:"30s"`
+	LockoutMax       time.Duration `yaml:"lockout_max" env-default:"1h"`
+	LockoutWindow    time.Duration `yaml:"lockout_window" env-default:"15m"`
+}
+
+type Broker struct {
+	// memory for a single instance, redis relays the messages between the instances behind a load balancer
+	BrokerDriver   string `yaml:"driver" env-default:"memory"`
+	BrokerAddress  string `yaml:"address" env-default:"localhost:6379"`
+	BrokerPassword string `yaml:"password"`
+	// Prefix of the pub/sub channels, deployments sharing a server need different prefixes.
+	BrokerPrefix string `yaml:"prefix" env-default:"websocket-chat:"`
 }
 
 func MustLoad() Config {
@@ -0,0 +1,169 @@
+package download
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"new-websocket-chat/internal/blob"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/lib/thumbnail"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+	"strings"
+)
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=AttachmentProvider
+type AttachmentProvider interface {
+	GetAttachment(attachmentID int64) (storage.Attachment, error)
+	IsRoomMember(room string, userID int64) (bool, error)
+}
+
+// @Summary Download attachment
+// @Description Returns the contents of an attachment. Attachments can be downloaded by the users who joined their room, attachments of direct rooms only by the two users of the room.
+// @Description Errors are returned as JSON like with the other endpoints.
+// @Tags attachment
+// @Produce octet-stream
+// @Security BearerAuth
+// @Param id path int true "Attachment ID"
+// @Success 200 {file} file "Contents of the attachment"
+// @Failure 400 {object} resp.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /attachments/{id} [get]
+func New(log *slog.Logger, attachmentProvider AttachmentProvider, blobs blob.Store) http.HandlerFunc {
+	return serve(log, attachmentProvider, blobs, false)
+}
+
+// @Summary Download attachment thumbnail
+// @Description Returns the thumbnail of an image attachment, at most 256x256 pixels: JPEG for JPEG images, PNG otherwise. Only attachments with thumbnail set to true have one.
+// @Tags attachment
+// @Produce jpeg,png
+// @Security BearerAuth
+// @Param id path int true "Attachment ID"
+// @Success 200 {file} file "Thumbnail of the attachment"
+// @Failure 400 {object} resp.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /attachments/{id}/thumbnail [get]
+func NewThumbnail(log *slog.Logger, attachmentProvider AttachmentProvider, blobs blob.Store) http.HandlerFunc {
+	return serve(log, attachmentProvider, blobs, true)
+}
+
+func serve(log *slog.Logger, attachmentProvider AttachmentProvider, blobs blob.Store, thumb bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.attachment.download.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("invalid user id in token", slog.String("userID", subject))
+
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			log.Error("invalid attachment id", slog.String("id", chi.URLParam(r, "id")))
+
+			render.JSON(w, r, resp.Error("invalid attachment id"))
+
+			return
+		}
+
+		attachment, err := attachmentProvider.GetAttachment(id)
+		if errors.Is(err, storage.ErrAttachmentNotFound) {
+			log.Info("attachment not found", slog.Int64("id", id))
+
+			render.JSON(w, r, resp.Error("attachment not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to get attachment", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get attachment"))
+
+			return
+		}
+
+		// direct rooms have no members in the storage, their users are in the name
+		member := dm.IsMember(attachment.Room, userID)
+		if !dm.IsDirect(attachment.Room) {
+			member, err = attachmentProvider.IsRoomMember(attachment.Room, userID)
+			if err != nil {
+				log.Error("failed to check room member", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to get attachment"))
+
+				return
+			}
+		}
+		if !member {
+			log.Error("user is not a member of the room", slog.String("room", attachment.Room), slog.Int64("userID", userID))
+
+			render.JSON(w, r, resp.Error("access denied"))
+
+			return
+		}
+
+		key, contentType := attachment.BlobKey, attachment.ContentType
+		if thumb {
+			if !attachment.Thumbnail {
+				render.JSON(w, r, resp.Error("attachment has no thumbnail"))
+
+				return
+			}
+			key, contentType = blob.ThumbnailKey(key), thumbnail.ContentType(attachment.ContentType)
+		}
+
+		contents, err := blobs.Get(r.Context(), key)
+		if err != nil {
+			log.Error("failed to open blob", slog.String("key", key), sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get attachment"))
+
+			return
+		}
+		defer contents.Close()
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", disposition(attachment.Name, contentType))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+
+		// local files support range requests, other stores are streamed as they are
+		if seeker, ok := contents.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, "", attachment.CreatedAt, seeker)
+			return
+		}
+		if !thumb {
+			w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		}
+		if _, err := io.Copy(w, contents); err != nil {
+			log.Error("failed to send attachment", sl.Err(err))
+		}
+	}
+}
+
+// disposition shows images in the browser and downloads the other files, so an uploaded document
+// is never rendered on the origin of the API.
+func disposition(name string, contentType string) string {
+	kind := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		kind = "inline"
+	}
+
+	return mime.FormatMediaType(kind, map[string]string{"filename": name})
+}
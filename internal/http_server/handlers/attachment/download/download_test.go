@@ -0,0 +1,169 @@
+package download_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/blob"
+	"new-websocket-chat/internal/blob/local"
+	"new-websocket-chat/internal/http_server/handlers/attachment/download"
+	"new-websocket-chat/internal/http_server/handlers/attachment/download/mocks"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadHandler(t *testing.T) {
+	tests := []struct {
+		name            string
+		id              string
+		thumbnail       bool
+		attachment      storage.Attachment
+		attachmentError error
+		checkMember     bool
+		member          bool
+		memberError     error
+		respError       string
+		wantBody        string
+		wantType        string
+		wantDisposition string
+	}{
+		{
+			name:            "Document",
+			id:              "5",
+			attachment:      testAttachment("general", "application/pdf", false),
+			checkMember:     true,
+			member:          true,
+			wantBody:        "contents",
+			wantType:        "application/pdf",
+			wantDisposition: `attachment; filename="report 1.pdf"`,
+		},
+		{
+			name:            "Thumbnail of image in direct room",
+			id:              "5",
+			thumbnail:       true,
+			attachment:      testAttachment("dm:3:7", "image/gif", true),
+			wantBody:        "thumbnail",
+			wantType:        "image/png",
+			wantDisposition: `inline; filename="report 1.pdf"`,
+		},
+		{
+			name:       "No thumbnail",
+			id:         "5",
+			thumbnail:  true,
+			attachment: testAttachment("dm:3:7", "application/pdf", false),
+			respError:  "attachment has no thumbnail",
+		},
+		{
+			name:        "Not a member",
+			id:          "5",
+			attachment:  testAttachment("general", "application/pdf", false),
+			checkMember: true,
+			respError:   "access denied",
+		},
+		{
+			name:       "Direct room of other users",
+			id:         "5",
+			attachment: testAttachment("dm:1:2", "application/pdf", false),
+			respError:  "access denied",
+		},
+		{
+			name:            "Not found",
+			id:              "5",
+			attachmentError: storage.ErrAttachmentNotFound,
+			respError:       "attachment not found",
+		},
+		{
+			name:      "Invalid id",
+			id:        "abc",
+			respError: "invalid attachment id",
+		},
+		{
+			name:        "Member check error",
+			id:          "5",
+			attachment:  testAttachment("general", "application/pdf", false),
+			checkMember: true,
+			memberError: errors.New("unexpected error"),
+			respError:   "failed to get attachment",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			blobs, err := local.New(t.TempDir())
+			require.NoError(t, err)
+			require.NoError(t, blobs.Put(context.Background(), "abc123", strings.NewReader("contents")))
+			require.NoError(t, blobs.Put(context.Background(), blob.ThumbnailKey("abc123"), strings.NewReader("thumbnail")))
+
+			attachmentProviderMock := mocks.NewAttachmentProvider(t)
+			if test.attachment.ID != 0 || test.attachmentError != nil {
+				attachmentProviderMock.On("GetAttachment", int64(5)).
+					Return(test.attachment, test.attachmentError).
+					Once()
+			}
+			if test.checkMember {
+				attachmentProviderMock.On("IsRoomMember", test.attachment.Room, int64(3)).
+					Return(test.member, test.memberError).
+					Once()
+			}
+
+			handler := download.New(slogdiscard.NewDiscardLogger(), attachmentProviderMock, blobs)
+			path := "/attachments/" + test.id
+			if test.thumbnail {
+				handler = download.NewThumbnail(slogdiscard.NewDiscardLogger(), attachmentProviderMock, blobs)
+				path += "/thumbnail"
+			}
+
+			req, err := http.NewRequest(http.MethodGet, path, nil)
+			require.NoError(t, err)
+
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("id", test.id)
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx)
+			req = req.WithContext(context.WithValue(ctx, "userID", "3"))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			if test.respError != "" {
+				var resp resp.Response
+
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+				require.Equal(t, test.respError, resp.Error)
+				return
+			}
+
+			require.Equal(t, test.wantBody, rr.Body.String())
+			require.Equal(t, test.wantType, rr.Header().Get("Content-Type"))
+			require.Equal(t, test.wantDisposition, rr.Header().Get("Content-Disposition"))
+			require.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+		})
+	}
+}
+
+func testAttachment(room string, contentType string, thumbnail bool) storage.Attachment {
+	return storage.Attachment{
+		ID:          5,
+		Room:        room,
+		UploaderID:  7,
+		Name:        "report 1.pdf",
+		ContentType: contentType,
+		Size:        8,
+		BlobKey:     "abc123",
+		Thumbnail:   thumbnail,
+		CreatedAt:   time.Now(),
+	}
+}
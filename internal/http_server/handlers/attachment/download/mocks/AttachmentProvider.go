@@ -0,0 +1,76 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	storage "new-websocket-chat/internal/storage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// AttachmentProvider is an autogenerated mock type for the AttachmentProvider type
+type AttachmentProvider struct {
+	mock.Mock
+}
+
+// GetAttachment provides a mock function with given fields: attachmentID
+func (_m *AttachmentProvider) GetAttachment(attachmentID int64) (storage.Attachment, error) {
+	ret := _m.Called(attachmentID)
+
+	var r0 storage.Attachment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(int64) (storage.Attachment, error)); ok {
+		return rf(attachmentID)
+	}
+	if rf, ok := ret.Get(0).(func(int64) storage.Attachment); ok {
+		r0 = rf(attachmentID)
+	} else {
+		r0 = ret.Get(0).(storage.Attachment)
+	}
+
+	if rf, ok := ret.Get(1).(func(int64) error); ok {
+		r1 = rf(attachmentID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IsRoomMember provides a mock function with given fields: room, userID
+func (_m *AttachmentProvider) IsRoomMember(room string, userID int64) (bool, error) {
+	ret := _m.Called(room, userID)
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, int64) (bool, error)); ok {
+		return rf(room, userID)
+	}
+	if rf, ok := ret.Get(0).(func(string, int64) bool); ok {
+		r0 = rf(room, userID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(string, int64) error); ok {
+		r1 = rf(room, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewAttachmentProvider creates a new instance of AttachmentProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewAttachmentProvider(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *AttachmentProvider {
+	mock := &AttachmentProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
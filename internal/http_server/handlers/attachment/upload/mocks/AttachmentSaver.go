@@ -0,0 +1,76 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	storage "new-websocket-chat/internal/storage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// AttachmentSaver is an autogenerated mock type for the AttachmentSaver type
+type AttachmentSaver struct {
+	mock.Mock
+}
+
+// IsRoomMember provides a mock function with given fields: room, userID
+func (_m *AttachmentSaver) IsRoomMember(room string, userID int64) (bool, error) {
+	ret := _m.Called(room, userID)
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, int64) (bool, error)); ok {
+		return rf(room, userID)
+	}
+	if rf, ok := ret.Get(0).(func(string, int64) bool); ok {
+		r0 = rf(room, userID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(string, int64) error); ok {
+		r1 = rf(room, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveAttachment provides a mock function with given fields: attachment
+func (_m *AttachmentSaver) SaveAttachment(attachment storage.Attachment) (int64, error) {
+	ret := _m.Called(attachment)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(storage.Attachment) (int64, error)); ok {
+		return rf(attachment)
+	}
+	if rf, ok := ret.Get(0).(func(storage.Attachment) int64); ok {
+		r0 = rf(attachment)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(storage.Attachment) error); ok {
+		r1 = rf(attachment)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewAttachmentSaver creates a new instance of AttachmentSaver. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewAttachmentSaver(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *AttachmentSaver {
+	mock := &AttachmentSaver{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,288 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"new-websocket-chat/internal/blob"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/lib/thumbnail"
+	"new-websocket-chat/internal/storage"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	formField     = "file"
+	maxNameLength = 255
+	// multipartOverhead is allowed on top of the maximum file size for the boundaries and the part headers.
+	multipartOverhead = 64 << 10
+)
+
+// allowedTypes are the MIME types of the files that can be uploaded, detected from the contents of the file.
+var allowedTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"application/zip": true,
+	"text/plain":      true,
+}
+
+// Response defines the response payload for the upload request.
+type Response struct {
+	resp.Response                     // Embedding the common response struct
+	Attachment    *storage.Attachment `json:"attachment,omitempty"` // Uploaded file, send its ID in the attachments of a message
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=AttachmentSaver
+type AttachmentSaver interface {
+	IsRoomMember(room string, userID int64) (bool, error)
+	SaveAttachment(attachment storage.Attachment) (int64, error)
+}
+
+// @Summary Upload attachment
+// @Description Uploads a file to a room the user joined, or to a direct room of the user, as the multipart form field "file".
+// @Description The type is detected from the contents: JPEG, PNG, GIF and WebP images, PDF, ZIP and plain text files are allowed. JPEG, PNG and GIF images get a thumbnail.
+// @Description Send the ID of the attachment in the attachments of a websocket message to share the file.
+// @Tags attachment
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param room path string true "Room name"
+// @Param file formData file true "File to upload"
+// @Success 200 {object} upload.Response "Uploaded attachment"
+// @Failure 400 {object} upload.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /rooms/{room}/attachments [post]
+func New(log *slog.Logger, attachmentSaver AttachmentSaver, blobs blob.Store, maxSize int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.attachment.upload.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("invalid user id in token", slog.String("userID", subject))
+
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		room := chi.URLParam(r, "room")
+		if room == "" || len(room) > 64 {
+			log.Error("invalid room name", slog.String("room", room))
+
+			render.JSON(w, r, resp.Error("invalid room"))
+
+			return
+		}
+
+		// direct rooms have no members in the storage, their users are in the name
+		member := dm.IsMember(room, userID)
+		if !dm.IsDirect(room) {
+			member, err = attachmentSaver.IsRoomMember(room, userID)
+			if err != nil {
+				log.Error("failed to check room member", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to upload file"))
+
+				return
+			}
+		}
+		if !member {
+			log.Error("user is not a member of the room", slog.String("room", room), slog.Int64("userID", userID))
+
+			render.JSON(w, r, resp.Error("access denied"))
+
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+		name, data, err := readFile(r, maxSize)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || errors.Is(err, errFileTooLarge) {
+			log.Info("file is too large", slog.Int64("maxSize", maxSize))
+
+			render.JSON(w, r, resp.Error("file is too large"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to read file", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("invalid file"))
+
+			return
+		}
+
+		contentType := http.DetectContentType(data)
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if !allowedTypes[mediaType] {
+			log.Info("file type is not allowed", slog.String("contentType", contentType))
+
+			render.JSON(w, r, resp.Error("file type is not allowed"))
+
+			return
+		}
+
+		key, err := newKey()
+		if err != nil {
+			log.Error("failed to generate blob key", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to upload file"))
+
+			return
+		}
+
+		if err := blobs.Put(r.Context(), key, bytes.NewReader(data)); err != nil {
+			log.Error("failed to store file", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to upload file"))
+
+			return
+		}
+
+		attachment := storage.Attachment{
+			Room:        room,
+			UploaderID:  userID,
+			Name:        name,
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			BlobKey:     key,
+			CreatedAt:   time.Now().UTC(),
+		}
+		// the file is usable without a thumbnail, failing to make one isn't an error of the upload
+		if thumbnail.Supported(mediaType) {
+			attachment.Thumbnail = storeThumbnail(r, log, blobs, key, data, mediaType)
+		}
+
+		id, err := attachmentSaver.SaveAttachment(attachment)
+		if err != nil {
+			log.Error("failed to save attachment", sl.Err(err))
+			deleteBlobs(r, log, blobs, attachment)
+
+			render.JSON(w, r, resp.Error("failed to upload file"))
+
+			return
+		}
+		attachment.ID = id
+
+		log.Info("attachment uploaded", slog.Int64("id", id), slog.String("contentType", contentType), slog.Int64("size", attachment.Size))
+
+		render.JSON(w, r, Response{
+			Response:   resp.OK(),
+			Attachment: &attachment,
+		})
+	}
+}
+
+var errFileTooLarge = errors.New("file is too large")
+
+// readFile returns the name and the contents of the file form field.
+func readFile(r *http.Request, maxSize int64) (string, []byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", nil, errors.New("file field is missing")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if part.FormName() != formField {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, maxSize+1))
+		if err != nil {
+			return "", nil, err
+		}
+		if int64(len(data)) > maxSize {
+			return "", nil, errFileTooLarge
+		}
+		if len(data) == 0 {
+			return "", nil, errors.New("file is empty")
+		}
+
+		return fileName(part.FileName()), data, nil
+	}
+}
+
+// fileName strips the directories from the name sent by the client and shortens it to maxNameLength bytes.
+func fileName(name string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, `\`, "/")))
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+
+	for len(name) > maxNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	return name
+}
+
+// storeThumbnail stores the thumbnail of the image under blob.ThumbnailKey and reports whether it did.
+func storeThumbnail(r *http.Request, log *slog.Logger, blobs blob.Store, key string, data []byte, mediaType string) bool {
+	thumb, _, err := thumbnail.Make(data, mediaType)
+	if err != nil {
+		log.Info("failed to make thumbnail", sl.Err(err))
+		return false
+	}
+
+	if err := blobs.Put(r.Context(), blob.ThumbnailKey(key), bytes.NewReader(thumb)); err != nil {
+		log.Error("failed to store thumbnail", sl.Err(err))
+		return false
+	}
+
+	return true
+}
+
+// deleteBlobs removes the stored files of an attachment that couldn't be saved.
+func deleteBlobs(r *http.Request, log *slog.Logger, blobs blob.Store, attachment storage.Attachment) {
+	keys := []string{attachment.BlobKey}
+	if attachment.Thumbnail {
+		keys = append(keys, blob.ThumbnailKey(attachment.BlobKey))
+	}
+
+	for _, key := range keys {
+		if err := blobs.Delete(r.Context(), key); err != nil {
+			log.Error("failed to delete blob", slog.String("key", key), sl.Err(err))
+		}
+	}
+}
+
+// newKey returns a random blob key.
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,224 @@
+package upload_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/blob"
+	"new-websocket-chat/internal/blob/local"
+	"new-websocket-chat/internal/http_server/handlers/attachment/upload"
+	"new-websocket-chat/internal/http_server/handlers/attachment/upload/mocks"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"testing"
+)
+
+func TestUploadHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		room          string
+		field         string
+		fileName      string
+		contents      []byte
+		maxSize       int64
+		checkMember   bool
+		member        bool
+		memberError   error
+		saveError     error
+		respError     string
+		wantType      string
+		wantName      string
+		wantThumbnail bool
+	}{
+		{
+			name:          "Image",
+			room:          "general",
+			fileName:      "cat.png",
+			contents:      testPNG(t, 512, 256),
+			checkMember:   true,
+			member:        true,
+			wantType:      "image/png",
+			wantName:      "cat.png",
+			wantThumbnail: true,
+		},
+		{
+			name:     "Text file in direct room",
+			room:     "dm:3:7",
+			fileName: `C:\Users\bob\notes.txt`,
+			contents: []byte("hello"),
+			wantType: "text/plain; charset=utf-8",
+			wantName: "notes.txt",
+		},
+		{
+			name:        "Not a member",
+			room:        "general",
+			fileName:    "notes.txt",
+			contents:    []byte("hello"),
+			checkMember: true,
+			respError:   "access denied",
+		},
+		{
+			name:      "Direct room of other users",
+			room:      "dm:1:2",
+			fileName:  "notes.txt",
+			contents:  []byte("hello"),
+			respError: "access denied",
+		},
+		{
+			name:        "Type not allowed",
+			room:        "general",
+			fileName:    "page.html",
+			contents:    []byte("<html><body>hi</body></html>"),
+			checkMember: true,
+			member:      true,
+			respError:   "file type is not allowed",
+		},
+		{
+			name:        "Too large",
+			room:        "general",
+			fileName:    "big.txt",
+			contents:    bytes.Repeat([]byte("a"), 1025),
+			maxSize:     1024,
+			checkMember: true,
+			member:      true,
+			respError:   "file is too large",
+		},
+		{
+			name:        "Missing file field",
+			room:        "general",
+			field:       "other",
+			fileName:    "notes.txt",
+			contents:    []byte("hello"),
+			checkMember: true,
+			member:      true,
+			respError:   "invalid file",
+		},
+		{
+			name:        "Member check error",
+			room:        "general",
+			fileName:    "notes.txt",
+			contents:    []byte("hello"),
+			checkMember: true,
+			memberError: errors.New("unexpected error"),
+			respError:   "failed to upload file",
+		},
+		{
+			name:        "Save error",
+			room:        "general",
+			fileName:    "notes.txt",
+			contents:    []byte("hello"),
+			checkMember: true,
+			member:      true,
+			saveError:   errors.New("unexpected error"),
+			respError:   "failed to upload file",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			blobs, err := local.New(t.TempDir())
+			require.NoError(t, err)
+
+			attachmentSaverMock := mocks.NewAttachmentSaver(t)
+			if test.checkMember {
+				attachmentSaverMock.On("IsRoomMember", test.room, int64(3)).
+					Return(test.member, test.memberError).
+					Once()
+			}
+			if test.wantType != "" || test.saveError != nil {
+				attachmentSaverMock.On("SaveAttachment", mock.AnythingOfType("storage.Attachment")).
+					Return(int64(5), test.saveError).
+					Once()
+			}
+
+			maxSize := test.maxSize
+			if maxSize == 0 {
+				maxSize = 1 << 20
+			}
+			handler := upload.New(slogdiscard.NewDiscardLogger(), attachmentSaverMock, blobs, maxSize)
+
+			field := test.field
+			if field == "" {
+				field = "file"
+			}
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			part, err := writer.CreateFormFile(field, test.fileName)
+			require.NoError(t, err)
+			_, err = part.Write(test.contents)
+			require.NoError(t, err)
+			require.NoError(t, writer.Close())
+
+			req, err := http.NewRequest(http.MethodPost, "/rooms/"+test.room+"/attachments", &body)
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("room", test.room)
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx)
+			req = req.WithContext(context.WithValue(ctx, "userID", "3"))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var resp upload.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, test.respError, resp.Error)
+			if test.respError != "" {
+				require.Nil(t, resp.Attachment)
+				return
+			}
+
+			require.Equal(t, int64(5), resp.Attachment.ID)
+			require.Equal(t, test.room, resp.Attachment.Room)
+			require.Equal(t, int64(3), resp.Attachment.UploaderID)
+			require.Equal(t, test.wantName, resp.Attachment.Name)
+			require.Equal(t, test.wantType, resp.Attachment.ContentType)
+			require.Equal(t, int64(len(test.contents)), resp.Attachment.Size)
+			require.Equal(t, test.wantThumbnail, resp.Attachment.Thumbnail)
+
+			saved := attachmentSaverMock.Calls[len(attachmentSaverMock.Calls)-1].Arguments.Get(0).(storage.Attachment)
+			stored, err := blobs.Get(context.Background(), saved.BlobKey)
+			require.NoError(t, err)
+			defer stored.Close()
+			data, err := io.ReadAll(stored)
+			require.NoError(t, err)
+			require.Equal(t, test.contents, data)
+
+			thumb, err := blobs.Get(context.Background(), blob.ThumbnailKey(saved.BlobKey))
+			if !test.wantThumbnail {
+				require.ErrorIs(t, err, blob.ErrNotFound)
+				return
+			}
+			require.NoError(t, err)
+			defer thumb.Close()
+			img, err := png.Decode(thumb)
+			require.NoError(t, err)
+			require.Equal(t, image.Rect(0, 0, 256, 128), img.Bounds())
+		})
+	}
+}
+
+func testPNG(t *testing.T, width int, height int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))))
+
+	return buf.Bytes()
+}
@@ -0,0 +1,197 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"log/slog"
+	"net/http"
+	mwRateLimit "new-websocket-chat/internal/http_server/middleware/ratelimit"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/encryption"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"time"
+)
+
+// errInvalidCredentials is returned both for unknown users and wrong passwords,
+// so the response doesn't reveal whether the account exists.
+const errInvalidCredentials = "invalid credentials"
+
+// dummyPasswordHash is compared with the password when the user doesn't exist,
+// so the response time doesn't reveal whether the account exists either.
+var dummyPasswordHash, _ = encryption.EncryptPassword("dummy password for unknown users")
+
+// Request defines the required information to log in.
+type Request struct {
+	Login    string `json:"login" validate:"required,max=254"`   // Username or email of the user
+	Password string `json:"password" validate:"required,max=72"` // Password of the user
+}
+
+// Response defines the response payload for the login request.
+type Response struct {
+	resp.Response          // Embedding the common response struct
+	Username        string `json:"username,omitempty"` // Username of the logged in user
+	JWTAccessToken  string `json:"jwtAccessToken"`     // Access JWT token for the user
+	JWTRefreshToken string `json:"jwtRefreshToken"`    // Refresh JWT token for the user
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=UserProvider
+type UserProvider interface {
+	GetUserByLogin(login string) (storage.User, error)
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=TokenIssuer
+type TokenIssuer interface {
+	IssueTokens(userID int64) (accessTokenString string, refreshTokenString string, err error)
+}
+
+// LoginGuard locks out the logins with too many wrong passwords in a row, see ratelimit.Guard.
+//
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=LoginGuard
+type LoginGuard interface {
+	Check(ctx context.Context, key string) (time.Duration, error)
+	Fail(ctx context.Context, key string) (time.Duration, error)
+	Succeed(ctx context.Context, key string) error
+}
+
+// @Summary Log in
+// @Description Verifies the password of the user found by username or email and issues a new pair of JWT tokens.
+// @Description Logins failing too many times in a row are locked out for a time doubling with every further failure.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body login.Request true "User Credentials"
+// @Success 200 {object} login.Response "Successfully logged in and generated JWT tokens"
+// @Failure 400 {object} login.Response "Bad Request with details"
+// @Failure 429 {object} resp.Response "Too many failed logins, retry after the Retry-After header"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /auth/login [post]
+func New(log *slog.Logger, userProvider UserProvider, tokenIssuer TokenIssuer, guard LoginGuard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.login.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		var req Request
+
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to decode request"))
+
+			return
+		}
+
+		if err := validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+
+			log.Error("invalid request to validator", sl.Err(err))
+
+			render.JSON(w, r, resp.ValidationError(validateErr))
+
+			return
+		}
+
+		user, err := userProvider.GetUserByLogin(req.Login)
+		found := err == nil
+		if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+			log.Error("failed to get user", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to log in"))
+
+			return
+		}
+
+		// failures count against the account whichever login named it, unknown logins are locked out as well,
+		// so the lockout doesn't reveal whether the account exists
+		key := mwRateLimit.AccountKey(user.ID, req.Login)
+
+		locked, err := guard.Check(r.Context(), key)
+		if err != nil {
+			log.Error("failed to check login lockout", sl.Err(err))
+		}
+		if locked > 0 {
+			log.Info("login is locked out", slog.String("login", req.Login), slog.Duration("for", locked))
+
+			mwRateLimit.TooManyRequests(w, r, locked)
+
+			return
+		}
+
+		if !found {
+			_ = encryption.ComparePassword(dummyPasswordHash, req.Password)
+
+			log.Info("user not found", slog.String("login", req.Login))
+
+			fail(r.Context(), log, guard, key)
+
+			render.JSON(w, r, resp.Error(errInvalidCredentials))
+
+			return
+		}
+
+		if err := encryption.ComparePassword(user.PasswordHash, req.Password); err != nil {
+			log.Info("wrong password", slog.Int64("id", user.ID))
+
+			fail(r.Context(), log, guard, key)
+
+			render.JSON(w, r, resp.Error(errInvalidCredentials))
+
+			return
+		}
+
+		jwtUserAccessToken, jwtUserRefreshToken, err := tokenIssuer.IssueTokens(user.ID)
+		if err != nil {
+			log.Error("failed to generate json web token for user id", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to generate jwt token"))
+
+			return
+		}
+
+		if err := guard.Succeed(r.Context(), key); err != nil {
+			log.Error("failed to reset login lockout", sl.Err(err))
+		}
+
+		log.Info("user logged in", slog.Int64("id", user.ID))
+
+		responseOK(w, r, user.Username, jwtUserAccessToken, jwtUserRefreshToken)
+	}
+}
+
+// fail records the failed login, the failure is logged only as the credentials are rejected anyway.
+func fail(ctx context.Context, log *slog.Logger, guard LoginGuard, key string) {
+	locked, err := guard.Fail(ctx, key)
+	if err != nil {
+		log.Error("failed to record failed login", sl.Err(err))
+		return
+	}
+	if locked > 0 {
+		log.Warn("login locked out", slog.String("login", key), slog.Duration("for", locked))
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, username string, jwtUserAccessToken string, jwtUserRefreshToken string) {
+	render.JSON(w, r, Response{
+		Response:        resp.OK(),
+		Username:        username,
+		JWTAccessToken:  jwtUserAccessToken,
+		JWTRefreshToken: jwtUserRefreshToken,
+	})
+}
@@ -0,0 +1,201 @@
+package login_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/http_server/handlers/auth/login"
+	"new-websocket-chat/internal/http_server/handlers/auth/login/mocks"
+	"new-websocket-chat/internal/lib/encryption"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginHandler(t *testing.T) {
+	passwordHash, err := encryption.EncryptPassword("Abdrahman_02!")
+	require.NoError(t, err)
+
+	user := storage.User{
+		ID:           1,
+		Username:     "AbdraBlya",
+		Email:        "dininchesterrr25@gmail.com",
+		PasswordHash: passwordHash,
+	}
+
+	tests := []struct {
+		name      string
+		login     string
+		password  string
+		respError string
+		mockUser  storage.User
+		mockError error
+		// lockout of the login returned by the guard
+		locked     time.Duration
+		guardError error
+		// failure recorded when the credentials are rejected
+		fail       bool
+		retryAfter string
+	}{
+		{
+			name:     "Success with username",
+			login:    "AbdraBlya",
+			password: "Abdrahman_02!",
+			mockUser: user,
+		},
+		{
+			name:     "Success with email",
+			login:    "dininchesterrr25@gmail.com",
+			password: "Abdrahman_02!",
+			mockUser: user,
+		},
+		{
+			name:      "Wrong password",
+			login:     "AbdraBlya",
+			password:  "Abdrahman_03!",
+			respError: "invalid credentials",
+			mockUser:  user,
+			fail:      true,
+		},
+		{
+			name:      "Unknown user",
+			login:     "AbdraBlyaaaaa",
+			password:  "Abdrahman_02!",
+			respError: "invalid credentials",
+			mockError: storage.ErrUserNotFound,
+			fail:      true,
+		},
+		{
+			name:      "Empty login",
+			login:     "",
+			password:  "Abdrahman_02!",
+			respError: "field Login is a required field",
+		},
+		{
+			name:      "Empty password",
+			login:     "AbdraBlya",
+			password:  "",
+			respError: "field Password is a required field",
+		},
+		{
+			name:      "GetUserByLogin Error",
+			login:     "AbdraBlya",
+			password:  "Abdrahman_02!",
+			respError: "failed to log in",
+			mockError: errors.New("unexpected error"),
+		},
+		{
+			name:       "Locked out",
+			login:      "AbdraBlya",
+			password:   "Abdrahman_02!",
+			respError:  "too many requests",
+			mockUser:   user,
+			locked:     29500 * time.Millisecond,
+			retryAfter: "30",
+		},
+		{
+			name:       "Locked out by email",
+			login:      "dininchesterrr25@gmail.com",
+			password:   "Abdrahman_02!",
+			respError:  "too many requests",
+			mockUser:   user,
+			locked:     time.Second,
+			retryAfter: "1",
+		},
+		{
+			name:       "Unknown user locked out",
+			login:      "AbdraBlyaaaaa",
+			password:   "Abdrahman_02!",
+			respError:  "too many requests",
+			mockError:  storage.ErrUserNotFound,
+			locked:     time.Second,
+			retryAfter: "1",
+		},
+		{
+			name:       "Lockout store error",
+			login:      " abdrablya",
+			password:   "Abdrahman_02!",
+			mockUser:   user,
+			guardError: errors.New("unexpected error"),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			userProviderMock := mocks.NewUserProvider(t)
+			tokenIssuerMock := mocks.NewTokenIssuer(t)
+			loginGuardMock := mocks.NewLoginGuard(t)
+
+			// failures of known users count against the account, whichever login named it
+			key := "user:1"
+			if errors.Is(test.mockError, storage.ErrUserNotFound) {
+				key = "login:" + strings.ToLower(strings.TrimSpace(test.login))
+			}
+
+			if test.login != "" && test.password != "" {
+				userProviderMock.On("GetUserByLogin", test.login).
+					Return(test.mockUser, test.mockError).
+					Once()
+			}
+
+			if test.login != "" && test.password != "" && (test.mockError == nil || errors.Is(test.mockError, storage.ErrUserNotFound)) {
+				loginGuardMock.On("Check", mock.Anything, key).
+					Return(test.locked, test.guardError).
+					Once()
+			}
+
+			if test.fail {
+				loginGuardMock.On("Fail", mock.Anything, key).
+					Return(time.Duration(0), nil).
+					Once()
+			}
+
+			if test.respError == "" {
+				tokenIssuerMock.On("IssueTokens", user.ID).
+					Return("access_token", "refresh_token", nil).
+					Once()
+				loginGuardMock.On("Succeed", mock.Anything, key).
+					Return(nil).
+					Once()
+			}
+
+			handler := login.New(slogdiscard.NewDiscardLogger(), userProviderMock, tokenIssuerMock, loginGuardMock)
+
+			input := fmt.Sprintf(`{"login": "%s", "password": "%s"}`, test.login, test.password)
+
+			req, err := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader([]byte(input)))
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if test.retryAfter != "" {
+				require.Equal(t, http.StatusTooManyRequests, rr.Code)
+				require.Equal(t, test.retryAfter, rr.Header().Get("Retry-After"))
+			} else {
+				require.Equal(t, rr.Code, http.StatusOK)
+			}
+
+			var resp login.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, test.respError, resp.Error)
+			if test.respError == "" {
+				require.Equal(t, user.Username, resp.Username)
+				require.NotEmpty(t, resp.JWTAccessToken)
+				require.NotEmpty(t, resp.JWTRefreshToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	storage "new-websocket-chat/internal/storage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// UserProvider is an autogenerated mock type for the UserProvider type
+type UserProvider struct {
+	mock.Mock
+}
+
+// GetUserByLogin provides a mock function with given fields: _a0
+func (_m *UserProvider) GetUserByLogin(_a0 string) (storage.User, error) {
+	ret := _m.Called(_a0)
+
+	var r0 storage.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (storage.User, error)); ok {
+		return rf(_a0)
+	}
+	if rf, ok := ret.Get(0).(func(string) storage.User); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(storage.User)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewUserProvider creates a new instance of UserProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewUserProvider(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *UserProvider {
+	mock := &UserProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,141 @@
+package logout
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	resp "new-websocket-chat/internal/lib/api/response"
+	jwtAuth "new-websocket-chat/internal/lib/jwt"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+)
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=SessionRevoker
+type SessionRevoker interface {
+	ExtractToken(r *http.Request) (string, error)
+	ValidateToken(tokenString string) (*jwtAuth.Claims, error)
+	RevokeSession(userID int64, refreshTokenString string) error
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=AllSessionsRevoker
+type AllSessionsRevoker interface {
+	RevokeAllSessions(userID int64) error
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=UserDisconnecter
+type UserDisconnecter interface {
+	DisconnectUser(userID int64)
+}
+
+// @Summary Log out
+// @Description Ends the session of the presented refresh token, every refresh token issued since the login is revoked.
+// @Tags auth
+// @Produce json
+// @Param token query string true "Refresh Token"
+// @Success 200 {object} resp.Response "Successfully logged out"
+// @Failure 400 {object} resp.Response "Invalid refresh token"
+// @Failure 429 {string} string "Too many requests, retry after the Retry-After header"
+// @Router /auth/logout [post]
+func New(log *slog.Logger, sessionRevoker SessionRevoker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.logout.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		refreshToken, err := sessionRevoker.ExtractToken(r)
+		if err != nil {
+			log.Error("failed to extract token from request", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to extract token"))
+
+			return
+		}
+
+		claims, err := sessionRevoker.ValidateToken(refreshToken)
+		if err != nil || claims.TokenType != jwtAuth.TokenTypeRefresh {
+			log.Error("invalid refresh token")
+
+			render.JSON(w, r, resp.Error("invalid refresh token"))
+
+			return
+		}
+
+		userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+		if err != nil {
+			log.Error("failed to parse userID to int64", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("invalid refresh token"))
+
+			return
+		}
+
+		err = sessionRevoker.RevokeSession(userID, refreshToken)
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Error("refresh token is not issued to the user", slog.Int64("userID", userID))
+
+			render.JSON(w, r, resp.Error("invalid refresh token"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to revoke session", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to log out"))
+
+			return
+		}
+
+		log.Info("user logged out", slog.Int64("userID", userID))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// @Summary Log out of all devices
+// @Description Revokes every refresh token of the user and closes all of the user's websocket connections. Issued access tokens stay valid until they expire.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} resp.Response "Successfully logged out of all devices"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /auth/logout/all [post]
+func NewAll(log *slog.Logger, allSessionsRevoker AllSessionsRevoker, userDisconnecter UserDisconnecter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.auth.logout.NewAll"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("userID is missing in request context", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		if err := allSessionsRevoker.RevokeAllSessions(userID); err != nil {
+			log.Error("failed to revoke sessions", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to log out"))
+
+			return
+		}
+
+		userDisconnecter.DisconnectUser(userID)
+
+		log.Info("user logged out of all devices", slog.Int64("userID", userID))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
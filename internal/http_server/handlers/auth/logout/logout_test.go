@@ -0,0 +1,176 @@
+package logout_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/http_server/handlers/auth/logout"
+	"new-websocket-chat/internal/http_server/handlers/auth/logout/mocks"
+	resp "new-websocket-chat/internal/lib/api/response"
+	jwtAuth "new-websocket-chat/internal/lib/jwt"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"testing"
+)
+
+func TestLogoutHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		respError string
+		setupMock func(*mocks.SessionRevoker)
+	}{
+		{
+			name: "Success",
+			setupMock: func(m *mocks.SessionRevoker) {
+				m.On("ExtractToken", mock.Anything).Return("refresh_token", nil)
+				m.On("ValidateToken", "refresh_token").Return(testClaims("1", jwtAuth.TokenTypeRefresh), nil)
+				m.On("RevokeSession", int64(1), "refresh_token").Return(nil)
+			},
+		},
+		{
+			name:      "Token Extraction Failure",
+			respError: "failed to extract token",
+			setupMock: func(m *mocks.SessionRevoker) {
+				m.On("ExtractToken", mock.Anything).Return("", fmt.Errorf("failed to extract token"))
+			},
+		},
+		{
+			name:      "Invalid Token",
+			respError: "invalid refresh token",
+			setupMock: func(m *mocks.SessionRevoker) {
+				m.On("ExtractToken", mock.Anything).Return("refresh_token", nil)
+				m.On("ValidateToken", "refresh_token").Return(nil, fmt.Errorf("invalid token"))
+			},
+		},
+		{
+			name:      "Access Token",
+			respError: "invalid refresh token",
+			setupMock: func(m *mocks.SessionRevoker) {
+				m.On("ExtractToken", mock.Anything).Return("access_token", nil)
+				m.On("ValidateToken", "access_token").Return(testClaims("1", jwtAuth.TokenTypeAccess), nil)
+			},
+		},
+		{
+			name:      "Unknown Token",
+			respError: "invalid refresh token",
+			setupMock: func(m *mocks.SessionRevoker) {
+				m.On("ExtractToken", mock.Anything).Return("refresh_token", nil)
+				m.On("ValidateToken", "refresh_token").Return(testClaims("1", jwtAuth.TokenTypeRefresh), nil)
+				m.On("RevokeSession", int64(1), "refresh_token").Return(fmt.Errorf("revoke: %w", storage.ErrTokenNotFound))
+			},
+		},
+		{
+			name:      "RevokeSession Error",
+			respError: "failed to log out",
+			setupMock: func(m *mocks.SessionRevoker) {
+				m.On("ExtractToken", mock.Anything).Return("refresh_token", nil)
+				m.On("ValidateToken", "refresh_token").Return(testClaims("1", jwtAuth.TokenTypeRefresh), nil)
+				m.On("RevokeSession", int64(1), "refresh_token").Return(errors.New("unexpected error"))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			sessionRevokerMock := mocks.NewSessionRevoker(t)
+			test.setupMock(sessionRevokerMock)
+
+			handler := logout.New(slogdiscard.NewDiscardLogger(), sessionRevokerMock)
+
+			req, err := http.NewRequest(http.MethodPost, "/auth/logout", nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var response resp.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+			require.Equal(t, test.respError, response.Error)
+		})
+	}
+}
+
+func TestLogoutAllHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     any
+		respError  string
+		mockError  error
+		disconnect bool
+	}{
+		{
+			name:       "Success",
+			userID:     "1",
+			disconnect: true,
+		},
+		{
+			name:      "Missing userID",
+			userID:    nil,
+			respError: "unauthorized",
+		},
+		{
+			name:      "RevokeAllSessions Error",
+			userID:    "1",
+			respError: "failed to log out",
+			mockError: errors.New("unexpected error"),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			allSessionsRevokerMock := mocks.NewAllSessionsRevoker(t)
+			userDisconnecterMock := mocks.NewUserDisconnecter(t)
+
+			if test.userID != nil {
+				allSessionsRevokerMock.On("RevokeAllSessions", int64(1)).
+					Return(test.mockError).
+					Once()
+			}
+			if test.disconnect {
+				userDisconnecterMock.On("DisconnectUser", int64(1)).Once()
+			}
+
+			handler := logout.NewAll(slogdiscard.NewDiscardLogger(), allSessionsRevokerMock, userDisconnecterMock)
+
+			req, err := http.NewRequest(http.MethodPost, "/auth/logout/all", nil)
+			require.NoError(t, err)
+			if test.userID != nil {
+				req = req.WithContext(context.WithValue(req.Context(), "userID", test.userID))
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var response resp.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+			require.Equal(t, test.respError, response.Error)
+		})
+	}
+}
+
+func testClaims(subject string, tokenType string) *jwtAuth.Claims {
+	return &jwtAuth.Claims{
+		StandardClaims: jwt.StandardClaims{Subject: subject},
+		TokenType:      tokenType,
+	}
+}
@@ -0,0 +1,38 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import mock "github.com/stretchr/testify/mock"
+
+// AllSessionsRevoker is an autogenerated mock type for the AllSessionsRevoker type
+type AllSessionsRevoker struct {
+	mock.Mock
+}
+
+// RevokeAllSessions provides a mock function with given fields: userID
+func (_m *AllSessionsRevoker) RevokeAllSessions(userID int64) error {
+	ret := _m.Called(userID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int64) error); ok {
+		r0 = rf(userID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// NewAllSessionsRevoker creates a new instance of AllSessionsRevoker. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewAllSessionsRevoker(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *AllSessionsRevoker {
+	mock := &AllSessionsRevoker{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,93 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	http "net/http"
+	jwtAuth "new-websocket-chat/internal/lib/jwt"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// SessionRevoker is an autogenerated mock type for the SessionRevoker type
+type SessionRevoker struct {
+	mock.Mock
+}
+
+// ExtractToken provides a mock function with given fields: r
+func (_m *SessionRevoker) ExtractToken(r *http.Request) (string, error) {
+	ret := _m.Called(r)
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(*http.Request) (string, error)); ok {
+		return rf(r)
+	}
+	if rf, ok := ret.Get(0).(func(*http.Request) string); ok {
+		r0 = rf(r)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(*http.Request) error); ok {
+		r1 = rf(r)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RevokeSession provides a mock function with given fields: userID, refreshTokenString
+func (_m *SessionRevoker) RevokeSession(userID int64, refreshTokenString string) error {
+	ret := _m.Called(userID, refreshTokenString)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int64, string) error); ok {
+		r0 = rf(userID, refreshTokenString)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ValidateToken provides a mock function with given fields: tokenString
+func (_m *SessionRevoker) ValidateToken(tokenString string) (*jwtAuth.Claims, error) {
+	ret := _m.Called(tokenString)
+
+	var r0 *jwtAuth.Claims
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (*jwtAuth.Claims, error)); ok {
+		return rf(tokenString)
+	}
+	if rf, ok := ret.Get(0).(func(string) *jwtAuth.Claims); ok {
+		r0 = rf(tokenString)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jwtAuth.Claims)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(tokenString)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewSessionRevoker creates a new instance of SessionRevoker. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewSessionRevoker(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *SessionRevoker {
+	mock := &SessionRevoker{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
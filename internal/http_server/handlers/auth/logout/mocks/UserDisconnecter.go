@@ -0,0 +1,29 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import mock "github.com/stretchr/testify/mock"
+
+// UserDisconnecter is an autogenerated mock type for the UserDisconnecter type
+type UserDisconnecter struct {
+	mock.Mock
+}
+
+// DisconnectUser provides a mock function with given fields: userID
+func (_m *UserDisconnecter) DisconnectUser(userID int64) {
+	_m.Called(userID)
+}
+
+// NewUserDisconnecter creates a new instance of UserDisconnecter. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewUserDisconnecter(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *UserDisconnecter {
+	mock := &UserDisconnecter{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
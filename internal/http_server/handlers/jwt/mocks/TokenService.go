@@ -4,8 +4,8 @@ package mocks
 
 import (
 	http "net/http"
+	jwtAuth "new-websocket-chat/internal/lib/jwt"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -38,30 +38,30 @@ func (_m *TokenService) ExtractToken(r *http.Request) (string, error) {
 	return r0, r1
 }
 
-// GenerateTokens provides a mock function with given fields: userID
-func (_m *TokenService) GenerateTokens(userID int64) (string, string, error) {
-	ret := _m.Called(userID)
+// RotateTokens provides a mock function with given fields: userID, refreshTokenString
+func (_m *TokenService) RotateTokens(userID int64, refreshTokenString string) (string, string, error) {
+	ret := _m.Called(userID, refreshTokenString)
 
 	var r0 string
 	var r1 string
 	var r2 error
-	if rf, ok := ret.Get(0).(func(int64) (string, string, error)); ok {
-		return rf(userID)
+	if rf, ok := ret.Get(0).(func(int64, string) (string, string, error)); ok {
+		return rf(userID, refreshTokenString)
 	}
-	if rf, ok := ret.Get(0).(func(int64) string); ok {
-		r0 = rf(userID)
+	if rf, ok := ret.Get(0).(func(int64, string) string); ok {
+		r0 = rf(userID, refreshTokenString)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
-	if rf, ok := ret.Get(1).(func(int64) string); ok {
-		r1 = rf(userID)
+	if rf, ok := ret.Get(1).(func(int64, string) string); ok {
+		r1 = rf(userID, refreshTokenString)
 	} else {
 		r1 = ret.Get(1).(string)
 	}
 
-	if rf, ok := ret.Get(2).(func(int64) error); ok {
-		r2 = rf(userID)
+	if rf, ok := ret.Get(2).(func(int64, string) error); ok {
+		r2 = rf(userID, refreshTokenString)
 	} else {
 		r2 = ret.Error(2)
 	}
@@ -70,19 +70,19 @@ func (_m *TokenService) GenerateTokens(userID int64) (string, string, error) {
 }
 
 // ValidateToken provides a mock function with given fields: tokenString
-func (_m *TokenService) ValidateToken(tokenString string) (*jwt.StandardClaims, error) {
+func (_m *TokenService) ValidateToken(tokenString string) (*jwtAuth.Claims, error) {
 	ret := _m.Called(tokenString)
 
-	var r0 *jwt.StandardClaims
+	var r0 *jwtAuth.Claims
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string) (*jwt.StandardClaims, error)); ok {
+	if rf, ok := ret.Get(0).(func(string) (*jwtAuth.Claims, error)); ok {
 		return rf(tokenString)
 	}
-	if rf, ok := ret.Get(0).(func(string) *jwt.StandardClaims); ok {
+	if rf, ok := ret.Get(0).(func(string) *jwtAuth.Claims); ok {
 		r0 = rf(tokenString)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*jwt.StandardClaims)
+			r0 = ret.Get(0).(*jwtAuth.Claims)
 		}
 	}
 
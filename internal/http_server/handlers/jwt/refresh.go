@@ -1,13 +1,15 @@
 package refresh
 
 import (
-	"github.com/dgrijalva/jwt-go"
+	"errors"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"log/slog"
 	"net/http"
 	resp "new-websocket-chat/internal/lib/api/response"
+	jwtAuth "new-websocket-chat/internal/lib/jwt"
 	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
 	"strconv"
 )
 
@@ -21,25 +23,27 @@ type Response struct {
 //go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=TokenService
 type TokenService interface {
 	ExtractToken(r *http.Request) (string, error)
-	ValidateToken(tokenString string) (*jwt.StandardClaims, error)
-	GenerateTokens(userID int64) (accessTokenString string, refreshTokenString string, err error)
+	ValidateToken(tokenString string) (*jwtAuth.Claims, error)
+	RotateTokens(userID int64, refreshTokenString string) (accessTokenString string, newRefreshTokenString string, err error)
 }
 
 // @Summary Refresh JWT Tokens
-// @Description Refreshes the JWT access and refresh tokens for a user.
+// @Description Refreshes the JWT access and refresh tokens for a user. The presented refresh token is revoked,
+// @Description presenting it again revokes every token issued since the login.
 // @Tags jwt
 // @Accept json
 // @Produce json
 // @Param refreshToken query string true "Refresh Token"
 // @Success 200 {object} refresh.Response "Successfully refreshed JWT tokens"
 // @Failure 400 {string} string "Invalid refresh token"
+// @Failure 429 {string} string "Too many requests, retry after the Retry-After header"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /api/jwt/refresh [post]
 func New(log *slog.Logger, tokenService TokenService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.jwt.refresh.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
@@ -53,8 +57,6 @@ func New(log *slog.Logger, tokenService TokenService) http.HandlerFunc {
 			return
 		}
 
-		log.Info("refreshToken extracted", slog.String("refreshToken", refreshToken))
-
 		claims, err := tokenService.ValidateToken(refreshToken)
 		if err != nil {
 			log.Error("Invalid refresh token", sl.Err(err))
@@ -64,7 +66,13 @@ func New(log *slog.Logger, tokenService TokenService) http.HandlerFunc {
 			return
 		}
 
-		log.Info("refreshToken validated", slog.String("refreshToken", refreshToken))
+		if claims.TokenType != jwtAuth.TokenTypeRefresh {
+			log.Error("Token is not a refresh token", slog.String("token_type", claims.TokenType))
+
+			render.JSON(w, r, resp.Error("Invalid refresh token"))
+
+			return
+		}
 
 		userID, err := strconv.ParseInt(claims.Subject, 10, 64)
 		if err != nil {
@@ -76,7 +84,21 @@ func New(log *slog.Logger, tokenService TokenService) http.HandlerFunc {
 		}
 		log.Info("userID parsed from string to int64", slog.Int64("userID", userID))
 
-		newAccessToken, newRefreshToken, err := tokenService.GenerateTokens(userID)
+		newAccessToken, newRefreshToken, err := tokenService.RotateTokens(userID, refreshToken)
+		if errors.Is(err, jwtAuth.ErrTokenReused) {
+			log.Warn("Refresh token reuse detected, token family revoked", slog.Int64("userID", userID))
+
+			render.JSON(w, r, resp.Error("Invalid refresh token"))
+
+			return
+		}
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Error("Refresh token is not issued to the user", slog.Int64("userID", userID))
+
+			render.JSON(w, r, resp.Error("Invalid refresh token"))
+
+			return
+		}
 		if err != nil {
 			log.Error("Failed to generate new access token", sl.Err(err))
 
@@ -84,8 +106,7 @@ func New(log *slog.Logger, tokenService TokenService) http.HandlerFunc {
 
 			return
 		}
-		log.Info("new access token generated", slog.String("newAccessToken", newAccessToken)) // TODO: remove due to security issues
-		log.Info("new refresh token generated", slog.String("newAccessToken", newRefreshToken))
+		log.Info("refresh token rotated", slog.Int64("userID", userID))
 
 		responseOK(w, r, newAccessToken, newRefreshToken)
 
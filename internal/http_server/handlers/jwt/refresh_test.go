@@ -10,7 +10,9 @@ import (
 	"net/http/httptest"
 	refresh "new-websocket-chat/internal/http_server/handlers/jwt"
 	"new-websocket-chat/internal/http_server/handlers/jwt/mocks"
+	jwtAuth "new-websocket-chat/internal/lib/jwt"
 	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
 	"os"
 	"strconv"
 	"testing"
@@ -23,6 +25,7 @@ func TestRefreshHandler(t *testing.T) {
 		tokenFunc      func(int64) (string, error)
 		expectError    bool
 		expectedStatus int
+		respError      string
 		setupMock      func(*mocks.TokenService, string, int64)
 	}{
 		{
@@ -32,8 +35,8 @@ func TestRefreshHandler(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			setupMock: func(m *mocks.TokenService, token string, userID int64) {
 				m.On("ExtractToken", mock.Anything).Return(token, nil)
-				m.On("ValidateToken", token).Return(&jwt.StandardClaims{Subject: strconv.FormatInt(userID, 10)}, nil)
-				m.On("GenerateTokens", userID).Return("new_access_token", "new_refresh_token", nil)
+				m.On("ValidateToken", token).Return(testClaims(strconv.FormatInt(userID, 10), jwtAuth.TokenTypeRefresh), nil)
+				m.On("RotateTokens", userID, token).Return("new_access_token", "new_refresh_token", nil)
 			},
 		},
 		{
@@ -41,6 +44,7 @@ func TestRefreshHandler(t *testing.T) {
 			tokenFunc:      generateExpiredTestRefreshToken,
 			expectError:    true,
 			expectedStatus: http.StatusUnauthorized,
+			respError:      "Invalid refresh token",
 			setupMock: func(m *mocks.TokenService, token string, userID int64) {
 				m.On("ExtractToken", mock.Anything).Return(token, nil)
 				m.On("ValidateToken", token).Return(nil, fmt.Errorf("invalid or expired token"))
@@ -51,6 +55,7 @@ func TestRefreshHandler(t *testing.T) {
 			tokenFunc:      generateTestRefreshToken,
 			expectError:    true,
 			expectedStatus: http.StatusUnauthorized,
+			respError:      "Failed to extract token",
 			setupMock: func(m *mocks.TokenService, token string, userID int64) {
 				m.On("ExtractToken", mock.Anything).Return("", fmt.Errorf("failed to extract token"))
 			},
@@ -60,19 +65,56 @@ func TestRefreshHandler(t *testing.T) {
 			tokenFunc:      generateTestRefreshToken,
 			expectError:    true,
 			expectedStatus: http.StatusUnauthorized,
+			respError:      "Invalid refresh token",
 			setupMock: func(m *mocks.TokenService, token string, userID int64) {
 				m.On("ExtractToken", mock.Anything).Return(token, nil)
 				m.On("ValidateToken", token).Return(nil, fmt.Errorf("invalid token"))
 			},
 		},
+		{
+			name:           "Access Token Instead Of Refresh Token",
+			tokenFunc:      generateTestRefreshToken,
+			expectError:    true,
+			expectedStatus: http.StatusUnauthorized,
+			respError:      "Invalid refresh token",
+			setupMock: func(m *mocks.TokenService, token string, userID int64) {
+				m.On("ExtractToken", mock.Anything).Return(token, nil)
+				m.On("ValidateToken", token).Return(testClaims(strconv.FormatInt(userID, 10), jwtAuth.TokenTypeAccess), nil)
+			},
+		},
 		{
 			name:           "User ID Parsing Error",
 			tokenFunc:      generateTestRefreshToken,
 			expectError:    true,
 			expectedStatus: http.StatusBadRequest,
+			respError:      "Failed to parse userID to int64",
+			setupMock: func(m *mocks.TokenService, token string, userID int64) {
+				m.On("ExtractToken", mock.Anything).Return(token, nil)
+				m.On("ValidateToken", token).Return(testClaims("invalid", jwtAuth.TokenTypeRefresh), nil)
+			},
+		},
+		{
+			name:           "Reused Token",
+			tokenFunc:      generateTestRefreshToken,
+			expectError:    true,
+			expectedStatus: http.StatusUnauthorized,
+			respError:      "Invalid refresh token",
+			setupMock: func(m *mocks.TokenService, token string, userID int64) {
+				m.On("ExtractToken", mock.Anything).Return(token, nil)
+				m.On("ValidateToken", token).Return(testClaims(strconv.FormatInt(userID, 10), jwtAuth.TokenTypeRefresh), nil)
+				m.On("RotateTokens", userID, token).Return("", "", fmt.Errorf("rotate: %w", jwtAuth.ErrTokenReused))
+			},
+		},
+		{
+			name:           "Unknown Token",
+			tokenFunc:      generateTestRefreshToken,
+			expectError:    true,
+			expectedStatus: http.StatusUnauthorized,
+			respError:      "Invalid refresh token",
 			setupMock: func(m *mocks.TokenService, token string, userID int64) {
 				m.On("ExtractToken", mock.Anything).Return(token, nil)
-				m.On("ValidateToken", token).Return(&jwt.StandardClaims{Subject: "invalid"}, nil)
+				m.On("ValidateToken", token).Return(testClaims(strconv.FormatInt(userID, 10), jwtAuth.TokenTypeRefresh), nil)
+				m.On("RotateTokens", userID, token).Return("", "", fmt.Errorf("rotate: %w", storage.ErrTokenNotFound))
 			},
 		},
 		{
@@ -80,10 +122,11 @@ func TestRefreshHandler(t *testing.T) {
 			tokenFunc:      generateTestRefreshToken,
 			expectError:    true,
 			expectedStatus: http.StatusInternalServerError,
+			respError:      "Failed to generate new access token",
 			setupMock: func(m *mocks.TokenService, token string, userID int64) {
 				m.On("ExtractToken", mock.Anything).Return(token, nil)
-				m.On("ValidateToken", token).Return(&jwt.StandardClaims{Subject: strconv.FormatInt(userID, 10)}, nil)
-				m.On("GenerateTokens", userID).Return("", "", fmt.Errorf("token generation failed"))
+				m.On("ValidateToken", token).Return(testClaims(strconv.FormatInt(userID, 10), jwtAuth.TokenTypeRefresh), nil)
+				m.On("RotateTokens", userID, token).Return("", "", fmt.Errorf("token generation failed"))
 			},
 		},
 	}
@@ -107,13 +150,15 @@ func TestRefreshHandler(t *testing.T) {
 			rr := httptest.NewRecorder()
 			handler.ServeHTTP(rr, req)
 
+			var resp refresh.Response
+			err = json.Unmarshal(rr.Body.Bytes(), &resp)
+			require.NoError(t, err, "Error unmarshalling response body")
+
 			if test.expectError {
 				require.NotEqual(t, test.expectedStatus, rr.Code)
+				require.Equal(t, test.respError, resp.Error)
 			} else {
 				require.Equal(t, rr.Code, http.StatusOK)
-				var resp refresh.Response
-				err = json.Unmarshal(rr.Body.Bytes(), &resp)
-				require.NoError(t, err, "Error unmarshalling response body")
 				require.NotEmpty(t, resp.JWTAccessToken, "JWTAccessToken should not be empty")
 				require.NotEmpty(t, resp.JWTRefreshToken, "JWTRefreshToken should not be empty")
 			}
@@ -121,6 +166,13 @@ func TestRefreshHandler(t *testing.T) {
 	}
 }
 
+func testClaims(subject string, tokenType string) *jwtAuth.Claims {
+	return &jwtAuth.Claims{
+		StandardClaims: jwt.StandardClaims{Subject: subject},
+		TokenType:      tokenType,
+	}
+}
+
 func generateTestRefreshToken(userID int64) (testRefreshToken string, err error) {
 	const op = "internal.http_server.handlers.jwt.generateTestToken"
 
@@ -0,0 +1,54 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	mock "github.com/stretchr/testify/mock"
+
+	storage "new-websocket-chat/internal/storage"
+)
+
+// MessageSearcher is an autogenerated mock type for the MessageSearcher type
+type MessageSearcher struct {
+	mock.Mock
+}
+
+// SearchMessages provides a mock function with given fields: query
+func (_m *MessageSearcher) SearchMessages(query storage.SearchQuery) ([]storage.Message, error) {
+	ret := _m.Called(query)
+
+	var r0 []storage.Message
+	var r1 error
+	if rf, ok := ret.Get(0).(func(storage.SearchQuery) ([]storage.Message, error)); ok {
+		return rf(query)
+	}
+	if rf, ok := ret.Get(0).(func(storage.SearchQuery) []storage.Message); ok {
+		r0 = rf(query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.Message)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(storage.SearchQuery) error); ok {
+		r1 = rf(query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewMessageSearcher creates a new instance of MessageSearcher. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMessageSearcher(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MessageSearcher {
+	mock := &MessageSearcher{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,197 @@
+package search
+
+import (
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/lib/search"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLimit   = 20
+	maxLimit       = 100
+	maxQueryLength = 200
+)
+
+// Result is a message matching the search with the highlighted part of its body.
+type Result struct {
+	Message storage.Message `json:"message"`
+	Snippet string          `json:"snippet"` // HTML escaped part of the body around the first match, matches wrapped in <mark>
+}
+
+// Response defines the response payload for the message search request.
+type Response struct {
+	resp.Response          // Embedding the common response struct
+	Results       []Result `json:"results"`              // Matching messages, newest first
+	NextCursor    int64    `json:"nextCursor,omitempty"` // Value of the before parameter to load older results, empty if there are no more results
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=MessageSearcher
+type MessageSearcher interface {
+	SearchMessages(query storage.SearchQuery) ([]storage.Message, error)
+}
+
+// @Summary Search messages
+// @Description Returns the messages containing every word of the query, newest first. Pass nextCursor of the previous page as before to load older results.
+// @Description Only the rooms the user joined at least once and the direct rooms of the user are searched, deleted messages are never returned.
+// @Tags message
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Words to search for, 200 characters at most"
+// @Param room query string false "Search only this room"
+// @Param sender query int false "Search only the messages of this user"
+// @Param from query string false "Search messages created at or after this time (RFC 3339)"
+// @Param to query string false "Search messages created before this time (RFC 3339)"
+// @Param before query int false "Return messages with ID less than this one"
+// @Param limit query int false "Page size, 20 by default, 100 at most"
+// @Success 200 {object} search.Response "Page of the matching messages"
+// @Failure 400 {object} search.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /messages/search [get]
+func New(log *slog.Logger, messageSearcher MessageSearcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.message.search.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("userID is missing in request context", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		params := r.URL.Query()
+		query := storage.SearchQuery{UserID: userID, Room: params.Get("room")}
+
+		text := params.Get("q")
+		query.Terms = search.Terms(text)
+		if len(text) > maxQueryLength || len(query.Terms) == 0 {
+			log.Error("invalid search query", slog.String("q", text))
+
+			render.JSON(w, r, resp.Error("invalid q"))
+
+			return
+		}
+
+		if len(query.Room) > 64 {
+			log.Error("invalid room name", slog.String("room", query.Room))
+
+			render.JSON(w, r, resp.Error("invalid room"))
+
+			return
+		}
+		if dm.IsDirect(query.Room) && !dm.IsMember(query.Room, userID) {
+			log.Error("user is not a member of the direct room", slog.String("room", query.Room), slog.Int64("userID", userID))
+
+			render.JSON(w, r, resp.Error("access denied"))
+
+			return
+		}
+
+		query.SenderID, err = parseQueryInt(r, "sender", 0)
+		if err != nil || query.SenderID < 0 {
+			log.Error("invalid sender parameter", slog.String("sender", params.Get("sender")))
+
+			render.JSON(w, r, resp.Error("invalid sender"))
+
+			return
+		}
+
+		query.From, err = parseQueryTime(r, "from")
+		if err != nil {
+			log.Error("invalid from parameter", slog.String("from", params.Get("from")))
+
+			render.JSON(w, r, resp.Error("invalid from"))
+
+			return
+		}
+
+		query.To, err = parseQueryTime(r, "to")
+		if err != nil {
+			log.Error("invalid to parameter", slog.String("to", params.Get("to")))
+
+			render.JSON(w, r, resp.Error("invalid to"))
+
+			return
+		}
+
+		query.BeforeID, err = parseQueryInt(r, "before", 0)
+		if err != nil || query.BeforeID < 0 {
+			log.Error("invalid before parameter", slog.String("before", params.Get("before")))
+
+			render.JSON(w, r, resp.Error("invalid before"))
+
+			return
+		}
+
+		limit, err := parseQueryInt(r, "limit", defaultLimit)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			log.Error("invalid limit parameter", slog.String("limit", params.Get("limit")))
+
+			render.JSON(w, r, resp.Error("invalid limit"))
+
+			return
+		}
+		query.Limit = int(limit)
+
+		messages, err := messageSearcher.SearchMessages(query)
+		if err != nil {
+			log.Error("failed to search messages", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to search messages"))
+
+			return
+		}
+
+		log.Info("messages searched", slog.Int64("userID", userID), slog.Int("count", len(messages)))
+
+		results := make([]Result, 0, len(messages))
+		for _, message := range messages {
+			results = append(results, Result{Message: message, Snippet: search.Snippet(message.Body, query.Terms)})
+		}
+
+		var nextCursor int64
+		if len(messages) == query.Limit {
+			nextCursor = messages[len(messages)-1].ID
+		}
+
+		render.JSON(w, r, Response{
+			Response:   resp.OK(),
+			Results:    results,
+			NextCursor: nextCursor,
+		})
+	}
+}
+
+func parseQueryInt(r *http.Request, name string, defaultValue int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// parseQueryTime returns the zero time if the parameter is missing.
+func parseQueryTime(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
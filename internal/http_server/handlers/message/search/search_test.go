@@ -0,0 +1,143 @@
+package search_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/http_server/handlers/message/search"
+	"new-websocket-chat/internal/http_server/handlers/message/search/mocks"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSearchHandler(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		userID         string
+		query          string
+		wantQuery      storage.SearchQuery
+		mockMessages   []storage.Message
+		mockError      error
+		respError      string
+		wantSnippets   []string
+		wantNextCursor int64
+	}{
+		{
+			name:         "Success",
+			userID:       "1",
+			query:        "?q=Lunch",
+			wantQuery:    storage.SearchQuery{Terms: []string{"lunch"}, UserID: 1, Limit: 20},
+			mockMessages: []storage.Message{{ID: 7, Room: "general", Body: "lunch at noon"}},
+			wantSnippets: []string{"<mark>lunch</mark> at noon"},
+		},
+		{
+			name:   "Filters",
+			userID: "1",
+			query:  "?q=lunch+noon&room=dm:1:2&sender=2&from=2024-01-01T00:00:00Z&before=50&limit=1",
+			wantQuery: storage.SearchQuery{
+				Terms:    []string{"lunch", "noon"},
+				UserID:   1,
+				Room:     "dm:1:2",
+				SenderID: 2,
+				From:     from,
+				BeforeID: 50,
+				Limit:    1,
+			},
+			mockMessages:   []storage.Message{{ID: 42, Room: "dm:1:2", Body: "noon lunch"}},
+			wantSnippets:   []string{"<mark>noon</mark> <mark>lunch</mark>"},
+			wantNextCursor: 42,
+		},
+		{
+			name:      "Missing user",
+			query:     "?q=lunch",
+			respError: "unauthorized",
+		},
+		{
+			name:      "Empty query",
+			userID:    "1",
+			query:     "?q=%3F%21",
+			respError: "invalid q",
+		},
+		{
+			name:      "Direct room of other users",
+			userID:    "3",
+			query:     "?q=lunch&room=dm:1:2",
+			respError: "access denied",
+		},
+		{
+			name:      "Invalid from",
+			userID:    "1",
+			query:     "?q=lunch&from=yesterday",
+			respError: "invalid from",
+		},
+		{
+			name:      "Limit too big",
+			userID:    "1",
+			query:     "?q=lunch&limit=101",
+			respError: "invalid limit",
+		},
+		{
+			name:      "Storage error",
+			userID:    "1",
+			query:     "?q=lunch",
+			wantQuery: storage.SearchQuery{Terms: []string{"lunch"}, UserID: 1, Limit: 20},
+			mockError: errors.New("unexpected error"),
+			respError: "failed to search messages",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			messageSearcherMock := mocks.NewMessageSearcher(t)
+
+			if test.wantQuery.Limit != 0 {
+				messageSearcherMock.On("SearchMessages", mock.MatchedBy(func(query storage.SearchQuery) bool {
+					// parsed times carry a location, compare them with Equal
+					want := test.wantQuery
+					if !query.From.Equal(want.From) {
+						return false
+					}
+					query.From, want.From = time.Time{}, time.Time{}
+
+					return reflect.DeepEqual(want, query)
+				})).
+					Return(test.mockMessages, test.mockError).
+					Once()
+			}
+
+			handler := search.New(slogdiscard.NewDiscardLogger(), messageSearcherMock)
+
+			req, err := http.NewRequest(http.MethodGet, "/messages/search"+test.query, nil)
+			require.NoError(t, err)
+			req = req.WithContext(context.WithValue(req.Context(), "userID", test.userID))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var resp search.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, test.respError, resp.Error)
+			require.Len(t, resp.Results, len(test.wantSnippets))
+			for i, snippet := range test.wantSnippets {
+				require.Equal(t, snippet, resp.Results[i].Snippet)
+			}
+			require.Equal(t, test.wantNextCursor, resp.NextCursor)
+		})
+	}
+}
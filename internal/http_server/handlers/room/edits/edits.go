@@ -0,0 +1,121 @@
+package edits
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+)
+
+// Response defines the response payload for the message edit history request.
+type Response struct {
+	resp.Response                       // Embedding the common response struct
+	Edits         []storage.MessageEdit `json:"edits"` // Previous bodies of the message, oldest first
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=EditProvider
+type EditProvider interface {
+	GetMessageEdits(room string, messageID int64) ([]storage.MessageEdit, error)
+	IsRoomMember(room string, userID int64) (bool, error)
+}
+
+// @Summary Message edit history
+// @Description Returns the previous bodies of an edited message, oldest first. The history of a deleted message is removed with its body.
+// @Description Rooms can only be read by the users who joined them at least once, direct message rooms (dm:<userID>:<userID>) by their two users.
+// @Tags room
+// @Produce json
+// @Security BearerAuth
+// @Param room path string true "Room name"
+// @Param id path int true "Message ID"
+// @Success 200 {object} edits.Response "Edit history of the message"
+// @Failure 400 {object} edits.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /rooms/{room}/messages/{id}/edits [get]
+func New(log *slog.Logger, editProvider EditProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.room.edits.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		room := chi.URLParam(r, "room")
+		if room == "" || len(room) > 64 {
+			log.Error("invalid room name", slog.String("room", room))
+
+			render.JSON(w, r, resp.Error("invalid room"))
+
+			return
+		}
+
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("invalid user id in token", slog.String("userID", subject))
+
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		// direct rooms have no members in the storage, their users are in the name
+		member := dm.IsMember(room, userID)
+		if !dm.IsDirect(room) {
+			member, err = editProvider.IsRoomMember(room, userID)
+			if err != nil {
+				log.Error("failed to check room member", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to get edits"))
+
+				return
+			}
+		}
+		if !member {
+			log.Error("user is not a member of the room", slog.String("room", room), slog.Int64("userID", userID))
+
+			render.JSON(w, r, resp.Error("access denied"))
+
+			return
+		}
+
+		messageID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || messageID <= 0 {
+			log.Error("invalid message id", slog.String("id", chi.URLParam(r, "id")))
+
+			render.JSON(w, r, resp.Error("invalid message id"))
+
+			return
+		}
+
+		edits, err := editProvider.GetMessageEdits(room, messageID)
+		if errors.Is(err, storage.ErrMessageNotFound) {
+			log.Info("message not found", slog.Int64("messageID", messageID))
+
+			render.JSON(w, r, resp.Error("message not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to get message edits", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get edits"))
+
+			return
+		}
+
+		log.Info("message edits loaded", slog.Int64("messageID", messageID), slog.Int("count", len(edits)))
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Edits:    edits,
+		})
+	}
+}
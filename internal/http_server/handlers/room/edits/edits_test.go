@@ -0,0 +1,149 @@
+package edits_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/http_server/handlers/room/edits"
+	"new-websocket-chat/internal/http_server/handlers/room/edits/mocks"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestEditsHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		room        string
+		id          string
+		userID      string
+		mockID      int64
+		mockEdits   []storage.MessageEdit
+		mockError   error
+		notMember   bool
+		memberError error
+		respError   string
+		wantCount   int
+	}{
+		{
+			name:      "Success",
+			room:      "general",
+			id:        "7",
+			mockID:    7,
+			mockEdits: []storage.MessageEdit{{ID: 1, MessageID: 7, Body: "helo", EditedBy: 1, EditedAt: time.Now()}},
+			wantCount: 1,
+		},
+		{
+			name:      "Never edited",
+			room:      "general",
+			id:        "7",
+			mockID:    7,
+			mockEdits: []storage.MessageEdit{},
+		},
+		{
+			name:      "Invalid id",
+			room:      "general",
+			id:        "abc",
+			respError: "invalid message id",
+		},
+		{
+			name:      "Direct room of other users",
+			room:      "dm:1:2",
+			id:        "7",
+			userID:    "3",
+			respError: "access denied",
+		},
+		{
+			name:      "Direct room of the user",
+			room:      "dm:1:2",
+			id:        "7",
+			userID:    "1",
+			mockID:    7,
+			mockEdits: []storage.MessageEdit{},
+		},
+		{
+			name:      "Message not found",
+			room:      "general",
+			id:        "7",
+			mockID:    7,
+			mockError: fmt.Errorf("get edits: %w", storage.ErrMessageNotFound),
+			respError: "message not found",
+		},
+		{
+			name:      "Not a member",
+			room:      "general",
+			id:        "7",
+			notMember: true,
+			respError: "access denied",
+		},
+		{
+			name:        "Member check error",
+			room:        "general",
+			id:          "7",
+			memberError: errors.New("unexpected error"),
+			respError:   "failed to get edits",
+		},
+		{
+			name:      "Storage error",
+			room:      "general",
+			id:        "7",
+			mockID:    7,
+			mockError: errors.New("unexpected error"),
+			respError: "failed to get edits",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			editProviderMock := mocks.NewEditProvider(t)
+
+			if !dm.IsDirect(test.room) {
+				editProviderMock.On("IsRoomMember", test.room, int64(1)).
+					Return(!test.notMember, test.memberError).
+					Once()
+			}
+			if test.mockID != 0 {
+				editProviderMock.On("GetMessageEdits", test.room, test.mockID).
+					Return(test.mockEdits, test.mockError).
+					Once()
+			}
+
+			handler := edits.New(slogdiscard.NewDiscardLogger(), editProviderMock)
+
+			req, err := http.NewRequest(http.MethodGet, "/rooms/"+test.room+"/messages/"+test.id+"/edits", nil)
+			require.NoError(t, err)
+
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("room", test.room)
+			routeCtx.URLParams.Add("id", test.id)
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx)
+			userID := test.userID
+			if userID == "" {
+				userID = "1"
+			}
+			req = req.WithContext(context.WithValue(ctx, "userID", userID))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var resp edits.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, test.respError, resp.Error)
+			require.Len(t, resp.Edits, test.wantCount)
+		})
+	}
+}
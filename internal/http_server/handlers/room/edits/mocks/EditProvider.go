@@ -0,0 +1,78 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	storage "new-websocket-chat/internal/storage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// EditProvider is an autogenerated mock type for the EditProvider type
+type EditProvider struct {
+	mock.Mock
+}
+
+// GetMessageEdits provides a mock function with given fields: room, messageID
+func (_m *EditProvider) GetMessageEdits(room string, messageID int64) ([]storage.MessageEdit, error) {
+	ret := _m.Called(room, messageID)
+
+	var r0 []storage.MessageEdit
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, int64) ([]storage.MessageEdit, error)); ok {
+		return rf(room, messageID)
+	}
+	if rf, ok := ret.Get(0).(func(string, int64) []storage.MessageEdit); ok {
+		r0 = rf(room, messageID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.MessageEdit)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(string, int64) error); ok {
+		r1 = rf(room, messageID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IsRoomMember provides a mock function with given fields: room, userID
+func (_m *EditProvider) IsRoomMember(room string, userID int64) (bool, error) {
+	ret := _m.Called(room, userID)
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, int64) (bool, error)); ok {
+		return rf(room, userID)
+	}
+	if rf, ok := ret.Get(0).(func(string, int64) bool); ok {
+		r0 = rf(room, userID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(string, int64) error); ok {
+		r1 = rf(room, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewEditProvider creates a new instance of EditProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewEditProvider(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *EditProvider {
+	mock := &EditProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
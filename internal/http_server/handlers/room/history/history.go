@@ -0,0 +1,148 @@
+package history
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 100
+)
+
+// Response defines the response payload for the room history request.
+type Response struct {
+	resp.Response                   // Embedding the common response struct
+	Messages      []storage.Message `json:"messages"`             // Messages of the room in chronological order
+	NextCursor    int64             `json:"nextCursor,omitempty"` // Value of the before parameter to load older messages, empty if there are no more messages
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=MessageProvider
+type MessageProvider interface {
+	GetRoomMessages(room string, beforeID int64, limit int) ([]storage.Message, error)
+	IsRoomMember(room string, userID int64) (bool, error)
+}
+
+// @Summary Room history
+// @Description Returns a page of the room messages in chronological order. Pass nextCursor of the previous page as before to load older messages.
+// @Description Rooms can only be read by the users who joined them at least once, direct message rooms (dm:<userID>:<userID>) by their two users.
+// @Tags room
+// @Produce json
+// @Security BearerAuth
+// @Param room path string true "Room name"
+// @Param before query int false "Return messages with ID less than this one"
+// @Param limit query int false "Page size, 50 by default, 100 at most"
+// @Success 200 {object} history.Response "Page of the room messages"
+// @Failure 400 {object} history.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /rooms/{room}/messages [get]
+func New(log *slog.Logger, messageProvider MessageProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.room.history.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		room := chi.URLParam(r, "room")
+		if room == "" || len(room) > 64 {
+			log.Error("invalid room name", slog.String("room", room))
+
+			render.JSON(w, r, resp.Error("invalid room"))
+
+			return
+		}
+
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("invalid user id in token", slog.String("userID", subject))
+
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		// direct rooms have no members in the storage, their users are in the name
+		member := dm.IsMember(room, userID)
+		if !dm.IsDirect(room) {
+			member, err = messageProvider.IsRoomMember(room, userID)
+			if err != nil {
+				log.Error("failed to check room member", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to get messages"))
+
+				return
+			}
+		}
+		if !member {
+			log.Error("user is not a member of the room", slog.String("room", room), slog.Int64("userID", userID))
+
+			render.JSON(w, r, resp.Error("access denied"))
+
+			return
+		}
+
+		before, err := parseQueryInt(r, "before", 0)
+		if err != nil || before < 0 {
+			log.Error("invalid before parameter", slog.String("before", r.URL.Query().Get("before")))
+
+			render.JSON(w, r, resp.Error("invalid before"))
+
+			return
+		}
+
+		limit, err := parseQueryInt(r, "limit", defaultLimit)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			log.Error("invalid limit parameter", slog.String("limit", r.URL.Query().Get("limit")))
+
+			render.JSON(w, r, resp.Error("invalid limit"))
+
+			return
+		}
+
+		messages, err := messageProvider.GetRoomMessages(room, before, int(limit))
+		if err != nil {
+			log.Error("failed to get room messages", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get messages"))
+
+			return
+		}
+
+		log.Info("room messages loaded", slog.String("room", room), slog.Int("count", len(messages)))
+
+		var nextCursor int64
+		if len(messages) == int(limit) {
+			nextCursor = messages[0].ID
+		}
+
+		responseOK(w, r, messages, nextCursor)
+	}
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, messages []storage.Message, nextCursor int64) {
+	render.JSON(w, r, Response{
+		Response:   resp.OK(),
+		Messages:   messages,
+		NextCursor: nextCursor,
+	})
+}
+
+func parseQueryInt(r *http.Request, name string, defaultValue int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
@@ -0,0 +1,174 @@
+package history_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/http_server/handlers/room/history"
+	"new-websocket-chat/internal/http_server/handlers/room/history/mocks"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		room           string
+		userID         string
+		query          string
+		mockBefore     int64
+		mockLimit      int
+		mockMessages   []storage.Message
+		mockError      error
+		notMember      bool
+		memberError    error
+		respError      string
+		wantCount      int
+		wantNextCursor int64
+	}{
+		{
+			name:         "Success latest",
+			room:         "general",
+			mockLimit:    50,
+			mockMessages: testMessages(3, 10),
+			wantCount:    3,
+		},
+		{
+			name:           "Full page has next cursor",
+			room:           "general",
+			query:          "?before=100&limit=2",
+			mockBefore:     100,
+			mockLimit:      2,
+			mockMessages:   testMessages(2, 98),
+			wantCount:      2,
+			wantNextCursor: 98,
+		},
+		{
+			name:      "Invalid before",
+			room:      "general",
+			query:     "?before=abc",
+			respError: "invalid before",
+		},
+		{
+			name:      "Negative before",
+			room:      "general",
+			query:     "?before=-1",
+			respError: "invalid before",
+		},
+		{
+			name:      "Limit too big",
+			room:      "general",
+			query:     "?limit=101",
+			respError: "invalid limit",
+		},
+		{
+			name:      "Zero limit",
+			room:      "general",
+			query:     "?limit=0",
+			respError: "invalid limit",
+		},
+		{
+			name:         "Direct room of the user",
+			room:         "dm:1:2",
+			userID:       "2",
+			mockLimit:    50,
+			mockMessages: testMessages(1, 1),
+			wantCount:    1,
+		},
+		{
+			name:      "Direct room of other users",
+			room:      "dm:1:2",
+			userID:    "3",
+			respError: "access denied",
+		},
+		{
+			name:      "Not a member",
+			room:      "general",
+			notMember: true,
+			respError: "access denied",
+		},
+		{
+			name:        "Member check error",
+			room:        "general",
+			memberError: errors.New("unexpected error"),
+			respError:   "failed to get messages",
+		},
+		{
+			name:      "Storage error",
+			room:      "general",
+			mockLimit: 50,
+			mockError: errors.New("unexpected error"),
+			respError: "failed to get messages",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			messageProviderMock := mocks.NewMessageProvider(t)
+
+			if !dm.IsDirect(test.room) {
+				messageProviderMock.On("IsRoomMember", test.room, int64(1)).
+					Return(!test.notMember, test.memberError).
+					Once()
+			}
+			if test.respError == "" || test.mockError != nil {
+				messageProviderMock.On("GetRoomMessages", test.room, test.mockBefore, test.mockLimit).
+					Return(test.mockMessages, test.mockError).
+					Once()
+			}
+
+			handler := history.New(slogdiscard.NewDiscardLogger(), messageProviderMock)
+
+			req, err := http.NewRequest(http.MethodGet, "/rooms/"+test.room+"/messages"+test.query, nil)
+			require.NoError(t, err)
+
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("room", test.room)
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx)
+			userID := test.userID
+			if userID == "" {
+				userID = "1"
+			}
+			req = req.WithContext(context.WithValue(ctx, "userID", userID))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var resp history.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, test.respError, resp.Error)
+			require.Len(t, resp.Messages, test.wantCount)
+			require.Equal(t, test.wantNextCursor, resp.NextCursor)
+		})
+	}
+}
+
+// testMessages returns count messages of the general room with IDs starting from firstID.
+func testMessages(count int, firstID int64) []storage.Message {
+	messages := make([]storage.Message, 0, count)
+	for i := 0; i < count; i++ {
+		messages = append(messages, storage.Message{
+			ID:        firstID + int64(i),
+			Room:      "general",
+			SenderID:  1,
+			Body:      "hello",
+			CreatedAt: time.Now(),
+		})
+	}
+
+	return messages
+}
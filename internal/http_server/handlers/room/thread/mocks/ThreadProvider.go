@@ -0,0 +1,102 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	storage "new-websocket-chat/internal/storage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// ThreadProvider is an autogenerated mock type for the ThreadProvider type
+type ThreadProvider struct {
+	mock.Mock
+}
+
+// GetMessage provides a mock function with given fields: messageID
+func (_m *ThreadProvider) GetMessage(messageID int64) (storage.Message, error) {
+	ret := _m.Called(messageID)
+
+	var r0 storage.Message
+	var r1 error
+	if rf, ok := ret.Get(0).(func(int64) (storage.Message, error)); ok {
+		return rf(messageID)
+	}
+	if rf, ok := ret.Get(0).(func(int64) storage.Message); ok {
+		r0 = rf(messageID)
+	} else {
+		r0 = ret.Get(0).(storage.Message)
+	}
+
+	if rf, ok := ret.Get(1).(func(int64) error); ok {
+		r1 = rf(messageID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetThreadReplies provides a mock function with given fields: parentID, beforeID, limit
+func (_m *ThreadProvider) GetThreadReplies(parentID int64, beforeID int64, limit int) ([]storage.Message, error) {
+	ret := _m.Called(parentID, beforeID, limit)
+
+	var r0 []storage.Message
+	var r1 error
+	if rf, ok := ret.Get(0).(func(int64, int64, int) ([]storage.Message, error)); ok {
+		return rf(parentID, beforeID, limit)
+	}
+	if rf, ok := ret.Get(0).(func(int64, int64, int) []storage.Message); ok {
+		r0 = rf(parentID, beforeID, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.Message)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(int64, int64, int) error); ok {
+		r1 = rf(parentID, beforeID, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IsRoomMember provides a mock function with given fields: room, userID
+func (_m *ThreadProvider) IsRoomMember(room string, userID int64) (bool, error) {
+	ret := _m.Called(room, userID)
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, int64) (bool, error)); ok {
+		return rf(room, userID)
+	}
+	if rf, ok := ret.Get(0).(func(string, int64) bool); ok {
+		r0 = rf(room, userID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(string, int64) error); ok {
+		r1 = rf(room, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewThreadProvider creates a new instance of ThreadProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewThreadProvider(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *ThreadProvider {
+	mock := &ThreadProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
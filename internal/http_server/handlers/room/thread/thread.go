@@ -0,0 +1,174 @@
+package thread
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 100
+)
+
+// Response defines the response payload for the thread replies request.
+type Response struct {
+	resp.Response                   // Embedding the common response struct
+	Parent        *storage.Message  `json:"parent,omitempty"`     // First message of the thread with the reply count
+	Replies       []storage.Message `json:"replies"`              // Replies of the thread in chronological order
+	NextCursor    int64             `json:"nextCursor,omitempty"` // Value of the before parameter to load older replies, empty if there are no more replies
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=ThreadProvider
+type ThreadProvider interface {
+	GetMessage(messageID int64) (storage.Message, error)
+	GetThreadReplies(parentID int64, beforeID int64, limit int) ([]storage.Message, error)
+	IsRoomMember(room string, userID int64) (bool, error)
+}
+
+// @Summary Thread replies
+// @Description Returns the first message of a thread and a page of its replies in chronological order. Pass nextCursor of the previous page as before to load older replies.
+// @Description Rooms can only be read by the users who joined them at least once, direct message rooms (dm:<userID>:<userID>) by their two users.
+// @Tags room
+// @Produce json
+// @Security BearerAuth
+// @Param room path string true "Room name"
+// @Param id path int true "ID of the first message of the thread"
+// @Param before query int false "Return replies with ID less than this one"
+// @Param limit query int false "Page size, 50 by default, 100 at most"
+// @Success 200 {object} thread.Response "Page of the thread replies"
+// @Failure 400 {object} thread.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /rooms/{room}/messages/{id}/replies [get]
+func New(log *slog.Logger, threadProvider ThreadProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.room.thread.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		room := chi.URLParam(r, "room")
+		if room == "" || len(room) > 64 {
+			log.Error("invalid room name", slog.String("room", room))
+
+			render.JSON(w, r, resp.Error("invalid room"))
+
+			return
+		}
+
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("invalid user id in token", slog.String("userID", subject))
+
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		// direct rooms have no members in the storage, their users are in the name
+		member := dm.IsMember(room, userID)
+		if !dm.IsDirect(room) {
+			member, err = threadProvider.IsRoomMember(room, userID)
+			if err != nil {
+				log.Error("failed to check room member", sl.Err(err))
+
+				render.JSON(w, r, resp.Error("failed to get replies"))
+
+				return
+			}
+		}
+		if !member {
+			log.Error("user is not a member of the room", slog.String("room", room), slog.Int64("userID", userID))
+
+			render.JSON(w, r, resp.Error("access denied"))
+
+			return
+		}
+
+		parentID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || parentID <= 0 {
+			log.Error("invalid message id", slog.String("id", chi.URLParam(r, "id")))
+
+			render.JSON(w, r, resp.Error("invalid message id"))
+
+			return
+		}
+
+		before, err := parseQueryInt(r, "before", 0)
+		if err != nil || before < 0 {
+			log.Error("invalid before parameter", slog.String("before", r.URL.Query().Get("before")))
+
+			render.JSON(w, r, resp.Error("invalid before"))
+
+			return
+		}
+
+		limit, err := parseQueryInt(r, "limit", defaultLimit)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			log.Error("invalid limit parameter", slog.String("limit", r.URL.Query().Get("limit")))
+
+			render.JSON(w, r, resp.Error("invalid limit"))
+
+			return
+		}
+
+		parent, err := threadProvider.GetMessage(parentID)
+		if errors.Is(err, storage.ErrMessageNotFound) || (err == nil && (parent.Room != room || parent.ParentID != 0)) {
+			log.Info("thread not found", slog.Int64("messageID", parentID))
+
+			render.JSON(w, r, resp.Error("thread not found"))
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to get thread parent", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get replies"))
+
+			return
+		}
+
+		replies, err := threadProvider.GetThreadReplies(parentID, before, int(limit))
+		if err != nil {
+			log.Error("failed to get thread replies", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get replies"))
+
+			return
+		}
+
+		log.Info("thread replies loaded", slog.Int64("messageID", parentID), slog.Int("count", len(replies)))
+
+		var nextCursor int64
+		if len(replies) == int(limit) {
+			nextCursor = replies[0].ID
+		}
+
+		render.JSON(w, r, Response{
+			Response:   resp.OK(),
+			Parent:     &parent,
+			Replies:    replies,
+			NextCursor: nextCursor,
+		})
+	}
+}
+
+func parseQueryInt(r *http.Request, name string, defaultValue int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
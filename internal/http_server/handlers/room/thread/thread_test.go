@@ -0,0 +1,196 @@
+package thread_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/http_server/handlers/room/thread"
+	"new-websocket-chat/internal/http_server/handlers/room/thread/mocks"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestThreadHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		room           string
+		id             string
+		query          string
+		parent         storage.Message
+		parentError    error
+		mockBefore     int64
+		mockLimit      int
+		mockReplies    []storage.Message
+		mockError      error
+		notMember      bool
+		memberError    error
+		respError      string
+		wantCount      int
+		wantNextCursor int64
+	}{
+		{
+			name:        "Success",
+			room:        "general",
+			id:          "10",
+			parent:      storage.Message{ID: 10, Room: "general", ReplyCount: 2},
+			mockLimit:   50,
+			mockReplies: testReplies(2, 11),
+			wantCount:   2,
+		},
+		{
+			name:           "Full page has next cursor",
+			room:           "general",
+			id:             "10",
+			query:          "?before=20&limit=2",
+			parent:         storage.Message{ID: 10, Room: "general", ReplyCount: 5},
+			mockBefore:     20,
+			mockLimit:      2,
+			mockReplies:    testReplies(2, 15),
+			wantCount:      2,
+			wantNextCursor: 15,
+		},
+		{
+			name:      "Invalid id",
+			room:      "general",
+			id:        "abc",
+			respError: "invalid message id",
+		},
+		{
+			name:      "Limit too big",
+			room:      "general",
+			id:        "10",
+			query:     "?limit=101",
+			respError: "invalid limit",
+		},
+		{
+			name:        "Parent not found",
+			room:        "general",
+			id:          "10",
+			parentError: storage.ErrMessageNotFound,
+			respError:   "thread not found",
+		},
+		{
+			name:      "Parent in other room",
+			room:      "general",
+			id:        "10",
+			parent:    storage.Message{ID: 10, Room: "random"},
+			respError: "thread not found",
+		},
+		{
+			name:      "Parent is a reply",
+			room:      "general",
+			id:        "11",
+			parent:    storage.Message{ID: 11, Room: "general", ParentID: 10},
+			respError: "thread not found",
+		},
+		{
+			name:      "Direct room of other users",
+			room:      "dm:1:2",
+			id:        "10",
+			respError: "access denied",
+		},
+		{
+			name:      "Not a member",
+			room:      "general",
+			id:        "10",
+			notMember: true,
+			respError: "access denied",
+		},
+		{
+			name:        "Member check error",
+			room:        "general",
+			id:          "10",
+			memberError: errors.New("unexpected error"),
+			respError:   "failed to get replies",
+		},
+		{
+			name:      "Storage error",
+			room:      "general",
+			id:        "10",
+			parent:    storage.Message{ID: 10, Room: "general"},
+			mockLimit: 50,
+			mockError: errors.New("unexpected error"),
+			respError: "failed to get replies",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			threadProviderMock := mocks.NewThreadProvider(t)
+
+			if !dm.IsDirect(test.room) {
+				threadProviderMock.On("IsRoomMember", test.room, int64(3)).
+					Return(!test.notMember, test.memberError).
+					Once()
+			}
+			if test.parent.ID != 0 || test.parentError != nil {
+				id := test.parent.ID
+				if id == 0 {
+					id = 10
+				}
+				threadProviderMock.On("GetMessage", id).
+					Return(test.parent, test.parentError).
+					Once()
+			}
+			if test.mockLimit != 0 {
+				threadProviderMock.On("GetThreadReplies", test.parent.ID, test.mockBefore, test.mockLimit).
+					Return(test.mockReplies, test.mockError).
+					Once()
+			}
+
+			handler := thread.New(slogdiscard.NewDiscardLogger(), threadProviderMock)
+
+			req, err := http.NewRequest(http.MethodGet, "/rooms/"+test.room+"/messages/"+test.id+"/replies"+test.query, nil)
+			require.NoError(t, err)
+
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("room", test.room)
+			routeCtx.URLParams.Add("id", test.id)
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx)
+			req = req.WithContext(context.WithValue(ctx, "userID", "3"))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var resp thread.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, test.respError, resp.Error)
+			require.Len(t, resp.Replies, test.wantCount)
+			require.Equal(t, test.wantNextCursor, resp.NextCursor)
+			if test.respError == "" {
+				require.Equal(t, test.parent.ReplyCount, resp.Parent.ReplyCount)
+			}
+		})
+	}
+}
+
+// testReplies returns count replies to message 10 with IDs starting from firstID.
+func testReplies(count int, firstID int64) []storage.Message {
+	replies := make([]storage.Message, 0, count)
+	for i := 0; i < count; i++ {
+		replies = append(replies, storage.Message{
+			ID:        firstID + int64(i),
+			Room:      "general",
+			SenderID:  1,
+			Body:      "hello",
+			CreatedAt: time.Now(),
+			ParentID:  10,
+		})
+	}
+
+	return replies
+}
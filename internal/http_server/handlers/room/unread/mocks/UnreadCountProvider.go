@@ -0,0 +1,54 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	storage "new-websocket-chat/internal/storage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// UnreadCountProvider is an autogenerated mock type for the UnreadCountProvider type
+type UnreadCountProvider struct {
+	mock.Mock
+}
+
+// GetUnreadCounts provides a mock function with given fields: userID
+func (_m *UnreadCountProvider) GetUnreadCounts(userID int64) ([]storage.UnreadCount, error) {
+	ret := _m.Called(userID)
+
+	var r0 []storage.UnreadCount
+	var r1 error
+	if rf, ok := ret.Get(0).(func(int64) ([]storage.UnreadCount, error)); ok {
+		return rf(userID)
+	}
+	if rf, ok := ret.Get(0).(func(int64) []storage.UnreadCount); ok {
+		r0 = rf(userID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.UnreadCount)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(int64) error); ok {
+		r1 = rf(userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewUnreadCountProvider creates a new instance of UnreadCountProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewUnreadCountProvider(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *UnreadCountProvider {
+	mock := &UnreadCountProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,69 @@
+package unread
+
+import (
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+)
+
+// Response defines the response payload for the unread counts request.
+type Response struct {
+	resp.Response                       // Embedding the common response struct
+	Rooms         []storage.UnreadCount `json:"rooms"` // Unread counts of the rooms the user received or read messages in
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=UnreadCountProvider
+type UnreadCountProvider interface {
+	GetUnreadCounts(userID int64) ([]storage.UnreadCount, error)
+}
+
+// @Summary Unread counts
+// @Description Returns the number of messages of other users after the last read message, for every room the user received or read messages in.
+// @Tags room
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} unread.Response "Unread counts of the rooms"
+// @Failure 400 {object} unread.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /rooms/unread [get]
+func New(log *slog.Logger, unreadCountProvider UnreadCountProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.room.unread.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("userID is missing in request context", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		counts, err := unreadCountProvider.GetUnreadCounts(userID)
+		if err != nil {
+			log.Error("failed to get unread counts", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get unread counts"))
+
+			return
+		}
+
+		log.Info("unread counts loaded", slog.Int64("userID", userID), slog.Int("rooms", len(counts)))
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Rooms:    counts,
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package unread_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/http_server/handlers/room/unread"
+	"new-websocket-chat/internal/http_server/handlers/room/unread/mocks"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"testing"
+)
+
+func TestUnreadHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     string
+		mockCounts []storage.UnreadCount
+		mockError  error
+		respError  string
+	}{
+		{
+			name:       "Success",
+			userID:     "1",
+			mockCounts: []storage.UnreadCount{{Room: "general", LastReadID: 10, Unread: 3}},
+		},
+		{
+			name:      "Missing user",
+			respError: "unauthorized",
+		},
+		{
+			name:      "Storage error",
+			userID:    "1",
+			mockError: errors.New("unexpected error"),
+			respError: "failed to get unread counts",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			unreadCountProviderMock := mocks.NewUnreadCountProvider(t)
+
+			if test.userID != "" {
+				unreadCountProviderMock.On("GetUnreadCounts", int64(1)).
+					Return(test.mockCounts, test.mockError).
+					Once()
+			}
+
+			handler := unread.New(slogdiscard.NewDiscardLogger(), unreadCountProviderMock)
+
+			req, err := http.NewRequest(http.MethodGet, "/rooms/unread", nil)
+			require.NoError(t, err)
+			req = req.WithContext(context.WithValue(req.Context(), "userID", test.userID))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var resp unread.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, test.respError, resp.Error)
+			require.Equal(t, test.mockCounts, resp.Rooms)
+		})
+	}
+}
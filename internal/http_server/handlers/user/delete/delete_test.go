@@ -11,6 +11,7 @@ import (
 	delete "new-websocket-chat/internal/http_server/handlers/user/delete"
 	"new-websocket-chat/internal/http_server/handlers/user/delete/mocks"
 	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
 	"testing"
 )
 
@@ -32,36 +33,37 @@ func TestDeleteHandler(t *testing.T) {
 			username:  "AbdraBlyaaaaa",
 			email:     "dininchesterrdfsdfr25@gmail.com",
 			respError: "user not found",
+			mockError: storage.ErrUsernameNotFound,
 		},
 		{
 			name:      "Empty username",
 			username:  "",
 			email:     "din02winchester25@gmail.com",
-			respError: "failed to decode request",
+			respError: "field Username is a required field",
 		},
 		{
 			name:      "Empty email",
 			username:  "Abdrahman",
 			email:     "",
-			respError: "field email is a required field",
+			respError: "field Email is a required field",
 		},
 		{
 			name:      "Empty username & email",
 			username:  "",
 			email:     "",
-			respError: "field username is a required field",
+			respError: "field Username is a required field, field Email is a required field",
 		},
 		{
 			name:      "Invalid username",
 			username:  "Aba",
 			email:     "din02winchester25@gmail.com",
-			respError: "field username is not a valid username",
+			respError: "field Username is not valid",
 		},
 		{
 			name:      "Invalid email",
 			username:  "Abdrahman",
 			email:     "din02winchester25",
-			respError: "field email is not a valid email",
+			respError: "field Email is not a valid email",
 		},
 		{
 			name:      "DeleteUser Error",
@@ -73,6 +75,7 @@ func TestDeleteHandler(t *testing.T) {
 	}
 
 	for _, test := range tests {
+		test := test
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
@@ -0,0 +1,123 @@
+package mentions
+
+import (
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 100
+)
+
+// Response defines the response payload for the mentions request.
+type Response struct {
+	resp.Response                   // Embedding the common response struct
+	Mentions      []storage.Mention `json:"mentions"`             // Messages mentioning the user, newest first
+	Unread        int               `json:"unread"`               // Number of unread mentions of the user
+	NextCursor    int64             `json:"nextCursor,omitempty"` // Value of the before parameter to load older mentions, empty if there are no more mentions
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=MentionProvider
+type MentionProvider interface {
+	GetMentions(userID int64, beforeID int64, limit int) ([]storage.Mention, error)
+	GetUnreadMentionCount(userID int64) (int, error)
+}
+
+// @Summary My mentions
+// @Description Returns a page of the messages mentioning the user with @username, newest first. Pass nextCursor of the previous page as before to load older mentions.
+// @Description A mention is read once the user read the room up to the message with a read envelope.
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Param before query int false "Return mentions in messages with ID less than this one"
+// @Param limit query int false "Page size, 50 by default, 100 at most"
+// @Success 200 {object} mentions.Response "Page of the mentions"
+// @Failure 400 {object} mentions.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /users/me/mentions [get]
+func New(log *slog.Logger, mentionProvider MentionProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.mentions.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("userID is missing in request context", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("unauthorized"))
+
+			return
+		}
+
+		before, err := parseQueryInt(r, "before", 0)
+		if err != nil || before < 0 {
+			log.Error("invalid before parameter", slog.String("before", r.URL.Query().Get("before")))
+
+			render.JSON(w, r, resp.Error("invalid before"))
+
+			return
+		}
+
+		limit, err := parseQueryInt(r, "limit", defaultLimit)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			log.Error("invalid limit parameter", slog.String("limit", r.URL.Query().Get("limit")))
+
+			render.JSON(w, r, resp.Error("invalid limit"))
+
+			return
+		}
+
+		mentions, err := mentionProvider.GetMentions(userID, before, int(limit))
+		if err != nil {
+			log.Error("failed to get mentions", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get mentions"))
+
+			return
+		}
+
+		unread, err := mentionProvider.GetUnreadMentionCount(userID)
+		if err != nil {
+			log.Error("failed to get unread mention count", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get mentions"))
+
+			return
+		}
+
+		log.Info("mentions loaded", slog.Int64("userID", userID), slog.Int("count", len(mentions)))
+
+		var nextCursor int64
+		if len(mentions) == int(limit) {
+			nextCursor = mentions[len(mentions)-1].Message.ID
+		}
+
+		render.JSON(w, r, Response{
+			Response:   resp.OK(),
+			Mentions:   mentions,
+			Unread:     unread,
+			NextCursor: nextCursor,
+		})
+	}
+}
+
+func parseQueryInt(r *http.Request, name string, defaultValue int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
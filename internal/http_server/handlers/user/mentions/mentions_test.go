@@ -0,0 +1,119 @@
+package mentions_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/http_server/handlers/user/mentions"
+	"new-websocket-chat/internal/http_server/handlers/user/mentions/mocks"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage"
+	"testing"
+)
+
+func TestMentionsHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		userID         string
+		query          string
+		mockBefore     int64
+		mockLimit      int
+		mockMentions   []storage.Mention
+		mockError      error
+		mockUnread     int
+		unreadError    error
+		respError      string
+		wantCount      int
+		wantUnread     int
+		wantNextCursor int64
+	}{
+		{
+			name:         "Success",
+			userID:       "1",
+			mockLimit:    50,
+			mockMentions: []storage.Mention{{Message: storage.Message{ID: 9}}, {Message: storage.Message{ID: 4}, Read: true}},
+			mockUnread:   1,
+			wantCount:    2,
+			wantUnread:   1,
+		},
+		{
+			name:           "Full page has next cursor",
+			userID:         "1",
+			query:          "?before=10&limit=2",
+			mockBefore:     10,
+			mockLimit:      2,
+			mockMentions:   []storage.Mention{{Message: storage.Message{ID: 9}}, {Message: storage.Message{ID: 4}}},
+			mockUnread:     5,
+			wantCount:      2,
+			wantUnread:     5,
+			wantNextCursor: 4,
+		},
+		{
+			name:      "Missing user",
+			respError: "unauthorized",
+		},
+		{
+			name:      "Invalid limit",
+			userID:    "1",
+			query:     "?limit=abc",
+			respError: "invalid limit",
+		},
+		{
+			name:      "Storage error",
+			userID:    "1",
+			mockLimit: 50,
+			mockError: errors.New("unexpected error"),
+			respError: "failed to get mentions",
+		},
+		{
+			name:        "Unread count error",
+			userID:      "1",
+			mockLimit:   50,
+			unreadError: errors.New("unexpected error"),
+			respError:   "failed to get mentions",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			mentionProviderMock := mocks.NewMentionProvider(t)
+
+			if test.mockLimit != 0 {
+				mentionProviderMock.On("GetMentions", int64(1), test.mockBefore, test.mockLimit).
+					Return(test.mockMentions, test.mockError).
+					Once()
+			}
+			if test.mockLimit != 0 && test.mockError == nil {
+				mentionProviderMock.On("GetUnreadMentionCount", int64(1)).
+					Return(test.mockUnread, test.unreadError).
+					Once()
+			}
+
+			handler := mentions.New(slogdiscard.NewDiscardLogger(), mentionProviderMock)
+
+			req, err := http.NewRequest(http.MethodGet, "/users/me/mentions"+test.query, nil)
+			require.NoError(t, err)
+			req = req.WithContext(context.WithValue(req.Context(), "userID", test.userID))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var resp mentions.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, test.respError, resp.Error)
+			require.Len(t, resp.Mentions, test.wantCount)
+			require.Equal(t, test.wantUnread, resp.Unread)
+			require.Equal(t, test.wantNextCursor, resp.NextCursor)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	storage "new-websocket-chat/internal/storage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// MentionProvider is an autogenerated mock type for the MentionProvider type
+type MentionProvider struct {
+	mock.Mock
+}
+
+// GetMentions provides a mock function with given fields: userID, beforeID, limit
+func (_m *MentionProvider) GetMentions(userID int64, beforeID int64, limit int) ([]storage.Mention, error) {
+	ret := _m.Called(userID, beforeID, limit)
+
+	var r0 []storage.Mention
+	var r1 error
+	if rf, ok := ret.Get(0).(func(int64, int64, int) ([]storage.Mention, error)); ok {
+		return rf(userID, beforeID, limit)
+	}
+	if rf, ok := ret.Get(0).(func(int64, int64, int) []storage.Mention); ok {
+		r0 = rf(userID, beforeID, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.Mention)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(int64, int64, int) error); ok {
+		r1 = rf(userID, beforeID, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUnreadMentionCount provides a mock function with given fields: userID
+func (_m *MentionProvider) GetUnreadMentionCount(userID int64) (int, error) {
+	ret := _m.Called(userID)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(int64) (int, error)); ok {
+		return rf(userID)
+	}
+	if rf, ok := ret.Get(0).(func(int64) int); ok {
+		r0 = rf(userID)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(int64) error); ok {
+		r1 = rf(userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewMentionProvider creates a new instance of MentionProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMentionProvider(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MentionProvider {
+	mock := &MentionProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
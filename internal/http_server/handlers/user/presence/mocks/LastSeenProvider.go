@@ -0,0 +1,54 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	mock "github.com/stretchr/testify/mock"
+
+	time "time"
+)
+
+// LastSeenProvider is an autogenerated mock type for the LastSeenProvider type
+type LastSeenProvider struct {
+	mock.Mock
+}
+
+// GetLastSeen provides a mock function with given fields: userIDs
+func (_m *LastSeenProvider) GetLastSeen(userIDs []int64) (map[int64]time.Time, error) {
+	ret := _m.Called(userIDs)
+
+	var r0 map[int64]time.Time
+	var r1 error
+	if rf, ok := ret.Get(0).(func([]int64) (map[int64]time.Time, error)); ok {
+		return rf(userIDs)
+	}
+	if rf, ok := ret.Get(0).(func([]int64) map[int64]time.Time); ok {
+		r0 = rf(userIDs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[int64]time.Time)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func([]int64) error); ok {
+		r1 = rf(userIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewLastSeenProvider creates a new instance of LastSeenProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewLastSeenProvider(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *LastSeenProvider {
+	mock := &LastSeenProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,40 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import mock "github.com/stretchr/testify/mock"
+
+// StatusProvider is an autogenerated mock type for the StatusProvider type
+type StatusProvider struct {
+	mock.Mock
+}
+
+// Statuses provides a mock function with given fields: userIDs
+func (_m *StatusProvider) Statuses(userIDs []int64) map[int64]string {
+	ret := _m.Called(userIDs)
+
+	var r0 map[int64]string
+	if rf, ok := ret.Get(0).(func([]int64) map[int64]string); ok {
+		r0 = rf(userIDs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[int64]string)
+		}
+	}
+
+	return r0
+}
+
+// NewStatusProvider creates a new instance of StatusProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewStatusProvider(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *StatusProvider {
+	mock := &StatusProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
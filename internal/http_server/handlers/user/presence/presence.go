@@ -0,0 +1,124 @@
+package presence
+
+import (
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statusOffline = "offline"
+	maxUsers      = 100
+)
+
+// UserPresence is the presence of a single user.
+type UserPresence struct {
+	UserID   int64      `json:"userId"`             // ID of the user
+	Status   string     `json:"status"`             // online, away, dnd or offline
+	LastSeen *time.Time `json:"lastSeen,omitempty"` // Time the user went offline, empty for connected users and users who never connected
+}
+
+// Response defines the response payload for the presence request.
+type Response struct {
+	resp.Response                // Embedding the common response struct
+	Users         []UserPresence `json:"users"` // Presence of the requested users in the requested order
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=StatusProvider
+type StatusProvider interface {
+	Statuses(userIDs []int64) map[int64]string
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=LastSeenProvider
+type LastSeenProvider interface {
+	GetLastSeen(userIDs []int64) (map[int64]time.Time, error)
+}
+
+// @Summary Users presence
+// @Description Returns the live status of the users and, for the offline ones, the time they were last seen.
+// @Tags user
+// @Produce json
+// @Security BearerAuth
+// @Param ids query string true "Comma separated user IDs, 100 at most"
+// @Success 200 {object} presence.Response "Presence of the users"
+// @Failure 400 {object} presence.Response "Bad Request with details"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /users/presence [get]
+func New(log *slog.Logger, statusProvider StatusProvider, lastSeenProvider LastSeenProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.presence.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userIDs, err := parseIDs(r.URL.Query().Get("ids"))
+		if err != nil || len(userIDs) == 0 || len(userIDs) > maxUsers {
+			log.Error("invalid ids parameter", slog.String("ids", r.URL.Query().Get("ids")))
+
+			render.JSON(w, r, resp.Error("invalid ids"))
+
+			return
+		}
+
+		statuses := statusProvider.Statuses(userIDs)
+
+		lastSeen, err := lastSeenProvider.GetLastSeen(userIDs)
+		if err != nil {
+			log.Error("failed to get last seen", sl.Err(err))
+
+			render.JSON(w, r, resp.Error("failed to get presence"))
+
+			return
+		}
+
+		users := make([]UserPresence, 0, len(userIDs))
+		for _, userID := range userIDs {
+			presence := UserPresence{UserID: userID, Status: statuses[userID]}
+			if presence.Status == "" {
+				presence.Status = statusOffline
+			}
+			if t, ok := lastSeen[userID]; ok && presence.Status == statusOffline {
+				presence.LastSeen = &t
+			}
+			users = append(users, presence)
+		}
+
+		log.Info("presence loaded", slog.Int("count", len(users)))
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Users:    users,
+		})
+	}
+}
+
+// parseIDs parses a comma separated list of user IDs, repeated IDs are returned once.
+func parseIDs(value string) ([]int64, error) {
+	var userIDs []int64
+	seen := make(map[int64]bool)
+
+	for _, part := range strings.Split(value, ",") {
+		if part == "" {
+			continue
+		}
+
+		userID, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	return userIDs, nil
+}
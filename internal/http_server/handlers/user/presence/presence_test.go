@@ -0,0 +1,104 @@
+package presence_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/http_server/handlers/user/presence"
+	"new-websocket-chat/internal/http_server/handlers/user/presence/mocks"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"testing"
+	"time"
+)
+
+func TestPresenceHandler(t *testing.T) {
+	lastSeen := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		query         string
+		mockIDs       []int64
+		mockStatuses  map[int64]string
+		mockLastSeen  map[int64]time.Time
+		mockError     error
+		respError     string
+		wantPresences []presence.UserPresence
+	}{
+		{
+			name:         "Success",
+			query:        "?ids=1,2,3,1",
+			mockIDs:      []int64{1, 2, 3},
+			mockStatuses: map[int64]string{1: "online", 2: "offline", 3: "offline"},
+			mockLastSeen: map[int64]time.Time{1: lastSeen, 2: lastSeen},
+			wantPresences: []presence.UserPresence{
+				{UserID: 1, Status: "online"},
+				{UserID: 2, Status: "offline", LastSeen: &lastSeen},
+				{UserID: 3, Status: "offline"},
+			},
+		},
+		{
+			name:      "Missing ids",
+			respError: "invalid ids",
+		},
+		{
+			name:      "Invalid id",
+			query:     "?ids=1,bob",
+			respError: "invalid ids",
+		},
+		{
+			name:         "Storage error",
+			query:        "?ids=1",
+			mockIDs:      []int64{1},
+			mockStatuses: map[int64]string{1: "offline"},
+			mockError:    errors.New("unexpected error"),
+			respError:    "failed to get presence",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			statusProviderMock := mocks.NewStatusProvider(t)
+			lastSeenProviderMock := mocks.NewLastSeenProvider(t)
+
+			if test.mockIDs != nil {
+				statusProviderMock.On("Statuses", test.mockIDs).
+					Return(test.mockStatuses).
+					Once()
+				lastSeenProviderMock.On("GetLastSeen", test.mockIDs).
+					Return(test.mockLastSeen, test.mockError).
+					Once()
+			}
+
+			handler := presence.New(slogdiscard.NewDiscardLogger(), statusProviderMock, lastSeenProviderMock)
+
+			req, err := http.NewRequest(http.MethodGet, "/users/presence"+test.query, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, rr.Code, http.StatusOK)
+
+			var resp presence.Response
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			require.Equal(t, test.respError, resp.Error)
+			require.Equal(t, len(test.wantPresences), len(resp.Users))
+			for i, want := range test.wantPresences {
+				require.Equal(t, want.UserID, resp.Users[i].UserID)
+				require.Equal(t, want.Status, resp.Users[i].Status)
+				if want.LastSeen == nil {
+					require.Nil(t, resp.Users[i].LastSeen)
+				} else {
+					require.True(t, want.LastSeen.Equal(*resp.Users[i].LastSeen))
+				}
+			}
+		})
+	}
+}
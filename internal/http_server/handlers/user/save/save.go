@@ -10,14 +10,13 @@ import (
 	"net/http"
 	resp "new-websocket-chat/internal/lib/api/response"
 	"new-websocket-chat/internal/lib/encryption"
-	jwtAuth "new-websocket-chat/internal/lib/jwt"
 	"new-websocket-chat/internal/lib/logger/sl"
 	"new-websocket-chat/internal/storage"
 )
 
 // Request defines the required information to create a new user.
 type Request struct {
-	Username string `json:"username" validate:"required,min=4,max=24"` // Username of the user
+	Username string `json:"username" validate:"required,min=4,max=24,excludes=@"` // Username of the user, logins with '@' are emails
 	Email    string `json:"email" validate:"required,email"` // Email of the user
 	Password string `json:"password" validate:"required,min=8,max=24,containsany=!@#?"` // Password of the user
 }
@@ -35,6 +34,11 @@ type UserSaver interface {
 	SaveUser(username string, email string, password string) (int64, error)
 }
 
+//go:generate go run github.com/vektra/mockery/v2@v2.37.1 --name=TokenIssuer
+type TokenIssuer interface {
+	IssueTokens(userID int64) (accessTokenString string, refreshTokenString string, err error)
+}
+
 // @Summary Create user
 // @Description Create a new user in the system.
 // @Tags user
@@ -43,9 +47,10 @@ type UserSaver interface {
 // @Param request body Request true "User Registration Data"
 // @Success 200 {object} Response "Successfully registered user and generated JWT tokens"
 // @Failure 400 {object} Response "Bad Request with details"
+// @Failure 429 {string} string "Too many requests, retry after the Retry-After header"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /user [post]
-func New(log *slog.Logger, userSaver UserSaver) http.HandlerFunc {
+func New(log *slog.Logger, userSaver UserSaver, tokenIssuer TokenIssuer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.user.save.New"
 
@@ -112,7 +117,7 @@ func New(log *slog.Logger, userSaver UserSaver) http.HandlerFunc {
 
 		log.Info("user saved into db", slog.Int64("id", id))
 
-		jwtUserAccessToken, jwtUserRefreshToken, err := jwtAuth.GenerateTokens(id)
+		jwtUserAccessToken, jwtUserRefreshToken, err := tokenIssuer.IssueTokens(id)
 		if err != nil {
 			log.Error("failed to generate json web token for user id", sl.Err(err))
 
@@ -35,70 +35,77 @@ func TestSaveHandler(t *testing.T) {
 			username:  "",
 			email:     "din02winchester25@gmail.com",
 			password:  "Abdrahman_02!",
-			respError: "failed to decode request",
+			respError: "field Username is a required field",
 		},
 		{
 			name:      "Empty email",
 			username:  "Abdrahman",
 			email:     "",
 			password:  "Abdrahman_02!",
-			respError: "field email is a required field",
+			respError: "field Email is a required field",
 		},
 		{
 			name:      "Empty password",
 			username:  "Abdrahman",
 			email:     "din02winchester25@gmail.com",
 			password:  "",
-			respError: "field password is a required field",
+			respError: "field Password is a required field",
 		},
 		{
 			name:      "Empty username & email",
 			username:  "",
 			email:     "",
 			password:  "Abdrahman_02!",
-			respError: "field username is a required field",
+			respError: "field Username is a required field, field Email is a required field",
 		},
 		{
 			name:      "Empty email & password",
 			username:  "Abdrahman",
 			email:     "",
 			password:  "",
-			respError: "field email is a required field",
+			respError: "field Email is a required field, field Password is a required field",
 		},
 		{
 			name:      "Empty username & password",
 			username:  "",
 			email:     "din02winchester25@gmail.com",
 			password:  "",
-			respError: "field username is a required field",
+			respError: "field Username is a required field, field Password is a required field",
 		},
 		{
 			name:      "All empty",
 			username:  "",
 			email:     "",
 			password:  "",
-			respError: "field username is a required field",
+			respError: "field Username is a required field, field Email is a required field, field Password is a required field",
 		},
 		{
 			name:      "Invalid username",
 			username:  "Aba",
 			email:     "din02winchester25@gmail.com",
 			password:  "Abdrahman_02!",
-			respError: "field username is not a valid username",
+			respError: "field Username is not valid",
+		},
+		{
+			name:      "Username with @",
+			username:  "alice@example.com",
+			email:     "din02winchester25@gmail.com",
+			password:  "Abdrahman_02!",
+			respError: "field Username is not valid",
 		},
 		{
 			name:      "Invalid email",
 			username:  "Abdrahman",
 			email:     "din02winchester25",
 			password:  "Abdrahman_02!",
-			respError: "field email is not a valid email",
+			respError: "field Email is not a valid email",
 		},
 		{
 			name:      "Invalid password",
 			username:  "Abdrahman",
 			email:     "din02winchester25@gmail.com",
 			password:  "abdrahman02",
-			respError: "field password is not a valid password",
+			respError: "field Password is not valid",
 		},
 		{
 			name:      "SaveUser Error",
@@ -111,10 +118,12 @@ func TestSaveHandler(t *testing.T) {
 	}
 
 	for _, test := range tests {
+		test := test
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
 			userSaverMock := mocks.NewUserSaver(t)
+			tokenIssuerMock := mocks.NewTokenIssuer(t)
 
 			if test.respError == "" || test.mockError != nil {
 				userSaverMock.On("SaveUser", test.username, test.email, mock.Anything). // mock.Anything because I encrypt user's password through bcrypt and hash everytime is different
@@ -122,7 +131,13 @@ func TestSaveHandler(t *testing.T) {
 													Once()
 			}
 
-			handler := save.New(slogdiscard.NewDiscardLogger(), userSaverMock)
+			if test.respError == "" {
+				tokenIssuerMock.On("IssueTokens", int64(1)).
+					Return("access_token", "refresh_token", nil).
+					Once()
+			}
+
+			handler := save.New(slogdiscard.NewDiscardLogger(), userSaverMock, tokenIssuerMock)
 
 			input := fmt.Sprintf(`{"username": "%s", "email": "%s", "password": "%s"}`, test.username, test.email, test.password)
 
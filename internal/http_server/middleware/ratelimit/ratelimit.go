@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/lib/ratelimit"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+// maxBodyPeek is the size of the request body read by ByJSONField, larger bodies aren't limited by the field.
+const maxBodyPeek = 64 << 10
+
+// KeyFunc returns the key the request is limited by, the request isn't limited if it's empty.
+type KeyFunc func(r *http.Request) string
+
+// New limits the requests with the same key to limit, the requests over it are rejected with 429 Too Many Requests
+// and a Retry-After header. The counters are kept in the store under the name of the limit and the key,
+// so limits sharing a store must have different names. A disabled limit passes every request.
+func New(log *slog.Logger, store ratelimit.Store, name string, limit ratelimit.Limit, key KeyFunc) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limit.Enabled() {
+			return next
+		}
+
+		log := log.With(
+			slog.String("component", "middleware/ratelimit"),
+			slog.String("limit", name),
+		)
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed, wait, err := store.Allow(r.Context(), name+":"+k, limit, time.Now())
+			if err != nil {
+				// the limits protect the server, an unavailable store shouldn't take it down
+				log.Error("failed to check rate limit", sl.Err(err))
+
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !allowed {
+				log.Info("rate limit exceeded",
+					slog.String("remote_addr", r.RemoteAddr),
+					slog.String("request_id", middleware.GetReqID(r.Context())),
+				)
+
+				TooManyRequests(w, r, wait)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// TooManyRequests rejects the request that can be retried after wait.
+func TooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(RetryAfter(wait), 10))
+	render.Status(r, http.StatusTooManyRequests)
+	render.JSON(w, r, resp.Error("too many requests"))
+}
+
+// RetryAfter returns the wait in whole seconds for the Retry-After header, rounded up and at least 1.
+func RetryAfter(wait time.Duration) int64 {
+	return max(int64(math.Ceil(wait.Seconds())), 1)
+}
+
+// ByIP keys the requests by the IP address of the peer. Behind a proxy, chi's RealIP middleware
+// must set the remote address first.
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// ByJSONField keys the requests by a string field of the JSON body, e.g. the login of the account,
+// trimmed and lowercased. The body is restored for the handler.
+func ByJSONField(field string) KeyFunc {
+	return func(r *http.Request) string {
+		return strings.ToLower(strings.TrimSpace(jsonField(r, field)))
+	}
+}
+
+// JSONField keys the requests by a string field of the JSON body as sent. The body is restored for the handler.
+func JSONField(field string) KeyFunc {
+	return func(r *http.Request) string {
+		return jsonField(r, field)
+	}
+}
+
+// jsonField returns a string field of the JSON body as sent, empty if there is none. The body is restored for the handler.
+func jsonField(r *http.Request, field string) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBodyPeek))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ""
+	}
+
+	var value string
+	if err := json.Unmarshal(fields[field], &value); err != nil {
+		return ""
+	}
+
+	return value
+}
+
+// ByAccount keys the requests by the account the login of the request names, see AccountKey, e.g. a field
+// of the JSON body or the subject of a token. resolve returns the ID of the user with the login, false if there is none.
+func ByAccount(login KeyFunc, resolve func(login string) (int64, bool)) KeyFunc {
+	return func(r *http.Request) string {
+		login := login(r)
+		if strings.TrimSpace(login) == "" {
+			return ""
+		}
+
+		userID, ok := resolve(login)
+		if !ok {
+			userID = 0
+		}
+
+		return AccountKey(userID, login)
+	}
+}
+
+// AccountKey returns the key of an account: the user ID, so the username and the email of the account
+// share one counter, or the trimmed and lowercased login if userID is 0 because the login is unknown.
+func AccountKey(userID int64, login string) string {
+	if userID != 0 {
+		return "user:" + strconv.FormatInt(userID, 10)
+	}
+
+	return "login:" + strings.ToLower(strings.TrimSpace(login))
+}
@@ -0,0 +1,109 @@
+package ratelimit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	mwRateLimit "new-websocket-chat/internal/http_server/middleware/ratelimit"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/lib/ratelimit"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      ratelimit.Limit
+		key        mwRateLimit.KeyFunc
+		bodies     []string
+		remoteAddr []string
+		// status of each request
+		want []int
+	}{
+		{
+			name:       "Limited by IP",
+			limit:      ratelimit.Limit{Rate: 0.001, Burst: 2},
+			key:        mwRateLimit.ByIP,
+			remoteAddr: []string{"10.0.0.1:1000", "10.0.0.1:1001", "10.0.0.1:1002", "10.0.0.2:1000"},
+			want:       []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+		{
+			name:   "Limited by JSON field",
+			limit:  ratelimit.Limit{Rate: 0.001, Burst: 1},
+			key:    mwRateLimit.ByJSONField("login"),
+			bodies: []string{`{"login": "Alice"}`, `{"login": " alice "}`, `{"login": "bob"}`},
+			want:   []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+		{
+			name:  "Limited by account",
+			limit: ratelimit.Limit{Rate: 0.001, Burst: 1},
+			key: mwRateLimit.ByAccount(mwRateLimit.JSONField("login"), func(login string) (int64, bool) {
+				if login == "Alice" || login == "alice@example.com" {
+					return 1, true
+				}
+				return 0, false
+			}),
+			bodies: []string{`{"login": "Alice"}`, `{"login": "alice@example.com"}`, `{"login": "bob"}`, `{"login": " BOB"}`},
+			want:   []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:   "Requests without the key aren't limited",
+			limit:  ratelimit.Limit{Rate: 0.001, Burst: 1},
+			key:    mwRateLimit.ByJSONField("login"),
+			bodies: []string{`{}`, `{}`, `not json`, `{"login": 5}`},
+			want:   []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:   "Disabled",
+			key:    mwRateLimit.ByIP,
+			bodies: []string{``, ``, ``},
+			want:   []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// the handler echoes the body, so the tests check that it was restored
+			handler := mwRateLimit.New(slogdiscard.NewDiscardLogger(), ratelimit.NewMemoryStore(), "test", test.limit, test.key)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					io.Copy(w, r.Body)
+				}),
+			)
+
+			for i, want := range test.want {
+				var body string
+				if i < len(test.bodies) {
+					body = test.bodies[i]
+				}
+				req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
+				if i < len(test.remoteAddr) {
+					req.RemoteAddr = test.remoteAddr[i]
+				}
+
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+
+				require.Equal(t, want, rr.Code, "request %d", i)
+				if want == http.StatusOK {
+					require.Equal(t, body, rr.Body.String())
+					continue
+				}
+
+				require.Equal(t, "1000", rr.Header().Get("Retry-After"))
+
+				var res resp.Response
+				require.NoError(t, json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&res))
+				require.Equal(t, "too many requests", res.Error)
+			}
+		})
+	}
+}
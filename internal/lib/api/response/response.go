@@ -34,7 +34,7 @@ func ValidationError(errs validator.ValidationErrors) Response {
 
 	for _, err := range errs {
 		switch err.ActualTag() {
-		case "required":
+		case "required", "required_if", "required_unless", "required_without":
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is a required field", err.Field()))
 		case "username":
 			errMsgs = append(errMsgs, fmt.Sprintf("field %s is not a valid username", err.Field()))
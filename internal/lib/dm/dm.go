@@ -0,0 +1,55 @@
+package dm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Prefix starts the names of the rooms that hold direct messages. Users can't join such rooms.
+const Prefix = "dm:"
+
+// Room returns the name of the room that holds the direct messages between two users.
+// The name doesn't depend on the order of the users.
+func Room(userID int64, otherUserID int64) string {
+	if userID > otherUserID {
+		userID, otherUserID = otherUserID, userID
+	}
+
+	return fmt.Sprintf("%s%d:%d", Prefix, userID, otherUserID)
+}
+
+// IsDirect reports whether the room name is reserved for direct messages.
+func IsDirect(room string) bool {
+	return strings.HasPrefix(room, Prefix)
+}
+
+// Members returns the users of a direct messages room. ok is false if the name isn't a valid direct room.
+func Members(room string) (userID int64, otherUserID int64, ok bool) {
+	first, second, found := strings.Cut(strings.TrimPrefix(room, Prefix), ":")
+	if !IsDirect(room) || !found {
+		return 0, 0, false
+	}
+
+	userID, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	otherUserID, err = strconv.ParseInt(second, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return userID, otherUserID, Room(userID, otherUserID) == room
+}
+
+// IsMember reports whether the user can read the room. Every user can read rooms that aren't direct.
+func IsMember(room string, userID int64) bool {
+	if !IsDirect(room) {
+		return true
+	}
+
+	first, second, ok := Members(room)
+
+	return ok && (userID == first || userID == second)
+}
@@ -0,0 +1,31 @@
+package dm_test
+
+import (
+	"new-websocket-chat/internal/lib/dm"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoom(t *testing.T) {
+	require.Equal(t, "dm:2:10", dm.Room(10, 2))
+	require.Equal(t, dm.Room(2, 10), dm.Room(10, 2))
+
+	first, second, ok := dm.Members("dm:2:10")
+	require.True(t, ok)
+	require.Equal(t, int64(2), first)
+	require.Equal(t, int64(10), second)
+
+	for _, room := range []string{"general", "dm:10:2", "dm:2", "dm:a:b", "dm:02:10"} {
+		_, _, ok := dm.Members(room)
+		require.False(t, ok, room)
+	}
+}
+
+func TestIsMember(t *testing.T) {
+	require.True(t, dm.IsMember("general", 1))
+	require.True(t, dm.IsMember("dm:1:2", 1))
+	require.True(t, dm.IsMember("dm:1:2", 2))
+	require.False(t, dm.IsMember("dm:1:2", 3))
+	require.False(t, dm.IsMember("dm:2:1", 1))
+}
@@ -11,3 +11,8 @@ func EncryptPassword(password string) (string, error) {
 
 	return string(passwordHash), nil
 }
+
+// ComparePassword returns nil if the password matches the bcrypt hash.
+func ComparePassword(passwordHash string, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
+}
@@ -88,3 +88,51 @@ func TestNewEncryptedPassword(t *testing.T) {
 		})
 	}
 }
+
+func TestComparePassword(t *testing.T) {
+	passwordHash, err := EncryptPassword("Abdrahman_02!")
+	if err != nil {
+		t.Fatalf("EncryptPassword returned an error %s", err)
+	}
+
+	tests := []struct {
+		name        string
+		hash        string
+		password    string
+		expectError bool
+	}{
+		{
+			name:        "matching password",
+			hash:        passwordHash,
+			password:    "Abdrahman_02!",
+			expectError: false,
+		},
+		{
+			name:        "wrong password",
+			hash:        passwordHash,
+			password:    "Abdrahman_02",
+			expectError: true,
+		},
+		{
+			name:        "empty password",
+			hash:        passwordHash,
+			password:    "",
+			expectError: true,
+		},
+		{
+			name:        "not a bcrypt hash",
+			hash:        "Abdrahman_02!",
+			password:    "Abdrahman_02!",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ComparePassword(test.hash, test.password)
+			if (err != nil) != test.expectError {
+				t.Errorf("ComparePassword(%q, %q) returned an error %v", test.hash, test.password, err)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package jwtAuth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"net/http"
@@ -10,47 +13,45 @@ import (
 	"time"
 )
 
-type JWTAuthService struct{}
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
 
-func (s *JWTAuthService) ExtractToken(r *http.Request) (string, error) {
-	return ExtractToken(r)
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// Claims are the claims of the access and refresh tokens.
+type Claims struct {
+	jwt.StandardClaims
+	TokenType string `json:"token_type"` // access or refresh
 }
 
-func (s *JWTAuthService) ValidateToken(tokenString string) (*jwt.StandardClaims, error) {
-	return ValidateToken(tokenString)
+// HashToken returns the hex encoded SHA-256 hash of the token, only hashes of refresh tokens are stored.
+func HashToken(tokenString string) string {
+	hash := sha256.Sum256([]byte(tokenString))
+
+	return hex.EncodeToString(hash[:])
 }
 
-func (s *JWTAuthService) GenerateTokens(userID int64) (string, string, error) {
-	return GenerateTokens(userID)
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
 }
 
 func GenerateTokens(userID int64) (accessTokenString string, refreshTokenString string, err error) {
 	const op = "lib.jwt.GenerateToken"
 
-	jwtIssuer := os.Getenv("JWT_ISSUER")
-	jwtSecretKey := os.Getenv("JWT_SECRET_KEY")
-
-	accessTokenClaims := jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(time.Minute * 15).Unix(),
-		IssuedAt:  time.Now().Unix(),
-		Issuer:    jwtIssuer,
-		Subject:   strconv.FormatInt(userID, 10),
-	}
-
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	accessTokenString, err = accessToken.SignedString([]byte(jwtSecretKey))
+	accessTokenString, err = signToken(userID, TokenTypeAccess, AccessTokenTTL)
 	if err != nil {
 		return "", "", fmt.Errorf("%s: failed to sign access token: %w", op, err)
 	}
 
-	refreshTokenClaims := jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 7).Unix(),
-		IssuedAt:  time.Now().Unix(),
-		Issuer:    jwtIssuer,
-		Subject:   strconv.FormatInt(userID, 10),
-	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims)
-	refreshTokenString, err = refreshToken.SignedString([]byte(jwtSecretKey))
+	refreshTokenString, err = signToken(userID, TokenTypeRefresh, RefreshTokenTTL)
 	if err != nil {
 		return "", "", fmt.Errorf("%s: failed to sign refresh token: %w", op, err)
 	}
@@ -58,10 +59,32 @@ func GenerateTokens(userID int64) (accessTokenString string, refreshTokenString
 	return accessTokenString, refreshTokenString, err
 }
 
-func ValidateToken(tokenString string) (*jwt.StandardClaims, error) {
+func signToken(userID int64, tokenType string, ttl time.Duration) (string, error) {
+	tokenID, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    os.Getenv("JWT_ISSUER"),
+			Subject:   strconv.FormatInt(userID, 10),
+		},
+		TokenType: tokenType,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
+}
+
+func ValidateToken(tokenString string) (*Claims, error) {
 	const op = "lib.jwt.ValidateToken"
 
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("%s: unexpected signing method: %v", op, token.Header["alg"])
 		}
@@ -71,7 +94,7 @@ func ValidateToken(tokenString string) (*jwt.StandardClaims, error) {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
 		return claims, nil
 	}
 
@@ -90,10 +113,10 @@ func ExtractToken(r *http.Request) (string, error) {
 
 	// if not in the auth header
 	queryParams := r.URL.Query()
-    token := queryParams.Get("token")
-    if token != "" {
-        return token, nil
-    }
+	token := queryParams.Get("token")
+	if token != "" {
+		return token, nil
+	}
 
-	return "", fmt.Errorf("%s: failed to extract token %w", op)
+	return "", fmt.Errorf("%s: failed to extract token", op)
 }
@@ -2,6 +2,7 @@ package jwtAuth
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	jwtAuth "new-websocket-chat/internal/lib/jwt"
 )
@@ -12,6 +13,7 @@ func TokenAuthMiddleware(next http.Handler) http.Handler {
 		tokenString, err := jwtAuth.ExtractToken(r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
 		}
 
 		claims, err := jwtAuth.ValidateToken(tokenString)
@@ -20,6 +22,12 @@ func TokenAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// refresh tokens are only accepted by the refresh endpoint
+		if claims.TokenType != jwtAuth.TokenTypeAccess {
+			http.Error(w, fmt.Sprintf("%s: not an access token", op), http.StatusUnauthorized)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), "userID", claims.Subject)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
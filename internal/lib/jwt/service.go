@@ -0,0 +1,141 @@
+package jwtAuth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"new-websocket-chat/internal/storage"
+	"time"
+)
+
+// ErrTokenReused is returned when an already rotated refresh token is presented again.
+// The whole token family is revoked in this case, because either the client or an attacker holds a stolen token.
+var ErrTokenReused = errors.New("refresh token reuse detected")
+
+// RefreshTokenStore persists hashes of the issued refresh tokens.
+type RefreshTokenStore interface {
+	SaveRefreshToken(userID int64, familyID string, tokenHash string, expiresAt time.Time) error
+	GetRefreshToken(tokenHash string) (storage.RefreshToken, error)
+	RevokeRefreshToken(tokenHash string) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int64) error
+}
+
+// JWTAuthService issues token pairs and rotates refresh tokens.
+// Every login starts a new token family, every refresh replaces the presented token by a new one of the same family.
+type JWTAuthService struct {
+	store RefreshTokenStore
+}
+
+func NewJWTAuthService(store RefreshTokenStore) *JWTAuthService {
+	return &JWTAuthService{store: store}
+}
+
+func (s *JWTAuthService) ExtractToken(r *http.Request) (string, error) {
+	return ExtractToken(r)
+}
+
+func (s *JWTAuthService) ValidateToken(tokenString string) (*Claims, error) {
+	return ValidateToken(tokenString)
+}
+
+// IssueTokens generates a token pair for a new session of the user.
+func (s *JWTAuthService) IssueTokens(userID int64) (accessTokenString string, refreshTokenString string, err error) {
+	const op = "lib.jwt.IssueTokens"
+
+	familyID, err := newRandomID()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: failed to generate family id: %w", op, err)
+	}
+
+	accessTokenString, refreshTokenString, err = s.issue(userID, familyID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return accessTokenString, refreshTokenString, nil
+}
+
+// RotateTokens revokes the presented refresh token and generates a new token pair of the same family.
+// It returns storage.ErrTokenNotFound if the token wasn't issued to the user and ErrTokenReused if it was already rotated.
+func (s *JWTAuthService) RotateTokens(userID int64, refreshTokenString string) (accessTokenString string, newRefreshTokenString string, err error) {
+	const op = "lib.jwt.RotateTokens"
+
+	tokenHash := HashToken(refreshTokenString)
+
+	stored, err := s.store.GetRefreshToken(tokenHash)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if stored.UserID != userID {
+		return "", "", fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+	}
+
+	if stored.RevokedAt == nil {
+		err = s.store.RevokeRefreshToken(tokenHash)
+	} else {
+		err = storage.ErrTokenRevoked
+	}
+	if errors.Is(err, storage.ErrTokenRevoked) {
+		if err := s.store.RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+			return "", "", fmt.Errorf("%s: failed to revoke token family: %w", op, err)
+		}
+
+		return "", "", fmt.Errorf("%s: %w", op, ErrTokenReused)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	accessTokenString, newRefreshTokenString, err = s.issue(userID, stored.FamilyID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return accessTokenString, newRefreshTokenString, nil
+}
+
+// RevokeSession revokes every refresh token of the family the presented token belongs to, ending the session.
+// It returns storage.ErrTokenNotFound if the token wasn't issued to the user.
+func (s *JWTAuthService) RevokeSession(userID int64, refreshTokenString string) error {
+	const op = "lib.jwt.RevokeSession"
+
+	stored, err := s.store.GetRefreshToken(HashToken(refreshTokenString))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if stored.UserID != userID {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+	}
+
+	if err := s.store.RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeAllSessions revokes every refresh token of the user. Access tokens stay valid until they expire.
+func (s *JWTAuthService) RevokeAllSessions(userID int64) error {
+	const op = "lib.jwt.RevokeAllSessions"
+
+	if err := s.store.RevokeUserRefreshTokens(userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *JWTAuthService) issue(userID int64, familyID string) (string, string, error) {
+	accessTokenString, refreshTokenString, err := GenerateTokens(userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	expiresAt := time.Now().Add(RefreshTokenTTL)
+	if err := s.store.SaveRefreshToken(userID, familyID, HashToken(refreshTokenString), expiresAt); err != nil {
+		return "", "", fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	return accessTokenString, refreshTokenString, nil
+}
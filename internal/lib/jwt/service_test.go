@@ -0,0 +1,179 @@
+package jwtAuth
+
+import (
+	"errors"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTokenStore keeps refresh tokens in memory.
+type fakeTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]storage.RefreshToken
+}
+
+func newFakeTokenStore() *fakeTokenStore {
+	return &fakeTokenStore{tokens: make(map[string]storage.RefreshToken)}
+}
+
+func (s *fakeTokenStore) SaveRefreshToken(userID int64, familyID string, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[tokenHash] = storage.RefreshToken{UserID: userID, FamilyID: familyID, TokenHash: tokenHash, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (s *fakeTokenStore) GetRefreshToken(tokenHash string) (storage.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[tokenHash]
+	if !ok {
+		return storage.RefreshToken{}, storage.ErrTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *fakeTokenStore) RevokeRefreshToken(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := s.tokens[tokenHash]
+	if token.RevokedAt != nil {
+		return storage.ErrTokenRevoked
+	}
+	now := time.Now()
+	token.RevokedAt = &now
+	s.tokens[tokenHash] = token
+	return nil
+}
+
+func (s *fakeTokenStore) RevokeRefreshTokenFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range s.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			s.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (s *fakeTokenStore) RevokeUserRefreshTokens(userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range s.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			s.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func TestGenerateTokensTypes(t *testing.T) {
+	accessToken, refreshToken, err := GenerateTokens(7)
+	require.NoError(t, err)
+
+	accessClaims, err := ValidateToken(accessToken)
+	require.NoError(t, err)
+	require.Equal(t, TokenTypeAccess, accessClaims.TokenType)
+	require.Equal(t, strconv.Itoa(7), accessClaims.Subject)
+
+	refreshClaims, err := ValidateToken(refreshToken)
+	require.NoError(t, err)
+	require.Equal(t, TokenTypeRefresh, refreshClaims.TokenType)
+	require.NotEqual(t, accessClaims.Id, refreshClaims.Id)
+}
+
+func TestRotateTokens(t *testing.T) {
+	store := newFakeTokenStore()
+	service := NewJWTAuthService(store)
+
+	_, refreshToken, err := service.IssueTokens(7)
+	require.NoError(t, err)
+
+	stored, err := store.GetRefreshToken(HashToken(refreshToken))
+	require.NoError(t, err)
+	require.Equal(t, int64(7), stored.UserID)
+
+	_, rotatedToken, err := service.RotateTokens(7, refreshToken)
+	require.NoError(t, err)
+	require.NotEqual(t, refreshToken, rotatedToken)
+
+	rotated, err := store.GetRefreshToken(HashToken(rotatedToken))
+	require.NoError(t, err)
+	require.Equal(t, stored.FamilyID, rotated.FamilyID)
+
+	// the rotated token of the same family keeps working
+	_, nextToken, err := service.RotateTokens(7, rotatedToken)
+	require.NoError(t, err)
+
+	// presenting an already rotated token revokes the whole family
+	_, _, err = service.RotateTokens(7, refreshToken)
+	require.True(t, errors.Is(err, ErrTokenReused))
+
+	_, _, err = service.RotateTokens(7, nextToken)
+	require.True(t, errors.Is(err, ErrTokenReused))
+}
+
+func TestRotateTokensOfAnotherUser(t *testing.T) {
+	store := newFakeTokenStore()
+	service := NewJWTAuthService(store)
+
+	_, refreshToken, err := service.IssueTokens(7)
+	require.NoError(t, err)
+
+	_, _, err = service.RotateTokens(8, refreshToken)
+	require.True(t, errors.Is(err, storage.ErrTokenNotFound))
+
+	_, _, err = service.RotateTokens(7, "not issued")
+	require.True(t, errors.Is(err, storage.ErrTokenNotFound))
+}
+
+func TestRevokeSessions(t *testing.T) {
+	store := newFakeTokenStore()
+	service := NewJWTAuthService(store)
+
+	_, firstSession, err := service.IssueTokens(7)
+	require.NoError(t, err)
+	_, secondSession, err := service.IssueTokens(7)
+	require.NoError(t, err)
+	_, thirdSession, err := service.IssueTokens(7)
+	require.NoError(t, err)
+	_, otherUserSession, err := service.IssueTokens(8)
+	require.NoError(t, err)
+
+	_, rotated, err := service.RotateTokens(7, firstSession)
+	require.NoError(t, err)
+
+	require.True(t, errors.Is(service.RevokeSession(8, rotated), storage.ErrTokenNotFound))
+
+	// logging out with the rotated token ends the session it was rotated in
+	require.NoError(t, service.RevokeSession(7, rotated))
+	_, _, err = service.RotateTokens(7, rotated)
+	require.True(t, errors.Is(err, ErrTokenReused))
+
+	_, secondSession, err = service.RotateTokens(7, secondSession)
+	require.NoError(t, err)
+
+	require.NoError(t, service.RevokeAllSessions(7))
+	_, _, err = service.RotateTokens(7, secondSession)
+	require.Error(t, err)
+	_, _, err = service.RotateTokens(7, thirdSession)
+	require.Error(t, err)
+
+	_, _, err = service.RotateTokens(8, otherUserSession)
+	require.NoError(t, err)
+}
@@ -0,0 +1,39 @@
+package mention
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Parse returns the usernames mentioned with @username in the text, without duplicates and in the order
+// of their first mention. A mention starts at the beginning of the text or after a character that can't be
+// part of a username, so e-mail addresses aren't mentions. Trailing dots are punctuation, not part of the name.
+func Parse(text string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && isNameRune(runes[i-1])) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isNameRune(runes[end]) {
+			end++
+		}
+
+		username := strings.TrimRight(string(runes[i+1:end]), ".")
+		if username != "" && !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+		i = end - 1
+	}
+
+	return usernames
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
@@ -0,0 +1,27 @@
+package mention_test
+
+import (
+	"new-websocket-chat/internal/lib/mention"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "hello", want: nil},
+		{text: "@alice hi", want: []string{"alice"}},
+		{text: "hi @alice and @bob.smith.", want: []string{"alice", "bob.smith"}},
+		{text: "@alice, @bob: @alice", want: []string{"alice", "bob"}},
+		{text: "write to alice@example.com", want: nil},
+		{text: "(@carol_1) @ @", want: []string{"carol_1"}},
+		{text: "привет @мария!", want: []string{"мария"}},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.want, mention.Parse(test.text), test.text)
+	}
+}
@@ -0,0 +1,23 @@
+package factory
+
+import (
+	"fmt"
+	"new-websocket-chat/internal/config"
+	"new-websocket-chat/internal/lib/ratelimit"
+)
+
+const (
+	StoreMemory = "memory"
+)
+
+// New creates the rate limit store selected by the auth limits config.
+func New(cfg config.AuthLimits) (ratelimit.Store, error) {
+	const op = "lib.ratelimit.factory.New"
+
+	switch cfg.Store {
+	case StoreMemory:
+		return ratelimit.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("%s: unknown rate limit store %q", op, cfg.Store)
+	}
+}
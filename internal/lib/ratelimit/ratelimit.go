@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often Keyed forgets the buckets that refilled.
+const pruneInterval = time.Minute
+
+// Limit is the rate of a token bucket: it holds Burst tokens at most and refills at Rate tokens per second.
+// A limit with a zero rate or burst doesn't limit anything.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Bucket is a token bucket, every allowed event takes a token. It isn't safe for concurrent use.
+type Bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket.
+func NewBucket(limit Limit, now time.Time) *Bucket {
+	return &Bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// Allow takes a token if there is one. Otherwise it returns false and how long it takes to refill a token.
+func (b *Bucket) Allow(now time.Time) (bool, time.Duration) {
+	if !b.limit.Enabled() {
+		return true, 0
+	}
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration(math.Ceil((1 - b.tokens) / b.limit.Rate * float64(time.Second)))
+	return false, wait
+}
+
+// Full reports whether the bucket refilled completely, a full bucket is the same as a new one.
+func (b *Bucket) Full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.last = now
+	}
+}
+
+// Keyed keeps a bucket for every key, e.g. a user ID. It is safe for concurrent use.
+type Keyed[K comparable] struct {
+	mu        sync.Mutex
+	limit     Limit
+	buckets   map[K]*Bucket
+	lastPrune time.Time
+}
+
+func NewKeyed[K comparable](limit Limit) *Keyed[K] {
+	return &Keyed[K]{limit: limit, buckets: make(map[K]*Bucket)}
+}
+
+// Allow takes a token from the bucket of the key, see Bucket.Allow.
+func (k *Keyed[K]) Allow(key K, now time.Time) (bool, time.Duration) {
+	if !k.limit.Enabled() {
+		return true, 0
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if now.Sub(k.lastPrune) >= pruneInterval {
+		k.prune(now)
+	}
+
+	bucket, ok := k.buckets[key]
+	if !ok {
+		bucket = NewBucket(k.limit, now)
+		k.buckets[key] = bucket
+	}
+
+	return bucket.Allow(now)
+}
+
+// Len returns the number of the buckets that are kept.
+func (k *Keyed[K]) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return len(k.buckets)
+}
+
+// prune forgets the full buckets, so keys that stopped sending don't take memory.
+func (k *Keyed[K]) prune(now time.Time) {
+	for key, bucket := range k.buckets {
+		if bucket.Full(now) {
+			delete(k.buckets, key)
+		}
+	}
+	k.lastPrune = now
+}
@@ -0,0 +1,64 @@
+package ratelimit_test
+
+import (
+	"new-websocket-chat/internal/lib/ratelimit"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	bucket := ratelimit.NewBucket(ratelimit.Limit{Rate: 2, Burst: 3}, now)
+
+	for i := 0; i < 3; i++ {
+		allowed, _ := bucket.Allow(now)
+		require.True(t, allowed)
+	}
+	allowed, wait := bucket.Allow(now)
+	require.False(t, allowed)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	// half a second refills one token at 2 tokens per second
+	allowed, _ = bucket.Allow(now.Add(500 * time.Millisecond))
+	require.True(t, allowed)
+	allowed, wait = bucket.Allow(now.Add(750 * time.Millisecond))
+	require.False(t, allowed)
+	require.Equal(t, 250*time.Millisecond, wait)
+
+	// the bucket never holds more than the burst
+	require.True(t, bucket.Full(now.Add(time.Hour)))
+	for i := 0; i < 3; i++ {
+		allowed, _ := bucket.Allow(now.Add(time.Hour))
+		require.True(t, allowed)
+	}
+	allowed, _ = bucket.Allow(now.Add(time.Hour))
+	require.False(t, allowed)
+}
+
+func TestBucketDisabled(t *testing.T) {
+	bucket := ratelimit.NewBucket(ratelimit.Limit{}, time.Now())
+	for i := 0; i < 100; i++ {
+		allowed, _ := bucket.Allow(time.Now())
+		require.True(t, allowed)
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	now := time.Now()
+	keyed := ratelimit.NewKeyed[int64](ratelimit.Limit{Rate: 1, Burst: 1})
+
+	allowed, _ := keyed.Allow(1, now)
+	require.True(t, allowed)
+	allowed, _ = keyed.Allow(1, now)
+	require.False(t, allowed)
+	allowed, _ = keyed.Allow(2, now)
+	require.True(t, allowed)
+	require.Equal(t, 2, keyed.Len())
+
+	// the refilled buckets are forgotten
+	allowed, _ = keyed.Allow(3, now.Add(2*time.Minute))
+	require.True(t, allowed)
+	require.Equal(t, 1, keyed.Len())
+}
@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps the rate limit counters by key. MemoryStore limits a single instance, a store shared by the
+// instances, e.g. on Redis, keeps the limits when the server is scaled out. Implementations must be safe for
+// concurrent use and change the counters of a key atomically.
+type Store interface {
+	// Allow takes a token from the bucket of the key, see Bucket.Allow. A new key starts with a full bucket.
+	Allow(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error)
+
+	// Fail records a failed attempt of the key and returns when the lockout of the key ends,
+	// the zero time if the key isn't locked out.
+	Fail(ctx context.Context, key string, lockout Lockout, now time.Time) (time.Time, error)
+
+	// LockedUntil returns when the lockout of the key ends, the zero time if the key isn't locked out at now.
+	LockedUntil(ctx context.Context, key string, now time.Time) (time.Time, error)
+
+	// Reset forgets the failed attempts of the key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Lockout locks a key out once it failed Threshold times in a row: for Base, doubled with every further
+// failure up to Max. Failures are forgotten Window after the last one. A zero threshold never locks out.
+type Lockout struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Window    time.Duration
+}
+
+// Duration returns how long a key is locked out after the number of failures in a row.
+func (l Lockout) Duration(failures int) time.Duration {
+	if l.Threshold <= 0 || failures < l.Threshold {
+		return 0
+	}
+
+	d := l.Base
+	for i := l.Threshold; i < failures && d < l.Max; i++ {
+		d *= 2
+	}
+
+	return min(d, l.Max)
+}
+
+// Guard locks out the keys with too many failed attempts, e.g. logins with wrong passwords.
+type Guard struct {
+	store   Store
+	lockout Lockout
+	now     func() time.Time
+}
+
+func NewGuard(store Store, lockout Lockout) *Guard {
+	return &Guard{store: store, lockout: lockout, now: time.Now}
+}
+
+// Check returns how long the key is still locked out, 0 if it isn't.
+func (g *Guard) Check(ctx context.Context, key string) (time.Duration, error) {
+	now := g.now()
+
+	until, err := g.store.LockedUntil(ctx, key, now)
+	if err != nil || until.IsZero() {
+		return 0, err
+	}
+
+	return until.Sub(now), nil
+}
+
+// Fail records a failed attempt and returns how long the key is locked out now, 0 if it isn't.
+func (g *Guard) Fail(ctx context.Context, key string) (time.Duration, error) {
+	now := g.now()
+
+	until, err := g.store.Fail(ctx, key, g.lockout, now)
+	if err != nil || until.IsZero() {
+		return 0, err
+	}
+
+	return until.Sub(now), nil
+}
+
+// Succeed forgets the failed attempts of the key.
+func (g *Guard) Succeed(ctx context.Context, key string) error {
+	return g.store.Reset(ctx, key)
+}
+
+// MemoryStore keeps the counters in memory, they are lost on restart and aren't shared between instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	failures  map[string]*failures
+	lastPrune time.Time
+}
+
+// failures are the failed attempts of a key in a row.
+type failures struct {
+	count       int
+	last        time.Time
+	window      time.Duration
+	lockedUntil time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  make(map[string]*Bucket),
+		failures: make(map[string]*failures),
+	}
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maybePrune(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok || bucket.limit != limit {
+		bucket = NewBucket(limit, now)
+		s.buckets[key] = bucket
+	}
+
+	allowed, wait := bucket.Allow(now)
+	return allowed, wait, nil
+}
+
+func (s *MemoryStore) Fail(ctx context.Context, key string, lockout Lockout, now time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maybePrune(now)
+
+	f, ok := s.failures[key]
+	if !ok || f.expired(now) {
+		f = &failures{}
+		s.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	f.window = lockout.Window
+
+	if d := lockout.Duration(f.count); d > 0 {
+		f.lockedUntil = now.Add(d)
+		return f.lockedUntil, nil
+	}
+
+	return time.Time{}, nil
+}
+
+func (s *MemoryStore) LockedUntil(ctx context.Context, key string, now time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.failures[key]
+	if !ok || !now.Before(f.lockedUntil) {
+		return time.Time{}, nil
+	}
+
+	return f.lockedUntil, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, key)
+
+	return nil
+}
+
+// expired reports whether the failures were forgotten: the window after the last one and the lockout passed.
+func (f *failures) expired(now time.Time) bool {
+	return now.Sub(f.last) > f.window && !now.Before(f.lockedUntil)
+}
+
+// maybePrune forgets the full buckets and the expired failures once per pruneInterval.
+func (s *MemoryStore) maybePrune(now time.Time) {
+	if now.Sub(s.lastPrune) < pruneInterval {
+		return
+	}
+
+	for key, bucket := range s.buckets {
+		if bucket.Full(now) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, f := range s.failures {
+		if f.expired(now) {
+			delete(s.failures, key)
+		}
+	}
+	s.lastPrune = now
+}
@@ -0,0 +1,130 @@
+package ratelimit_test
+
+import (
+	"context"
+	"new-websocket-chat/internal/lib/ratelimit"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	lockout := ratelimit.Lockout{Threshold: 3, Base: 30 * time.Second, Max: 5 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 2, want: 0},
+		{failures: 3, want: 30 * time.Second},
+		{failures: 4, want: time.Minute},
+		{failures: 6, want: 4 * time.Minute},
+		{failures: 7, want: 5 * time.Minute},
+		{failures: 1000, want: 5 * time.Minute},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.want, lockout.Duration(test.failures), "failures: %d", test.failures)
+	}
+
+	require.Zero(t, ratelimit.Lockout{}.Duration(1000))
+}
+
+func TestMemoryStoreAllow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := ratelimit.NewMemoryStore()
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := store.Allow(ctx, "a", limit, now)
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	allowed, wait, err := store.Allow(ctx, "a", limit, now)
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.Equal(t, time.Second, wait)
+
+	// keys are limited separately
+	allowed, _, err = store.Allow(ctx, "b", limit, now)
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	allowed, _, err = store.Allow(ctx, "a", limit, now.Add(time.Second))
+	require.NoError(t, err)
+	require.True(t, allowed)
+}
+
+func TestMemoryStoreLockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := ratelimit.NewMemoryStore()
+	lockout := ratelimit.Lockout{Threshold: 2, Base: time.Minute, Max: time.Hour, Window: 10 * time.Minute}
+
+	until, err := store.Fail(ctx, "alice", lockout, now)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+
+	until, err = store.Fail(ctx, "alice", lockout, now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(time.Minute), until)
+
+	until, err = store.LockedUntil(ctx, "alice", now.Add(30*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, now.Add(time.Minute), until)
+
+	until, err = store.LockedUntil(ctx, "bob", now)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+
+	// the lockout doubles with every failure after it ended
+	now = now.Add(time.Minute)
+	until, err = store.LockedUntil(ctx, "alice", now)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+
+	until, err = store.Fail(ctx, "alice", lockout, now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(2*time.Minute), until)
+
+	// the failures are forgotten after the window
+	now = now.Add(time.Hour)
+	until, err = store.Fail(ctx, "alice", lockout, now)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+
+	// and on reset
+	require.NoError(t, store.Reset(ctx, "alice"))
+	until, err = store.Fail(ctx, "alice", lockout, now)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+}
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	guard := ratelimit.NewGuard(ratelimit.NewMemoryStore(), ratelimit.Lockout{Threshold: 2, Base: time.Minute, Max: time.Hour, Window: time.Hour})
+
+	locked, err := guard.Fail(ctx, "alice")
+	require.NoError(t, err)
+	require.Zero(t, locked)
+
+	locked, err = guard.Check(ctx, "alice")
+	require.NoError(t, err)
+	require.Zero(t, locked)
+
+	locked, err = guard.Fail(ctx, "alice")
+	require.NoError(t, err)
+	require.InDelta(t, time.Minute, locked, float64(time.Second))
+
+	locked, err = guard.Check(ctx, "alice")
+	require.NoError(t, err)
+	require.InDelta(t, time.Minute, locked, float64(time.Second))
+
+	require.NoError(t, guard.Succeed(ctx, "alice"))
+	locked, err = guard.Check(ctx, "alice")
+	require.NoError(t, err)
+	require.Zero(t, locked)
+}
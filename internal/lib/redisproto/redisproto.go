@@ -0,0 +1,124 @@
+// Package redisproto reads and writes the values of RESP, the protocol of Redis.
+package redisproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxBulkLength limits the bulk strings and arrays read from the peer.
+const maxBulkLength = 512 << 20
+
+var ErrProtocol = errors.New("invalid RESP value")
+
+// Error is an error reply of the peer.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Read reads a value: a string for simple and bulk strings, an int64 for integers, an Error for errors,
+// a []any for arrays and nil for null bulk strings and arrays. Bulk strings are returned as []byte.
+func Read(r *bufio.Reader) (any, error) {
+	const op = "lib.redisproto.Read"
+
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrProtocol)
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, ErrProtocol)
+		}
+		return n, nil
+	case '$':
+		n, err := parseLength(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if data[n] != '\r' || data[n+1] != '\n' {
+			return nil, fmt.Errorf("%s: %w", op, ErrProtocol)
+		}
+		return data[:n], nil
+	case '*':
+		n, err := parseLength(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]any, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			v, err := Read(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", op, ErrProtocol)
+	}
+}
+
+// WriteCommand writes the command as an array of bulk strings, the way clients send commands.
+func WriteCommand(w *bufio.Writer, args ...[]byte) error {
+	const op = "lib.redisproto.WriteCommand"
+
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		w.Write(arg)
+		w.WriteString("\r\n")
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// readLine reads a line without the CRLF.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, ErrProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, ErrProtocol
+	}
+
+	return line[:len(line)-2], nil
+}
+
+// parseLength parses the length of a bulk string or an array, -1 is a null value.
+func parseLength(s []byte) (int, error) {
+	const op = "lib.redisproto.parseLength"
+
+	n, err := strconv.Atoi(string(s))
+	if err != nil || n < -1 || n > maxBulkLength {
+		return 0, fmt.Errorf("%s: %w", op, ErrProtocol)
+	}
+
+	return n, nil
+}
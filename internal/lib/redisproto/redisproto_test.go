@@ -0,0 +1,58 @@
+package redisproto_test
+
+import (
+	"bufio"
+	"bytes"
+	"new-websocket-chat/internal/lib/redisproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    any
+		wantErr bool
+	}{
+		{name: "Simple string", input: "+OK\r\n", want: "OK"},
+		{name: "Error", input: "-ERR unknown\r\n", want: redisproto.Error("ERR unknown")},
+		{name: "Integer", input: ":42\r\n", want: int64(42)},
+		{name: "Bulk string", input: "$7\r\nhi\r\nyou\r\n", want: []byte("hi\r\nyou")},
+		{name: "Null bulk string", input: "$-1\r\n", want: nil},
+		{name: "Array", input: "*3\r\n$7\r\nmessage\r\n$3\r\nhub\r\n:1\r\n", want: []any{[]byte("message"), []byte("hub"), int64(1)}},
+		{name: "Unknown type", input: "?1\r\n", wantErr: true},
+		{name: "Missing CR", input: "+OK\n", wantErr: true},
+		{name: "Short bulk string", input: "$5\r\nhi\r\n", wantErr: true},
+		{name: "Invalid length", input: "*x\r\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			v, err := redisproto.Read(bufio.NewReader(strings.NewReader(test.input)))
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, v)
+		})
+	}
+}
+
+func TestWriteCommand(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+
+	require.NoError(t, redisproto.WriteCommand(w, []byte("PUBLISH"), []byte("hub"), []byte("a\r\nb")))
+	require.Equal(t, "*3\r\n$7\r\nPUBLISH\r\n$3\r\nhub\r\n$4\r\na\r\nb\r\n", buf.String())
+
+	v, err := redisproto.Read(bufio.NewReader(&buf))
+	require.NoError(t, err)
+	require.Equal(t, []any{[]byte("PUBLISH"), []byte("hub"), []byte("a\r\nb")}, v)
+}
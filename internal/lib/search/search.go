@@ -0,0 +1,104 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// snippetWords is the number of words of the message kept around the first match in a snippet.
+const snippetWords = 20
+
+// Terms returns the lowercase words of the search query without duplicates. A message matches the query
+// if it contains every term as a word, like plainto_tsquery with the simple configuration of the postgres backend.
+func Terms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(query), isSeparator) {
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+
+	return terms
+}
+
+// Matches reports whether the text contains every term as a word.
+func Matches(text string, terms []string) bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isSeparator) {
+		words[word] = true
+	}
+
+	for _, term := range terms {
+		if !words[term] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Snippet returns the part of the text around the first term, HTML escaped and with the terms wrapped
+// in <mark> tags. Cut ends are marked with an ellipsis.
+func Snippet(text string, terms []string) string {
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+
+	// segments alternate between separators and words, words are at odd indexes and
+	// the text starts and ends with a separator, maybe empty
+	var segments []string
+	start := 0
+	inWord := false
+	for i, r := range text {
+		if isSeparator(r) == inWord {
+			segments = append(segments, text[start:i])
+			start = i
+			inWord = !inWord
+		}
+	}
+	segments = append(segments, text[start:])
+	if len(segments)%2 == 0 {
+		segments = append(segments, "")
+	}
+
+	words := len(segments) / 2
+	first := 0
+	for w := 0; w < words; w++ {
+		if wanted[strings.ToLower(segments[2*w+1])] {
+			first = w
+			break
+		}
+	}
+	from := max(0, first-snippetWords/4)
+	to := min(words, from+snippetWords)
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	} else {
+		b.WriteString(html.EscapeString(segments[0]))
+	}
+	for w := from; w < to; w++ {
+		word := html.EscapeString(segments[2*w+1])
+		if wanted[strings.ToLower(segments[2*w+1])] {
+			word = "<mark>" + word + "</mark>"
+		}
+		b.WriteString(word)
+		if w+1 < to || to == words {
+			b.WriteString(html.EscapeString(segments[2*w+2]))
+		}
+	}
+	if to < words {
+		b.WriteString("…")
+	}
+
+	return b.String()
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,31 @@
+package search_test
+
+import (
+	"new-websocket-chat/internal/lib/search"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerms(t *testing.T) {
+	require.Equal(t, []string{"lunch", "at", "12"}, search.Terms("Lunch at 12? lunch!"))
+	require.Empty(t, search.Terms(" ?! "))
+}
+
+func TestMatches(t *testing.T) {
+	require.True(t, search.Matches("Lunch at noon?", []string{"lunch", "noon"}))
+	require.False(t, search.Matches("Lunch at noon?", []string{"lunch", "dinner"}))
+	require.False(t, search.Matches("lunches", []string{"lunch"}))
+}
+
+func TestSnippet(t *testing.T) {
+	require.Equal(t, "<mark>Lunch</mark> at &lt;b&gt;noon&lt;/b&gt;?", search.Snippet("Lunch at <b>noon</b>?", []string{"lunch"}))
+
+	long := strings.Repeat("word ", 30) + "lunch " + strings.Repeat("word ", 30)
+	snippet := search.Snippet(long, []string{"lunch"})
+	require.True(t, strings.HasPrefix(snippet, "…word "))
+	require.True(t, strings.HasSuffix(snippet, " word…"))
+	require.Contains(t, snippet, "<mark>lunch</mark>")
+	require.Len(t, strings.Fields(snippet), 20)
+}
@@ -0,0 +1,131 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// Size is the maximum width and height of a thumbnail in pixels.
+const Size = 256
+
+// MaxPixels is the biggest image thumbnails are made of, so a small file can't make the server decode a huge image.
+const MaxPixels = 16 << 20
+
+var (
+	ErrUnsupported = errors.New("image format is not supported")
+	ErrTooLarge    = errors.New("image is too large")
+)
+
+// Supported reports whether thumbnails can be made of the files of the MIME type.
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	default:
+		return false
+	}
+}
+
+// Make scales the image down to fit Size x Size, keeping the aspect ratio, and returns the encoded thumbnail
+// with its MIME type. JPEG images get JPEG thumbnails, PNG and GIF images get PNG ones to keep the transparency.
+// Images smaller than Size are encoded as they are.
+func Make(data []byte, contentType string) ([]byte, string, error) {
+	const op = "lib.thumbnail.Make"
+
+	decode, ok := decoders[contentType]
+	if !ok {
+		return nil, "", fmt.Errorf("%s: %w", op, ErrUnsupported)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: decode config: %w", op, err)
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, "", fmt.Errorf("%s: %w", op, ErrTooLarge)
+	}
+
+	src, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: decode image: %w", op, err)
+	}
+	thumb := scale(src)
+
+	var buf bytes.Buffer
+	if ContentType(contentType) == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: encode thumbnail: %w", op, err)
+	}
+
+	return buf.Bytes(), ContentType(contentType), nil
+}
+
+// ContentType returns the MIME type of the thumbnails of the images of the MIME type.
+func ContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+
+	return "image/png"
+}
+
+var decoders = map[string]func(r *bytes.Reader) (image.Image, error){
+	"image/jpeg": func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) },
+	"image/png":  func(r *bytes.Reader) (image.Image, error) { return png.Decode(r) },
+	"image/gif":  func(r *bytes.Reader) (image.Image, error) { return gif.Decode(r) }, // the first frame
+}
+
+// scale averages the boxes of source pixels that make up every pixel of the thumbnail.
+func scale(src image.Image) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= Size && h <= Size {
+		return src
+	}
+
+	tw, th := Size, Size
+	if w > h {
+		th = max(1, h*Size/w)
+	} else {
+		tw = max(1, w*Size/h)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := bounds.Min.Y+y*h/th, bounds.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := bounds.Min.X+x*w/tw, bounds.Min.X+(x+1)*w/tw
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA() // alpha premultiplied
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			// un-premultiply the average, NRGBA stores straight alpha
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r * 0xff / a),
+				G: uint8(g * 0xff / a),
+				B: uint8(b * 0xff / a),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,111 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"new-websocket-chat/internal/lib/thumbnail"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMake(t *testing.T) {
+	cases := []struct {
+		name        string
+		width       int
+		height      int
+		encode      func(buf *bytes.Buffer, img image.Image) error
+		contentType string
+		wantType    string
+		wantWidth   int
+		wantHeight  int
+		wantErr     error
+	}{
+		{
+			name:  "Landscape PNG",
+			width: 1024, height: 512,
+			encode:      func(buf *bytes.Buffer, img image.Image) error { return png.Encode(buf, img) },
+			contentType: "image/png",
+			wantType:    "image/png",
+			wantWidth:   256, wantHeight: 128,
+		},
+		{
+			name:  "Portrait JPEG",
+			width: 300, height: 600,
+			encode:      func(buf *bytes.Buffer, img image.Image) error { return jpeg.Encode(buf, img, nil) },
+			contentType: "image/jpeg",
+			wantType:    "image/jpeg",
+			wantWidth:   128, wantHeight: 256,
+		},
+		{
+			name:  "GIF",
+			width: 512, height: 512,
+			encode:      func(buf *bytes.Buffer, img image.Image) error { return gif.Encode(buf, img, nil) },
+			contentType: "image/gif",
+			wantType:    "image/png",
+			wantWidth:   256, wantHeight: 256,
+		},
+		{
+			name:  "Small image",
+			width: 100, height: 50,
+			encode:      func(buf *bytes.Buffer, img image.Image) error { return png.Encode(buf, img) },
+			contentType: "image/png",
+			wantType:    "image/png",
+			wantWidth:   100, wantHeight: 50,
+		},
+		{
+			name:  "Too large",
+			width: 8192, height: 4096,
+			encode:      func(buf *bytes.Buffer, img image.Image) error { return png.Encode(buf, img) },
+			contentType: "image/png",
+			wantErr:     thumbnail.ErrTooLarge,
+		},
+		{
+			name:  "Unsupported",
+			width: 10, height: 10,
+			encode:      func(buf *bytes.Buffer, img image.Image) error { return png.Encode(buf, img) },
+			contentType: "application/pdf",
+			wantErr:     thumbnail.ErrUnsupported,
+		},
+	}
+
+	for _, test := range cases {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			img := image.NewNRGBA(image.Rect(0, 0, test.width, test.height))
+			for y := 0; y < test.height; y++ {
+				for x := 0; x < test.width; x++ {
+					img.SetNRGBA(x, y, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+				}
+			}
+			var buf bytes.Buffer
+			require.NoError(t, test.encode(&buf, img))
+
+			data, contentType, err := thumbnail.Make(buf.Bytes(), test.contentType)
+			if test.wantErr != nil {
+				require.True(t, errors.Is(err, test.wantErr), err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.wantType, contentType)
+
+			thumb, _, err := image.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+			require.Equal(t, test.wantWidth, thumb.Bounds().Dx())
+			require.Equal(t, test.wantHeight, thumb.Bounds().Dy())
+
+			r, g, b, _ := thumb.At(test.wantWidth/2, test.wantHeight/2).RGBA()
+			require.InDelta(t, 200, r>>8, 8)
+			require.InDelta(t, 100, g>>8, 8)
+			require.InDelta(t, 50, b>>8, 8)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package factory
+
+import (
+	"fmt"
+	"new-websocket-chat/internal/config"
+	"new-websocket-chat/internal/storage"
+	"new-websocket-chat/internal/storage/memory"
+	"new-websocket-chat/internal/storage/postgres"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+// New creates the storage backend selected by the driver of the database config.
+func New(cfg config.Database) (storage.Storage, error) {
+	const op = "storage.factory.New"
+
+	switch cfg.Driver {
+	case DriverPostgres:
+		s, err := postgres.New(cfg.User, cfg.Password, cfg.DBname, cfg.Hostname, cfg.Port)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if cfg.AutoMigrate {
+			if _, err := s.MigrateUp(0); err != nil {
+				s.Close()
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+		}
+		return s, nil
+	case DriverMemory:
+		return memory.New(), nil
+	default:
+		return nil, fmt.Errorf("%s: unknown storage driver %q", op, cfg.Driver)
+	}
+}
@@ -0,0 +1,1017 @@
+package memory
+
+import (
+	"fmt"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/search"
+	"new-websocket-chat/internal/storage"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage keeps everything in memory. It is meant for tests and local development, the data is lost on restart.
+type Storage struct {
+	mu sync.RWMutex
+
+	users      map[int64]storage.User
+	lastUserID int64
+	lastSeen   map[int64]time.Time
+
+	refreshTokens      map[string]storage.RefreshToken // by token hash
+	lastRefreshTokenID int64
+
+	messages      []storage.Message // ordered by ID
+	lastMessageID int64
+
+	edits      map[int64][]storage.MessageEdit // previous bodies by message ID, oldest first
+	lastEditID int64
+
+	moderators map[string]int64          // user ID by room
+	members    map[string]map[int64]bool // by room
+
+	attachments      []storage.Attachment // ordered by ID
+	lastAttachmentID int64
+
+	reactions map[int64][]reaction // by message ID, in the order the users reacted
+
+	mentions map[int64]map[int64]bool // message IDs by mentioned user
+
+	pendingMessages map[int64][]int64 // message IDs by recipient
+
+	cursors map[int64]map[string]roomCursor // by user and room
+}
+
+// reaction is an emoji reaction of a user on a message.
+type reaction struct {
+	userID int64
+	emoji  string
+}
+
+// roomCursor is the newest message of a room delivered to and read by a user.
+type roomCursor struct {
+	deliveredID int64
+	readID      int64
+}
+
+func New() *Storage {
+	return &Storage{
+		users:           make(map[int64]storage.User),
+		lastSeen:        make(map[int64]time.Time),
+		refreshTokens:   make(map[string]storage.RefreshToken),
+		edits:           make(map[int64][]storage.MessageEdit),
+		moderators:      make(map[string]int64),
+		members:         make(map[string]map[int64]bool),
+		reactions:       make(map[int64][]reaction),
+		mentions:        make(map[int64]map[int64]bool),
+		pendingMessages: make(map[int64][]int64),
+		cursors:         make(map[int64]map[string]roomCursor),
+	}
+}
+
+func (s *Storage) Close() error {
+	return nil
+}
+
+func (s *Storage) SaveUser(username string, email string, password string) (int64, error) {
+	const op = "storage.memory.SaveUser"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Username == username || user.Email == email {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+	}
+
+	s.lastUserID++
+	s.users[s.lastUserID] = storage.User{
+		ID:           s.lastUserID,
+		Username:     username,
+		Email:        email,
+		PasswordHash: password,
+	}
+
+	return s.lastUserID, nil
+}
+
+func (s *Storage) GetUserEmail(username string) (*string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Username == username {
+			email := user.Email
+			return &email, nil
+		}
+	}
+
+	return nil, storage.ErrEmailNotFound
+}
+
+func (s *Storage) GetUsername(email string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return user.Username, nil
+		}
+	}
+
+	return "", storage.ErrUsernameNotFound
+}
+
+// GetUserByLogin returns the user whose email equals the login if it contains '@', whose username equals it otherwise.
+func (s *Storage) GetUserByLogin(login string) (storage.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byEmail := strings.Contains(login, "@")
+	for _, user := range s.users {
+		if (byEmail && user.Email == login) || (!byEmail && user.Username == login) {
+			return user, nil
+		}
+	}
+
+	return storage.User{}, storage.ErrUserNotFound
+}
+
+// GetUserIDs returns the IDs of the users by username, unknown usernames are left out.
+func (s *Storage) GetUserIDs(usernames []string) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		wanted[username] = true
+	}
+
+	ids := make(map[string]int64, len(usernames))
+	for id, user := range s.users {
+		if wanted[user.Username] {
+			ids[user.Username] = id
+		}
+	}
+
+	return ids, nil
+}
+
+// UserExists reports whether the user is registered.
+func (s *Storage) UserExists(userID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.users[userID]
+
+	return ok, nil
+}
+
+// DeleteUser deletes the user with its sessions and messages, like the foreign keys of the postgres backend do.
+func (s *Storage) DeleteUser(username string, email string) error {
+	const op = "storage.memory.DeleteUser"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, user := range s.users {
+		if user.Username != username || user.Email != email {
+			continue
+		}
+
+		delete(s.users, id)
+		for hash, token := range s.refreshTokens {
+			if token.UserID == id {
+				delete(s.refreshTokens, hash)
+			}
+		}
+		// parents come before their replies, the replies of removed messages are removed too
+		removed := make(map[int64]bool)
+		messages := s.messages[:0]
+		for _, message := range s.messages {
+			if message.SenderID != id && !removed[message.ParentID] {
+				messages = append(messages, message)
+			} else {
+				removed[message.ID] = true
+				delete(s.edits, message.ID)
+				delete(s.reactions, message.ID)
+			}
+		}
+		for messageID, reactions := range s.reactions {
+			kept := reactions[:0]
+			for _, r := range reactions {
+				if r.userID != id {
+					kept = append(kept, r)
+				}
+			}
+			s.reactions[messageID] = kept
+		}
+		s.messages = messages
+		for messageID, edits := range s.edits {
+			for i := range edits {
+				if edits[i].EditedBy == id {
+					edits[i].EditedBy = 0
+				}
+			}
+			s.edits[messageID] = edits
+		}
+		for room, moderator := range s.moderators {
+			if moderator == id {
+				delete(s.moderators, room)
+			}
+		}
+		for _, members := range s.members {
+			delete(members, id)
+		}
+		attachments := s.attachments[:0]
+		for _, attachment := range s.attachments {
+			if attachment.UploaderID != id && !removed[attachment.MessageID] {
+				attachments = append(attachments, attachment)
+			}
+		}
+		s.attachments = attachments
+		// mentions of removed messages are skipped by findMessage
+		delete(s.mentions, id)
+
+		return nil
+	}
+
+	return fmt.Errorf("%s: %w", op, storage.ErrUsernameNotFound)
+}
+
+func (s *Storage) SaveRefreshToken(userID int64, familyID string, tokenHash string, expiresAt time.Time) error {
+	const op = "storage.memory.SaveRefreshToken"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	s.lastRefreshTokenID++
+	s.refreshTokens[tokenHash] = storage.RefreshToken{
+		ID:        s.lastRefreshTokenID,
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	return nil
+}
+
+func (s *Storage) GetRefreshToken(tokenHash string) (storage.RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok {
+		return storage.RefreshToken{}, storage.ErrTokenNotFound
+	}
+
+	return token, nil
+}
+
+func (s *Storage) RevokeRefreshToken(tokenHash string) error {
+	const op = "storage.memory.RevokeRefreshToken"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok || token.RevokedAt != nil {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenRevoked)
+	}
+
+	now := time.Now()
+	token.RevokedAt = &now
+	s.refreshTokens[tokenHash] = token
+
+	return nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(familyID string) error {
+	s.revokeRefreshTokens(func(token storage.RefreshToken) bool { return token.FamilyID == familyID })
+
+	return nil
+}
+
+func (s *Storage) RevokeUserRefreshTokens(userID int64) error {
+	s.revokeRefreshTokens(func(token storage.RefreshToken) bool { return token.UserID == userID })
+
+	return nil
+}
+
+func (s *Storage) revokeRefreshTokens(match func(token storage.RefreshToken) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range s.refreshTokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &now
+			s.refreshTokens[hash] = token
+		}
+	}
+}
+
+func (s *Storage) UpdateLastSeen(userID int64, lastSeen time.Time) error {
+	const op = "storage.memory.UpdateLastSeen"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	s.lastSeen[userID] = lastSeen
+
+	return nil
+}
+
+// GetLastSeen returns the last seen time of the users, users that never connected or don't exist are left out.
+func (s *Storage) GetLastSeen(userIDs []int64) (map[int64]time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lastSeen := make(map[int64]time.Time, len(userIDs))
+	for _, id := range userIDs {
+		if t, ok := s.lastSeen[id]; ok {
+			lastSeen[id] = t
+		}
+	}
+
+	return lastSeen, nil
+}
+
+func (s *Storage) SaveMessage(room string, senderID int64, body string, createdAt time.Time) (int64, error) {
+	const op = "storage.memory.SaveMessage"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[senderID]; !ok {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	s.lastMessageID++
+	s.messages = append(s.messages, storage.Message{
+		ID:        s.lastMessageID,
+		Room:      room,
+		SenderID:  senderID,
+		Body:      body,
+		CreatedAt: createdAt,
+	})
+
+	return s.lastMessageID, nil
+}
+
+// GetRoomMessages returns up to limit messages of the room with ID less than beforeID in chronological order.
+// If beforeID is 0 the latest messages are returned. Thread replies are left out, see GetThreadReplies.
+func (s *Storage) GetRoomMessages(room string, beforeID int64, limit int) ([]storage.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.page(func(m storage.Message) bool { return m.Room == room && m.ParentID == 0 }, beforeID, limit), nil
+}
+
+// GetMissedMessages returns up to limit messages with ID greater than afterID in the rooms and in the direct rooms
+// of the user, oldest first and with their reactions and attachments. Thread replies and deleted messages are left out.
+func (s *Storage) GetMissedMessages(userID int64, rooms []string, afterID int64, limit int) ([]storage.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var messages []storage.Message
+	for _, m := range s.messages {
+		switch {
+		case m.ParentID != 0 || m.DeletedAt != nil:
+		case m.ID <= afterID:
+		case !slices.Contains(rooms, m.Room) && !(dm.IsDirect(m.Room) && dm.IsMember(m.Room, userID)):
+		default:
+			for _, r := range s.reactions[m.ID] {
+				m.Reactions = storage.AppendReaction(m.Reactions, r.emoji, r.userID)
+			}
+			m.Attachments = s.messageAttachments(m.ID)
+			messages = append(messages, m)
+		}
+	}
+
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	return messages, nil
+}
+
+// GetThreadReplies returns up to limit replies of the thread with ID less than beforeID in chronological order.
+// If beforeID is 0 the latest replies are returned.
+func (s *Storage) GetThreadReplies(parentID int64, beforeID int64, limit int) ([]storage.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.page(func(m storage.Message) bool { return m.ParentID == parentID }, beforeID, limit), nil
+}
+
+// page returns up to limit matching messages with ID less than beforeID, or the latest ones if beforeID is 0,
+// in chronological order and with their reactions and attachments.
+func (s *Storage) page(match func(m storage.Message) bool, beforeID int64, limit int) []storage.Message {
+	end := len(s.messages)
+	if beforeID > 0 {
+		end = sort.Search(len(s.messages), func(i int) bool { return s.messages[i].ID >= beforeID })
+	}
+
+	messages := make([]storage.Message, 0, limit)
+	for i := end - 1; i >= 0 && len(messages) < limit; i-- {
+		if match(s.messages[i]) {
+			message := s.messages[i]
+			for _, r := range s.reactions[message.ID] {
+				message.Reactions = storage.AppendReaction(message.Reactions, r.emoji, r.userID)
+			}
+			message.Attachments = s.messageAttachments(message.ID)
+			messages = append(messages, message)
+		}
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages
+}
+
+// SaveReply saves a reply to the thread of the parent message in the room of the parent and updates
+// the reply count and the last reply time of the parent. It returns the ID of the reply and the updated parent.
+func (s *Storage) SaveReply(parentID int64, senderID int64, body string, createdAt time.Time) (int64, storage.Message, error) {
+	const op = "storage.memory.SaveReply"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[senderID]; !ok {
+		return 0, storage.Message{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	i, ok := s.findMessage(parentID)
+	if !ok {
+		return 0, storage.Message{}, fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+	parent := &s.messages[i]
+	if parent.DeletedAt != nil {
+		return 0, storage.Message{}, fmt.Errorf("%s: %w", op, storage.ErrMessageDeleted)
+	}
+	if parent.ParentID != 0 {
+		return 0, storage.Message{}, fmt.Errorf("%s: %w", op, storage.ErrNotThreadRoot)
+	}
+
+	parent.ReplyCount++
+	if parent.LastReplyAt == nil || createdAt.After(*parent.LastReplyAt) {
+		parent.LastReplyAt = &createdAt
+	}
+	updated := *parent
+
+	s.lastMessageID++
+	s.messages = append(s.messages, storage.Message{
+		ID:        s.lastMessageID,
+		Room:      updated.Room,
+		SenderID:  senderID,
+		Body:      body,
+		CreatedAt: createdAt,
+		ParentID:  parentID,
+	})
+
+	return s.lastMessageID, updated, nil
+}
+
+func (s *Storage) GetMessage(messageID int64) (storage.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.findMessage(messageID)
+	if !ok {
+		return storage.Message{}, storage.ErrMessageNotFound
+	}
+
+	return s.messages[i], nil
+}
+
+// EditMessage replaces the body of the message and keeps the previous body in the edit history.
+func (s *Storage) EditMessage(messageID int64, editorID int64, body string, editedAt time.Time) error {
+	const op = "storage.memory.EditMessage"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.findMessage(messageID)
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+	message := &s.messages[i]
+	if message.DeletedAt != nil {
+		return fmt.Errorf("%s: %w", op, storage.ErrMessageDeleted)
+	}
+
+	s.lastEditID++
+	s.edits[messageID] = append(s.edits[messageID], storage.MessageEdit{
+		ID:        s.lastEditID,
+		MessageID: messageID,
+		Body:      message.Body,
+		EditedBy:  editorID,
+		EditedAt:  editedAt,
+	})
+	message.Body = body
+	message.EditedAt = &editedAt
+
+	return nil
+}
+
+// DeleteMessage turns the message into a tombstone: the body, the edit history, the reactions and the attachments
+// are removed, the message stays. It returns the removed attachments, the caller deletes their blobs.
+func (s *Storage) DeleteMessage(messageID int64, deletedAt time.Time) ([]storage.Attachment, error) {
+	const op = "storage.memory.DeleteMessage"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.findMessage(messageID)
+	if !ok || s.messages[i].DeletedAt != nil {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMessageDeleted)
+	}
+
+	s.messages[i].Body = ""
+	s.messages[i].DeletedAt = &deletedAt
+	delete(s.edits, messageID)
+	delete(s.reactions, messageID)
+
+	return s.removeAttachments(func(a storage.Attachment) bool { return a.MessageID == messageID }), nil
+}
+
+// removeAttachments removes the attachments matching the filter and returns them.
+func (s *Storage) removeAttachments(remove func(storage.Attachment) bool) []storage.Attachment {
+	var removed []storage.Attachment
+	kept := s.attachments[:0]
+	for _, attachment := range s.attachments {
+		if remove(attachment) {
+			removed = append(removed, attachment)
+		} else {
+			kept = append(kept, attachment)
+		}
+	}
+	s.attachments = kept
+
+	return removed
+}
+
+// GetMessageEdits returns the previous bodies of a message of the room, oldest first.
+func (s *Storage) GetMessageEdits(room string, messageID int64) ([]storage.MessageEdit, error) {
+	const op = "storage.memory.GetMessageEdits"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i, ok := s.findMessage(messageID); !ok || s.messages[i].Room != room {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+
+	return append([]storage.MessageEdit{}, s.edits[messageID]...), nil
+}
+
+// SetRoomModerator makes the user the moderator of the room, replacing the previous one.
+func (s *Storage) SetRoomModerator(room string, userID int64) error {
+	const op = "storage.memory.SetRoomModerator"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	s.moderators[room] = userID
+
+	return nil
+}
+
+// RemoveRoomModerator leaves the room without a moderator.
+func (s *Storage) RemoveRoomModerator(room string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.moderators, room)
+
+	return nil
+}
+
+func (s *Storage) IsRoomModerator(room string, userID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	moderator, ok := s.moderators[room]
+
+	return ok && moderator == userID, nil
+}
+
+// AddRoomMember records that the user joined the room, joining again is a no-op.
+func (s *Storage) AddRoomMember(room string, userID int64) error {
+	const op = "storage.memory.AddRoomMember"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	if s.members[room] == nil {
+		s.members[room] = make(map[int64]bool)
+	}
+	s.members[room][userID] = true
+
+	return nil
+}
+
+// IsRoomMember reports whether the user ever joined the room.
+func (s *Storage) IsRoomMember(room string, userID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.members[room][userID], nil
+}
+
+// SaveAttachment saves the metadata of an uploaded file, the ID and the message ID of the attachment are ignored.
+func (s *Storage) SaveAttachment(attachment storage.Attachment) (int64, error) {
+	const op = "storage.memory.SaveAttachment"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[attachment.UploaderID]; !ok {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	s.lastAttachmentID++
+	attachment.ID = s.lastAttachmentID
+	attachment.MessageID = 0
+	s.attachments = append(s.attachments, attachment)
+
+	return s.lastAttachmentID, nil
+}
+
+func (s *Storage) GetAttachment(attachmentID int64) (storage.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.findAttachment(attachmentID)
+	if !ok {
+		return storage.Attachment{}, storage.ErrAttachmentNotFound
+	}
+
+	return s.attachments[i], nil
+}
+
+// AttachToMessage links the attachments to the message. It fails with ErrAttachmentNotFound and links none of them
+// if any attachment doesn't exist or already belongs to a message.
+func (s *Storage) AttachToMessage(messageID int64, attachmentIDs []int64) error {
+	const op = "storage.memory.AttachToMessage"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.findMessage(messageID); !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+
+	indexes := make([]int, 0, len(attachmentIDs))
+	for _, id := range attachmentIDs {
+		i, ok := s.findAttachment(id)
+		if !ok || s.attachments[i].MessageID != 0 {
+			return fmt.Errorf("%s: %w", op, storage.ErrAttachmentNotFound)
+		}
+		indexes = append(indexes, i)
+	}
+	for _, i := range indexes {
+		s.attachments[i].MessageID = messageID
+	}
+
+	return nil
+}
+
+// DeleteUnattachedAttachments removes the attachments uploaded before the time that no message refers to
+// and returns them, the caller deletes their blobs.
+func (s *Storage) DeleteUnattachedAttachments(uploadedBefore time.Time) ([]storage.Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.removeAttachments(func(a storage.Attachment) bool {
+		return a.MessageID == 0 && a.CreatedAt.Before(uploadedBefore)
+	}), nil
+}
+
+// findAttachment returns the index of the attachment in s.attachments.
+func (s *Storage) findAttachment(id int64) (int, bool) {
+	i := sort.Search(len(s.attachments), func(i int) bool { return s.attachments[i].ID >= id })
+
+	return i, i < len(s.attachments) && s.attachments[i].ID == id
+}
+
+// messageAttachments returns the attachments of the message in the order they were uploaded.
+func (s *Storage) messageAttachments(messageID int64) []storage.Attachment {
+	var attachments []storage.Attachment
+	for _, attachment := range s.attachments {
+		if attachment.MessageID == messageID {
+			attachments = append(attachments, attachment)
+		}
+	}
+
+	return attachments
+}
+
+// AddReaction adds the emoji reaction of the user to the message. It returns false if the user
+// already reacted with the emoji or the message is deleted.
+func (s *Storage) AddReaction(messageID int64, userID int64, emoji string) (bool, error) {
+	const op = "storage.memory.AddReaction"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	i, ok := s.findMessage(messageID)
+	if !ok || s.messages[i].DeletedAt != nil {
+		return false, nil
+	}
+
+	for _, r := range s.reactions[messageID] {
+		if r.userID == userID && r.emoji == emoji {
+			return false, nil
+		}
+	}
+	s.reactions[messageID] = append(s.reactions[messageID], reaction{userID: userID, emoji: emoji})
+
+	return true, nil
+}
+
+// RemoveReaction removes the emoji reaction of the user from the message. It returns false if there was none.
+func (s *Storage) RemoveReaction(messageID int64, userID int64, emoji string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reactions := s.reactions[messageID]
+	for i, r := range reactions {
+		if r.userID == userID && r.emoji == emoji {
+			s.reactions[messageID] = append(reactions[:i], reactions[i+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *Storage) SavePendingMessage(userID int64, messageID int64) error {
+	const op = "storage.memory.SavePendingMessage"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	for _, id := range s.pendingMessages[userID] {
+		if id == messageID {
+			return nil
+		}
+	}
+	s.pendingMessages[userID] = append(s.pendingMessages[userID], messageID)
+
+	return nil
+}
+
+// TakePendingMessages returns the messages waiting for the user in chronological order and removes them from the queue.
+func (s *Storage) TakePendingMessages(userID int64) ([]storage.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := s.pendingMessages[userID]
+	delete(s.pendingMessages, userID)
+
+	var messages []storage.Message
+	for _, id := range pending {
+		// messages of deleted senders are gone, like with the cascading foreign key of the postgres backend
+		if i, ok := s.findMessage(id); ok && s.messages[i].DeletedAt == nil {
+			message := s.messages[i]
+			message.Attachments = s.messageAttachments(id)
+			messages = append(messages, message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+
+	return messages, nil
+}
+
+// findMessage returns the index of the message in s.messages.
+func (s *Storage) findMessage(id int64) (int, bool) {
+	i := sort.Search(len(s.messages), func(i int) bool { return s.messages[i].ID >= id })
+
+	return i, i < len(s.messages) && s.messages[i].ID == id
+}
+
+// UpdateDeliveredCursors moves the delivered cursors of the users in the rooms forward, at most one per user and room.
+// It returns the cursors that moved with their previous message, the others were already at a newer message
+// or belong to users who don't exist anymore.
+func (s *Storage) UpdateDeliveredCursors(cursors []storage.DeliveredCursor) ([]storage.DeliveredCursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var advanced []storage.DeliveredCursor
+	for _, c := range cursors {
+		if _, ok := s.users[c.UserID]; !ok {
+			continue
+		}
+
+		cursor := s.cursors[c.UserID][c.Room]
+		if cursor.deliveredID >= c.MessageID {
+			continue
+		}
+		c.Previous = cursor.deliveredID
+		cursor.deliveredID = c.MessageID
+		s.setCursor(c.UserID, c.Room, cursor)
+		advanced = append(advanced, c)
+	}
+
+	return advanced, nil
+}
+
+// UpdateReadCursor moves the read cursor of the user in the room forward to a message of the room
+// and returns the previous cursor. The cursor doesn't move back, compare the result with messageID.
+func (s *Storage) UpdateReadCursor(userID int64, room string, messageID int64) (int64, error) {
+	const op = "storage.memory.UpdateReadCursor"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	if i, ok := s.findMessage(messageID); !ok || s.messages[i].Room != room {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+
+	cursor := s.cursors[userID][room]
+	previous := cursor.readID
+	cursor.readID = max(cursor.readID, messageID)
+	cursor.deliveredID = max(cursor.deliveredID, messageID)
+	s.setCursor(userID, room, cursor)
+
+	return previous, nil
+}
+
+func (s *Storage) setCursor(userID int64, room string, cursor roomCursor) {
+	cursors, ok := s.cursors[userID]
+	if !ok {
+		cursors = make(map[string]roomCursor)
+		s.cursors[userID] = cursors
+	}
+	cursors[room] = cursor
+}
+
+// GetMessageSenders returns the distinct senders of the room messages with afterID < ID <= upToID.
+func (s *Storage) GetMessageSenders(room string, afterID int64, upToID int64) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var senders []int64
+	seen := make(map[int64]bool)
+	for i, _ := s.findMessage(afterID + 1); i < len(s.messages) && s.messages[i].ID <= upToID; i++ {
+		message := s.messages[i]
+		if message.Room == room && !seen[message.SenderID] {
+			seen[message.SenderID] = true
+			senders = append(senders, message.SenderID)
+		}
+	}
+
+	return senders, nil
+}
+
+// GetUnreadCounts returns the unread counts of every room the user has a cursor in, ordered by room.
+func (s *Storage) GetUnreadCounts(userID int64) ([]storage.UnreadCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := []storage.UnreadCount{}
+	for room, cursor := range s.cursors[userID] {
+		count := storage.UnreadCount{Room: room, LastReadID: cursor.readID}
+		for i, _ := s.findMessage(cursor.readID + 1); i < len(s.messages); i++ {
+			if s.messages[i].Room == room && s.messages[i].SenderID != userID {
+				count.Unread++
+			}
+		}
+		counts = append(counts, count)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Room < counts[j].Room })
+
+	return counts, nil
+}
+
+// SaveMentions records that the message mentions the users and returns the users it didn't mention before.
+func (s *Storage) SaveMentions(messageID int64, userIDs []int64) ([]int64, error) {
+	const op = "storage.memory.SaveMentions"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.findMessage(messageID); !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+
+	var added []int64
+	for _, userID := range userIDs {
+		if _, ok := s.users[userID]; !ok || s.mentions[userID][messageID] {
+			continue
+		}
+		mentions, ok := s.mentions[userID]
+		if !ok {
+			mentions = make(map[int64]bool)
+			s.mentions[userID] = mentions
+		}
+		mentions[messageID] = true
+		added = append(added, userID)
+	}
+
+	return added, nil
+}
+
+// GetMentions returns up to limit messages mentioning the user with ID less than beforeID, newest first.
+// If beforeID is 0 the latest mentions are returned. Deleted messages are left out.
+func (s *Storage) GetMentions(userID int64, beforeID int64, limit int) ([]storage.Mention, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	end := len(s.messages)
+	if beforeID > 0 {
+		end = sort.Search(len(s.messages), func(i int) bool { return s.messages[i].ID >= beforeID })
+	}
+
+	mentions := make([]storage.Mention, 0, limit)
+	for i := end - 1; i >= 0 && len(mentions) < limit; i-- {
+		message := s.messages[i]
+		if s.mentions[userID][message.ID] && message.DeletedAt == nil {
+			read := message.ID <= s.cursors[userID][message.Room].readID
+			mentions = append(mentions, storage.Mention{Message: message, Read: read})
+		}
+	}
+
+	return mentions, nil
+}
+
+// GetUnreadMentionCount returns the number of messages mentioning the user after the read cursor of their room.
+func (s *Storage) GetUnreadMentionCount(userID int64) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for messageID := range s.mentions[userID] {
+		i, ok := s.findMessage(messageID)
+		if !ok {
+			continue
+		}
+		message := s.messages[i]
+		if message.DeletedAt == nil && message.ID > s.cursors[userID][message.Room].readID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// SearchMessages returns up to query.Limit messages containing every term, newest first, of the rooms
+// the user joined and of the direct rooms of the user. Deleted messages are left out.
+func (s *Storage) SearchMessages(query storage.SearchQuery) ([]storage.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	end := len(s.messages)
+	if query.BeforeID > 0 {
+		end = sort.Search(len(s.messages), func(i int) bool { return s.messages[i].ID >= query.BeforeID })
+	}
+
+	messages := make([]storage.Message, 0, query.Limit)
+	for i := end - 1; i >= 0 && len(messages) < query.Limit; i-- {
+		m := s.messages[i]
+		switch {
+		case m.DeletedAt != nil:
+		case !s.members[m.Room][query.UserID] && !(dm.IsDirect(m.Room) && dm.IsMember(m.Room, query.UserID)):
+		case query.Room != "" && m.Room != query.Room:
+		case query.SenderID != 0 && m.SenderID != query.SenderID:
+		case !query.From.IsZero() && m.CreatedAt.Before(query.From):
+		case !query.To.IsZero() && !m.CreatedAt.Before(query.To):
+		case !search.Matches(m.Body, query.Terms):
+		default:
+			messages = append(messages, m)
+		}
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,507 @@
+package memory_test
+
+import (
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/storage"
+	"new-websocket-chat/internal/storage/memory"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageUsers(t *testing.T) {
+	s := memory.New()
+
+	id, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+
+	_, err = s.SaveUser("alice", "other@example.com", "hash")
+	require.ErrorIs(t, err, storage.ErrUserExists)
+
+	user, err := s.GetUserByLogin("alice@example.com")
+	require.NoError(t, err)
+	require.Equal(t, id, user.ID)
+
+	// a username that looks like the email of another user doesn't log in as that user
+	_, err = s.SaveUser("alice@example.com", "bob@example.com", "hash")
+	require.NoError(t, err)
+	user, err = s.GetUserByLogin("alice@example.com")
+	require.NoError(t, err)
+	require.Equal(t, id, user.ID)
+	user, err = s.GetUserByLogin("alice")
+	require.NoError(t, err)
+	require.Equal(t, id, user.ID)
+
+	exists, err := s.UserExists(id)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	require.NoError(t, s.SaveRefreshToken(id, "family", "token", time.Now().Add(time.Hour)))
+	require.NoError(t, s.DeleteUser("alice", "alice@example.com"))
+
+	_, err = s.GetUserByLogin("alice")
+	require.ErrorIs(t, err, storage.ErrUserNotFound)
+	_, err = s.GetRefreshToken("token")
+	require.ErrorIs(t, err, storage.ErrTokenNotFound)
+	require.ErrorIs(t, s.DeleteUser("alice", "alice@example.com"), storage.ErrUsernameNotFound)
+}
+
+func TestStorageRefreshTokens(t *testing.T) {
+	s := memory.New()
+
+	id, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveRefreshToken(id, "family", "first", time.Now().Add(time.Hour)))
+	require.NoError(t, s.SaveRefreshToken(id, "family", "second", time.Now().Add(time.Hour)))
+
+	require.NoError(t, s.RevokeRefreshToken("first"))
+	require.ErrorIs(t, s.RevokeRefreshToken("first"), storage.ErrTokenRevoked)
+
+	require.NoError(t, s.RevokeRefreshTokenFamily("family"))
+	token, err := s.GetRefreshToken("second")
+	require.NoError(t, err)
+	require.NotNil(t, token.RevokedAt)
+}
+
+func TestStorageRoomMessages(t *testing.T) {
+	s := memory.New()
+
+	id, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+
+	for _, room := range []string{"general", "random", "general", "general"} {
+		_, err := s.SaveMessage(room, id, "hi", time.Now())
+		require.NoError(t, err)
+	}
+
+	messages, err := s.GetRoomMessages("general", 0, 2)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	require.Equal(t, int64(3), messages[0].ID)
+	require.Equal(t, int64(4), messages[1].ID)
+
+	messages, err = s.GetRoomMessages("general", 3, 2)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, int64(1), messages[0].ID)
+}
+
+func TestStorageMissedMessages(t *testing.T) {
+	s := memory.New()
+
+	alice, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.SaveUser("bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	// alice joined random too but left it, only the rooms of her session are passed
+	require.NoError(t, s.AddRoomMember("general", alice))
+	require.NoError(t, s.AddRoomMember("random", alice))
+	rooms := []string{"general"}
+
+	start := time.Now()
+	save := func(room string, senderID int64, at time.Duration) int64 {
+		id, err := s.SaveMessage(room, senderID, "hi", start.Add(at))
+		require.NoError(t, err)
+		return id
+	}
+	seen := save("general", bob, 0)
+	joined := save("general", bob, time.Second)
+	direct := save(dm.Room(alice, bob), bob, 2*time.Second)
+	save("random", bob, 3*time.Second)
+	_, _, err = s.SaveReply(joined, bob, "reply", start.Add(4*time.Second))
+	require.NoError(t, err)
+	deleted := save("general", bob, 5*time.Second)
+	_, err = s.DeleteMessage(deleted, start.Add(6*time.Second))
+	require.NoError(t, err)
+	late := save("general", bob, 7*time.Second)
+	_, err = s.AddReaction(joined, alice, "👍")
+	require.NoError(t, err)
+
+	ids := func(messages []storage.Message) []int64 {
+		var ids []int64
+		for _, m := range messages {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	messages, err := s.GetMissedMessages(alice, rooms, seen, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{joined, direct, late}, ids(messages))
+	require.Equal(t, []storage.Reaction{{Emoji: "👍", Count: 1, UserIDs: []int64{alice}}}, messages[0].Reactions)
+
+	messages, err = s.GetMissedMessages(alice, rooms, seen, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{joined}, ids(messages))
+
+	messages, err = s.GetMissedMessages(alice, rooms, direct, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{late}, ids(messages))
+}
+
+func TestStorageLastSeen(t *testing.T) {
+	s := memory.New()
+
+	id, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+
+	lastSeen := time.Now()
+	require.NoError(t, s.UpdateLastSeen(id, lastSeen))
+	require.ErrorIs(t, s.UpdateLastSeen(42, lastSeen), storage.ErrUserNotFound)
+
+	got, err := s.GetLastSeen([]int64{id, 42})
+	require.NoError(t, err)
+	require.Equal(t, map[int64]time.Time{id: lastSeen}, got)
+}
+
+func TestStorageCursors(t *testing.T) {
+	s := memory.New()
+
+	alice, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.SaveUser("bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+
+	var ids []int64
+	for _, sender := range []int64{alice, bob, alice, alice} {
+		id, err := s.SaveMessage("general", sender, "hi", time.Now())
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	advanced, err := s.UpdateDeliveredCursors([]storage.DeliveredCursor{
+		{UserID: bob, Room: "general", MessageID: ids[2]},
+		{UserID: alice, Room: "general", MessageID: ids[1]},
+		{UserID: 42, Room: "general", MessageID: ids[2]},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []storage.DeliveredCursor{
+		{UserID: bob, Room: "general", MessageID: ids[2]},
+		{UserID: alice, Room: "general", MessageID: ids[1]},
+	}, advanced)
+
+	advanced, err = s.UpdateDeliveredCursors([]storage.DeliveredCursor{
+		{UserID: bob, Room: "general", MessageID: ids[0]},
+		{UserID: alice, Room: "general", MessageID: ids[3]},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []storage.DeliveredCursor{{UserID: alice, Room: "general", MessageID: ids[3], Previous: ids[1]}}, advanced)
+
+	previous, err := s.UpdateReadCursor(bob, "general", ids[2])
+	require.NoError(t, err)
+	require.Zero(t, previous)
+
+	senders, err := s.GetMessageSenders("general", previous, ids[2])
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int64{alice, bob}, senders)
+
+	_, err = s.UpdateReadCursor(bob, "random", ids[3])
+	require.ErrorIs(t, err, storage.ErrMessageNotFound)
+
+	counts, err := s.GetUnreadCounts(bob)
+	require.NoError(t, err)
+	require.Equal(t, []storage.UnreadCount{{Room: "general", LastReadID: ids[2], Unread: 1}}, counts)
+}
+
+func TestStorageMessageEdits(t *testing.T) {
+	s := memory.New()
+
+	alice, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.SaveUser("bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+
+	require.ErrorIs(t, s.SetRoomModerator("general", 42), storage.ErrUserNotFound)
+	require.NoError(t, s.SetRoomModerator("general", bob))
+	require.NoError(t, s.SetRoomModerator("general", alice))
+	moderator, err := s.IsRoomModerator("general", bob)
+	require.NoError(t, err)
+	require.False(t, moderator, "a room has one moderator")
+
+	id, err := s.SaveMessage("general", bob, "helo", time.Now())
+	require.NoError(t, err)
+	require.NoError(t, s.EditMessage(id, bob, "hello", time.Now()))
+
+	message, err := s.GetMessage(id)
+	require.NoError(t, err)
+	require.Equal(t, "hello", message.Body)
+	require.NotNil(t, message.EditedAt)
+
+	edits, err := s.GetMessageEdits("general", id)
+	require.NoError(t, err)
+	require.Len(t, edits, 1)
+	require.Equal(t, "helo", edits[0].Body)
+	_, err = s.GetMessageEdits("random", id)
+	require.ErrorIs(t, err, storage.ErrMessageNotFound)
+
+	_, err = s.DeleteMessage(id, time.Now())
+	require.NoError(t, err)
+	_, err = s.DeleteMessage(id, time.Now())
+	require.ErrorIs(t, err, storage.ErrMessageDeleted)
+	require.ErrorIs(t, s.EditMessage(id, bob, "back", time.Now()), storage.ErrMessageDeleted)
+
+	message, err = s.GetMessage(id)
+	require.NoError(t, err)
+	require.Empty(t, message.Body)
+	require.NotNil(t, message.DeletedAt)
+	edits, err = s.GetMessageEdits("general", id)
+	require.NoError(t, err)
+	require.Empty(t, edits)
+}
+
+func TestStorageReactions(t *testing.T) {
+	s := memory.New()
+
+	alice, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.SaveUser("bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+
+	id, err := s.SaveMessage("general", alice, "lunch?", time.Now())
+	require.NoError(t, err)
+
+	for _, r := range []struct {
+		userID int64
+		emoji  string
+	}{{bob, "👍"}, {alice, "🍕"}, {alice, "👍"}} {
+		added, err := s.AddReaction(id, r.userID, r.emoji)
+		require.NoError(t, err)
+		require.True(t, added)
+	}
+	added, err := s.AddReaction(id, bob, "👍")
+	require.NoError(t, err)
+	require.False(t, added)
+
+	removed, err := s.RemoveReaction(id, alice, "🍕")
+	require.NoError(t, err)
+	require.True(t, removed)
+	removed, err = s.RemoveReaction(id, alice, "🍕")
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	messages, err := s.GetRoomMessages("general", 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []storage.Reaction{{Emoji: "👍", Count: 2, UserIDs: []int64{bob, alice}}}, messages[0].Reactions)
+
+	// reactions go away with the message body
+	_, err = s.DeleteMessage(id, time.Now())
+	require.NoError(t, err)
+	messages, err = s.GetRoomMessages("general", 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, messages[0].Reactions)
+	added, err = s.AddReaction(id, bob, "👍")
+	require.NoError(t, err)
+	require.False(t, added)
+}
+
+func TestStorageAttachments(t *testing.T) {
+	s := memory.New()
+
+	alice, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.SaveUser("bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+
+	require.NoError(t, s.AddRoomMember("general", bob))
+	require.NoError(t, s.AddRoomMember("general", bob))
+	member, err := s.IsRoomMember("general", bob)
+	require.NoError(t, err)
+	require.True(t, member)
+	member, err = s.IsRoomMember("general", alice)
+	require.NoError(t, err)
+	require.False(t, member)
+
+	var ids []int64
+	for _, uploaderID := range []int64{alice, alice, bob} {
+		id, err := s.SaveAttachment(storage.Attachment{Room: "general", UploaderID: uploaderID, Name: "cat.png", BlobKey: "key"})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	_, err = s.SaveAttachment(storage.Attachment{Room: "general", UploaderID: 42})
+	require.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	id, err := s.SaveMessage("general", alice, "cats", time.Now())
+	require.NoError(t, err)
+	require.NoError(t, s.AttachToMessage(id, ids[:2]))
+
+	// an attachment can't be moved to another message, none of the list is attached then
+	other, err := s.SaveMessage("general", bob, "more cats", time.Now())
+	require.NoError(t, err)
+	require.ErrorIs(t, s.AttachToMessage(other, []int64{ids[2], ids[1]}), storage.ErrAttachmentNotFound)
+	attachment, err := s.GetAttachment(ids[2])
+	require.NoError(t, err)
+	require.Zero(t, attachment.MessageID)
+
+	messages, err := s.GetRoomMessages("general", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, messages[0].Attachments, 2)
+	require.Equal(t, id, messages[0].Attachments[1].MessageID)
+	require.Empty(t, messages[1].Attachments)
+
+	// attachments go away with the message body and with the uploader
+	deleted, err := s.DeleteMessage(id, time.Now())
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+	require.Equal(t, ids[0], deleted[0].ID)
+	require.Equal(t, "key", deleted[0].BlobKey)
+	_, err = s.GetAttachment(ids[0])
+	require.ErrorIs(t, err, storage.ErrAttachmentNotFound)
+
+	// uploads no message refers to are removed once they are old enough
+	deleted, err = s.DeleteUnattachedAttachments(time.Time{})
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+	deleted, err = s.DeleteUnattachedAttachments(time.Now())
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	require.Equal(t, ids[2], deleted[0].ID)
+	require.NoError(t, s.DeleteUser("bob", "bob@example.com"))
+	_, err = s.GetAttachment(ids[2])
+	require.ErrorIs(t, err, storage.ErrAttachmentNotFound)
+	member, err = s.IsRoomMember("general", bob)
+	require.NoError(t, err)
+	require.False(t, member)
+}
+
+func TestStorageThreads(t *testing.T) {
+	s := memory.New()
+
+	alice, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.SaveUser("bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+
+	parentID, err := s.SaveMessage("general", alice, "lunch?", time.Now())
+	require.NoError(t, err)
+
+	var replyIDs []int64
+	for _, sender := range []int64{bob, alice, bob} {
+		id, parent, err := s.SaveReply(parentID, sender, "sure", time.Now())
+		require.NoError(t, err)
+		require.Equal(t, "general", parent.Room)
+		require.Equal(t, len(replyIDs)+1, parent.ReplyCount)
+		replyIDs = append(replyIDs, id)
+	}
+
+	_, _, err = s.SaveReply(replyIDs[0], alice, "nested", time.Now())
+	require.ErrorIs(t, err, storage.ErrNotThreadRoot)
+
+	messages, err := s.GetRoomMessages("general", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, 3, messages[0].ReplyCount)
+	require.NotNil(t, messages[0].LastReplyAt)
+
+	replies, err := s.GetThreadReplies(parentID, replyIDs[2], 10)
+	require.NoError(t, err)
+	require.Len(t, replies, 2)
+	require.Equal(t, replyIDs[0], replies[0].ID)
+
+	// replies go away with the parent of a deleted user
+	require.NoError(t, s.DeleteUser("alice", "alice@example.com"))
+	replies, err = s.GetThreadReplies(parentID, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, replies)
+}
+
+func TestStorageMentions(t *testing.T) {
+	s := memory.New()
+
+	alice, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.SaveUser("bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+
+	ids, err := s.GetUserIDs([]string{"bob", "nobody"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"bob": bob}, ids)
+
+	var messageIDs []int64
+	for _, room := range []string{"general", "random", "general"} {
+		id, err := s.SaveMessage(room, alice, "@bob", time.Now())
+		require.NoError(t, err)
+		messageIDs = append(messageIDs, id)
+
+		added, err := s.SaveMentions(id, []int64{bob})
+		require.NoError(t, err)
+		require.Equal(t, []int64{bob}, added)
+	}
+	added, err := s.SaveMentions(messageIDs[0], []int64{bob})
+	require.NoError(t, err)
+	require.Empty(t, added)
+
+	_, err = s.UpdateReadCursor(bob, "general", messageIDs[0])
+	require.NoError(t, err)
+	_, err = s.DeleteMessage(messageIDs[1], time.Now())
+	require.NoError(t, err)
+
+	mentions, err := s.GetMentions(bob, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, mentions, 2)
+	require.Equal(t, messageIDs[2], mentions[0].Message.ID)
+	require.False(t, mentions[0].Read)
+	require.True(t, mentions[1].Read)
+
+	unread, err := s.GetUnreadMentionCount(bob)
+	require.NoError(t, err)
+	require.Equal(t, 1, unread)
+}
+
+func TestStorageSearchMessages(t *testing.T) {
+	s := memory.New()
+
+	alice, err := s.SaveUser("alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.SaveUser("bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	require.NoError(t, s.AddRoomMember("general", alice))
+
+	start := time.Now()
+	messages := []struct {
+		room   string
+		sender int64
+		body   string
+	}{
+		{"general", alice, "Lunch at noon?"},
+		{"dm:1:2", bob, "lunch tomorrow"},
+		{"dm:2:3", bob, "secret lunch"},
+		{"random", bob, "lunches are great"},
+		{"general", bob, "lunch!"},
+		{"random", bob, "lunch in a room alice never joined"},
+	}
+	var ids []int64
+	for i, m := range messages {
+		id, err := s.SaveMessage(m.room, m.sender, m.body, start.Add(time.Duration(i)*time.Minute))
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	_, err = s.DeleteMessage(ids[4], time.Now())
+	require.NoError(t, err)
+
+	search := func(query storage.SearchQuery) []int64 {
+		query.Terms = []string{"lunch"}
+		query.UserID = alice
+		query.Limit = 10
+
+		found, err := s.SearchMessages(query)
+		require.NoError(t, err)
+
+		var foundIDs []int64
+		for _, m := range found {
+			foundIDs = append(foundIDs, m.ID)
+		}
+		return foundIDs
+	}
+
+	require.Equal(t, []int64{ids[1], ids[0]}, search(storage.SearchQuery{}))
+	require.Equal(t, []int64{ids[0]}, search(storage.SearchQuery{Room: "general"}))
+	require.Equal(t, []int64{ids[1]}, search(storage.SearchQuery{SenderID: bob}))
+	require.Equal(t, []int64{ids[1]}, search(storage.SearchQuery{From: start.Add(time.Minute)}))
+	require.Equal(t, []int64{ids[0]}, search(storage.SearchQuery{To: start.Add(time.Minute)}))
+	require.Equal(t, []int64{ids[0]}, search(storage.SearchQuery{BeforeID: ids[1]}))
+	require.Empty(t, search(storage.SearchQuery{Room: "random"}))
+
+	require.NoError(t, s.AddRoomMember("random", alice))
+	require.Equal(t, []int64{ids[5]}, search(storage.SearchQuery{Room: "random"}))
+}
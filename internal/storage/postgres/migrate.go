@@ -0,0 +1,261 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID is the key of the advisory lock held while migrating, so two instances don't migrate concurrently.
+const migrationLockID = 7_305_114_091
+
+// Migration is one version of the schema. Files are named <version>_<name>.up.sql and <version>_<name>.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	sub, err := fs.Sub(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return loadMigrations(sub)
+}
+
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	const op = "storage.postgres.loadMigrations"
+
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base, direction := strings.TrimSuffix(file, ".sql"), ""
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			base, direction = strings.TrimSuffix(base, ".up"), "up"
+		case strings.HasSuffix(base, ".down"):
+			base, direction = strings.TrimSuffix(base, ".down"), "down"
+		default:
+			return nil, fmt.Errorf("%s: %s is neither an up nor a down migration", op, file)
+		}
+
+		versionStr, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("%s: %s doesn't start with a positive version", op, file)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Clean(file))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("%s: version %d is used by %s and %s", op, version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%s: migration %d_%s needs both up and down files", op, m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Version returns the latest applied migration version, 0 if none is applied.
+func (s *Storage) Version() (int, error) {
+	const op = "storage.postgres.Version"
+
+	var version int
+	err := s.withMigrationLock(func(conn *sql.Conn) error {
+		var err error
+		version, err = currentVersion(conn)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, nil
+}
+
+// MigrateUp applies up to steps pending migrations, all of them if steps is 0.
+// It returns the versions that were applied.
+func (s *Storage) MigrateUp(steps int) ([]int, error) {
+	const op = "storage.postgres.MigrateUp"
+
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var applied []int
+	err = s.withMigrationLock(func(conn *sql.Conn) error {
+		version, err := currentVersion(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.Version <= version {
+				continue
+			}
+			if steps > 0 && len(applied) == steps {
+				break
+			}
+
+			err := runMigration(conn, m.Up, `INSERT INTO schema_migrations(version, name) VALUES($1, $2)`, m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("apply %d_%s: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m.Version)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return applied, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts up to steps applied migrations starting from the latest one, all of them if steps is 0.
+// It returns the versions that were reverted.
+func (s *Storage) MigrateDown(steps int) ([]int, error) {
+	const op = "storage.postgres.MigrateDown"
+
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var reverted []int
+	err = s.withMigrationLock(func(conn *sql.Conn) error {
+		version, err := currentVersion(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.Version > version {
+				continue
+			}
+			if steps > 0 && len(reverted) == steps {
+				break
+			}
+
+			err := runMigration(conn, m.Down, `DELETE FROM schema_migrations WHERE version=$1`, m.Version)
+			if err != nil {
+				return fmt.Errorf("revert %d_%s: %w", m.Version, m.Name, err)
+			}
+			reverted = append(reverted, m.Version)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return reverted, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return reverted, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration advisory lock.
+// Advisory locks belong to the session, that's why everything has to run on the same connection.
+func (s *Storage) withMigrationLock(fn func(conn *sql.Conn) error) (err error) {
+	ctx := context.Background()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, unlockErr := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID); unlockErr != nil && err == nil {
+			err = fmt.Errorf("release migration lock: %w", unlockErr)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations(
+	    version INTEGER PRIMARY KEY,
+	    name CHARACTER VARYING(100) NOT NULL,
+	    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW());
+	`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func currentVersion(conn *sql.Conn) (int, error) {
+	var version int
+	err := conn.QueryRowContext(context.Background(), `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// runMigration executes the migration script and records it in schema_migrations in one transaction.
+func runMigration(conn *sql.Conn, script string, record string, args ...any) error {
+	ctx := context.Background()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		require.Equal(t, i+1, m.Version, "versions have to be sequential")
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		versions []int
+		err      string
+	}{
+		{
+			name: "Ordered by version",
+			fsys: fstest.MapFS{
+				"0010_b.up.sql":   file("b up"),
+				"0010_b.down.sql": file("b down"),
+				"0002_a.up.sql":   file("a up"),
+				"0002_a.down.sql": file("a down"),
+			},
+			versions: []int{2, 10},
+		},
+		{
+			name: "Missing down",
+			fsys: fstest.MapFS{
+				"0001_a.up.sql": file("a up"),
+			},
+			err: "needs both up and down files",
+		},
+		{
+			name: "No direction",
+			fsys: fstest.MapFS{
+				"0001_a.sql": file("a"),
+			},
+			err: "neither an up nor a down migration",
+		},
+		{
+			name: "No version",
+			fsys: fstest.MapFS{
+				"init.up.sql":   file("up"),
+				"init.down.sql": file("down"),
+			},
+			err: "doesn't start with a positive version",
+		},
+		{
+			name: "Duplicate version",
+			fsys: fstest.MapFS{
+				"0001_a.up.sql":   file("a up"),
+				"0001_a.down.sql": file("a down"),
+				"0001_b.up.sql":   file("b up"),
+				"0001_b.down.sql": file("b down"),
+			},
+			err: "version 1 is used by",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := loadMigrations(test.fsys)
+			if test.err != "" {
+				require.ErrorContains(t, err, test.err)
+				return
+			}
+
+			require.NoError(t, err)
+			versions := make([]int, 0, len(migrations))
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+			require.Equal(t, test.versions, versions)
+		})
+	}
+}
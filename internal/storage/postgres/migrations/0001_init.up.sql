@@ -0,0 +1,28 @@
+-- IF NOT EXISTS keeps the migration working on databases created before the migrations were introduced.
+CREATE TABLE IF NOT EXISTS users(
+    id SERIAL PRIMARY KEY,
+    username CHARACTER VARYING(30) NOT NULL UNIQUE CHECK(username !=''),
+    email CHARACTER VARYING(30) NOT NULL UNIQUE CHECK(email !=''),
+    password CHARACTER VARYING(100) NOT NULL);
+
+CREATE INDEX IF NOT EXISTS idx_username ON users(username);
+
+CREATE TABLE IF NOT EXISTS messages(
+    id BIGSERIAL PRIMARY KEY,
+    room CHARACTER VARYING(64) NOT NULL CHECK(room !=''),
+    sender_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());
+
+CREATE INDEX IF NOT EXISTS idx_messages_room_id ON messages(room, id DESC);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens(
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id CHARACTER VARYING(64) NOT NULL,
+    token_hash CHARACTER(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
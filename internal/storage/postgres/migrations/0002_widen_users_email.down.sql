@@ -0,0 +1 @@
+ALTER TABLE users ALTER COLUMN email TYPE CHARACTER VARYING(30);
@@ -0,0 +1,2 @@
+-- 254 characters is the longest valid email address.
+ALTER TABLE users ALTER COLUMN email TYPE CHARACTER VARYING(254);
@@ -0,0 +1,5 @@
+-- Direct messages that couldn't be delivered because the recipient wasn't connected.
+CREATE TABLE pending_messages(
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    PRIMARY KEY(user_id, message_id));
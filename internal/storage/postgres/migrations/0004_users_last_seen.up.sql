@@ -0,0 +1,2 @@
+-- Time the user's last websocket connection was closed, NULL if the user never connected.
+ALTER TABLE users ADD COLUMN last_seen TIMESTAMPTZ;
@@ -0,0 +1,7 @@
+-- Newest message of the room delivered to and read by the user. Unread messages are the ones after read_id.
+CREATE TABLE room_cursors(
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    room CHARACTER VARYING(64) NOT NULL,
+    delivered_id BIGINT NOT NULL DEFAULT 0,
+    read_id BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY(user_id, room));
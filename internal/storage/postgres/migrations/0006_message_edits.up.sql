@@ -0,0 +1,20 @@
+-- Deleted messages stay as tombstones: the body is cleared and deleted_at is set.
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- Previous bodies of the edited messages.
+CREATE TABLE message_edits(
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    edited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    edited_at TIMESTAMPTZ NOT NULL);
+
+CREATE INDEX idx_message_edits_message_id ON message_edits(message_id, id);
+
+-- Moderators can edit and delete every message of their room. Granted with the moderator command, one per room since 0012_room_moderator.
+CREATE TABLE room_moderators(
+    room CHARACTER VARYING(64) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY(room, user_id));
@@ -0,0 +1,6 @@
+-- Replies of a thread point to the first message of the thread, which counts them.
+ALTER TABLE messages ADD COLUMN parent_id BIGINT REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN last_reply_at TIMESTAMPTZ;
+
+CREATE INDEX idx_messages_parent_id ON messages(parent_id, id) WHERE parent_id IS NOT NULL;
@@ -0,0 +1,7 @@
+-- A mention is read once the read cursor of the user in the room of the message passes it.
+CREATE TABLE mentions(
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY(user_id, message_id));
+
+CREATE INDEX idx_mentions_message_id ON mentions(message_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_body_tsv;
+ALTER TABLE messages DROP COLUMN IF EXISTS body_tsv;
@@ -0,0 +1,4 @@
+-- The simple configuration only lowercases the words, it works the same for every language.
+ALTER TABLE messages ADD COLUMN body_tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', body)) STORED;
+
+CREATE INDEX idx_messages_body_tsv ON messages USING GIN(body_tsv);
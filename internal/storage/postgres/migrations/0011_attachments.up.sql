@@ -0,0 +1,22 @@
+-- Users who joined a room at least once, they can download the attachments of the room.
+CREATE TABLE room_members(
+    room CHARACTER VARYING(64) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY(room, user_id));
+
+-- Metadata of the uploaded files, the contents are kept in the blob store under blob_key.
+-- message_id is set once a message of the uploader refers to the attachment.
+CREATE TABLE attachments(
+    id BIGSERIAL PRIMARY KEY,
+    room CHARACTER VARYING(64) NOT NULL,
+    uploader_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id BIGINT REFERENCES messages(id) ON DELETE CASCADE,
+    name CHARACTER VARYING(255) NOT NULL,
+    content_type CHARACTER VARYING(127) NOT NULL,
+    size BIGINT NOT NULL,
+    blob_key CHARACTER VARYING(128) NOT NULL UNIQUE,
+    thumbnail BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());
+
+CREATE INDEX idx_attachments_message_id ON attachments(message_id) WHERE message_id IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE room_moderators DROP CONSTRAINT room_moderators_pkey;
+ALTER TABLE room_moderators ADD PRIMARY KEY(room, user_id);
@@ -0,0 +1,5 @@
+-- Moderators are granted explicitly now, see `websocket-chat moderator`. The rows claimed by the first user
+-- who joined a room are dropped and every room has at most one moderator.
+DELETE FROM room_moderators;
+ALTER TABLE room_moderators DROP CONSTRAINT room_moderators_pkey;
+ALTER TABLE room_moderators ADD PRIMARY KEY(room);
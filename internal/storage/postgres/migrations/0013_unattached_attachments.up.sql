@@ -0,0 +1,2 @@
+-- Uploads no message refers to are deleted after a while, see blob/janitor.
+CREATE INDEX idx_attachments_unattached ON attachments(created_at) WHERE message_id IS NULL;
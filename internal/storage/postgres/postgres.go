@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"github.com/lib/pq"
 	"new-websocket-chat/internal/storage"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -23,37 +26,20 @@ func New(user string, password string, dbname string, hostname string, port int)
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	stmt1, err := db.Prepare(`
-	CREATE TABLE IF NOT EXISTS users(
-	    id SERIAL PRIMARY KEY,
-	    username CHARACTER VARYING(30) NOT NULL UNIQUE CHECK(username !=''),
-	    email CHARACTER VARYING(30) NOT NULL UNIQUE CHECK(email !=''),
-		password CHARACTER VARYING(100) NOT NULL);
-	`) // migrations ??? not necessary to create table, think about putting in another file and mark in main that you have migrations first
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
-	}
-
-	_, err = stmt1.Exec()
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
-	}
-
-	stmt2, err := db.Prepare(`
-		CREATE INDEX IF NOT EXISTS idx_username ON users(username);
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
-	}
-
-	_, err = stmt2.Exec()
-	if err != nil {
+	// the schema is created by the migrations, see MigrateUp
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
 	return &Storage{db: db}, nil
 }
 
+// Close closes the database, it waits for the queries that have started to finish.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
 func (s *Storage) SaveUser(username string, email string, password string) (int64, error) {
 	const op = "storage.postgres.SaveUser"
 
@@ -116,6 +102,71 @@ func (s *Storage) GetUsername(email string) (string, error) {
 	return resUsername, nil
 }
 
+// GetUserByLogin returns the user whose email equals the login if it contains '@', whose username equals it otherwise.
+func (s *Storage) GetUserByLogin(login string) (storage.User, error) {
+	const op = "storage.postgres.GetUserByLogin"
+
+	column := "username"
+	if strings.Contains(login, "@") {
+		column = "email"
+	}
+
+	stmt, err := s.db.Prepare(`SELECT id, username, email, password FROM users WHERE ` + column + `=$1`)
+	if err != nil {
+		return storage.User{}, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	var user storage.User
+	err = stmt.QueryRow(login).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.User{}, storage.ErrUserNotFound
+	}
+	if err != nil {
+		return storage.User{}, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// GetUserIDs returns the IDs of the users by username, unknown usernames are left out.
+func (s *Storage) GetUserIDs(usernames []string) (map[string]int64, error) {
+	const op = "storage.postgres.GetUserIDs"
+
+	rows, err := s.db.Query(`SELECT id, username FROM users WHERE username = ANY($1)`, pq.Array(usernames))
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int64, len(usernames))
+	for rows.Next() {
+		var id int64
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		ids[username] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+// UserExists reports whether the user is registered.
+func (s *Storage) UserExists(userID int64) (bool, error) {
+	const op = "storage.postgres.UserExists"
+
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id=$1)`, userID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return exists, nil
+}
+
 // Returns <nil> if user deleted successfully
 func (s *Storage) DeleteUser(username string, email string) error {
 	const op = "storage.postgres.DeleteUser"
@@ -136,8 +187,1203 @@ func (s *Storage) DeleteUser(username string, email string) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("%s: no rows affected: %w", op, err)
+		return fmt.Errorf("%s: no rows affected: %w", op, storage.ErrUsernameNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateLastSeen(userID int64, lastSeen time.Time) error {
+	const op = "storage.postgres.UpdateLastSeen"
+
+	stmt, err := s.db.Prepare(`UPDATE users SET last_seen=$2 WHERE id=$1`)
+	if err != nil {
+		return fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	result, err := stmt.Exec(userID, lastSeen)
+	if err != nil {
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: get rows affected: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// GetLastSeen returns the last seen time of the users, users that never connected or don't exist are left out.
+func (s *Storage) GetLastSeen(userIDs []int64) (map[int64]time.Time, error) {
+	const op = "storage.postgres.GetLastSeen"
+
+	stmt, err := s.db.Prepare(`SELECT id, last_seen FROM users WHERE id = ANY($1) AND last_seen IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	rows, err := stmt.Query(pq.Array(userIDs))
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	lastSeen := make(map[int64]time.Time, len(userIDs))
+	for rows.Next() {
+		var id int64
+		var t time.Time
+		if err := rows.Scan(&id, &t); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		lastSeen[id] = t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	return lastSeen, nil
+}
+
+func (s *Storage) SaveMessage(room string, senderID int64, body string, createdAt time.Time) (int64, error) {
+	const op = "storage.postgres.SaveMessage"
+
+	stmt, err := s.db.Prepare(`INSERT INTO messages(room, sender_id, body, created_at) VALUES($1, $2, $3, $4) RETURNING id`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	var id int64
+	err = stmt.QueryRow(room, senderID, body, createdAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// GetRoomMessages returns up to limit messages of the room with ID less than beforeID in chronological order.
+// If beforeID is 0 the latest messages are returned. Thread replies are left out, see GetThreadReplies.
+func (s *Storage) GetRoomMessages(room string, beforeID int64, limit int) ([]storage.Message, error) {
+	const op = "storage.postgres.GetRoomMessages"
+
+	stmt, err := s.db.Prepare(`
+		SELECT ` + messageColumns + ` FROM messages
+		WHERE room=$1 AND parent_id IS NULL AND ($2::BIGINT = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	rows, err := stmt.Query(room, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	messages := make([]storage.Message, 0, limit)
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	// rows are selected newest first to apply the limit, clients render them oldest first
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	if err := s.loadReactions(messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := s.loadAttachments(messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return messages, nil
+}
+
+// GetMissedMessages returns up to limit messages with ID greater than afterID in the rooms and in the direct rooms
+// of the user, oldest first and with their reactions and attachments. Thread replies and deleted messages are left out.
+func (s *Storage) GetMissedMessages(userID int64, rooms []string, afterID int64, limit int) ([]storage.Message, error) {
+	const op = "storage.postgres.GetMissedMessages"
+
+	stmt, err := s.db.Prepare(`
+		SELECT ` + messageColumns + ` FROM messages
+		WHERE parent_id IS NULL AND deleted_at IS NULL
+			AND id > $3
+			AND (room = ANY($1) OR room LIKE 'dm:' || $2 || ':%' OR room LIKE 'dm:%:' || $2)
+		ORDER BY id
+		LIMIT $4`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	rows, err := stmt.Query(pq.Array(rooms), strconv.FormatInt(userID, 10), afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	messages := make([]storage.Message, 0, limit)
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	if err := s.loadReactions(messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := s.loadAttachments(messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return messages, nil
+}
+
+// messageColumns are the columns scanned by scanMessage.
+const messageColumns = `id, room, sender_id, body, created_at, edited_at, deleted_at, COALESCE(parent_id, 0), reply_count, last_reply_at`
+
+// scanMessage scans a row of messageColumns.
+func scanMessage(row interface{ Scan(dest ...any) error }) (storage.Message, error) {
+	var m storage.Message
+	err := row.Scan(&m.ID, &m.Room, &m.SenderID, &m.Body, &m.CreatedAt, &m.EditedAt, &m.DeletedAt, &m.ParentID, &m.ReplyCount, &m.LastReplyAt)
+
+	return m, err
+}
+
+// SaveReply saves a reply to the thread of the parent message in the room of the parent and updates
+// the reply count and the last reply time of the parent. It returns the ID of the reply and the updated parent.
+func (s *Storage) SaveReply(parentID int64, senderID int64, body string, createdAt time.Time) (int64, storage.Message, error) {
+	const op = "storage.postgres.SaveReply"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, storage.Message{}, fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	parent, err := scanMessage(tx.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id=$1 FOR UPDATE`, parentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.Message{}, fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+	if err != nil {
+		return 0, storage.Message{}, fmt.Errorf("%s: select parent: %w", op, err)
+	}
+	if parent.DeletedAt != nil {
+		return 0, storage.Message{}, fmt.Errorf("%s: %w", op, storage.ErrMessageDeleted)
+	}
+	if parent.ParentID != 0 {
+		return 0, storage.Message{}, fmt.Errorf("%s: %w", op, storage.ErrNotThreadRoot)
+	}
+
+	var id int64
+	err = tx.QueryRow(`INSERT INTO messages(room, sender_id, body, created_at, parent_id) VALUES($1, $2, $3, $4, $5) RETURNING id`,
+		parent.Room, senderID, body, createdAt, parentID).Scan(&id)
+	if err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == "23503" { // 23503 foreign key violation - if user doesn't exist
+			return 0, storage.Message{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return 0, storage.Message{}, fmt.Errorf("%s: save reply: %w", op, err)
+	}
+
+	parent, err = scanMessage(tx.QueryRow(`
+		UPDATE messages SET reply_count = reply_count + 1, last_reply_at = GREATEST(last_reply_at, $2)
+		WHERE id=$1
+		RETURNING `+messageColumns, parentID, createdAt))
+	if err != nil {
+		return 0, storage.Message{}, fmt.Errorf("%s: update parent: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, storage.Message{}, fmt.Errorf("%s: commit transaction: %w", op, err)
+	}
+
+	return id, parent, nil
+}
+
+// GetThreadReplies returns up to limit replies of the thread with ID less than beforeID in chronological order.
+// If beforeID is 0 the latest replies are returned.
+func (s *Storage) GetThreadReplies(parentID int64, beforeID int64, limit int) ([]storage.Message, error) {
+	const op = "storage.postgres.GetThreadReplies"
+
+	stmt, err := s.db.Prepare(`
+		SELECT ` + messageColumns + ` FROM messages
+		WHERE parent_id=$1 AND ($2::BIGINT = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	rows, err := stmt.Query(parentID, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	replies := make([]storage.Message, 0, limit)
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		replies = append(replies, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	for i, j := 0, len(replies)-1; i < j; i, j = i+1, j-1 {
+		replies[i], replies[j] = replies[j], replies[i]
+	}
+
+	if err := s.loadReactions(replies); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := s.loadAttachments(replies); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return replies, nil
+}
+
+// loadReactions sets the aggregated reactions of the messages, emojis ordered by their first reaction.
+func (s *Storage) loadReactions(messages []storage.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(messages))
+	index := make(map[int64]int, len(messages))
+	for i, m := range messages {
+		ids = append(ids, m.ID)
+		index[m.ID] = i
+	}
+
+	rows, err := s.db.Query(`
+		SELECT message_id, emoji, user_id FROM message_reactions
+		WHERE message_id = ANY($1)
+		ORDER BY message_id, created_at, user_id`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("select reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, userID int64
+		var emoji string
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			return fmt.Errorf("scan reaction: %w", err)
+		}
+		m := &messages[index[messageID]]
+		m.Reactions = storage.AppendReaction(m.Reactions, emoji, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate reactions: %w", err)
+	}
+
+	return nil
+}
+
+// AddReaction adds the emoji reaction of the user to the message. It returns false if the user
+// already reacted with the emoji or the message is deleted.
+func (s *Storage) AddReaction(messageID int64, userID int64, emoji string) (bool, error) {
+	const op = "storage.postgres.AddReaction"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO message_reactions(message_id, user_id, emoji)
+		SELECT $1, $2, $3 WHERE EXISTS (SELECT 1 FROM messages WHERE id=$1 AND deleted_at IS NULL)
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return false, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	result, err := stmt.Exec(messageID, userID, emoji)
+	if err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == "23503" { // 23503 foreign key violation - if user doesn't exist
+			return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return false, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: get rows affected: %w", op, err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// RemoveReaction removes the emoji reaction of the user from the message. It returns false if there was none.
+func (s *Storage) RemoveReaction(messageID int64, userID int64, emoji string) (bool, error) {
+	const op = "storage.postgres.RemoveReaction"
+
+	stmt, err := s.db.Prepare(`DELETE FROM message_reactions WHERE message_id=$1 AND user_id=$2 AND emoji=$3`)
+	if err != nil {
+		return false, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	result, err := stmt.Exec(messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s: get rows affected: %w", op, err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Storage) GetMessage(messageID int64) (storage.Message, error) {
+	const op = "storage.postgres.GetMessage"
+
+	stmt, err := s.db.Prepare(`SELECT ` + messageColumns + ` FROM messages WHERE id=$1`)
+	if err != nil {
+		return storage.Message{}, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	m, err := scanMessage(stmt.QueryRow(messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Message{}, storage.ErrMessageNotFound
+	}
+	if err != nil {
+		return storage.Message{}, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// EditMessage replaces the body of the message and keeps the previous body in the edit history.
+func (s *Storage) EditMessage(messageID int64, editorID int64, body string, editedAt time.Time) error {
+	const op = "storage.postgres.EditMessage"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var previous string
+	var deletedAt *time.Time
+	err = tx.QueryRow(`SELECT body, deleted_at FROM messages WHERE id=$1 FOR UPDATE`, messageID).Scan(&previous, &deletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: select message: %w", op, err)
+	}
+	if deletedAt != nil {
+		return fmt.Errorf("%s: %w", op, storage.ErrMessageDeleted)
+	}
+
+	_, err = tx.Exec(`INSERT INTO message_edits(message_id, body, edited_by, edited_at) VALUES($1, $2, $3, $4)`, messageID, previous, editorID, editedAt)
+	if err != nil {
+		return fmt.Errorf("%s: save edit: %w", op, err)
+	}
+
+	_, err = tx.Exec(`UPDATE messages SET body=$2, edited_at=$3 WHERE id=$1`, messageID, body, editedAt)
+	if err != nil {
+		return fmt.Errorf("%s: update message: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: commit transaction: %w", op, err)
 	}
 
 	return nil
 }
+
+// DeleteMessage turns the message into a tombstone: the body, the edit history, the reactions and the attachments
+// are removed, the row stays. It returns the removed attachments, the caller deletes their blobs.
+func (s *Storage) DeleteMessage(messageID int64, deletedAt time.Time) ([]storage.Attachment, error) {
+	const op = "storage.postgres.DeleteMessage"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE messages SET body='', deleted_at=$2 WHERE id=$1 AND deleted_at IS NULL`, messageID, deletedAt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: update message: %w", op, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("%s: get rows affected: %w", op, err)
+	}
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMessageDeleted)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id=$1`, messageID); err != nil {
+		return nil, fmt.Errorf("%s: delete edits: %w", op, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM message_reactions WHERE message_id=$1`, messageID); err != nil {
+		return nil, fmt.Errorf("%s: delete reactions: %w", op, err)
+	}
+
+	rows, err := tx.Query(`DELETE FROM attachments WHERE message_id=$1 RETURNING `+attachmentColumns, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: delete attachments: %w", op, err)
+	}
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: commit transaction: %w", op, err)
+	}
+
+	return attachments, nil
+}
+
+// GetMessageEdits returns the previous bodies of a message of the room, oldest first.
+func (s *Storage) GetMessageEdits(room string, messageID int64) ([]storage.MessageEdit, error) {
+	const op = "storage.postgres.GetMessageEdits"
+
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM messages WHERE id=$1 AND room=$2)`, messageID, room).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("%s: select message: %w", op, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+
+	rows, err := s.db.Query(`SELECT id, message_id, body, COALESCE(edited_by, 0), edited_at FROM message_edits WHERE message_id=$1 ORDER BY id`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	edits := []storage.MessageEdit{}
+	for rows.Next() {
+		var e storage.MessageEdit
+		if err := rows.Scan(&e.ID, &e.MessageID, &e.Body, &e.EditedBy, &e.EditedAt); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		edits = append(edits, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	return edits, nil
+}
+
+// SetRoomModerator makes the user the moderator of the room, replacing the previous one.
+func (s *Storage) SetRoomModerator(room string, userID int64) error {
+	const op = "storage.postgres.SetRoomModerator"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO room_moderators(room, user_id) VALUES($1, $2)
+		ON CONFLICT (room) DO UPDATE SET user_id=EXCLUDED.user_id, created_at=NOW()`)
+	if err != nil {
+		return fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	if _, err := stmt.Exec(room, userID); err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == "23503" { // 23503 foreign key violation - if user doesn't exist
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveRoomModerator leaves the room without a moderator.
+func (s *Storage) RemoveRoomModerator(room string) error {
+	const op = "storage.postgres.RemoveRoomModerator"
+
+	if _, err := s.db.Exec(`DELETE FROM room_moderators WHERE room=$1`, room); err != nil {
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsRoomModerator(room string, userID int64) (bool, error) {
+	const op = "storage.postgres.IsRoomModerator"
+
+	var moderator bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM room_moderators WHERE room=$1 AND user_id=$2)`, room, userID).Scan(&moderator)
+	if err != nil {
+		return false, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return moderator, nil
+}
+
+// AddRoomMember records that the user joined the room, joining again is a no-op.
+func (s *Storage) AddRoomMember(room string, userID int64) error {
+	const op = "storage.postgres.AddRoomMember"
+
+	stmt, err := s.db.Prepare(`INSERT INTO room_members(room, user_id) VALUES($1, $2) ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	_, err = stmt.Exec(room, userID)
+	if err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == "23503" { // 23503 foreign key violation - if user doesn't exist
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return nil
+}
+
+// IsRoomMember reports whether the user ever joined the room.
+func (s *Storage) IsRoomMember(room string, userID int64) (bool, error) {
+	const op = "storage.postgres.IsRoomMember"
+
+	var member bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM room_members WHERE room=$1 AND user_id=$2)`, room, userID).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return member, nil
+}
+
+// SaveAttachment saves the metadata of an uploaded file, the ID and the message ID of the attachment are ignored.
+func (s *Storage) SaveAttachment(attachment storage.Attachment) (int64, error) {
+	const op = "storage.postgres.SaveAttachment"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO attachments(room, uploader_id, name, content_type, size, blob_key, thumbnail, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	var id int64
+	err = stmt.QueryRow(attachment.Room, attachment.UploaderID, attachment.Name, attachment.ContentType,
+		attachment.Size, attachment.BlobKey, attachment.Thumbnail, attachment.CreatedAt).Scan(&id)
+	if err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == "23503" { // 23503 foreign key violation - if user doesn't exist
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return 0, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) GetAttachment(attachmentID int64) (storage.Attachment, error) {
+	const op = "storage.postgres.GetAttachment"
+
+	a, err := scanAttachment(s.db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id=$1`, attachmentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Attachment{}, storage.ErrAttachmentNotFound
+	}
+	if err != nil {
+		return storage.Attachment{}, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return a, nil
+}
+
+// AttachToMessage links the attachments to the message. It fails with ErrAttachmentNotFound and links none of them
+// if any attachment doesn't exist or already belongs to a message.
+func (s *Storage) AttachToMessage(messageID int64, attachmentIDs []int64) error {
+	const op = "storage.postgres.AttachToMessage"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: begin transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE attachments SET message_id=$1 WHERE id = ANY($2) AND message_id IS NULL`, messageID, pq.Array(attachmentIDs))
+	if err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == "23503" { // 23503 foreign key violation - if message doesn't exist
+			return fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+		}
+
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: get rows affected: %w", op, err)
+	}
+	if rowsAffected != int64(len(attachmentIDs)) {
+		return fmt.Errorf("%s: %w", op, storage.ErrAttachmentNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: commit transaction: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteUnattachedAttachments removes the attachments uploaded before the time that no message refers to
+// and returns them, the caller deletes their blobs.
+func (s *Storage) DeleteUnattachedAttachments(uploadedBefore time.Time) ([]storage.Attachment, error) {
+	const op = "storage.postgres.DeleteUnattachedAttachments"
+
+	rows, err := s.db.Query(`
+		DELETE FROM attachments WHERE message_id IS NULL AND created_at < $1
+		RETURNING `+attachmentColumns, uploadedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return attachments, nil
+}
+
+// attachmentColumns are the columns scanned by scanAttachment.
+const attachmentColumns = `id, room, uploader_id, COALESCE(message_id, 0), name, content_type, size, blob_key, thumbnail, created_at`
+
+// scanAttachment scans a row of attachmentColumns.
+func scanAttachment(row interface{ Scan(dest ...any) error }) (storage.Attachment, error) {
+	var a storage.Attachment
+	err := row.Scan(&a.ID, &a.Room, &a.UploaderID, &a.MessageID, &a.Name, &a.ContentType, &a.Size, &a.BlobKey, &a.Thumbnail, &a.CreatedAt)
+
+	return a, err
+}
+
+// scanAttachments scans and closes the rows of attachmentColumns.
+func scanAttachments(rows *sql.Rows) ([]storage.Attachment, error) {
+	defer rows.Close()
+
+	var attachments []storage.Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan attachment: %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// loadAttachments sets the attachments of the messages in the order they were uploaded.
+func (s *Storage) loadAttachments(messages []storage.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(messages))
+	index := make(map[int64]int, len(messages))
+	for i, m := range messages {
+		ids = append(ids, m.ID)
+		index[m.ID] = i
+	}
+
+	rows, err := s.db.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE message_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("select attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return fmt.Errorf("scan attachment: %w", err)
+		}
+		m := &messages[index[a.MessageID]]
+		m.Attachments = append(m.Attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate attachments: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SavePendingMessage(userID int64, messageID int64) error {
+	const op = "storage.postgres.SavePendingMessage"
+
+	stmt, err := s.db.Prepare(`INSERT INTO pending_messages(user_id, message_id) VALUES($1, $2) ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	_, err = stmt.Exec(userID, messageID)
+	if err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == "23503" { // 23503 foreign key violation - if user doesn't exist
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return nil
+}
+
+// TakePendingMessages returns the messages waiting for the user in chronological order and removes them from the queue.
+func (s *Storage) TakePendingMessages(userID int64) ([]storage.Message, error) {
+	const op = "storage.postgres.TakePendingMessages"
+
+	stmt, err := s.db.Prepare(`
+		WITH taken AS (DELETE FROM pending_messages WHERE user_id=$1 RETURNING message_id)
+		SELECT m.id, m.room, m.sender_id, m.body, m.created_at, m.edited_at, m.deleted_at FROM messages m
+		JOIN taken t ON t.message_id = m.id
+		WHERE m.deleted_at IS NULL
+		ORDER BY m.id`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	var messages []storage.Message
+	for rows.Next() {
+		var m storage.Message
+		if err := rows.Scan(&m.ID, &m.Room, &m.SenderID, &m.Body, &m.CreatedAt, &m.EditedAt, &m.DeletedAt); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	if err := s.loadAttachments(messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return messages, nil
+}
+
+// UpdateDeliveredCursors moves the delivered cursors of the users in the rooms forward, at most one per user and room,
+// in one statement. It returns the cursors that moved with their previous message, the others were already
+// at a newer message or belong to users who don't exist anymore.
+func (s *Storage) UpdateDeliveredCursors(cursors []storage.DeliveredCursor) ([]storage.DeliveredCursor, error) {
+	const op = "storage.postgres.UpdateDeliveredCursors"
+
+	if len(cursors) == 0 {
+		return nil, nil
+	}
+
+	userIDs := make([]int64, len(cursors))
+	rooms := make([]string, len(cursors))
+	messageIDs := make([]int64, len(cursors))
+	for i, c := range cursors {
+		userIDs[i], rooms[i], messageIDs[i] = c.UserID, c.Room, c.MessageID
+	}
+
+	// previous reads the cursors as they were before the statement
+	rows, err := s.db.Query(`
+		WITH batch AS (
+		    SELECT * FROM unnest($1::BIGINT[], $2::TEXT[], $3::BIGINT[]) AS b(user_id, room, delivered_id)
+		), previous AS (
+		    SELECT c.user_id, c.room, c.delivered_id FROM room_cursors c JOIN batch b USING (user_id, room)
+		), updated AS (
+		    INSERT INTO room_cursors(user_id, room, delivered_id)
+		    SELECT user_id, room, delivered_id FROM batch WHERE EXISTS (SELECT 1 FROM users WHERE id=batch.user_id)
+		    ON CONFLICT (user_id, room) DO UPDATE SET delivered_id=EXCLUDED.delivered_id
+		    WHERE room_cursors.delivered_id < EXCLUDED.delivered_id
+		    RETURNING user_id, room, delivered_id
+		)
+		SELECT u.user_id, u.room, u.delivered_id, COALESCE(p.delivered_id, 0)
+		FROM updated u LEFT JOIN previous p USING (user_id, room)`,
+		pq.Array(userIDs), pq.Array(rooms), pq.Array(messageIDs))
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	var advanced []storage.DeliveredCursor
+	for rows.Next() {
+		var c storage.DeliveredCursor
+		if err := rows.Scan(&c.UserID, &c.Room, &c.MessageID, &c.Previous); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		advanced = append(advanced, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	return advanced, nil
+}
+
+// UpdateReadCursor moves the read cursor of the user in the room forward to a message of the room
+// and returns the previous cursor. The cursor doesn't move back, compare the result with messageID.
+func (s *Storage) UpdateReadCursor(userID int64, room string, messageID int64) (int64, error) {
+	const op = "storage.postgres.UpdateReadCursor"
+
+	stmt, err := s.db.Prepare(`
+		WITH previous AS (SELECT read_id FROM room_cursors WHERE user_id=$1 AND room=$2)
+		INSERT INTO room_cursors(user_id, room, delivered_id, read_id)
+		SELECT $1, $2, $3, $3 WHERE EXISTS (SELECT 1 FROM messages WHERE id=$3 AND room=$2)
+		ON CONFLICT (user_id, room) DO UPDATE SET
+		    read_id=GREATEST(room_cursors.read_id, EXCLUDED.read_id),
+		    delivered_id=GREATEST(room_cursors.delivered_id, EXCLUDED.read_id)
+		RETURNING COALESCE((SELECT read_id FROM previous), 0)`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	var previous int64
+	err = stmt.QueryRow(userID, room, messageID).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return previous, nil
+}
+
+// GetMessageSenders returns the distinct senders of the room messages with afterID < ID <= upToID.
+func (s *Storage) GetMessageSenders(room string, afterID int64, upToID int64) ([]int64, error) {
+	const op = "storage.postgres.GetMessageSenders"
+
+	stmt, err := s.db.Prepare(`SELECT DISTINCT sender_id FROM messages WHERE room=$1 AND id > $2 AND id <= $3`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	rows, err := stmt.Query(room, afterID, upToID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	var senders []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		senders = append(senders, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	return senders, nil
+}
+
+// GetUnreadCounts returns the unread counts of every room the user has a cursor in, ordered by room.
+func (s *Storage) GetUnreadCounts(userID int64) ([]storage.UnreadCount, error) {
+	const op = "storage.postgres.GetUnreadCounts"
+
+	stmt, err := s.db.Prepare(`
+		SELECT c.room, c.read_id, COUNT(m.id) FROM room_cursors c
+		LEFT JOIN messages m ON m.room = c.room AND m.id > c.read_id AND m.sender_id <> c.user_id
+		WHERE c.user_id=$1
+		GROUP BY c.room, c.read_id
+		ORDER BY c.room`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	counts := []storage.UnreadCount{}
+	for rows.Next() {
+		var c storage.UnreadCount
+		if err := rows.Scan(&c.Room, &c.LastReadID, &c.Unread); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	return counts, nil
+}
+
+func (s *Storage) SaveRefreshToken(userID int64, familyID string, tokenHash string, expiresAt time.Time) error {
+	const op = "storage.postgres.SaveRefreshToken"
+
+	stmt, err := s.db.Prepare(`INSERT INTO refresh_tokens(user_id, family_id, token_hash, expires_at) VALUES($1, $2, $3, $4)`)
+	if err != nil {
+		return fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	_, err = stmt.Exec(userID, familyID, tokenHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetRefreshToken(tokenHash string) (storage.RefreshToken, error) {
+	const op = "storage.postgres.GetRefreshToken"
+
+	stmt, err := s.db.Prepare(`
+		SELECT id, user_id, family_id, token_hash, expires_at, revoked_at, created_at
+		FROM refresh_tokens WHERE token_hash=$1`)
+	if err != nil {
+		return storage.RefreshToken{}, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	var token storage.RefreshToken
+	err = stmt.QueryRow(tokenHash).Scan(&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash, &token.ExpiresAt, &token.RevokedAt, &token.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.RefreshToken{}, storage.ErrTokenNotFound
+	}
+	if err != nil {
+		return storage.RefreshToken{}, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// RevokeRefreshToken marks the token as revoked. It returns storage.ErrTokenRevoked if the token was already revoked,
+// so two concurrent refreshes with the same token can't both succeed.
+func (s *Storage) RevokeRefreshToken(tokenHash string) error {
+	const op = "storage.postgres.RevokeRefreshToken"
+
+	stmt, err := s.db.Prepare(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE token_hash=$1 AND revoked_at IS NULL`)
+	if err != nil {
+		return fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	result, err := stmt.Exec(tokenHash)
+	if err != nil {
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: getting rows affected: %w", op, err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenRevoked)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(familyID string) error {
+	const op = "storage.postgres.RevokeRefreshTokenFamily"
+
+	stmt, err := s.db.Prepare(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=$1 AND revoked_at IS NULL`)
+	if err != nil {
+		return fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	_, err = stmt.Exec(familyID)
+	if err != nil {
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeUserRefreshTokens(userID int64) error {
+	const op = "storage.postgres.RevokeUserRefreshTokens"
+
+	stmt, err := s.db.Prepare(`UPDATE refresh_tokens SET revoked_at=NOW() WHERE user_id=$1 AND revoked_at IS NULL`)
+	if err != nil {
+		return fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	_, err = stmt.Exec(userID)
+	if err != nil {
+		return fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveMentions records that the message mentions the users and returns the users it didn't mention before.
+func (s *Storage) SaveMentions(messageID int64, userIDs []int64) ([]int64, error) {
+	const op = "storage.postgres.SaveMentions"
+
+	rows, err := s.db.Query(`
+		INSERT INTO mentions(message_id, user_id)
+		SELECT $1, unnest($2::INTEGER[])
+		ON CONFLICT DO NOTHING
+		RETURNING user_id`, messageID, pq.Array(userIDs))
+	if err != nil {
+		if postgresErr, ok := err.(*pq.Error); ok && postgresErr.Code == "23503" { // 23503 foreign key violation - if message doesn't exist
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrMessageNotFound)
+		}
+
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	var added []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		added = append(added, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	return added, nil
+}
+
+// GetMentions returns up to limit messages mentioning the user with ID less than beforeID, newest first.
+// If beforeID is 0 the latest mentions are returned. Deleted messages are left out.
+func (s *Storage) GetMentions(userID int64, beforeID int64, limit int) ([]storage.Mention, error) {
+	const op = "storage.postgres.GetMentions"
+
+	stmt, err := s.db.Prepare(`
+		SELECT ` + messageColumns + `,
+			id <= COALESCE((SELECT read_id FROM room_cursors c WHERE c.user_id=$1 AND c.room=messages.room), 0)
+		FROM messages
+		WHERE id IN (SELECT message_id FROM mentions WHERE user_id=$1)
+			AND deleted_at IS NULL AND ($2::BIGINT = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	rows, err := stmt.Query(userID, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	mentions := make([]storage.Mention, 0, limit)
+	for rows.Next() {
+		var m storage.Mention
+		err := rows.Scan(&m.Message.ID, &m.Message.Room, &m.Message.SenderID, &m.Message.Body, &m.Message.CreatedAt,
+			&m.Message.EditedAt, &m.Message.DeletedAt, &m.Message.ParentID, &m.Message.ReplyCount, &m.Message.LastReplyAt, &m.Read)
+		if err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		mentions = append(mentions, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	return mentions, nil
+}
+
+// GetUnreadMentionCount returns the number of messages mentioning the user after the read cursor of their room.
+func (s *Storage) GetUnreadMentionCount(userID int64) (int, error) {
+	const op = "storage.postgres.GetUnreadMentionCount"
+
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM mentions mn
+		JOIN messages m ON m.id = mn.message_id
+		LEFT JOIN room_cursors c ON c.user_id = mn.user_id AND c.room = m.room
+		WHERE mn.user_id=$1 AND m.deleted_at IS NULL AND m.id > COALESCE(c.read_id, 0)`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+
+	return count, nil
+}
+
+// SearchMessages returns up to query.Limit messages containing every term, newest first, of the rooms
+// the user joined and of the direct rooms of the user. Deleted messages are left out.
+func (s *Storage) SearchMessages(query storage.SearchQuery) ([]storage.Message, error) {
+	const op = "storage.postgres.SearchMessages"
+
+	var from, to *time.Time
+	if !query.From.IsZero() {
+		from = &query.From
+	}
+	if !query.To.IsZero() {
+		to = &query.To
+	}
+
+	// the terms are words already, joined with & they match like plainto_tsquery
+	stmt, err := s.db.Prepare(`
+		SELECT ` + messageColumns + ` FROM messages
+		WHERE body_tsv @@ to_tsquery('simple', $1)
+			AND deleted_at IS NULL
+			AND (room IN (SELECT room FROM room_members WHERE user_id=$9)
+				OR room LIKE 'dm:' || $2 || ':%' OR room LIKE 'dm:%:' || $2)
+			AND ($3 = '' OR room = $3)
+			AND ($4::BIGINT = 0 OR sender_id = $4)
+			AND ($5::TIMESTAMPTZ IS NULL OR created_at >= $5)
+			AND ($6::TIMESTAMPTZ IS NULL OR created_at < $6)
+			AND ($7::BIGINT = 0 OR id < $7)
+		ORDER BY id DESC
+		LIMIT $8`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: prepare statement: %w", op, err)
+	}
+
+	rows, err := stmt.Query(tsQuery(query.Terms), strconv.FormatInt(query.UserID, 10), query.Room, query.SenderID, from, to, query.BeforeID, query.Limit, query.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: execute statement: %w", op, err)
+	}
+	defer rows.Close()
+
+	messages := make([]storage.Message, 0, query.Limit)
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: scan row: %w", op, err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: iterate rows: %w", op, err)
+	}
+
+	return messages, nil
+}
+
+// tsQuery joins the search terms into a to_tsquery expression matching every term.
+// Each term is quoted, so words like "or" and "!" can't change the meaning of the query.
+func tsQuery(terms []string) string {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, "'"+strings.ReplaceAll(term, "'", "''")+"'")
+	}
+
+	return strings.Join(quoted, " & ")
+}
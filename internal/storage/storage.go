@@ -2,15 +2,199 @@ package storage
 
 import (
 	"errors"
+	"time"
 )
 
 var (
-	ErrUsernameNotFound = errors.New("username is not found")
-	ErrEmailNotFound    = errors.New("email is not found")
-	ErrUserExists       = errors.New("user already exists")
+	ErrUsernameNotFound   = errors.New("username is not found")
+	ErrEmailNotFound      = errors.New("email is not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user is not found")
+	ErrTokenNotFound      = errors.New("token is not found")
+	ErrTokenRevoked       = errors.New("token is revoked")
+	ErrMessageNotFound    = errors.New("message is not found")
+	ErrMessageDeleted     = errors.New("message is deleted")
+	ErrNotThreadRoot      = errors.New("message is a thread reply")
+	ErrAttachmentNotFound = errors.New("attachment is not found")
 )
 
+// User is a registered user with the bcrypt hash of the password.
+type User struct {
+	ID           int64
+	Username     string
+	Email        string
+	PasswordHash string
+}
+
+// RefreshToken is the stored hash of an issued refresh token.
+// Tokens of the same family descend from one login, each of them replaced the previous one on refresh.
+type RefreshToken struct {
+	ID        int64
+	UserID    int64
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// Message is a chat message sent to a room. Deleted messages are tombstones with an empty body.
+// Replies of a thread have the ID of the first message of the thread as ParentID.
+type Message struct {
+	ID          int64        `json:"id"`
+	Room        string       `json:"room"`
+	SenderID    int64        `json:"senderId"`
+	Body        string       `json:"body"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	EditedAt    *time.Time   `json:"editedAt,omitempty"`
+	DeletedAt   *time.Time   `json:"deletedAt,omitempty"`
+	ParentID    int64        `json:"parentId,omitempty"`
+	ReplyCount  int          `json:"replyCount,omitempty"`
+	LastReplyAt *time.Time   `json:"lastReplyAt,omitempty"`
+	Reactions   []Reaction   `json:"reactions,omitempty"`   // set by GetRoomMessages, GetThreadReplies and GetMissedMessages only
+	Attachments []Attachment `json:"attachments,omitempty"` // set by GetRoomMessages, GetThreadReplies, GetMissedMessages and TakePendingMessages only
+}
+
+// Reaction is the aggregate of the reactions with the same emoji on a message.
+type Reaction struct {
+	Emoji   string  `json:"emoji"`
+	Count   int     `json:"count"`
+	UserIDs []int64 `json:"userIds"` // in the order the users reacted
+}
+
+// AppendReaction adds the reaction of the user to the aggregates. Backends call it in the order the users reacted.
+func AppendReaction(reactions []Reaction, emoji string, userID int64) []Reaction {
+	for i := range reactions {
+		if reactions[i].Emoji == emoji {
+			reactions[i].Count++
+			reactions[i].UserIDs = append(reactions[i].UserIDs, userID)
+			return reactions
+		}
+	}
+
+	return append(reactions, Reaction{Emoji: emoji, Count: 1, UserIDs: []int64{userID}})
+}
+
+// Attachment is a file uploaded to a room, its contents are kept in a blob store under BlobKey.
+// MessageID is 0 until a message of the uploader refers to the attachment.
+type Attachment struct {
+	ID          int64     `json:"id"`
+	Room        string    `json:"room"`
+	UploaderID  int64     `json:"uploaderId"`
+	MessageID   int64     `json:"messageId,omitempty"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	BlobKey     string    `json:"-"`
+	Thumbnail   bool      `json:"thumbnail"` // the thumbnail is kept under blob.ThumbnailKey(BlobKey)
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// MessageEdit is a previous body of an edited message.
+type MessageEdit struct {
+	ID        int64     `json:"id"`
+	MessageID int64     `json:"messageId"`
+	Body      string    `json:"body"`
+	EditedBy  int64     `json:"editedBy"` // 0 if the user was deleted
+	EditedAt  time.Time `json:"editedAt"`
+}
+
+// SearchQuery selects the messages returned by SearchMessages, zero fields don't filter.
+type SearchQuery struct {
+	Terms    []string // lowercase words the message must contain, see lib/search
+	UserID   int64    // user searching, only messages of the rooms the user joined and of the user's direct rooms are returned
+	Room     string
+	SenderID int64
+	From     time.Time // messages created at or after
+	To       time.Time // messages created before
+	BeforeID int64     // messages with ID less than, for paging
+	Limit    int
+}
+
+// Mention is a message that mentions the user. It is read once the user read the room up to the message.
+type Mention struct {
+	Message Message `json:"message"`
+	Read    bool    `json:"read"`
+}
+
+// UnreadCount is the number of messages of other users in a room after the last one the user read.
+type UnreadCount struct {
+	Room       string `json:"room"`
+	LastReadID int64  `json:"lastReadId"`
+	Unread     int64  `json:"unread"`
+}
+
+// DeliveredCursor is the newest message of a room delivered to a user, see Storage.UpdateDeliveredCursors.
+type DeliveredCursor struct {
+	UserID    int64
+	Room      string
+	MessageID int64
+	Previous  int64 // Cursor before the update, set by UpdateDeliveredCursors
+}
+
+// Storage is implemented by every storage backend, see storage/factory for choosing one from the config.
+type Storage interface {
+	// Users
+	SaveUser(username string, email string, password string) (int64, error)
+	GetUserEmail(username string) (*string, error)
+	GetUsername(email string) (string, error)
+	GetUserByLogin(login string) (User, error)
+	GetUserIDs(usernames []string) (map[string]int64, error)
+	UserExists(userID int64) (bool, error)
+	DeleteUser(username string, email string) error
+	UpdateLastSeen(userID int64, lastSeen time.Time) error
+	GetLastSeen(userIDs []int64) (map[int64]time.Time, error)
+
+	// Sessions
+	SaveRefreshToken(userID int64, familyID string, tokenHash string, expiresAt time.Time) error
+	GetRefreshToken(tokenHash string) (RefreshToken, error)
+	RevokeRefreshToken(tokenHash string) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int64) error
+
+	// Messages
+	SaveMessage(room string, senderID int64, body string, createdAt time.Time) (int64, error)
+	GetRoomMessages(room string, beforeID int64, limit int) ([]Message, error)
+	GetMissedMessages(userID int64, rooms []string, afterID int64, limit int) ([]Message, error)
+	GetMessage(messageID int64) (Message, error)
+	SaveReply(parentID int64, senderID int64, body string, createdAt time.Time) (int64, Message, error)
+	GetThreadReplies(parentID int64, beforeID int64, limit int) ([]Message, error)
+	EditMessage(messageID int64, editorID int64, body string, editedAt time.Time) error
+	DeleteMessage(messageID int64, deletedAt time.Time) ([]Attachment, error)
+	GetMessageEdits(room string, messageID int64) ([]MessageEdit, error)
+	SearchMessages(query SearchQuery) ([]Message, error)
+	SetRoomModerator(room string, userID int64) error
+	RemoveRoomModerator(room string) error
+	IsRoomModerator(room string, userID int64) (bool, error)
+	AddRoomMember(room string, userID int64) error
+	IsRoomMember(room string, userID int64) (bool, error)
+	AddReaction(messageID int64, userID int64, emoji string) (bool, error)
+	RemoveReaction(messageID int64, userID int64, emoji string) (bool, error)
+	SavePendingMessage(userID int64, messageID int64) error
+	TakePendingMessages(userID int64) ([]Message, error)
+
+	// Attachments
+	SaveAttachment(attachment Attachment) (int64, error)
+	GetAttachment(attachmentID int64) (Attachment, error)
+	AttachToMessage(messageID int64, attachmentIDs []int64) error
+	DeleteUnattachedAttachments(uploadedBefore time.Time) ([]Attachment, error)
+
+	// Receipts
+	UpdateDeliveredCursors(cursors []DeliveredCursor) ([]DeliveredCursor, error)
+	UpdateReadCursor(userID int64, room string, messageID int64) (int64, error)
+	GetMessageSenders(room string, afterID int64, upToID int64) ([]int64, error)
+	GetUnreadCounts(userID int64) ([]UnreadCount, error)
+
+	// Mentions
+	SaveMentions(messageID int64, userIDs []int64) ([]int64, error)
+	GetMentions(userID int64, beforeID int64, limit int) ([]Mention, error)
+	GetUnreadMentionCount(userID int64) (int, error)
+
+	Close() error
+}
+
 /* Clean code thoughts & questions to myself
 TODO:
-[ ] - Shouldn't here be a method that init a storage by your choice (postgres, mysql, etc) and returns needed instance? Right now in main it's just: postgres.New(...)
+[x] - Shouldn't here be a method that init a storage by your choice (postgres, mysql, etc) and returns needed instance? Right now in main it's just: postgres.New(...)
+      Done in storage/factory, it can't live here because the backends import this package.
 */
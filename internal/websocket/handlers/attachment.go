@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"errors"
+	"log/slog"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+)
+
+// addRoomMember records that the user joined the room, members can download the attachments of the room.
+func (c *Client) addRoomMember(log *slog.Logger, room string) {
+	if err := c.hub.store.AddRoomMember(room, c.userID); err != nil {
+		log.Error("failed to add room member", sl.Err(err))
+	}
+}
+
+// checkAttachments loads the attachments of a message to the room and checks that the user uploaded them
+// to the room and no other message refers to them. It replies with an error frame and returns false otherwise.
+func (c *Client) checkAttachments(log *slog.Logger, ref string, room string, ids []string) ([]storage.Attachment, bool) {
+	if len(ids) == 0 {
+		return nil, true
+	}
+
+	attachments := make([]storage.Attachment, 0, len(ids))
+	for _, id := range ids {
+		attachmentID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "field Attachments is not valid", Ref: ref})
+			return nil, false
+		}
+
+		attachment, err := c.hub.store.GetAttachment(attachmentID)
+		if errors.Is(err, storage.ErrAttachmentNotFound) || (err == nil && (attachment.Room != room || attachment.UploaderID != c.userID)) {
+			c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "attachment " + id + " is not found in the room", Ref: ref})
+			return nil, false
+		}
+		if err != nil {
+			log.Error("failed to get attachment", sl.Err(err))
+			c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to load attachments", Ref: ref})
+			return nil, false
+		}
+		if attachment.MessageID != 0 {
+			c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "attachment " + id + " is already attached to a message", Ref: ref})
+			return nil, false
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, true
+}
+
+// attach links the checked attachments to the saved message. It replies with an error frame and returns false
+// if another message of the user took an attachment in the meantime, the message stays without attachments then.
+func (c *Client) attach(log *slog.Logger, ref string, messageID int64, attachments []storage.Attachment) bool {
+	if len(attachments) == 0 {
+		return true
+	}
+
+	ids := make([]int64, 0, len(attachments))
+	for _, attachment := range attachments {
+		ids = append(ids, attachment.ID)
+	}
+
+	err := c.hub.store.AttachToMessage(messageID, ids)
+	if errors.Is(err, storage.ErrAttachmentNotFound) {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "attachments are already attached to a message", Ref: ref})
+		return false
+	}
+	if err != nil {
+		log.Error("failed to attach files", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to attach files", Ref: ref})
+		return false
+	}
+
+	for i := range attachments {
+		attachments[i].MessageID = messageID
+	}
+
+	return true
+}
+
+// attachmentIDs returns the IDs of the attachments as sent by the clients.
+func attachmentIDs(attachments []storage.Attachment) []string {
+	var ids []string
+	for _, attachment := range attachments {
+		ids = append(ids, strconv.FormatInt(attachment.ID, 10))
+	}
+
+	return ids
+}
@@ -1,10 +1,11 @@
 package ws
 
 import (
-	"bytes"
 	"log/slog"
 	"net/http"
 	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/lib/ratelimit"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
@@ -22,12 +23,10 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer.
-	maxMessageSize = 512
-)
+	maxMessageSize = 4096
 
-var (
-	newline = []byte{'\n'}
-	space   = []byte{' '}
+	// Frames queued for a client, a resuming client has room for the frames it missed on top.
+	sendBufferSize = 256
 )
 
 var upgrader = websocket.Upgrader{
@@ -45,8 +44,79 @@ type Client struct {
 	// The websocket connection.
 	conn *websocket.Conn
 
-	// Buffered channel of outbound messages.
-	send chan []byte
+	// Buffered channel of outbound messages. Written and closed by the shard of the client.
+	send chan outbound
+
+	// Shard queuing the frames for the client, assigned when the hub registers the client.
+	shard *shard
+
+	// Set while the shard drops frames of the client, with the frames dropped by class since the client last kept up,
+	// logged when it keeps up again or is disconnected. Owned by the shard goroutine.
+	shedding bool
+	shed     [classCount]int
+
+	// ID of the upgrade request, for the logs.
+	requestID string
+
+	// Set for a client resuming the session of its user, with the sequence number of the last frame it saw
+	// and the ID of the last chat message it got, which the messages missed before the log of the shard follow.
+	resuming    bool
+	resumeFrom  int64
+	resumeAfter int64
+
+	// Rooms of the session a resuming client is back in, sent once by the hub, see Hub.rejoinRooms.
+	rejoined chan []string
+
+	// Missed messages of a resuming client loaded from the storage, not queued again when the hub routes them.
+	// Owned by the shard goroutine.
+	replayed map[int64]bool
+
+	// Set while the missed messages of a resuming client are loaded, the frames queued meanwhile are held.
+	// Owned by the shard goroutine.
+	catchingUp bool
+	held       []outbound
+
+	// ID of the authenticated user who opened the connection.
+	userID int64
+
+	// Rooms the client joined. Owned by the hub goroutine.
+	rooms map[string]bool
+
+	// Rooms of the threads the client subscribed to, by parent message ID. Owned by the hub goroutine.
+	threads map[int64]string
+
+	// Presence status set by the client, online when it connects. Owned by the hub goroutine.
+	status string
+
+	// Rooms the client asked to join. Owned by the readPump goroutine.
+	joined map[string]bool
+
+	// Rate limit of the envelopes of the connection and its violations, see RateLimits. Owned by the readPump goroutine.
+	limiter         *ratelimit.Bucket
+	violations      int
+	violationsSince time.Time
+
+	// Set once the client asked the hub to close the connection, the envelopes read after are ignored.
+	// Owned by the readPump goroutine.
+	closing bool
+
+	// Close frame sent when the shard closes the send channel. Set by the shard before closing the channel.
+	closeCode   int
+	closeReason string
+}
+
+// outbound is a frame queued for the client.
+type outbound struct {
+	data []byte
+
+	// Set for chat messages of other users, writePump reports their delivery to the sender.
+	delivery *delivery
+
+	// Decides what happens to the frame when the send buffer is full, see SlowConsumerPolicies.
+	class MessageClass
+
+	// Sequence number of the frame in the log of the shard, 0 if it isn't replayed to resuming clients, see session.
+	seq int64
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -60,24 +130,53 @@ func (c *Client) readPump(log *slog.Logger) {
 	log = log.With(
 		slog.String("op", op), // add request.id middleware later
 	)
-	
+
 	defer func() {
-		c.hub.unregister <- c
+		enqueue(c.hub, c.hub.unregister, c)
 		c.conn.Close()
+		c.hub.pumps.Done()
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	c.awaitRooms()
+	c.deliverPending(log)
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Error("Failed to extract token from request", sl.Err(err))
+				log.Error("unexpected close of websocket connection", sl.Err(err))
 			}
 			break
 		}
-		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		c.hub.broadcast <- message
+		if c.closing || !c.allow(log) {
+			continue
+		}
+
+		env, errPayload := parseEnvelope(message)
+		if errPayload != nil {
+			log.Info("rejected invalid envelope", slog.String("code", errPayload.Code), slog.String("error", errPayload.Message))
+			c.replyError(*errPayload)
+			continue
+		}
+
+		c.dispatch(log, env)
+	}
+}
+
+// awaitRooms waits until the hub put a resuming client back in the rooms of its session,
+// so the envelopes the client sends to them aren't rejected.
+func (c *Client) awaitRooms() {
+	if !c.resuming {
+		return
+	}
+
+	select {
+	case rooms := <-c.rejoined:
+		for _, room := range rooms {
+			c.joined[room] = true
+		}
+	case <-c.hub.done:
 	}
 }
 
@@ -99,33 +198,35 @@ func (c *Client) writePump(log *slog.Logger) {
 		log.Info("ticker stopped")
 		c.conn.Close()
 		log.Info("connection closed")
-		}()
+		c.hub.pumps.Done()
+	}()
 	for {
 		select {
 		case message, ok := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
-				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				// The shard closed the channel.
+				if c.closeCode != 0 {
+					c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason))
+				} else {
+					c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				}
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-			log.Info("message written: ", slog.String("message: ", string(message)))
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.send)
+			data := message.data
+			if message.seq != 0 {
+				data = withSeq(data, message.seq)
 			}
 
-			if err := w.Close(); err != nil {
+			// Every envelope is written in its own frame so that clients always receive valid JSON.
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Error("failed to write message", sl.Err(err))
 				return
 			}
+			if message.delivery != nil {
+				c.reportDelivery(message.delivery)
+			}
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -140,30 +241,83 @@ func ServeWs(log *slog.Logger, hub *Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "websocket.handlers.client.ServeWs"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
-		
+
 		// Check for JWT token before upgrading
-		userID := r.Context().Value("userID")
-		log.Info("extracted userID in ServeWs", slog.Any("userID", userID))
-		
+		subject, _ := r.Context().Value("userID").(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			log.Error("userID is missing in request context", sl.Err(err))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		log.Info("extracted userID in ServeWs", slog.Int64("userID", userID))
+
+		// a client resuming its session presents the sequence number of the last frame it saw
+		// and the ID of the last chat message it got
+		var resumeFrom, resumeAfter int64
+		resuming := r.URL.Query().Has("resume")
+		if resuming {
+			resumeFrom, err = strconv.ParseInt(r.URL.Query().Get("resume"), 10, 64)
+			if err != nil || resumeFrom < 0 {
+				log.Info("invalid resume sequence number", slog.String("resume", r.URL.Query().Get("resume")))
+				http.Error(w, "invalid resume sequence number", http.StatusBadRequest)
+				return
+			}
+			resumeAfter, err = strconv.ParseInt(r.URL.Query().Get("last_message"), 10, 64)
+			if err != nil || resumeAfter < 0 {
+				log.Info("invalid last message ID", slog.String("last_message", r.URL.Query().Get("last_message")))
+				http.Error(w, "invalid last message ID", http.StatusBadRequest)
+				return
+			}
+		}
+		sendSize := sendBufferSize
+		if resuming {
+			sendSize += replaySize + missedLimit
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Error("Failed to upgrade http connection to websocket", sl.Err(err))
 			return
 		}
-		
+
 		log.Info("upgraded HTTP connection to Websocket")
 
-		client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256)}
-		client.hub.register <- client
+		client := &Client{
+			hub:       hub,
+			conn:      conn,
+			send:      make(chan outbound, sendSize),
+			userID:    userID,
+			rooms:     make(map[string]bool),
+			threads:   make(map[int64]string),
+			status:    StatusOnline,
+			joined:    make(map[string]bool),
+			limiter:   ratelimit.NewBucket(hub.limits.Connection, time.Now()),
+			requestID: middleware.GetReqID(r.Context()),
+
+			resuming:    resuming,
+			resumeFrom:  resumeFrom,
+			resumeAfter: resumeAfter,
+			rejoined:    make(chan []string, 1),
+		}
+		// pumps are counted before registering, so Wait can't miss a client registered while the hub stops
+		hub.pumps.Add(2)
+		if !enqueue(hub, hub.register, client) {
+			log.Info("hub stopped, closing connection")
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+			conn.Close()
+			hub.pumps.Add(-2)
+			return
+		}
 
 		// Allow collection of memory referenced by the caller by doing all work in
 		// new goroutines.
 		go client.writePump(log)
 		go client.readPump(log)
 	}
-	
-}
\ No newline at end of file
+
+}
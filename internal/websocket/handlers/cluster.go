@@ -0,0 +1,257 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// hubTopic is the broker topic the hubs of every instance relay their events through.
+const hubTopic = "hub"
+
+// heartbeatInterval is how often a hub shares the statuses of its users. The other hubs forget
+// the statuses of an instance not heard from for three intervals, e.g. when it crashed.
+const heartbeatInterval = 10 * time.Second
+
+// outboxSize is the number of events waiting to be published before the hub drops them.
+const outboxSize = 1024
+
+// Kinds of the cluster events.
+const (
+	eventMessage    = "message"
+	eventDirect     = "direct"
+	eventReply      = "reply"
+	eventUpdate     = "update"
+	eventReceipt    = "receipt"
+	eventMention    = "mention"
+	eventTyping     = "typing"
+	eventPresence   = "presence"
+	eventAnnounce   = "announce"
+	eventDisconnect = "disconnect"
+	eventNode       = "node"
+	eventBye        = "bye"
+)
+
+// clusterEvent is a delivery the hub relays to the hubs of the other instances, which deliver it to their clients.
+// Every hub keeps the rooms, threads and connections of its own clients only, the hub of the sender
+// checks the request and the hubs of the recipients deliver the encoded frames.
+type clusterEvent struct {
+	Kind string `json:"kind"`
+	Node string `json:"node"` // Instance that published the event
+
+	Room      string  `json:"room,omitempty"`
+	UserID    int64   `json:"userId,omitempty"` // Sender of a message, or the user the event is about
+	Recipient int64   `json:"recipient,omitempty"`
+	UserIDs   []int64 `json:"userIds,omitempty"` // Recipients of receipts and mentions
+	MessageID int64   `json:"messageId,omitempty"`
+	ParentID  int64   `json:"parentId,omitempty"`
+
+	// Status of the user's connections to the publishing instance, and the rooms they joined.
+	Status string   `json:"status,omitempty"`
+	Rooms  []string `json:"rooms,omitempty"`
+
+	// Statuses of every user connected to the publishing instance.
+	Statuses map[int64]string `json:"statuses,omitempty"`
+
+	Data    json.RawMessage `json:"data,omitempty"`    // Encoded frame for the clients
+	Summary json.RawMessage `json:"summary,omitempty"` // Thread summary frame of a reply
+}
+
+// remoteNode is another instance, known from its events.
+type remoteNode struct {
+	statuses map[int64]string
+	seen     time.Time
+}
+
+// newNodeID returns a random ID of the instance.
+func newNodeID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id) // crypto/rand doesn't fail on supported platforms
+
+	return hex.EncodeToString(id)
+}
+
+// connectBroker subscribes to the events of the other instances and starts publishing the events of the hub.
+// Without the subscription the hub keeps serving its own clients.
+func (h *Hub) connectBroker(ctx context.Context) {
+	events, err := h.broker.Subscribe(ctx, hubTopic)
+	if err != nil {
+		h.log.Error("failed to subscribe to broker, events of other instances won't be delivered", sl.Err(err))
+	} else {
+		go h.receiveEvents(events)
+	}
+
+	go h.relay(ctx)
+}
+
+// receiveEvents passes the events of the other instances to the hub.
+func (h *Hub) receiveEvents(events <-chan []byte) {
+	for data := range events {
+		var e clusterEvent
+		if err := json.Unmarshal(data, &e); err != nil {
+			h.log.Error("failed to decode cluster event", sl.Err(err))
+			continue
+		}
+		if e.Node == h.node {
+			continue
+		}
+
+		if !enqueue(h, h.remote, e) {
+			return
+		}
+	}
+}
+
+// relay encodes and publishes the events of the hub in order, so neither the encoding nor a slow broker blocks the hub.
+func (h *Hub) relay(ctx context.Context) {
+	for {
+		select {
+		case e := <-h.outbox:
+			data, err := json.Marshal(e)
+			if err != nil {
+				h.log.Error("failed to encode cluster event", sl.Err(err))
+				continue
+			}
+			if err := h.broker.Publish(ctx, hubTopic, data); err != nil && ctx.Err() == nil {
+				h.log.Error("failed to publish cluster event", sl.Err(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publish queues the event for the other instances, it is dropped if the outbox is full.
+func (h *Hub) publish(e clusterEvent) {
+	e.Node = h.node
+
+	select {
+	case h.outbox <- e:
+	default:
+		h.log.Error("broker outbox is full, dropping cluster event", slog.String("kind", e.Kind))
+	}
+}
+
+// publishBye tells the other instances to forget the statuses of the hub's users. Called by stop,
+// when the relay goroutine has returned.
+func (h *Hub) publishBye() {
+	data, _ := json.Marshal(clusterEvent{Kind: eventBye, Node: h.node}) // marshaling of strings can't fail
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := h.broker.Publish(ctx, hubTopic, data); err != nil {
+		h.log.Error("failed to publish bye", sl.Err(err))
+	}
+}
+
+// heartbeat shares the statuses of the hub's users and forgets the instances that went silent.
+func (h *Hub) heartbeat(now time.Time) {
+	statuses := make(map[int64]string, len(h.users))
+	for userID := range h.users {
+		statuses[userID] = h.localStatus(userID)
+	}
+	h.publish(clusterEvent{Kind: eventNode, Statuses: statuses})
+
+	for id, node := range h.nodes {
+		if now.Sub(node.seen) > 3*h.heartbeatInterval {
+			h.log.Warn("forgetting silent instance", slog.String("node", id))
+			delete(h.nodes, id)
+		}
+	}
+}
+
+// handleEvent delivers the event of another instance to the clients of the hub.
+func (h *Hub) handleEvent(e clusterEvent) {
+	node := h.remoteNode(e.Node)
+
+	switch e.Kind {
+	case eventMessage:
+		h.deliverRoomMessage(e.Room, e.UserID, e.MessageID, e.Data)
+	case eventDirect:
+		// contacts are kept for the hub's own users only
+		if len(h.users[e.UserID]) > 0 || len(h.users[e.Recipient]) > 0 {
+			h.addContact(e.UserID, e.Recipient)
+		}
+		h.deliverDirectMessage(e.Room, e.UserID, e.Recipient, e.MessageID, e.Data)
+	case eventReply:
+		h.deliverThreadReply(e.Room, e.ParentID, e.UserID, e.MessageID, e.Data, e.Summary)
+	case eventUpdate:
+		h.deliverUpdate(messageUpdate{room: e.Room, data: e.Data})
+	case eventReceipt:
+		h.deliverReceipt(receipt{userIDs: e.UserIDs, data: e.Data})
+	case eventMention:
+		h.deliverMention(mentionNotice{userIDs: e.UserIDs, data: e.Data})
+	case eventTyping:
+		h.deliverTyping(typingKey{userID: e.UserID, room: e.Room}, e.Data)
+	case eventPresence:
+		if e.Status == StatusOffline {
+			delete(node.statuses, e.UserID)
+		} else {
+			node.statuses[e.UserID] = e.Status
+		}
+		if len(e.Data) > 0 {
+			// the shards skip the rooms without members here and send the frame once to a member of several rooms
+			audience := h.presenceAudience(e.UserID, nil)
+			audience.rooms = append(audience.rooms, e.Rooms...)
+			h.deliverPresence(e.Data, audience)
+		}
+	case eventAnnounce:
+		h.fanOut(e.Room, outbound{data: e.Data}, e.UserID)
+	case eventDisconnect:
+		for client := range h.users[e.UserID] {
+			h.closeClient(client, websocket.CloseNormalClosure, "logged out")
+		}
+	case eventNode:
+		node.statuses = e.Statuses
+		if node.statuses == nil {
+			node.statuses = make(map[int64]string)
+		}
+	case eventBye:
+		delete(h.nodes, e.Node)
+	}
+}
+
+// remoteNode returns the instance and records that it was heard from.
+func (h *Hub) remoteNode(id string) *remoteNode {
+	node, ok := h.nodes[id]
+	if !ok {
+		node = &remoteNode{statuses: make(map[int64]string)}
+		h.nodes[id] = node
+	}
+	node.seen = time.Now()
+
+	return node
+}
+
+// userRooms returns the rooms joined by any connection of the user to this instance.
+func (h *Hub) userRooms(userID int64) []string {
+	seen := make(map[string]bool)
+	var rooms []string
+	for client := range h.users[userID] {
+		for room := range client.rooms {
+			if !seen[room] {
+				seen[room] = true
+				rooms = append(rooms, room)
+			}
+		}
+	}
+
+	return rooms
+}
+
+// publishStatus shares the status of the user's connections to this instance, with the presence frame
+// for the audience of the other instances if the status of the user changed.
+func (h *Hub) publishStatus(userID int64, frame []byte, rooms []string) {
+	if h.stopping {
+		return
+	}
+
+	h.publish(clusterEvent{Kind: eventPresence, UserID: userID, Status: h.localStatus(userID), Rooms: rooms, Data: frame})
+}
@@ -0,0 +1,182 @@
+package ws
+
+import (
+	"encoding/json"
+	"log/slog"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+)
+
+// dispatch handles a valid envelope received from the client. It is called from the readPump goroutine.
+func (c *Client) dispatch(log *slog.Logger, env *Envelope) {
+	switch env.Type {
+	case TypeJoin:
+		c.joined[env.Room] = true
+		c.addRoomMember(log, env.Room)
+		enqueue(c.hub, c.hub.join, subscription{client: c, room: env.Room})
+	case TypeLeave:
+		delete(c.joined, env.Room)
+		enqueue(c.hub, c.hub.leave, subscription{client: c, room: env.Room})
+	case TypeMessage:
+		c.handleMessage(log, env)
+	case TypeDirect:
+		c.handleDirect(log, env)
+	case TypeTyping:
+		var payload TypingPayload
+		_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+		recipient, _ := env.recipient()           // 0 for rooms
+		enqueue(c.hub, c.hub.typingEvents, typingEvent{client: c, room: env.Room, recipient: recipient, state: payload.State})
+	case TypeRead:
+		c.handleRead(log, env)
+	case TypeEdit:
+		c.handleEdit(log, env)
+	case TypeDelete:
+		c.handleDelete(log, env)
+	case TypeReaction:
+		c.handleReaction(log, env)
+	case TypeSubscribe:
+		c.handleSubscribe(log, env)
+	case TypeUnsubscribe:
+		c.handleUnsubscribe(env)
+	case TypeStatus:
+		var payload StatusPayload
+		_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+		enqueue(c.hub, c.hub.status, statusChange{client: c, status: payload.Status})
+	}
+}
+
+// handleMessage persists a chat message and relays it to the members of the room.
+func (c *Client) handleMessage(log *slog.Logger, env *Envelope) {
+	ref := env.ID
+
+	if !c.joined[env.Room] {
+		c.replyError(ErrorPayload{Code: ErrCodeNotMember, Message: "join the room before sending messages to it", Ref: ref})
+		return
+	}
+
+	var payload MessagePayload
+	_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+	if payload.ParentID != "" {
+		c.handleReply(log, env, payload)
+		return
+	}
+
+	files, ok := c.checkAttachments(log, ref, env.Room, payload.Attachments)
+	if !ok {
+		return
+	}
+
+	env.stamp(strconv.FormatInt(c.userID, 10))
+
+	id, err := c.hub.store.SaveMessage(env.Room, c.userID, payload.Text, env.Timestamp)
+	if err != nil {
+		log.Error("failed to save message", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to save message", Ref: ref})
+		return
+	}
+	env.ID = strconv.FormatInt(id, 10)
+	if !c.attach(log, ref, id, files) {
+		return
+	}
+	env.Payload, _ = json.Marshal(MessagePayload{Text: payload.Text, Attachments: payload.Attachments, Files: files}) // the server sets the files
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Error("failed to encode envelope", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to process message", Ref: ref})
+		return
+	}
+
+	if !enqueue(c.hub, c.hub.broadcast, roomMessage{sender: c, room: env.Room, messageID: id, data: data}) {
+		return
+	}
+	c.notifyMentions(log, storage.Message{ID: id, Room: env.Room, SenderID: c.userID, Body: payload.Text, CreatedAt: env.Timestamp})
+}
+
+// handleDirect persists a direct message and relays it to the devices of the recipient and the sender.
+func (c *Client) handleDirect(log *slog.Logger, env *Envelope) {
+	ref := env.ID
+	recipient, _ := env.recipient() // validated by parseEnvelope
+
+	var payload MessagePayload
+	_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+
+	env.stamp(strconv.FormatInt(c.userID, 10))
+	env.Room = dm.Room(c.userID, recipient)
+
+	// checked before saving, so a message to nobody doesn't end up in the history of the sender
+	exists, err := c.hub.store.UserExists(recipient)
+	if err != nil {
+		log.Error("failed to check recipient", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to save message", Ref: ref})
+		return
+	}
+	if !exists {
+		c.replyError(ErrorPayload{Code: ErrCodeNoUser, Message: "recipient doesn't exist", Ref: ref})
+		return
+	}
+
+	files, ok := c.checkAttachments(log, ref, env.Room, payload.Attachments)
+	if !ok {
+		return
+	}
+
+	id, err := c.hub.store.SaveMessage(env.Room, c.userID, payload.Text, env.Timestamp)
+	if err != nil {
+		log.Error("failed to save direct message", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to save message", Ref: ref})
+		return
+	}
+	env.ID = strconv.FormatInt(id, 10)
+	if !c.attach(log, ref, id, files) {
+		return
+	}
+	env.Payload, _ = json.Marshal(MessagePayload{Text: payload.Text, Attachments: payload.Attachments, Files: files}) // the server sets the files
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Error("failed to encode envelope", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to process message", Ref: ref})
+		return
+	}
+
+	enqueue(c.hub, c.hub.direct, directMessage{sender: c, room: env.Room, recipient: recipient, messageID: id, ref: ref, data: data})
+}
+
+// deliverPending sends the direct messages that arrived while the user was offline.
+// It runs once the client is registered, so no message can fall between the queue and live delivery.
+func (c *Client) deliverPending(log *slog.Logger) {
+	// the hub queued the messages to the user before it registered the client, they are saved first
+	if !c.hub.flushWrites() {
+		return
+	}
+
+	messages, err := c.hub.store.TakePendingMessages(c.userID)
+	if err != nil {
+		log.Error("failed to load pending messages", sl.Err(err))
+		return
+	}
+
+	for _, message := range messages {
+		data, err := directEnvelope(message, c.userID)
+		if err != nil {
+			log.Error("failed to encode pending message", sl.Err(err))
+			continue
+		}
+		d := &delivery{room: message.Room, messageID: message.ID, senderID: message.SenderID}
+		if !enqueue(c.hub, c.hub.reply, clientMessage{client: c, data: data, delivery: d, class: ClassMessage}) {
+			return
+		}
+	}
+
+	if len(messages) > 0 {
+		log.Info("delivered pending messages", slog.Int("count", len(messages)))
+	}
+}
+
+// replyError sends an error frame to the client through the hub.
+func (c *Client) replyError(payload ErrorPayload) {
+	enqueue(c.hub, c.hub.reply, clientMessage{client: c, data: errorFrame(payload)})
+}
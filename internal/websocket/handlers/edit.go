@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"new-websocket-chat/internal/blob"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+	"time"
+)
+
+// EditPayload is the payload of an edit envelope. The server sends it back to the members of the room
+// with the sender of the envelope set to the user who edited the message.
+type EditPayload struct {
+	MessageID string `json:"messageId" validate:"required,numeric"` // ID of the edited message
+	Text      string `json:"text" validate:"required,max=2000"`     // New text of the message
+}
+
+// DeletePayload is the payload of a delete envelope. Deleted messages stay in the history as tombstones
+// without a body.
+type DeletePayload struct {
+	MessageID string `json:"messageId" validate:"required,numeric"` // ID of the deleted message
+}
+
+// messageUpdate is an encoded edit, delete or reaction envelope that has to be delivered to the members
+// of the room, or to both users of a direct room.
+type messageUpdate struct {
+	room string
+	data []byte
+}
+
+// handleEdit replaces the text of a message and tells the members of the room.
+func (c *Client) handleEdit(log *slog.Logger, env *Envelope) {
+	ref := env.ID
+
+	var payload EditPayload
+	_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+
+	message, ok := c.authorizeChange(log, env, payload.MessageID)
+	if !ok {
+		return
+	}
+
+	editedAt := time.Now().UTC()
+	err := c.hub.store.EditMessage(message.ID, c.userID, payload.Text, editedAt)
+	if errors.Is(err, storage.ErrMessageDeleted) {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "message is deleted", Ref: ref})
+		return
+	}
+	if err != nil {
+		log.Error("failed to edit message", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to edit message", Ref: ref})
+		return
+	}
+
+	data := updateFrame(TypeEdit, c.userID, message, editedAt, EditPayload{MessageID: payload.MessageID, Text: payload.Text})
+	if !enqueue(c.hub, c.hub.updates, messageUpdate{room: message.Room, data: data}) {
+		return
+	}
+	message.Body = payload.Text
+	c.notifyMentions(log, message)
+}
+
+// handleDelete replaces a message with a tombstone and tells the members of the room.
+func (c *Client) handleDelete(log *slog.Logger, env *Envelope) {
+	ref := env.ID
+
+	var payload DeletePayload
+	_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+
+	message, ok := c.authorizeChange(log, env, payload.MessageID)
+	if !ok {
+		return
+	}
+
+	deletedAt := time.Now().UTC()
+	attachments, err := c.hub.store.DeleteMessage(message.ID, deletedAt)
+	if errors.Is(err, storage.ErrMessageDeleted) {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "message is deleted", Ref: ref})
+		return
+	}
+	if err != nil {
+		log.Error("failed to delete message", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to delete message", Ref: ref})
+		return
+	}
+
+	// the files go with the message, nothing refers to them anymore
+	for _, attachment := range attachments {
+		c.hub.queueWrite(storeWrite{kind: writeBlobDelete, key: attachment.BlobKey})
+		if attachment.Thumbnail {
+			c.hub.queueWrite(storeWrite{kind: writeBlobDelete, key: blob.ThumbnailKey(attachment.BlobKey)})
+		}
+	}
+
+	data := updateFrame(TypeDelete, c.userID, message, deletedAt, DeletePayload{MessageID: payload.MessageID})
+	enqueue(c.hub, c.hub.updates, messageUpdate{room: message.Room, data: data})
+}
+
+// authorizeChange loads the message an edit or delete envelope refers to and checks that the user
+// is its author or a moderator of the room. It replies with an error frame and returns false otherwise.
+func (c *Client) authorizeChange(log *slog.Logger, env *Envelope, id string) (storage.Message, bool) {
+	ref := env.ID
+
+	message, ok := c.loadMessage(log, env, id)
+	if !ok {
+		return storage.Message{}, false
+	}
+
+	if message.SenderID == c.userID {
+		return message, true
+	}
+
+	moderator, err := c.hub.store.IsRoomModerator(env.Room, c.userID)
+	if err != nil {
+		log.Error("failed to check room moderator", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to load message", Ref: ref})
+		return storage.Message{}, false
+	}
+	if !moderator {
+		c.replyError(ErrorPayload{Code: ErrCodeForbidden, Message: "only the author or a moderator of the room can change the message", Ref: ref})
+		return storage.Message{}, false
+	}
+
+	return message, true
+}
+
+// loadMessage loads the message of the room the envelope refers to, if the user is a member of the room
+// and the message isn't deleted. It replies with an error frame and returns false otherwise.
+func (c *Client) loadMessage(log *slog.Logger, env *Envelope, id string) (storage.Message, bool) {
+	ref := env.ID
+
+	if dm.IsDirect(env.Room) {
+		if !dm.IsMember(env.Room, c.userID) {
+			c.replyError(ErrorPayload{Code: ErrCodeNotMember, Message: "direct messages can only be accessed by their users", Ref: ref})
+			return storage.Message{}, false
+		}
+	} else if !c.joined[env.Room] {
+		c.replyError(ErrorPayload{Code: ErrCodeNotMember, Message: "join the room before accessing its messages", Ref: ref})
+		return storage.Message{}, false
+	}
+
+	messageID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "field MessageID is not valid", Ref: ref})
+		return storage.Message{}, false
+	}
+
+	message, err := c.hub.store.GetMessage(messageID)
+	if errors.Is(err, storage.ErrMessageNotFound) || (err == nil && message.Room != env.Room) {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "message is not found in the room", Ref: ref})
+		return storage.Message{}, false
+	}
+	if err != nil {
+		log.Error("failed to get message", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to load message", Ref: ref})
+		return storage.Message{}, false
+	}
+	if message.DeletedAt != nil {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "message is deleted", Ref: ref})
+		return storage.Message{}, false
+	}
+
+	return message, true
+}
+
+// deliverUpdate sends the edit, delete or reaction envelope to the members of the room,
+// or to both users of a direct room.
+func (h *Hub) deliverUpdate(update messageUpdate) {
+	if first, second, ok := dm.Members(update.room); ok {
+		h.deliverUser(first, outbound{data: update.data})
+		if second != first {
+			h.deliverUser(second, outbound{data: update.data})
+		}
+		return
+	}
+
+	h.fanOut(update.room, outbound{data: update.data}, 0)
+}
+
+// updateFrame encodes an edit, delete or reaction envelope of the message sent by the user who changed it.
+func updateFrame(typ string, userID int64, message storage.Message, changedAt time.Time, payload any) []byte {
+	data, _ := json.Marshal(payload) // marshaling of a struct with string fields can't fail
+
+	frame, _ := json.Marshal(Envelope{
+		Type:      typ,
+		Room:      message.Room,
+		Sender:    strconv.FormatInt(userID, 10),
+		Timestamp: changedAt,
+		Payload:   data,
+	})
+
+	return frame
+}
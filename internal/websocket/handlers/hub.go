@@ -1,47 +1,650 @@
 package ws
 
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"new-websocket-chat/internal/blob"
+	"new-websocket-chat/internal/broker"
+	"new-websocket-chat/internal/lib/ratelimit"
+	"new-websocket-chat/internal/storage"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MessageStore persists the chat messages routed by the hub and the direct messages waiting for offline users.
+type MessageStore interface {
+	SaveMessage(room string, senderID int64, body string, createdAt time.Time) (int64, error)
+	GetMessage(messageID int64) (storage.Message, error)
+	SaveReply(parentID int64, senderID int64, body string, createdAt time.Time) (int64, storage.Message, error)
+	GetUserIDs(usernames []string) (map[string]int64, error)
+	UserExists(userID int64) (bool, error)
+	SaveMentions(messageID int64, userIDs []int64) ([]int64, error)
+	EditMessage(messageID int64, editorID int64, body string, editedAt time.Time) error
+	DeleteMessage(messageID int64, deletedAt time.Time) ([]storage.Attachment, error)
+	IsRoomModerator(room string, userID int64) (bool, error)
+	AddRoomMember(room string, userID int64) error
+	GetAttachment(attachmentID int64) (storage.Attachment, error)
+	AttachToMessage(messageID int64, attachmentIDs []int64) error
+	AddReaction(messageID int64, userID int64, emoji string) (bool, error)
+	RemoveReaction(messageID int64, userID int64, emoji string) (bool, error)
+	SavePendingMessage(userID int64, messageID int64) error
+	TakePendingMessages(userID int64) ([]storage.Message, error)
+	UpdateLastSeen(userID int64, lastSeen time.Time) error
+	UpdateDeliveredCursors(cursors []storage.DeliveredCursor) ([]storage.DeliveredCursor, error)
+	UpdateReadCursor(userID int64, room string, messageID int64) (int64, error)
+	GetMessageSenders(room string, afterID int64, upToID int64) ([]int64, error)
+	GetMissedMessages(userID int64, rooms []string, afterID int64, limit int) ([]storage.Message, error)
+}
+
+// Hub maintains the set of active clients and the rooms they joined, and
+// routes messages to the members of a room. Hubs of several instances relay
+// the messages to each other through a broker, see cluster.go.
+//
+// The hub goroutine only decides who gets a frame. The users are spread over
+// shards that queue the frames for their clients, see shard.go, so a message to
+// a room costs the hub one task per shard however many members the room has.
 type Hub struct {
+	log *slog.Logger
+
+	// Storage of the chat messages.
+	store MessageStore
+
+	// Contents of the attachments, deleted with their messages.
+	blobs blob.Store
+
 	// Registered clients.
 	clients map[*Client]bool
 
+	// Connections of every user that has at least one client registered.
+	users map[int64]map[*Client]bool
+
+	// Members of every room that has at least one client in it.
+	rooms map[string]map[*Client]bool
+
+	// Subscribers of every thread that has at least one, by parent message ID.
+	threads map[int64]map[*Client]bool
+
+	// Connected users that exchanged direct messages, by user.
+	contacts map[int64]map[int64]bool
+
+	// Shards writing to the send buffers of the clients, see shardOf.
+	shards []*shard
+
 	// Inbound messages from the clients.
-	broadcast chan []byte
+	broadcast chan roomMessage
 
 	// Register requests from the clients.
 	register chan *Client
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Join room requests from the clients.
+	join chan subscription
+
+	// Leave room requests from the clients.
+	leave chan subscription
+
+	// Direct messages from the clients.
+	direct chan directMessage
+
+	// Thread replies from the clients.
+	replies chan threadReply
+
+	// Subscribe to thread requests from the clients.
+	subscribe chan threadSubscription
+
+	// Unsubscribe from thread requests from the clients.
+	unsubscribe chan threadSubscription
+
+	// Frames addressed to a single client, e.g. errors.
+	reply chan clientMessage
+
+	// Delivered and read receipts for the senders of the messages.
+	receipts chan receipt
+
+	// Edits, deletions and reactions of the messages.
+	updates chan messageUpdate
+
+	// Mentions of the users in the messages.
+	mentions chan mentionNotice
+
+	// Typing events from the clients.
+	typingEvents chan typingEvent
+
+	// Expiration of the users that are typing. Never persisted.
+	typing map[typingKey]time.Time
+
+	// How long a user is typing without a refresh, typingTimeout unless changed by tests.
+	typingTimeout time.Duration
+
+	// Status changes requested by the clients.
+	status chan statusChange
+
+	// Queries of the users' statuses.
+	presence chan presenceQuery
+
+	// Requests to close every connection of a user.
+	disconnect chan int64
+
+	// Requests to close a single connection, e.g. of a client exceeding the rate limits.
+	kick chan clientClose
+
+	// Messages loaded from the storage for resuming clients, see loadMissed.
+	missed chan missedMessages
+
+	// Rooms of the sessions resumed by the clients, from the shards.
+	rejoin chan rejoin
+
+	// Rate limits of the envelopes received from the clients.
+	limits RateLimits
+
+	// What the shards do with the frames of clients that don't keep up, and how often they did it.
+	policies SlowConsumerPolicies
+	slow     slowCounters
+
+	// Buckets of the users for limits.User, shared by the readPump goroutines.
+	userLimiter *ratelimit.Keyed[int64]
+
+	// Broker relaying the events to the hubs of the other instances.
+	broker broker.Broker
+
+	// Random ID of the instance, the events it published itself are skipped.
+	node string
+
+	// Events of the other instances.
+	remote chan clusterEvent
+
+	// Events waiting to be published by the relay goroutine.
+	outbox chan clusterEvent
+
+	// Other instances and the statuses of their users, by instance ID.
+	nodes map[string]*remoteNode
+
+	// How often the hub shares the statuses of its users, heartbeatInterval unless changed by tests.
+	heartbeatInterval time.Duration
+
+	// Closed when the hub stops routing messages.
+	done chan struct{}
+
+	// Set while the hub closes the clients on stop, presence changes are not sent then.
+	stopping bool
+
+	// Clients that were connected when the hub stopped. Written by Run before closing done.
+	stopped []*Client
+
+	// Running readPump and writePump goroutines.
+	pumps sync.WaitGroup
+
+	// Storage writes waiting for persist. Wait closes stopWrites once the pumps returned,
+	// persist closes persisted when it made the remaining writes.
+	writes     chan storeWrite
+	stopWrites chan struct{}
+	persisted  chan struct{}
+}
+
+// subscription is a request of a client to join or leave a room.
+type subscription struct {
+	client *Client
+	room   string
+}
+
+// clientMessage is an encoded frame that has to be delivered to a single client.
+type clientMessage struct {
+	client   *Client
+	data     []byte
+	delivery *delivery // set for chat messages, see outbound
+	class    MessageClass
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
+// directMessage is an encoded direct message that has to be delivered to every connection
+// of the recipient and of the sender.
+type directMessage struct {
+	sender    *Client
+	room      string
+	recipient int64
+	messageID int64
+	ref       string
+	data      []byte
+}
+
+// roomMessage is an encoded message that has to be delivered to every member of a room.
+type roomMessage struct {
+	sender    *Client
+	room      string
+	messageID int64
+	data      []byte
+}
+
+func NewHub(log *slog.Logger, store MessageStore, blobs blob.Store, limits RateLimits, policies SlowConsumerPolicies, broker broker.Broker) *Hub {
+	h := &Hub{
+		log:           log.With(slog.String("op", "websocket.handlers.hub")),
+		store:         store,
+		blobs:         blobs,
+		broker:        broker,
+		node:          newNodeID(),
+		remote:        make(chan clusterEvent),
+		outbox:        make(chan clusterEvent, outboxSize),
+		nodes:         make(map[string]*remoteNode),
+		broadcast:     make(chan roomMessage),
+		direct:        make(chan directMessage),
+		replies:       make(chan threadReply),
+		subscribe:     make(chan threadSubscription),
+		unsubscribe:   make(chan threadSubscription),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		join:          make(chan subscription),
+		leave:         make(chan subscription),
+		reply:         make(chan clientMessage),
+		status:        make(chan statusChange),
+		receipts:      make(chan receipt),
+		updates:       make(chan messageUpdate),
+		mentions:      make(chan mentionNotice),
+		typingEvents:  make(chan typingEvent),
+		presence:      make(chan presenceQuery),
+		disconnect:    make(chan int64),
+		kick:          make(chan clientClose),
+		missed:        make(chan missedMessages),
+		rejoin:        make(chan rejoin),
+		limits:        limits,
+		policies:      policies,
+		userLimiter:   ratelimit.NewKeyed[int64](limits.User),
+		done:          make(chan struct{}),
+		clients:       make(map[*Client]bool),
+		users:         make(map[int64]map[*Client]bool),
+		rooms:         make(map[string]map[*Client]bool),
+		threads:       make(map[int64]map[*Client]bool),
+		contacts:      make(map[int64]map[int64]bool),
+		typing:        make(map[typingKey]time.Time),
+		typingTimeout: typingTimeout,
+		writes:        make(chan storeWrite, writesSize),
+		stopWrites:    make(chan struct{}),
+		persisted:     make(chan struct{}),
+
+		heartbeatInterval: heartbeatInterval,
 	}
+
+	h.shards = make([]*shard, runtime.GOMAXPROCS(0))
+	for i := range h.shards {
+		h.shards[i] = newShard(h)
+	}
+
+	return h
 }
 
-func (h *Hub) Run() {
+// Run routes messages until ctx is cancelled. Then it sends a going away close frame
+// to every client and returns, use Wait to let the clients drain their send buffers.
+func (h *Hub) Run(ctx context.Context) {
+	for _, s := range h.shards {
+		go s.run()
+	}
+	go h.persist()
+
+	typingTicker := time.NewTicker(h.typingTimeout / 5)
+	defer typingTicker.Stop()
+
+	h.connectBroker(ctx)
+	heartbeatTicker := time.NewTicker(h.heartbeatInterval)
+	defer heartbeatTicker.Stop()
+
 	for {
 		select {
+		case <-ctx.Done():
+			h.stop()
+			return
 		case client := <-h.register:
-			h.clients[client] = true
+			h.addClient(client)
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+				h.removeClient(client)
+			}
+		case userID := <-h.disconnect:
+			for client := range h.users[userID] {
+				h.closeClient(client, websocket.CloseNormalClosure, "logged out")
+			}
+			h.publish(clusterEvent{Kind: eventDisconnect, UserID: userID})
+		case e := <-h.remote:
+			h.handleEvent(e)
+		case now := <-heartbeatTicker.C:
+			h.heartbeat(now)
+			for _, s := range h.shards {
+				s.tasks <- shardTask{kind: taskExpire, now: now}
+			}
+		case m := <-h.missed:
+			if _, ok := h.clients[m.client]; ok {
+				m.client.shard.tasks <- shardTask{kind: taskMissed, client: m.client, missed: m.frames, complete: m.complete}
 			}
+		case r := <-h.rejoin:
+			h.rejoinRooms(r)
+		case c := <-h.kick:
+			if _, ok := h.clients[c.client]; ok {
+				h.closeClient(c.client, c.code, c.reason)
+			}
+		case event := <-h.typingEvents:
+			h.handleTyping(event)
+		case now := <-typingTicker.C:
+			h.expireTyping(now)
+		case change := <-h.status:
+			h.setStatus(change.client, change.status)
+		case query := <-h.presence:
+			h.answerPresence(query)
+		case s := <-h.join:
+			h.joinRoom(s.client, s.room)
+		case s := <-h.leave:
+			h.leaveRoom(s.client, s.room)
+		case s := <-h.subscribe:
+			h.subscribeThread(s)
+		case s := <-h.unsubscribe:
+			h.unsubscribeThread(s.client, s.parentID)
+		case message := <-h.reply:
+			if _, ok := h.clients[message.client]; ok {
+				h.push(message.client, outbound{data: message.data, delivery: message.delivery, class: message.class})
+			}
+		case r := <-h.receipts:
+			h.deliverReceipt(r)
+			h.publish(clusterEvent{Kind: eventReceipt, UserIDs: r.userIDs, Data: r.data})
+		case update := <-h.updates:
+			h.deliverUpdate(update)
+			h.publish(clusterEvent{Kind: eventUpdate, Room: update.room, Data: update.data})
+		case notice := <-h.mentions:
+			h.deliverMention(notice)
+			h.publish(clusterEvent{Kind: eventMention, UserIDs: notice.userIDs, Data: notice.data})
 		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+			members, ok := h.rooms[message.room]
+			if !ok || !members[message.sender] {
+				// Only members of a room can send messages to it.
+				if _, ok := h.clients[message.sender]; ok {
+					h.deliver(message.sender, errorFrame(ErrorPayload{
+						Code:    ErrCodeNotMember,
+						Message: "join the room before sending messages to it",
+					}))
 				}
+				continue
 			}
+			h.clearTyping(message.sender.userID, message.room)
+			h.deliverRoomMessage(message.room, message.sender.userID, message.messageID, message.data)
+			h.publish(clusterEvent{
+				Kind:      eventMessage,
+				Room:      message.room,
+				UserID:    message.sender.userID,
+				MessageID: message.messageID,
+				Data:      message.data,
+			})
+		case message := <-h.direct:
+			h.deliverDirect(message)
+		case reply := <-h.replies:
+			h.deliverReply(reply)
+		}
+	}
+}
+
+// deliver queues an event frame for the client, see ClassEvent.
+func (h *Hub) deliver(client *Client, data []byte) {
+	h.push(client, outbound{data: data})
+}
+
+// push is deliver for frames that carry more than the data. The frame isn't replayed to resuming clients.
+func (h *Hub) push(client *Client, frame outbound) {
+	client.shard.tasks <- shardTask{kind: taskDeliver, client: client, frame: frame}
+}
+
+// deliverUser queues the frame for every connection of the user to this instance, under a single sequence number.
+func (h *Hub) deliverUser(userID int64, frame outbound) {
+	if len(h.users[userID]) == 0 {
+		return
+	}
+
+	h.shardOf(userID).tasks <- shardTask{kind: taskUser, userID: userID, frame: frame}
+}
+
+// fanOut queues the frame for the members of the room connected to this instance, except the connections
+// of the excluded user. Members sending a chat message get it without the delivery, see outbound.
+// The shards log the frame for resuming the sessions of the users that were in the room, see session.go.
+func (h *Hub) fanOut(room string, frame outbound, exclude int64) {
+	for _, s := range h.shards {
+		s.tasks <- shardTask{kind: taskRoom, room: room, frame: frame, exclude: exclude}
+	}
+}
+
+// deliverRoomMessage sends the message to the members of the room connected to this instance.
+func (h *Hub) deliverRoomMessage(room string, senderID int64, messageID int64, data []byte) {
+	d := &delivery{room: room, messageID: messageID, senderID: senderID}
+	h.fanOut(room, outbound{data: data, delivery: d, class: ClassMessage}, 0)
+}
+
+// deliverDirect sends the direct message to every device of the recipient and of the sender, on any instance.
+// When the recipient isn't connected the message is queued until their next connection.
+func (h *Hub) deliverDirect(message directMessage) {
+	online := h.userStatus(message.recipient) != StatusOffline
+	h.addContact(message.sender.userID, message.recipient)
+	h.clearTyping(message.sender.userID, message.room)
+
+	h.deliverDirectMessage(message.room, message.sender.userID, message.recipient, message.messageID, message.data)
+	h.publish(clusterEvent{
+		Kind:      eventDirect,
+		Room:      message.room,
+		UserID:    message.sender.userID,
+		Recipient: message.recipient,
+		MessageID: message.messageID,
+		Data:      message.data,
+	})
+
+	if online {
+		return
+	}
+
+	// queued here rather than by the sender, so a recipient connecting at the same time can't miss the message,
+	// see deliverPending
+	h.queueWrite(storeWrite{kind: writePending, userID: message.recipient, messageID: message.messageID, client: message.sender, ref: message.ref})
+}
+
+// deliverDirectMessage sends the direct message to the devices of the recipient and of the sender connected to this instance.
+func (h *Hub) deliverDirectMessage(room string, senderID int64, recipient int64, messageID int64, data []byte) {
+	frame := outbound{data: data, delivery: &delivery{room: room, messageID: messageID, senderID: senderID}, class: ClassMessage}
+	h.deliverUser(recipient, frame)
+	if recipient != senderID {
+		h.deliverUser(senderID, frame)
+	}
+}
+
+func (h *Hub) joinRoom(client *Client, room string) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	members, ok := h.rooms[room]
+	if !ok {
+		members = make(map[*Client]bool)
+		h.rooms[room] = members
+	}
+
+	// the members learn the user is around when the first device of the user joins
+	present := false
+	for member := range members {
+		if member.userID == client.userID {
+			present = true
+			break
+		}
+	}
+
+	members[client] = true
+	client.rooms[room] = true
+	client.shard.tasks <- shardTask{kind: taskJoin, client: client, room: room}
+
+	if !present && !h.stopping {
+		frame := presenceFrame(client.userID, h.userStatus(client.userID), nil)
+		h.fanOut(room, outbound{data: frame}, client.userID)
+		h.publish(clusterEvent{Kind: eventAnnounce, UserID: client.userID, Room: room, Data: frame})
+	}
+}
+
+// rejoinRooms puts a resuming client back in the rooms of its session, then lets its readPump handle the envelopes.
+func (h *Hub) rejoinRooms(r rejoin) {
+	for _, room := range r.rooms {
+		h.joinRoom(r.client, room)
+	}
+
+	r.client.rejoined <- r.rooms
+}
+
+// leaveRoom removes the client from the room on its request, the session of the user doesn't keep the room.
+func (h *Hub) leaveRoom(client *Client, room string) {
+	client.shard.tasks <- shardTask{kind: taskLeave, client: client, room: room}
+	h.removeMember(client, room)
+}
+
+// removeMember removes the client from the room and from the threads of the room it subscribed to.
+func (h *Hub) removeMember(client *Client, room string) {
+	members, ok := h.rooms[room]
+	if !ok {
+		return
+	}
+
+	delete(members, client)
+	delete(client.rooms, room)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+
+	for parentID, threadRoom := range client.threads {
+		if threadRoom == room {
+			h.unsubscribeThread(client, parentID)
+		}
+	}
+}
+
+// DisconnectUser closes every websocket connection of the user.
+func (h *Hub) DisconnectUser(userID int64) {
+	enqueue(h, h.disconnect, userID)
+}
+
+// Wait blocks until every client wrote its queued messages and the close frame, its pumps returned
+// and the storage writes of the hub are made. When ctx is done before that, the remaining connections
+// are closed without draining.
+func (h *Hub) Wait(ctx context.Context) error {
+	const op = "websocket.handlers.hub.Wait"
+
+	<-h.done
+
+	drained := make(chan struct{})
+	go func() {
+		h.pumps.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		for _, client := range h.stopped {
+			client.conn.Close()
 		}
+		// closed connections make the pumps return right away, readPump only finishes the message it is saving
+		<-drained
+
+		err = fmt.Errorf("%s: %w", op, ctx.Err())
+	}
+
+	close(h.stopWrites)
+	<-h.persisted
+
+	return err
+}
+
+// stop closes every client with a going away close frame and stops accepting requests.
+func (h *Hub) stop() {
+	h.stopping = true
+	for client := range h.clients {
+		h.stopped = append(h.stopped, client)
+		h.closeClient(client, websocket.CloseGoingAway, "server shutting down")
+	}
+	h.publishBye()
+	close(h.done)
+
+	// the shards return once they wrote the close frames to the send buffers
+	for _, s := range h.shards {
+		close(s.tasks)
+	}
+}
+
+// shardOf returns the shard of the user, which serves every connection of the user.
+func (h *Hub) shardOf(userID int64) *shard {
+	return h.shards[uint64(userID)%uint64(len(h.shards))]
+}
+
+// enqueue sends the request to the hub. It returns false without blocking if the hub has stopped.
+func enqueue[T any](h *Hub, ch chan<- T, request T) bool {
+	select {
+	case ch <- request:
+		return true
+	case <-h.done:
+		return false
 	}
-}
\ No newline at end of file
+}
+
+func (h *Hub) addClient(client *Client) {
+	before := h.userStatus(client.userID)
+
+	h.clients[client] = true
+	client.shard = h.shardOf(client.userID)
+	client.shard.tasks <- shardTask{kind: taskAdd, client: client}
+
+	connections, ok := h.users[client.userID]
+	if !ok {
+		connections = make(map[*Client]bool)
+		h.users[client.userID] = connections
+	}
+	connections[client] = true
+
+	// the new connection knows it is online, the other devices and contacts of the user don't
+	audience := h.presenceAudience(client.userID, client)
+	var frame []byte
+	if after := h.userStatus(client.userID); after != before {
+		frame = h.notifyPresence(client.userID, after, nil, audience)
+	}
+	h.publishStatus(client.userID, frame, audience.rooms)
+}
+
+// removeClient drops the client from every room it joined and makes its shard close its send channel.
+func (h *Hub) removeClient(client *Client) {
+	h.closeClient(client, 0, "")
+}
+
+// closeClient removes the client and makes its writePump send a close frame with the code and reason.
+func (h *Hub) closeClient(client *Client, code int, reason string) {
+	before := h.userStatus(client.userID)
+	// taken before the connection leaves its rooms and the contacts of the user are forgotten
+	audience := h.presenceAudience(client.userID, client)
+
+	// the shard keeps the rooms for resuming the session
+	for room := range client.rooms {
+		h.removeMember(client, room)
+	}
+
+	delete(h.clients, client)
+	if connections, ok := h.users[client.userID]; ok {
+		delete(connections, client)
+		if len(connections) == 0 {
+			delete(h.users, client.userID)
+			h.removeContacts(client.userID)
+		}
+	}
+
+	client.shard.tasks <- shardTask{kind: taskRemove, client: client, closeCode: code, closeReason: reason}
+
+	after := h.userStatus(client.userID)
+	var lastSeen *time.Time
+	if after == StatusOffline {
+		t := h.wentOffline(client.userID)
+		lastSeen = &t
+	}
+	var frame []byte
+	if after != before {
+		frame = h.notifyPresence(client.userID, after, lastSeen, audience)
+	}
+	h.publishStatus(client.userID, frame, audience.rooms)
+}
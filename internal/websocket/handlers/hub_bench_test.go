@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"context"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/storage/memory"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	brokerMemory "new-websocket-chat/internal/broker/memory"
+)
+
+// benchConnections is the number of simulated connections of the hub benchmarks.
+const benchConnections = 10000
+
+// BenchmarkHubBroadcast sends messages to 100 rooms of 100 members each from concurrent senders,
+// like the readPumps of 10k connections do. frames/s counts the frames queued for the connections.
+func BenchmarkHubBroadcast(b *testing.B) {
+	benchmarkBroadcast(b, benchConnections/100, benchConnections)
+}
+
+// BenchmarkHubBroadcastBusyRoom sends messages to a single room every connection joined. The connections
+// belong to 100 users, so that the presence announcements of the joins don't overflow the send buffers.
+func BenchmarkHubBroadcastBusyRoom(b *testing.B) {
+	benchmarkBroadcast(b, 1, 100)
+}
+
+func benchmarkBroadcast(b *testing.B, rooms int, users int) {
+	hub := NewHub(slogdiscard.NewDiscardLogger(), memory.New(), newTestBlobs(b), RateLimits{}, SlowConsumerPolicies{}, brokerMemory.New())
+	ctx, stop := context.WithCancel(context.Background())
+	go hub.Run(ctx)
+
+	// the next round of the benchmark starts once every connection of this one is closed,
+	// closing them mustn't be measured with its messages
+	var connections sync.WaitGroup
+	defer func() {
+		stop()
+		connections.Wait()
+	}()
+
+	names := make([]string, rooms)
+	for i := range names {
+		names[i] = "room-" + strconv.Itoa(i)
+	}
+
+	var delivered atomic.Int64
+	clients := make([]*Client, benchConnections)
+	for i := range clients {
+		client := &Client{
+			hub:     hub,
+			send:    make(chan outbound, 256),
+			userID:  int64(i%users + 1),
+			rooms:   make(map[string]bool),
+			threads: make(map[int64]string),
+			status:  StatusOnline,
+			joined:  make(map[string]bool),
+		}
+		clients[i] = client
+
+		// the simulated connection counts the frames instead of writing them
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			for range client.send {
+				delivered.Add(1)
+			}
+		}()
+
+		enqueue(hub, hub.register, client)
+		enqueue(hub, hub.join, subscription{client: client, room: names[i%rooms]})
+	}
+
+	// the joins are announced to the members, the benchmark counts the messages only
+	members := int64(benchConnections / rooms)
+	waitFrames(b, &delivered, func(n int64) bool {
+		time.Sleep(100 * time.Millisecond)
+		return delivered.Load() == n
+	})
+	delivered.Store(0)
+
+	data := []byte(`{"type": "message", "room": "room", "payload": {"text": "hello"}}`)
+	var next atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(next.Add(1) % benchConnections)
+			enqueue(hub, hub.broadcast, roomMessage{sender: clients[i], room: names[i%rooms], messageID: 1, data: data})
+		}
+	})
+
+	want := int64(b.N) * members
+	waitFrames(b, &delivered, func(int64) bool { return delivered.Load() >= want })
+	b.StopTimer()
+
+	b.ReportMetric(float64(want)/b.Elapsed().Seconds(), "frames/s")
+}
+
+// waitFrames waits until done reports that the simulated connections received every frame.
+func waitFrames(b *testing.B, delivered *atomic.Int64, done func(n int64) bool) {
+	deadline := time.Now().Add(time.Minute)
+	for !done(delivered.Load()) {
+		if time.Now().After(deadline) {
+			b.Fatalf("connections received %d frames, a slow consumer was dropped", delivered.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
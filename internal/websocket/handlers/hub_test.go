@@ -0,0 +1,1361 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"new-websocket-chat/internal/blob"
+	"new-websocket-chat/internal/blob/local"
+	"new-websocket-chat/internal/broker"
+	brokerMemory "new-websocket-chat/internal/broker/memory"
+	"new-websocket-chat/internal/lib/logger/handlers/slogdiscard"
+	"new-websocket-chat/internal/lib/ratelimit"
+	"new-websocket-chat/internal/storage"
+	"new-websocket-chat/internal/storage/memory"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer runs a hub behind a websocket endpoint that authenticates users by the user query parameter.
+// Users 1, 2 and 3 exist in its storage.
+type testServer struct {
+	hub    *Hub
+	server *httptest.Server
+	stop   context.CancelFunc
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return newLimitedTestServer(t, RateLimits{})
+}
+
+// newLimitedTestServer is newTestServer with rate limits of the envelopes.
+func newLimitedTestServer(t *testing.T, limits RateLimits) *testServer {
+	return startTestServer(t, newTestStore(t), limits, brokerMemory.New())
+}
+
+// newClusterTestServers runs hubs sharing a storage and a broker, like the instances of the server
+// behind a load balancer.
+func newClusterTestServers(t *testing.T, n int) []*testServer {
+	store := newTestStore(t)
+	b := brokerMemory.New()
+
+	servers := make([]*testServer, n)
+	for i := range servers {
+		servers[i] = startTestServer(t, store, RateLimits{}, b)
+	}
+
+	return servers
+}
+
+func newTestStore(t *testing.T) *memory.Storage {
+	store := memory.New()
+	for _, username := range []string{"alice", "bob", "carol"} {
+		_, err := store.SaveUser(username, username+"@example.com", "hash")
+		require.NoError(t, err)
+	}
+
+	return store
+}
+
+func newTestBlobs(tb testing.TB) *local.Storage {
+	blobs, err := local.New(tb.TempDir())
+	require.NoError(tb, err)
+
+	return blobs
+}
+
+func startTestServer(t *testing.T, store MessageStore, limits RateLimits, b broker.Broker) *testServer {
+	hub := NewHub(slogdiscard.NewDiscardLogger(), store, newTestBlobs(t), limits, SlowConsumerPolicies{}, b)
+	hub.typingTimeout = 200 * time.Millisecond
+	ctx, stop := context.WithCancel(context.Background())
+	go hub.Run(ctx)
+
+	serveWs := ServeWs(slogdiscard.NewDiscardLogger(), hub)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), "userID", r.URL.Query().Get("user"))
+		serveWs(w, r.WithContext(ctx))
+	}))
+
+	t.Cleanup(func() {
+		stop()
+		server.Close()
+	})
+
+	return &testServer{hub: hub, server: server, stop: stop}
+}
+
+func (s *testServer) dial(t *testing.T, userID int64) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(s.server.URL, "http") + "?user=" + strconv.FormatInt(userID, 10)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// resume dials a connection resuming the session of the user after the frame, with its sequence number,
+// and the last chat message the connection got.
+func (s *testServer) resume(t *testing.T, userID int64, seq int64, lastMessage string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(s.server.URL, "http") + "?user=" + strconv.FormatInt(userID, 10) +
+		"&resume=" + strconv.FormatInt(seq, 10) + "&last_message=" + lastMessage
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, frame string) {
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+}
+
+// receive returns the next envelope that isn't a presence change or a receipt, see receiveType.
+func receive(t *testing.T, conn *websocket.Conn) Envelope {
+	for {
+		if env := receiveAny(t, conn); env.Type != TypePresence && env.Type != TypeReceipt {
+			return env
+		}
+	}
+}
+
+// receiveType returns the next envelope of the type, skipping the others.
+func receiveType(t *testing.T, conn *websocket.Conn, envType string) Envelope {
+	for {
+		if env := receiveAny(t, conn); env.Type == envType {
+			return env
+		}
+	}
+}
+
+// receivePresence returns the status from the next presence change of the user, skipping other envelopes.
+func receivePresence(t *testing.T, conn *websocket.Conn, userID string) PresencePayload {
+	for {
+		env := receiveType(t, conn, TypePresence)
+		if env.Sender != userID {
+			continue
+		}
+
+		var payload PresencePayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+
+		return payload
+	}
+}
+
+func receiveAny(t *testing.T, conn *websocket.Conn) Envelope {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	var env Envelope
+	require.NoError(t, json.Unmarshal(data, &env))
+
+	return env
+}
+
+// flush makes sure the hub processed every frame the client sent before, by waiting for an error reply.
+func flush(t *testing.T, conn *websocket.Conn) {
+	send(t, conn, `{"type": "bogus", "room": "sync"}`)
+	require.Equal(t, TypeError, receive(t, conn).Type)
+}
+
+func TestHubRooms(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	carol := s.dial(t, 3)
+
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	send(t, bob, `{"type": "join", "room": "general"}`)
+	send(t, carol, `{"type": "join", "room": "random"}`)
+	flush(t, alice)
+	flush(t, bob)
+	flush(t, carol)
+
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "hi"}}`)
+
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		env := receive(t, conn)
+		require.Equal(t, TypeMessage, env.Type)
+		require.Equal(t, "general", env.Room)
+		require.Equal(t, "1", env.Sender)
+		require.Equal(t, "1", env.ID)
+	}
+
+	// carol is not a member of general, the next frame she gets is the reply to her own message
+	send(t, carol, `{"type": "message", "room": "general", "payload": {"text": "hi"}}`)
+	env := receive(t, carol)
+	require.Equal(t, TypeError, env.Type)
+
+	var payload ErrorPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	require.Equal(t, ErrCodeNotMember, payload.Code)
+}
+
+func TestHubLeaveRoom(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		send(t, conn, `{"type": "join", "room": "general"}`)
+		send(t, conn, `{"type": "join", "room": "random"}`)
+		flush(t, conn)
+	}
+
+	send(t, bob, `{"type": "leave", "room": "general"}`)
+	flush(t, bob)
+
+	// bob doesn't get the message to general, the next frame he gets is the one to random
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "general"}}`)
+	require.Equal(t, "general", receive(t, alice).Room)
+	send(t, alice, `{"type": "message", "room": "random", "payload": {"text": "random"}}`)
+	require.Equal(t, "random", receive(t, alice).Room)
+	require.Equal(t, "random", receive(t, bob).Room)
+
+	send(t, bob, `{"type": "message", "room": "general", "payload": {"text": "back?"}}`)
+	env := receive(t, bob)
+	require.Equal(t, TypeError, env.Type)
+
+	var payload ErrorPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	require.Equal(t, ErrCodeNotMember, payload.Code)
+
+	// leaving a room twice or a room the client never joined changes nothing
+	send(t, bob, `{"type": "leave", "room": "general"}`)
+	send(t, bob, `{"type": "leave", "room": "nowhere"}`)
+	flush(t, bob)
+}
+
+func TestHubRemoveClient(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bobPhone := s.dial(t, 2)
+	bobLaptop := s.dial(t, 2)
+	for _, conn := range []*websocket.Conn{alice, bobPhone, bobLaptop} {
+		send(t, conn, `{"type": "join", "room": "general"}`)
+		flush(t, conn)
+	}
+
+	// the other device of bob stays in the room
+	bobPhone.Close()
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "still there?"}}`)
+	require.Equal(t, "still there?", receiveText(t, bobLaptop))
+	require.Equal(t, "still there?", receiveText(t, alice))
+
+	// the room outlives the last connection of bob
+	bobLaptop.Close()
+	require.Equal(t, StatusOffline, receivePresence(t, alice, "2").Status)
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "alone"}}`)
+	require.Equal(t, "alone", receiveText(t, alice))
+
+	// a new connection isn't in the rooms of the closed ones
+	bob := s.dial(t, 2)
+	send(t, bob, `{"type": "message", "room": "general", "payload": {"text": "hi"}}`)
+	require.Equal(t, TypeError, receive(t, bob).Type)
+}
+
+// receiveText returns the text of the next chat message, skipping the other envelopes.
+func receiveText(t *testing.T, conn *websocket.Conn) string {
+	env := receiveType(t, conn, TypeMessage)
+
+	var payload MessagePayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+
+	return payload.Text
+}
+
+func TestHubDirectMessages(t *testing.T) {
+	s := newTestServer(t)
+
+	alicePhone := s.dial(t, 1)
+	aliceLaptop := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	flush(t, alicePhone)
+	flush(t, aliceLaptop)
+	flush(t, bob)
+
+	send(t, alicePhone, `{"type": "direct", "to": "2", "payload": {"text": "hi bob"}}`)
+
+	for _, conn := range []*websocket.Conn{bob, alicePhone, aliceLaptop} {
+		env := receive(t, conn)
+		require.Equal(t, TypeDirect, env.Type)
+		require.Equal(t, "dm:1:2", env.Room)
+		require.Equal(t, "1", env.Sender)
+		require.Equal(t, "2", env.To)
+	}
+
+	// carol is offline, she gets the message when she connects
+	send(t, bob, `{"type": "direct", "to": "3", "payload": {"text": "hi carol"}}`)
+	require.Equal(t, "dm:2:3", receive(t, bob).Room)
+	flush(t, bob)
+
+	carol := s.dial(t, 3)
+	env := receive(t, carol)
+	require.Equal(t, TypeDirect, env.Type)
+	require.Equal(t, "dm:2:3", env.Room)
+	require.Equal(t, "2", env.Sender)
+	require.Equal(t, "3", env.To)
+
+	var payload MessagePayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	require.Equal(t, "hi carol", payload.Text)
+
+	// the queue is emptied by the delivery
+	carolAgain := s.dial(t, 3)
+	flush(t, carolAgain)
+
+	// a message to nobody is neither echoed nor saved
+	send(t, bob, `{"type": "direct", "id": "c1", "to": "42", "payload": {"text": "anyone?"}}`)
+	env = receive(t, bob)
+	require.Equal(t, TypeError, env.Type)
+
+	var errPayload ErrorPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &errPayload))
+	require.Equal(t, ErrCodeNoUser, errPayload.Code)
+	require.Equal(t, "c1", errPayload.Ref)
+
+	messages, err := s.hub.store.(*memory.Storage).GetRoomMessages("dm:2:42", 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, messages)
+}
+
+func TestHubPresence(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+
+	// alice learns bob is online when he joins the room they share
+	send(t, bob, `{"type": "join", "room": "general"}`)
+	require.Equal(t, StatusOnline, receivePresence(t, alice, "2").Status)
+
+	send(t, bob, `{"type": "status", "payload": {"status": "away"}}`)
+	require.Equal(t, StatusAway, receivePresence(t, alice, "2").Status)
+
+	// a second device that is online makes bob online again
+	bobLaptop := s.dial(t, 2)
+	require.Equal(t, StatusOnline, receivePresence(t, alice, "2").Status)
+
+	send(t, bobLaptop, `{"type": "status", "payload": {"status": "dnd"}}`)
+	require.Equal(t, StatusDND, receivePresence(t, alice, "2").Status)
+
+	// every device of bob is told about the changes too
+	for _, status := range []string{StatusAway, StatusOnline, StatusDND} {
+		require.Equal(t, status, receivePresence(t, bob, "2").Status)
+	}
+
+	require.Equal(t, map[int64]string{1: StatusOnline, 2: StatusDND, 3: StatusOffline}, s.hub.Statuses([]int64{1, 2, 3}))
+
+	bobLaptop.Close()
+	require.Equal(t, StatusAway, receivePresence(t, alice, "2").Status)
+	bob.Close()
+	presence := receivePresence(t, alice, "2")
+	require.Equal(t, StatusOffline, presence.Status)
+	require.NotNil(t, presence.LastSeen)
+
+	// the time is saved without the hub waiting for it
+	require.Eventually(t, func() bool {
+		lastSeen, err := s.hub.store.(*memory.Storage).GetLastSeen([]int64{2})
+		require.NoError(t, err)
+		return lastSeen[2].Equal(*presence.LastSeen)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHubTyping(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	send(t, bob, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+	flush(t, bob)
+
+	typing := func(env Envelope) string {
+		require.Equal(t, TypeTyping, env.Type)
+		require.Equal(t, "1", env.Sender)
+
+		var payload TypingPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		return payload.State
+	}
+
+	send(t, alice, `{"type": "typing", "room": "general", "payload": {"state": "start"}}`)
+	require.Equal(t, TypingStart, typing(receive(t, bob)))
+
+	// the refresh isn't forwarded and the message ends the typing without a stop
+	send(t, alice, `{"type": "typing", "room": "general", "payload": {"state": "start"}}`)
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "hi"}}`)
+	require.Equal(t, TypeMessage, receive(t, bob).Type)
+
+	send(t, alice, `{"type": "typing", "room": "general", "payload": {"state": "start"}}`)
+	require.Equal(t, TypingStart, typing(receive(t, bob)))
+	require.Equal(t, TypingStop, typing(receive(t, bob)), "typing expires without refresh")
+
+	send(t, alice, `{"type": "typing", "to": "2", "payload": {"state": "start"}}`)
+	env := receive(t, bob)
+	require.Equal(t, TypingStart, typing(env))
+	require.Equal(t, "dm:1:2", env.Room)
+	require.Equal(t, "2", env.To)
+
+	send(t, alice, `{"type": "typing", "to": "2", "payload": {"state": "stop"}}`)
+	require.Equal(t, TypingStop, typing(receive(t, bob)))
+}
+
+func TestHubTypingBackpressure(t *testing.T) {
+	hub := NewHub(slogdiscard.NewDiscardLogger(), memory.New(), newTestBlobs(t), RateLimits{}, SlowConsumerPolicies{}, brokerMemory.New())
+	client := &Client{hub: hub, send: make(chan outbound, 4), userID: 1, rooms: make(map[string]bool), status: StatusOnline}
+	hub.addClient(client)
+	// the hub isn't running, the test runs the tasks of the shard itself
+	shard := client.shard
+	for len(shard.tasks) > 0 {
+		shard.handle(<-shard.tasks)
+	}
+
+	client.send <- outbound{data: []byte("message")}
+	client.send <- outbound{data: []byte("message")}
+
+	// typing is dropped while the buffer is half full, the client stays connected
+	hub.deliverEphemeral(client, []byte("typing"))
+	shard.handle(<-shard.tasks)
+	require.Len(t, client.send, 2)
+	require.Contains(t, shard.clients, client)
+
+	<-client.send
+	hub.deliverEphemeral(client, []byte("typing"))
+	shard.handle(<-shard.tasks)
+	require.Len(t, client.send, 2)
+}
+
+func TestShardPresence(t *testing.T) {
+	hub := NewHub(slogdiscard.NewDiscardLogger(), memory.New(), newTestBlobs(t), RateLimits{}, SlowConsumerPolicies{}, brokerMemory.New())
+	// the hub isn't running, the test runs the tasks of the shards itself
+	run := func() {
+		for _, shard := range hub.shards {
+			for len(shard.tasks) > 0 {
+				shard.handle(<-shard.tasks)
+			}
+		}
+	}
+	connect := func(userID int64, rooms ...string) *Client {
+		client := &Client{hub: hub, send: make(chan outbound, 8), userID: userID, rooms: make(map[string]bool), threads: make(map[int64]string), status: StatusOnline}
+		hub.addClient(client)
+		for _, room := range rooms {
+			hub.joinRoom(client, room)
+		}
+		return client
+	}
+
+	alice := connect(1, "general", "random")
+	bob := connect(2, "general", "random")
+	carol := connect(3, "other")
+	run()
+	for _, client := range []*Client{alice, bob, carol} {
+		for len(client.send) > 0 {
+			<-client.send
+		}
+	}
+
+	// alice shares two rooms with bob and is told once
+	hub.setStatus(bob, StatusAway)
+	run()
+
+	require.Len(t, alice.send, 1)
+	require.Len(t, bob.send, 1)
+	require.Empty(t, carol.send)
+
+	var env Envelope
+	require.NoError(t, json.Unmarshal((<-alice.send).data, &env))
+	require.Equal(t, TypePresence, env.Type)
+	require.Equal(t, "2", env.Sender)
+}
+
+func TestShardSlowConsumerPolicies(t *testing.T) {
+	cases := []struct {
+		name         string
+		policies     SlowConsumerPolicies
+		frame        outbound
+		queued       []string
+		connected    bool
+		shed         map[string]int64
+		disconnected map[string]int64
+	}{
+		{
+			name:         "Disconnect by default",
+			frame:        outbound{data: []byte("new"), class: ClassMessage},
+			queued:       []string{"old", "older"},
+			connected:    false,
+			shed:         map[string]int64{"message": 0, "event": 0, "typing": 0},
+			disconnected: map[string]int64{"message": 1, "event": 0, "typing": 0},
+		},
+		{
+			name:         "Drop newest",
+			policies:     SlowConsumerPolicies{Event: PolicyDropNewest},
+			frame:        outbound{data: []byte("new")},
+			queued:       []string{"old", "older"},
+			connected:    true,
+			shed:         map[string]int64{"message": 0, "event": 1, "typing": 0},
+			disconnected: map[string]int64{"message": 0, "event": 0, "typing": 0},
+		},
+		{
+			name:         "Drop oldest",
+			policies:     SlowConsumerPolicies{Message: PolicyDropOldest},
+			frame:        outbound{data: []byte("new"), class: ClassMessage},
+			queued:       []string{"older", "new"},
+			connected:    true,
+			shed:         map[string]int64{"message": 1, "event": 0, "typing": 0},
+			disconnected: map[string]int64{"message": 0, "event": 0, "typing": 0},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			hub := NewHub(slogdiscard.NewDiscardLogger(), memory.New(), newTestBlobs(t), RateLimits{}, tc.policies, brokerMemory.New())
+			client := &Client{hub: hub, send: make(chan outbound, 2), userID: 1, rooms: make(map[string]bool), status: StatusOnline}
+			hub.addClient(client)
+			shard := client.shard
+			for len(shard.tasks) > 0 {
+				shard.handle(<-shard.tasks)
+			}
+
+			client.send <- outbound{data: []byte("old"), class: ClassMessage}
+			client.send <- outbound{data: []byte("older"), class: ClassMessage}
+
+			hub.push(client, tc.frame)
+			shard.handle(<-shard.tasks)
+
+			var queued []string
+			for len(client.send) > 0 {
+				queued = append(queued, string((<-client.send).data))
+			}
+			require.Equal(t, tc.queued, queued)
+			require.Equal(t, tc.connected, shard.clients[client] != nil)
+			require.Equal(t, Metrics{Shed: tc.shed, Disconnected: tc.disconnected}, hub.Metrics())
+		})
+	}
+}
+
+func TestShardShedEpisodes(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&logs, nil))
+	hub := NewHub(log, memory.New(), newTestBlobs(t), RateLimits{}, SlowConsumerPolicies{Event: PolicyDropNewest}, brokerMemory.New())
+	client := &Client{hub: hub, send: make(chan outbound, 2), userID: 1, rooms: make(map[string]bool), status: StatusOnline}
+	hub.addClient(client)
+	// the hub isn't running, the test runs the tasks of the shard itself
+	shard := client.shard
+	run := func() {
+		for len(shard.tasks) > 0 {
+			shard.handle(<-shard.tasks)
+		}
+	}
+	run()
+
+	// shedCounts returns the shed counts logged since the last call, by outcome and class
+	shedCounts := func() map[string]int {
+		counts := make(map[string]int)
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var entry struct {
+				Msg     string `json:"msg"`
+				Class   string `json:"class"`
+				Count   int    `json:"count"`
+				Outcome string `json:"outcome"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			if entry.Msg == "shed frames of slow client" {
+				counts[entry.Outcome+" "+entry.Class] = entry.Count
+			}
+		}
+		logs.Reset()
+		return counts
+	}
+
+	client.send <- outbound{data: []byte("old")}
+	client.send <- outbound{data: []byte("older")}
+	hub.deliver(client, []byte("event"))
+	hub.deliver(client, []byte("event"))
+	hub.deliverEphemeral(client, []byte("typing"))
+	run()
+	require.Empty(t, shedCounts())
+
+	// the frames of the episode are logged once the client keeps up again
+	<-client.send
+	hub.deliver(client, []byte("event"))
+	run()
+	require.Equal(t, map[string]int{"recovered event": 2, "recovered typing": 1}, shedCounts())
+
+	hub.deliver(client, []byte("event"))
+	run()
+	hub.removeClient(client)
+	run()
+	require.Equal(t, map[string]int{"disconnected event": 1}, shedCounts())
+}
+
+func TestHubSlowClient(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+
+	// bob never reads, his shard drops him once his send buffer is full
+	hub := s.hub
+	bob := &Client{hub: hub, send: make(chan outbound, 2), userID: 2, rooms: make(map[string]bool), threads: make(map[int64]string), status: StatusOnline}
+	require.True(t, enqueue(hub, hub.register, bob))
+	require.True(t, enqueue(hub, hub.join, subscription{client: bob, room: "general"}))
+	flush(t, alice)
+
+	for i := 0; i < 3; i++ {
+		send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "hi"}}`)
+		require.Equal(t, TypeMessage, receive(t, alice).Type)
+	}
+
+	require.Eventually(t, func() bool {
+		return hub.Statuses([]int64{2})[2] == StatusOffline
+	}, time.Second, 10*time.Millisecond)
+
+	frames := 0
+	for range bob.send {
+		frames++
+	}
+	require.Equal(t, 2, frames)
+	require.Equal(t, websocket.CloseTryAgainLater, bob.closeCode)
+	require.Equal(t, int64(1), hub.Metrics().Disconnected["message"])
+
+	// the room keeps working for the others
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "still here"}}`)
+	require.Equal(t, TypeMessage, receive(t, alice).Type)
+}
+
+func TestHubSlowDirectRecipient(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	flush(t, alice)
+
+	// bob never reads, the direct message that doesn't fit is kept for his next connection
+	hub := s.hub
+	bob := &Client{hub: hub, send: make(chan outbound, 2), userID: 2, rooms: make(map[string]bool), threads: make(map[int64]string), status: StatusOnline}
+	require.True(t, enqueue(hub, hub.register, bob))
+
+	for _, text := range []string{"one", "two", "three"} {
+		send(t, alice, `{"type": "direct", "to": "2", "payload": {"text": "`+text+`"}}`)
+		require.Equal(t, TypeDirect, receive(t, alice).Type)
+	}
+
+	store := hub.store.(*memory.Storage)
+	var pending []storage.Message
+	require.Eventually(t, func() bool {
+		messages, err := store.TakePendingMessages(2)
+		require.NoError(t, err)
+		pending = append(pending, messages...)
+		return len(pending) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.Len(t, pending, 1)
+	require.Equal(t, "three", pending[0].Body)
+}
+
+func TestHubReceipts(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	send(t, bob, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+	flush(t, bob)
+
+	receipt := func(env Envelope) ReceiptPayload {
+		require.Equal(t, TypeReceipt, env.Type)
+		require.Equal(t, "general", env.Room)
+		require.Equal(t, "2", env.Sender)
+
+		var payload ReceiptPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		return payload
+	}
+
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "hi"}}`)
+	message := receive(t, alice)
+	require.Equal(t, TypeMessage, message.Type)
+	require.Equal(t, message.ID, receive(t, bob).ID)
+
+	require.Equal(t, ReceiptPayload{State: ReceiptDelivered, MessageID: message.ID}, receipt(receiveType(t, alice, TypeReceipt)))
+
+	send(t, bob, `{"type": "read", "room": "general", "payload": {"messageId": "`+message.ID+`"}}`)
+	require.Equal(t, ReceiptPayload{State: ReceiptRead, MessageID: message.ID}, receipt(receiveType(t, alice, TypeReceipt)))
+	require.Equal(t, ReceiptPayload{State: ReceiptRead, MessageID: message.ID}, receipt(receiveType(t, bob, TypeReceipt)))
+
+	errorPayload := func(env Envelope) ErrorPayload {
+		require.Equal(t, TypeError, env.Type)
+
+		var payload ErrorPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		return payload
+	}
+
+	// reading the same message again changes nothing, a message of another room can't be read
+	send(t, bob, `{"type": "read", "room": "general", "payload": {"messageId": "`+message.ID+`"}}`)
+	send(t, bob, `{"type": "join", "room": "random"}`)
+	send(t, bob, `{"type": "read", "room": "random", "id": "c1", "payload": {"messageId": "`+message.ID+`"}}`)
+	require.Equal(t, ErrorPayload{Code: ErrCodeInvalid, Message: "message is not found in the room", Ref: "c1"}, errorPayload(receiveType(t, bob, TypeError)))
+
+	// only the rooms the user joined can be read
+	send(t, bob, `{"type": "read", "room": "secret", "id": "c2", "payload": {"messageId": "`+message.ID+`"}}`)
+	require.Equal(t, ErrCodeNotMember, errorPayload(receive(t, bob)).Code)
+}
+
+func TestHubDeliveryBatch(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	flush(t, alice)
+
+	store := s.hub.store.(*memory.Storage)
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		id, err := store.SaveMessage("general", 1, "hi", time.Now())
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	receiptOf := func() int64 {
+		env := receiveType(t, alice, TypeReceipt)
+		var payload ReceiptPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		require.Equal(t, ReceiptDelivered, payload.State)
+
+		id, err := strconv.ParseInt(payload.MessageID, 10, 64)
+		require.NoError(t, err)
+		return id
+	}
+
+	// both devices of bob wrote the second message, the sender hears about it once
+	s.hub.saveDeliveries([]storeWrite{
+		{kind: writeDelivered, userID: 2, room: "general", messageID: ids[1], senderID: 1},
+		{kind: writeDelivered, userID: 2, room: "general", messageID: ids[1], senderID: 1},
+		{kind: writeDelivered, userID: 2, room: "general", messageID: ids[0], senderID: 1},
+		{kind: writeDelivered, userID: 2, room: "general", messageID: ids[2], senderID: 1},
+	})
+	require.Equal(t, []int64{ids[1], ids[0], ids[2]}, []int64{receiptOf(), receiptOf(), receiptOf()})
+
+	// messages up to the cursor were delivered already
+	s.hub.saveDeliveries([]storeWrite{
+		{kind: writeDelivered, userID: 2, room: "general", messageID: ids[2], senderID: 1},
+		{kind: writeDelivered, userID: 2, room: "general", messageID: ids[3], senderID: 1},
+	})
+	require.Equal(t, ids[3], receiptOf())
+}
+
+func TestHubEdits(t *testing.T) {
+	s := newTestServer(t)
+
+	// alice is granted the room, joining it first doesn't make carol a moderator
+	require.NoError(t, s.hub.store.(*memory.Storage).SetRoomModerator("general", 1))
+	carol := s.dial(t, 3)
+	send(t, carol, `{"type": "join", "room": "general"}`)
+	flush(t, carol)
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	send(t, bob, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+	flush(t, bob)
+	flush(t, carol)
+
+	errorCode := func(conn *websocket.Conn) string {
+		env := receive(t, conn)
+		require.Equal(t, TypeError, env.Type)
+
+		var payload ErrorPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		return payload.Code
+	}
+
+	send(t, bob, `{"type": "message", "room": "general", "payload": {"text": "helo"}}`)
+	id := receive(t, bob).ID
+	receive(t, alice)
+	receive(t, carol)
+
+	send(t, carol, `{"type": "edit", "room": "general", "payload": {"messageId": "`+id+`", "text": "hacked"}}`)
+	require.Equal(t, ErrCodeForbidden, errorCode(carol))
+
+	send(t, bob, `{"type": "edit", "room": "general", "payload": {"messageId": "`+id+`", "text": "hello"}}`)
+	for _, conn := range []*websocket.Conn{alice, bob, carol} {
+		env := receive(t, conn)
+		require.Equal(t, TypeEdit, env.Type)
+		require.Equal(t, "2", env.Sender)
+
+		var payload EditPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		require.Equal(t, EditPayload{MessageID: id, Text: "hello"}, payload)
+	}
+
+	send(t, alice, `{"type": "delete", "room": "general", "payload": {"messageId": "`+id+`"}}`)
+	for _, conn := range []*websocket.Conn{alice, bob, carol} {
+		env := receive(t, conn)
+		require.Equal(t, TypeDelete, env.Type)
+		require.Equal(t, "1", env.Sender)
+	}
+
+	send(t, bob, `{"type": "edit", "room": "general", "payload": {"messageId": "`+id+`", "text": "back"}}`)
+	require.Equal(t, ErrCodeInvalid, errorCode(bob))
+	send(t, bob, `{"type": "delete", "room": "random", "payload": {"messageId": "`+id+`"}}`)
+	require.Equal(t, ErrCodeNotMember, errorCode(bob))
+
+	messages, err := s.hub.store.(*memory.Storage).GetRoomMessages("general", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Empty(t, messages[0].Body)
+	require.NotNil(t, messages[0].DeletedAt)
+}
+
+func TestHubReactions(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	send(t, bob, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+	flush(t, bob)
+
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "lunch?"}}`)
+	id := receive(t, alice).ID
+	receive(t, bob)
+
+	reaction := func(conn *websocket.Conn) ReactionPayload {
+		env := receive(t, conn)
+		require.Equal(t, TypeReaction, env.Type)
+		require.Equal(t, "2", env.Sender)
+
+		var payload ReactionPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		return payload
+	}
+
+	// the second add is a no-op and isn't forwarded
+	send(t, bob, `{"type": "reaction", "room": "general", "payload": {"messageId": "`+id+`", "emoji": "👍", "action": "add"}}`)
+	send(t, bob, `{"type": "reaction", "room": "general", "payload": {"messageId": "`+id+`", "emoji": "👍", "action": "add"}}`)
+	send(t, bob, `{"type": "reaction", "room": "general", "payload": {"messageId": "`+id+`", "emoji": "🍕", "action": "add"}}`)
+	send(t, bob, `{"type": "reaction", "room": "general", "payload": {"messageId": "`+id+`", "emoji": "🍕", "action": "remove"}}`)
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		require.Equal(t, ReactionPayload{MessageID: id, Emoji: "👍", Action: ReactionAdd}, reaction(conn))
+		require.Equal(t, ReactionPayload{MessageID: id, Emoji: "🍕", Action: ReactionAdd}, reaction(conn))
+		require.Equal(t, ReactionPayload{MessageID: id, Emoji: "🍕", Action: ReactionRemove}, reaction(conn))
+	}
+
+	messages, err := s.hub.store.(*memory.Storage).GetRoomMessages("general", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, []storage.Reaction{{Emoji: "👍", Count: 1, UserIDs: []int64{2}}}, messages[0].Reactions)
+}
+
+func TestHubThreads(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	carol := s.dial(t, 3)
+	for _, conn := range []*websocket.Conn{alice, bob, carol} {
+		send(t, conn, `{"type": "join", "room": "general"}`)
+		flush(t, conn)
+	}
+
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "lunch?"}}`)
+	id := receive(t, alice).ID
+	receive(t, bob)
+	receive(t, carol)
+
+	send(t, bob, `{"type": "subscribe", "room": "general", "payload": {"messageId": "`+id+`"}}`)
+	flush(t, bob)
+
+	summary := func(conn *websocket.Conn) ThreadSummaryPayload {
+		env := receive(t, conn)
+		require.Equal(t, TypeThread, env.Type)
+
+		var payload ThreadSummaryPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		return payload
+	}
+
+	// carol isn't subscribed, she gets her reply and the reply counts only
+	send(t, carol, `{"type": "message", "room": "general", "payload": {"text": "sure", "parentId": "`+id+`"}}`)
+	var replyID string
+	for _, conn := range []*websocket.Conn{bob, carol} {
+		env := receive(t, conn)
+		replyID = env.ID
+		require.Equal(t, TypeMessage, env.Type)
+		require.Equal(t, "3", env.Sender)
+
+		var payload MessagePayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		require.Equal(t, MessagePayload{Text: "sure", ParentID: id}, payload)
+	}
+	for _, conn := range []*websocket.Conn{alice, bob, carol} {
+		got := summary(conn)
+		require.Equal(t, id, got.MessageID)
+		require.Equal(t, 1, got.ReplyCount)
+	}
+
+	send(t, bob, `{"type": "unsubscribe", "room": "general", "payload": {"messageId": "`+id+`"}}`)
+	flush(t, bob)
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "12:30", "parentId": "`+id+`"}}`)
+	require.Equal(t, TypeMessage, receive(t, alice).Type)
+	require.Equal(t, 2, summary(bob).ReplyCount)
+
+	// replies form a single level
+	send(t, carol, `{"type": "subscribe", "room": "general", "payload": {"messageId": "`+replyID+`"}}`)
+	env := receiveType(t, carol, TypeError)
+
+	var errPayload ErrorPayload
+	require.NoError(t, json.Unmarshal(env.Payload, &errPayload))
+	require.Equal(t, ErrCodeInvalid, errPayload.Code)
+
+	messages, err := s.hub.store.(*memory.Storage).GetRoomMessages("general", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, 2, messages[0].ReplyCount)
+}
+
+func TestHubMentions(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	carol := s.dial(t, 3)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+
+	mentioned := func(conn *websocket.Conn) MentionPayload {
+		env := receiveType(t, conn, TypeMention)
+		require.Equal(t, "general", env.Room)
+		require.Equal(t, "1", env.Sender)
+
+		var payload MentionPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		return payload
+	}
+
+	// bob gets the mention without joining the room, alice doesn't mention herself
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "@bob @alice @nobody lunch?"}}`)
+	id := receive(t, alice).ID
+	require.Equal(t, MentionPayload{MessageID: id, Text: "@bob @alice @nobody lunch?"}, mentioned(bob))
+
+	// an edit notifies only the users who weren't mentioned before
+	send(t, alice, `{"type": "edit", "room": "general", "payload": {"messageId": "`+id+`", "text": "@bob @carol lunch?"}}`)
+	require.Equal(t, TypeEdit, receive(t, alice).Type)
+	require.Equal(t, MentionPayload{MessageID: id, Text: "@bob @carol lunch?"}, mentioned(carol))
+	flush(t, bob)
+	flush(t, alice)
+
+	mentions, err := s.hub.store.(*memory.Storage).GetMentions(2, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, mentions, 1)
+	require.False(t, mentions[0].Read)
+}
+
+func TestHubAttachments(t *testing.T) {
+	s := newTestServer(t)
+	store := s.hub.store.(*memory.Storage)
+
+	upload := func(room string, uploaderID int64) string {
+		id, err := store.SaveAttachment(storage.Attachment{Room: room, UploaderID: uploaderID, Name: "cat.png", ContentType: "image/png", Size: 10, BlobKey: room + strconv.FormatInt(uploaderID, 10), Thumbnail: true})
+		require.NoError(t, err)
+		return strconv.FormatInt(id, 10)
+	}
+	photo := upload("general", 1)
+	otherRoom := upload("random", 1)
+	bobsPhoto := upload("general", 2)
+	directPhoto := upload("dm:1:3", 1)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	send(t, bob, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+	flush(t, bob)
+
+	member, err := store.IsRoomMember("general", 2)
+	require.NoError(t, err)
+	require.True(t, member)
+
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"attachments": ["`+photo+`"], "files": [{"name": "fake"}]}}`)
+	var messageID string
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		env := receive(t, conn)
+		require.Equal(t, TypeMessage, env.Type)
+		messageID = env.ID
+
+		var payload MessagePayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		require.Equal(t, []string{photo}, payload.Attachments)
+		require.Len(t, payload.Files, 1)
+		require.Equal(t, "cat.png", payload.Files[0].Name)
+		require.Equal(t, messageID, strconv.FormatInt(payload.Files[0].MessageID, 10))
+	}
+
+	for _, id := range []string{photo, otherRoom, bobsPhoto, "999"} {
+		send(t, alice, `{"type": "message", "id": "c1", "room": "general", "payload": {"text": "again", "attachments": ["`+id+`"]}}`)
+		env := receive(t, alice)
+		require.Equal(t, TypeError, env.Type, id)
+
+		var payload ErrorPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		require.Equal(t, ErrCodeInvalid, payload.Code)
+		require.Equal(t, "c1", payload.Ref)
+	}
+
+	messages, err := store.GetRoomMessages("general", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Len(t, messages[0].Attachments, 1)
+	require.Equal(t, photo, strconv.FormatInt(messages[0].Attachments[0].ID, 10))
+
+	// carol is offline, the queued message keeps its files
+	send(t, alice, `{"type": "direct", "to": "3", "payload": {"text": "look", "attachments": ["`+directPhoto+`"]}}`)
+	require.Equal(t, TypeDirect, receive(t, alice).Type)
+
+	carol := s.dial(t, 3)
+	env := receive(t, carol)
+	require.Equal(t, TypeDirect, env.Type)
+
+	var payload MessagePayload
+	require.NoError(t, json.Unmarshal(env.Payload, &payload))
+	require.Equal(t, "look", payload.Text)
+	require.Equal(t, []string{directPhoto}, payload.Attachments)
+	require.Len(t, payload.Files, 1)
+
+	// deleting the message deletes the file and its thumbnail
+	blobs := s.hub.blobs
+	for _, key := range []string{"general1", blob.ThumbnailKey("general1")} {
+		require.NoError(t, blobs.Put(context.Background(), key, strings.NewReader("cat")))
+	}
+	send(t, alice, `{"type": "delete", "room": "general", "payload": {"messageId": "`+messageID+`"}}`)
+	require.Equal(t, TypeDelete, receiveType(t, alice, TypeDelete).Type)
+
+	require.Eventually(t, func() bool {
+		for _, key := range []string{"general1", blob.ThumbnailKey("general1")} {
+			if _, err := blobs.Get(context.Background(), key); !errors.Is(err, blob.ErrNotFound) {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHubRateLimits(t *testing.T) {
+	s := newLimitedTestServer(t, RateLimits{
+		Connection:      ratelimit.Limit{Rate: 0.001, Burst: 3},
+		MaxViolations:   3,
+		ViolationWindow: time.Minute,
+	})
+
+	alice := s.dial(t, 1)
+	for i := 0; i < 5; i++ {
+		send(t, alice, `{"type": "leave", "room": "general"}`)
+	}
+	for i := 0; i < 2; i++ {
+		env := receive(t, alice)
+		require.Equal(t, TypeError, env.Type)
+
+		var payload ErrorPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		require.Equal(t, ErrCodeRateLimited, payload.Code)
+		require.Positive(t, payload.RetryAfter)
+	}
+
+	// the third violation closes the connection
+	send(t, alice, `{"type": "leave", "room": "general"}`)
+	require.NoError(t, alice.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, _, err := alice.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), err)
+
+	// other connections of the user have their own buckets
+	aliceAgain := s.dial(t, 1)
+	flush(t, aliceAgain)
+}
+
+func TestHubUserRateLimits(t *testing.T) {
+	s := newLimitedTestServer(t, RateLimits{User: ratelimit.Limit{Rate: 0.001, Burst: 5}})
+
+	phone := s.dial(t, 1)
+	laptop := s.dial(t, 1)
+	bob := s.dial(t, 2)
+
+	for i := 0; i < 3; i++ {
+		send(t, phone, `{"type": "leave", "room": "general"}`)
+	}
+	flush(t, phone)
+
+	// the devices share the bucket of the user, the violations never close the connection
+	send(t, laptop, `{"type": "leave", "room": "general"}`)
+	for i := 0; i < 3; i++ {
+		send(t, laptop, `{"type": "leave", "room": "general"}`)
+		env := receive(t, laptop)
+		require.Equal(t, TypeError, env.Type)
+
+		var payload ErrorPayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		require.Equal(t, ErrCodeRateLimited, payload.Code)
+	}
+
+	flush(t, bob)
+}
+
+func TestHubCluster(t *testing.T) {
+	nodes := newClusterTestServers(t, 2)
+
+	alice := nodes[0].dial(t, 1)
+	bob := nodes[1].dial(t, 2)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+
+	// alice learns bob is online when he joins the room on the other instance
+	send(t, bob, `{"type": "join", "room": "general"}`)
+	require.Equal(t, StatusOnline, receivePresence(t, alice, "2").Status)
+	require.Equal(t, map[int64]string{1: StatusOnline, 2: StatusOnline, 3: StatusOffline}, nodes[0].hub.Statuses([]int64{1, 2, 3}))
+
+	// messages, typing and receipts reach the other instance
+	send(t, alice, `{"type": "typing", "room": "general", "payload": {"state": "start"}}`)
+	require.Equal(t, TypeTyping, receive(t, bob).Type)
+
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "hi from node 0"}}`)
+	env := receive(t, bob)
+	require.Equal(t, TypeMessage, env.Type)
+	require.Equal(t, "1", env.Sender)
+	require.Equal(t, TypeMessage, receive(t, alice).Type)
+
+	delivered := receiveType(t, alice, TypeReceipt)
+	require.Equal(t, "2", delivered.Sender)
+
+	send(t, bob, `{"type": "direct", "to": "1", "payload": {"text": "hi from node 1"}}`)
+	env = receive(t, alice)
+	require.Equal(t, TypeDirect, env.Type)
+	require.Equal(t, "dm:1:2", env.Room)
+	require.Equal(t, TypeDirect, receive(t, bob).Type)
+
+	// a status is aggregated over the devices of the user on every instance
+	bobLaptop := nodes[0].dial(t, 2)
+	send(t, bobLaptop, `{"type": "status", "payload": {"status": "dnd"}}`)
+	require.Equal(t, StatusDND, receivePresence(t, alice, "2").Status)
+	statusOnNode1 := func(status string) func() bool {
+		return func() bool { return nodes[1].hub.Statuses([]int64{2})[2] == status }
+	}
+	require.Eventually(t, statusOnNode1(StatusDND), 2*time.Second, 10*time.Millisecond)
+
+	bobLaptop.Close()
+	require.Equal(t, StatusOnline, receivePresence(t, alice, "2").Status)
+	require.Eventually(t, statusOnNode1(StatusOnline), 2*time.Second, 10*time.Millisecond)
+
+	bob.Close()
+	presence := receivePresence(t, alice, "2")
+	require.Equal(t, StatusOffline, presence.Status)
+	require.NotNil(t, presence.LastSeen)
+
+	// logging out on one instance closes the connections to the others
+	nodes[1].hub.DisconnectUser(1)
+	require.NoError(t, alice.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for {
+		if _, _, err := alice.ReadMessage(); err != nil {
+			require.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+			break
+		}
+	}
+}
+
+func TestHubResume(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	send(t, alice, `{"type": "join", "room": "general"}`)
+	send(t, bob, `{"type": "join", "room": "general"}`)
+	flush(t, alice)
+	flush(t, bob)
+
+	text := func(env Envelope) string {
+		require.Equal(t, TypeMessage, env.Type)
+
+		var payload MessagePayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		return payload.Text
+	}
+
+	send(t, bob, `{"type": "message", "room": "general", "payload": {"text": "one"}}`)
+	require.Equal(t, "one", text(receive(t, bob)))
+	seen := receive(t, alice)
+	require.Equal(t, "one", text(seen))
+	require.NotZero(t, seen.Seq)
+
+	// alice's phone loses its connection, the shard keeps logging the room for the session
+	require.NoError(t, alice.Close())
+	require.Eventually(t, func() bool {
+		return s.hub.Statuses([]int64{1})[1] == StatusOffline
+	}, time.Second, 10*time.Millisecond)
+
+	for _, message := range []string{"two", "three"} {
+		send(t, bob, `{"type": "message", "room": "general", "payload": {"text": "`+message+`"}}`)
+		require.Equal(t, message, text(receive(t, bob)))
+	}
+
+	alice = s.resume(t, 1, seen.Seq, seen.ID)
+	last := seen.Seq
+	for _, message := range []string{"two", "three"} {
+		env := receive(t, alice)
+		require.Equal(t, message, text(env))
+		require.Greater(t, env.Seq, last)
+		last = env.Seq
+	}
+
+	resumed := receive(t, alice)
+	require.Equal(t, TypeResumed, resumed.Type)
+	var payload ResumedPayload
+	require.NoError(t, json.Unmarshal(resumed.Payload, &payload))
+	require.True(t, payload.Complete)
+	require.GreaterOrEqual(t, payload.Seq, last)
+
+	// the resumed connection is back in the room without joining it again
+	send(t, bob, `{"type": "message", "room": "general", "payload": {"text": "four"}}`)
+	require.Equal(t, "four", text(receive(t, bob)))
+	require.Equal(t, "four", text(receive(t, alice)))
+	send(t, alice, `{"type": "message", "room": "general", "payload": {"text": "five"}}`)
+	require.Equal(t, "five", text(receive(t, alice)))
+	require.Equal(t, "five", text(receive(t, bob)))
+
+	for _, query := range []string{"resume=last&last_message=1", "resume=1", "resume=1&last_message=-1"} {
+		_, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.server.URL, "http")+"?user=1&"+query, nil)
+		require.Error(t, err, query)
+		require.Equal(t, http.StatusBadRequest, res.StatusCode, query)
+	}
+}
+
+func TestHubResumeFromStorage(t *testing.T) {
+	s := newTestServer(t)
+
+	alice := s.dial(t, 1)
+	bob := s.dial(t, 2)
+	for _, room := range []string{"general", "random"} {
+		send(t, alice, `{"type": "join", "room": "`+room+`"}`)
+		send(t, bob, `{"type": "join", "room": "`+room+`"}`)
+	}
+	// the storage keeps alice as a member of random, her session doesn't
+	send(t, alice, `{"type": "leave", "room": "random"}`)
+	flush(t, alice)
+	flush(t, bob)
+
+	send(t, bob, `{"type": "message", "room": "general", "payload": {"text": "seen"}}`)
+	receive(t, bob)
+	seen := receive(t, alice)
+
+	require.NoError(t, alice.Close())
+	require.Eventually(t, func() bool {
+		return s.hub.Statuses([]int64{1})[1] == StatusOffline
+	}, time.Second, 10*time.Millisecond)
+
+	// more messages than are replayed, the oldest ones are loaded from the storage
+	missed := replaySize + 6
+	for i := 0; i < missed; i++ {
+		send(t, bob, `{"type": "message", "room": "general", "payload": {"text": "`+strconv.Itoa(i)+`"}}`)
+		receive(t, bob)
+		send(t, bob, `{"type": "message", "room": "random", "payload": {"text": "left"}}`)
+		receive(t, bob)
+	}
+
+	alice = s.resume(t, 1, seen.Seq, seen.ID)
+	for i := 0; i < missed; i++ {
+		env := receive(t, alice)
+		require.Equal(t, TypeMessage, env.Type)
+
+		var payload MessagePayload
+		require.NoError(t, json.Unmarshal(env.Payload, &payload))
+		require.Equal(t, strconv.Itoa(i), payload.Text)
+		// only the replayed messages have sequence numbers
+		require.Equal(t, i >= missed-replaySize, env.Seq != 0)
+	}
+
+	resumed := receive(t, alice)
+	require.Equal(t, TypeResumed, resumed.Type)
+	var payload ResumedPayload
+	require.NoError(t, json.Unmarshal(resumed.Payload, &payload))
+	require.True(t, payload.Complete)
+}
+
+func TestShardExpire(t *testing.T) {
+	hub := NewHub(slogdiscard.NewDiscardLogger(), memory.New(), newTestBlobs(t), RateLimits{}, SlowConsumerPolicies{}, brokerMemory.New())
+	client := &Client{hub: hub, send: make(chan outbound, 4), userID: 1, rooms: make(map[string]bool), threads: make(map[int64]string), status: StatusOnline}
+
+	// the hub isn't running, the test runs the tasks of the shard itself
+	hub.addClient(client)
+	hub.joinRoom(client, "general")
+	hub.removeClient(client)
+	shard := client.shard
+	for len(shard.tasks) > 0 {
+		shard.handle(<-shard.tasks)
+	}
+
+	sess := shard.sessions[1]
+	require.NotNil(t, sess)
+	require.Contains(t, shard.rooms["general"], sess)
+
+	shard.handle(shardTask{kind: taskExpire, now: time.Now().Add(resumeWindow / 2)})
+	require.Contains(t, shard.sessions, int64(1))
+	require.Contains(t, shard.rooms["general"], sess)
+
+	shard.handle(shardTask{kind: taskExpire, now: time.Now().Add(resumeWindow + time.Second)})
+	require.NotContains(t, shard.sessions, int64(1))
+	require.NotContains(t, shard.rooms, "general")
+}
+
+func TestHubShutdown(t *testing.T) {
+	s := newTestServer(t)
+
+	conn := s.dial(t, 1)
+	send(t, conn, `{"type": "join", "room": "general"}`)
+	flush(t, conn)
+
+	s.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// the client has to answer the close frame, so it reads concurrently with Wait
+	closeCode := make(chan int, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closeErr, ok := err.(*websocket.CloseError)
+				if ok {
+					closeCode <- closeErr.Code
+				} else {
+					closeCode <- 0
+				}
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, s.hub.Wait(ctx))
+	require.Equal(t, websocket.CloseGoingAway, <-closeCode)
+}
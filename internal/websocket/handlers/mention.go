@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"encoding/json"
+	"log/slog"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/lib/mention"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+	"time"
+)
+
+// MentionPayload is the payload of a mention envelope, sent to every connection of a user mentioned
+// with @username in a message of a room, whether or not the connection joined the room.
+type MentionPayload struct {
+	MessageID string `json:"messageId"`          // ID of the message
+	Text      string `json:"text"`               // Text of the message
+	ParentID  string `json:"parentId,omitempty"` // ID of the first message of the thread, if the message is a reply
+}
+
+// mentionNotice is an encoded mention envelope that has to be delivered to every connection of the users.
+type mentionNotice struct {
+	userIDs []int64
+	data    []byte
+}
+
+// notifyMentions records the users mentioned in the text of a room message and sends them a mention
+// envelope. Edited messages only notify the users they didn't mention before. Direct messages and
+// mentions of the sender are ignored.
+func (c *Client) notifyMentions(log *slog.Logger, message storage.Message) {
+	if dm.IsDirect(message.Room) {
+		return
+	}
+
+	usernames := mention.Parse(message.Body)
+	if len(usernames) == 0 {
+		return
+	}
+
+	ids, err := c.hub.store.GetUserIDs(usernames)
+	if err != nil {
+		log.Error("failed to resolve mentions", sl.Err(err))
+		return
+	}
+
+	userIDs := make([]int64, 0, len(ids))
+	for _, username := range usernames {
+		if id, ok := ids[username]; ok && id != c.userID {
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == 0 {
+		return
+	}
+
+	added, err := c.hub.store.SaveMentions(message.ID, userIDs)
+	if err != nil {
+		log.Error("failed to save mentions", sl.Err(err))
+		return
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	enqueue(c.hub, c.hub.mentions, mentionNotice{userIDs: added, data: mentionFrame(c.userID, message)})
+}
+
+func (h *Hub) deliverMention(notice mentionNotice) {
+	for _, userID := range notice.userIDs {
+		h.deliverUser(userID, outbound{data: notice.data})
+	}
+}
+
+// mentionFrame encodes a mention envelope of the message, sent by the user who mentioned.
+func mentionFrame(userID int64, message storage.Message) []byte {
+	payload := MentionPayload{MessageID: strconv.FormatInt(message.ID, 10), Text: message.Body}
+	if message.ParentID != 0 {
+		payload.ParentID = strconv.FormatInt(message.ParentID, 10)
+	}
+	data, _ := json.Marshal(payload) // marshaling of a struct with string fields can't fail
+
+	frame, _ := json.Marshal(Envelope{
+		Type:      TypeMention,
+		Room:      message.Room,
+		Sender:    strconv.FormatInt(userID, 10),
+		Timestamp: time.Now().UTC(),
+		Payload:   data,
+	})
+
+	return frame
+}
@@ -0,0 +1,215 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	resp "new-websocket-chat/internal/lib/api/response"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Types of the envelopes exchanged over the websocket connection.
+const (
+	TypeJoin        = "join"
+	TypeLeave       = "leave"
+	TypeMessage     = "message"
+	TypeDirect      = "direct"
+	TypeStatus      = "status"
+	TypeTyping      = "typing"
+	TypeRead        = "read"
+	TypeEdit        = "edit"
+	TypeDelete      = "delete"
+	TypeReaction    = "reaction"
+	TypeSubscribe   = "subscribe"
+	TypeUnsubscribe = "unsubscribe"
+	TypeThread      = "thread"
+	TypeMention     = "mention"
+	TypeReceipt     = "receipt"
+	TypePresence    = "presence"
+	TypeResumed     = "resumed"
+	TypeError       = "error"
+)
+
+// Codes of the error frames sent to the clients.
+const (
+	ErrCodeMalformed   = "malformed"    // frame is not a valid JSON envelope
+	ErrCodeInvalid     = "invalid"      // envelope or its payload failed validation
+	ErrCodeNotMember   = "not_member"   // client sent a message to a room it didn't join
+	ErrCodeInternal    = "internal"     // server failed to process the envelope
+	ErrCodeNoUser      = "no_user"      // recipient of a direct message doesn't exist
+	ErrCodeForbidden   = "forbidden"    // user isn't allowed to change the message
+	ErrCodeRateLimited = "rate_limited" // client sends envelopes faster than allowed, see RateLimits
+)
+
+var validate = validator.New()
+
+// directRoomTypes are the types of the envelopes that can refer to a direct room.
+var directRoomTypes = map[string]bool{
+	TypeDirect:   true,
+	TypeRead:     true,
+	TypeEdit:     true,
+	TypeDelete:   true,
+	TypeReaction: true,
+}
+
+// Envelope is a frame exchanged between the clients and the server.
+// ID, Sender and Timestamp are always stamped by the server, the values sent by a client
+// are only used to correlate error frames with the envelope that caused them.
+type Envelope struct {
+	Type      string          `json:"type" validate:"required,oneof=join leave message direct status typing read edit delete reaction subscribe unsubscribe"`                                                                                                                                       // Type of the envelope
+	ID        string          `json:"id,omitempty"`                                                                                                                                                                                                                                                 // Unique ID assigned by the server
+	Room      string          `json:"room,omitempty" validate:"required_if=Type join,required_if=Type leave,required_if=Type message,required_if=Type read,required_if=Type edit,required_if=Type delete,required_if=Type reaction,required_if=Type subscribe,required_if=Type unsubscribe,max=64"` // Name of the room the envelope belongs to, assigned by the server for direct messages
+	To        string          `json:"to,omitempty" validate:"required_if=Type direct"`                                                                                                                                                                                                              // ID of the user a direct message or typing is addressed to
+	Sender    string          `json:"sender,omitempty"`                                                                                                                                                                                                                                             // ID of the authenticated user who sent the envelope
+	Timestamp time.Time       `json:"timestamp"`                                                                                                                                                                                                                                                    // Time the server received the envelope
+	Payload   json.RawMessage `json:"payload,omitempty"`                                                                                                                                                                                                                                            // Type specific payload
+	Seq       int64           `json:"seq,omitempty"`                                                                                                                                                                                                                                                // Sequence number of the frame in the shard of the user, growing with gaps, present the last one seen when resuming
+}
+
+// MessagePayload is the payload of a chat message. Files are set by the server from the attachment IDs.
+type MessagePayload struct {
+	Text        string               `json:"text" validate:"required_without=Attachments,max=2000"`       // Text of the chat message, optional if it has attachments
+	ParentID    string               `json:"parentId,omitempty" validate:"omitempty,numeric"`             // ID of the first message of the thread the message replies to
+	Attachments []string             `json:"attachments,omitempty" validate:"max=10,unique,dive,numeric"` // IDs of the files uploaded to the room for the message
+	Files       []storage.Attachment `json:"files,omitempty" validate:"-"`                                // Attached files, ignored if sent by a client
+	Reactions   []storage.Reaction   `json:"reactions,omitempty" validate:"-"`                            // Reactions to a message loaded from the storage for a resuming client, ignored if sent by a client
+}
+
+// ResumedPayload is the payload of the envelope that ends the replay of a resumed session.
+type ResumedPayload struct {
+	Seq      int64 `json:"seq"`      // Sequence number of the shard of the user when the replay ended, present it when resuming again
+	Complete bool  `json:"complete"` // False if some missed frames couldn't be replayed, reload the history of the rooms then
+}
+
+// ErrorPayload is the payload of an error frame.
+type ErrorPayload struct {
+	Code       string `json:"code"`                 // Machine readable error code
+	Message    string `json:"message"`              // Human readable description of the error
+	Ref        string `json:"ref,omitempty"`        // ID of the client envelope that caused the error, if it had one
+	RetryAfter int64  `json:"retryAfter,omitempty"` // Milliseconds until the next envelope is allowed, for rate limited errors
+}
+
+// parseEnvelope decodes and validates an envelope received from a client.
+// The returned error payload is nil if the envelope is valid.
+func parseEnvelope(data []byte) (*Envelope, *ErrorPayload) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, &ErrorPayload{Code: ErrCodeMalformed, Message: "frame is not a valid JSON envelope"}
+	}
+
+	if err := validate.Struct(env); err != nil {
+		return nil, &ErrorPayload{Code: ErrCodeInvalid, Message: validationMessage(err), Ref: env.ID}
+	}
+
+	if env.Type == TypeDirect || env.To != "" {
+		if _, err := env.recipient(); err != nil {
+			return nil, &ErrorPayload{Code: ErrCodeInvalid, Message: "field To is not a valid user ID", Ref: env.ID}
+		}
+	}
+	// direct rooms are readable and editable by their users, the handlers check the membership
+	if !directRoomTypes[env.Type] && dm.IsDirect(env.Room) {
+		return nil, &ErrorPayload{Code: ErrCodeInvalid, Message: "rooms starting with " + dm.Prefix + " are reserved for direct messages", Ref: env.ID}
+	}
+	if env.Type == TypeTyping && (env.Room == "") == (env.To == "") {
+		return nil, &ErrorPayload{Code: ErrCodeInvalid, Message: "typing needs either a room or a recipient", Ref: env.ID}
+	}
+
+	var payload any
+	switch env.Type {
+	case TypeMessage, TypeDirect:
+		payload = &MessagePayload{}
+	case TypeStatus:
+		payload = &StatusPayload{}
+	case TypeTyping:
+		payload = &TypingPayload{}
+	case TypeRead:
+		payload = &ReadPayload{}
+	case TypeEdit:
+		payload = &EditPayload{}
+	case TypeDelete:
+		payload = &DeletePayload{}
+	case TypeReaction:
+		payload = &ReactionPayload{}
+	case TypeSubscribe, TypeUnsubscribe:
+		payload = &ThreadPayload{}
+	}
+	if payload != nil {
+		if err := json.Unmarshal(env.Payload, payload); err != nil {
+			return nil, &ErrorPayload{Code: ErrCodeMalformed, Message: env.Type + " payload is not valid JSON", Ref: env.ID}
+		}
+		if err := validate.Struct(payload); err != nil {
+			return nil, &ErrorPayload{Code: ErrCodeInvalid, Message: validationMessage(err), Ref: env.ID}
+		}
+	}
+	if message, ok := payload.(*MessagePayload); ok && env.Type == TypeDirect && message.ParentID != "" {
+		return nil, &ErrorPayload{Code: ErrCodeInvalid, Message: "direct messages can't be thread replies", Ref: env.ID}
+	}
+	// required_without accepts an empty list of attachments
+	if message, ok := payload.(*MessagePayload); ok && message.Text == "" && len(message.Attachments) == 0 {
+		return nil, &ErrorPayload{Code: ErrCodeInvalid, Message: "field Text is a required field", Ref: env.ID}
+	}
+
+	return &env, nil
+}
+
+// stamp replaces the client controlled fields of the envelope with the values assigned by the server.
+// The ID is assigned when the envelope is persisted.
+func (e *Envelope) stamp(sender string) {
+	e.ID = ""
+	e.Sender = sender
+	e.Timestamp = time.Now().UTC()
+}
+
+// recipient returns the ID of the user a direct message is addressed to.
+func (e *Envelope) recipient() (int64, error) {
+	userID, err := strconv.ParseInt(e.To, 10, 64)
+	if err == nil && userID <= 0 {
+		err = errors.New("user ID must be positive")
+	}
+
+	return userID, err
+}
+
+// directEnvelope encodes a stored direct message for its recipient.
+func directEnvelope(message storage.Message, recipient int64) ([]byte, error) {
+	payload, err := json.Marshal(MessagePayload{Text: message.Body, Attachments: attachmentIDs(message.Attachments), Files: message.Attachments, Reactions: message.Reactions})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(Envelope{
+		Type:      TypeDirect,
+		ID:        strconv.FormatInt(message.ID, 10),
+		Room:      message.Room,
+		To:        strconv.FormatInt(recipient, 10),
+		Sender:    strconv.FormatInt(message.SenderID, 10),
+		Timestamp: message.CreatedAt.UTC(),
+		Payload:   payload,
+	})
+}
+
+// errorFrame encodes an error frame for the client.
+func errorFrame(payload ErrorPayload) []byte {
+	data, _ := json.Marshal(payload) // marshaling of a struct with string fields can't fail
+
+	frame, _ := json.Marshal(Envelope{
+		Type:      TypeError,
+		Timestamp: time.Now().UTC(),
+		Payload:   data,
+	})
+
+	return frame
+}
+
+func validationMessage(err error) string {
+	validateErrs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err.Error()
+	}
+
+	return resp.ValidationError(validateErrs).Error
+}
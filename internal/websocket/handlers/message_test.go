@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     string
+		errorCode string
+		errorRef  string
+	}{
+		{
+			name:  "Message",
+			frame: `{"type": "message", "room": "general", "payload": {"text": "hello"}}`,
+		},
+		{
+			name:  "Join",
+			frame: `{"type": "join", "room": "general"}`,
+		},
+		{
+			name:  "Direct",
+			frame: `{"type": "direct", "to": "2", "payload": {"text": "hello"}}`,
+		},
+		{
+			name:      "Direct without recipient",
+			frame:     `{"type": "direct", "payload": {"text": "hello"}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:      "Direct to invalid user",
+			frame:     `{"type": "direct", "to": "bob", "id": "c3", "payload": {"text": "hello"}}`,
+			errorCode: ErrCodeInvalid,
+			errorRef:  "c3",
+		},
+		{
+			name:      "Join direct room",
+			frame:     `{"type": "join", "room": "dm:1:2"}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:  "Typing in room",
+			frame: `{"type": "typing", "room": "general", "payload": {"state": "start"}}`,
+		},
+		{
+			name:  "Typing to user",
+			frame: `{"type": "typing", "to": "2", "payload": {"state": "stop"}}`,
+		},
+		{
+			name:      "Typing to room and user",
+			frame:     `{"type": "typing", "room": "general", "to": "2", "payload": {"state": "start"}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:      "Typing with unknown state",
+			frame:     `{"type": "typing", "room": "general", "payload": {"state": "thinking"}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:  "Read direct room",
+			frame: `{"type": "read", "room": "dm:1:2", "payload": {"messageId": "42"}}`,
+		},
+		{
+			name:      "Read without message",
+			frame:     `{"type": "read", "room": "general", "payload": {}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:  "Edit",
+			frame: `{"type": "edit", "room": "general", "payload": {"messageId": "42", "text": "hello"}}`,
+		},
+		{
+			name:      "Edit without text",
+			frame:     `{"type": "edit", "room": "general", "payload": {"messageId": "42"}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:  "Delete direct message",
+			frame: `{"type": "delete", "room": "dm:1:2", "payload": {"messageId": "42"}}`,
+		},
+		{
+			name:      "Delete without room",
+			frame:     `{"type": "delete", "payload": {"messageId": "42"}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:  "Reaction",
+			frame: `{"type": "reaction", "room": "general", "payload": {"messageId": "42", "emoji": "👍", "action": "add"}}`,
+		},
+		{
+			name:      "Reaction with unknown action",
+			frame:     `{"type": "reaction", "room": "general", "payload": {"messageId": "42", "emoji": "👍", "action": "toggle"}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:  "Thread reply",
+			frame: `{"type": "message", "room": "general", "payload": {"text": "hello", "parentId": "42"}}`,
+		},
+		{
+			name:      "Direct thread reply",
+			frame:     `{"type": "direct", "to": "2", "payload": {"text": "hello", "parentId": "42"}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:  "Attachments without text",
+			frame: `{"type": "message", "room": "general", "payload": {"attachments": ["5", "6"]}}`,
+		},
+		{
+			name:      "Neither text nor attachments",
+			frame:     `{"type": "message", "room": "general", "payload": {"attachments": []}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:      "Duplicate attachments",
+			frame:     `{"type": "direct", "to": "2", "payload": {"attachments": ["5", "5"]}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:      "Invalid attachment ID",
+			frame:     `{"type": "message", "room": "general", "payload": {"text": "hello", "attachments": ["cat.png"]}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:      "Subscribe without room",
+			frame:     `{"type": "subscribe", "payload": {"messageId": "42"}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:      "Not JSON",
+			frame:     `hello`,
+			errorCode: ErrCodeMalformed,
+		},
+		{
+			name:      "Unknown type",
+			frame:     `{"type": "shout", "room": "general", "id": "c1"}`,
+			errorCode: ErrCodeInvalid,
+			errorRef:  "c1",
+		},
+		{
+			name:      "Missing room",
+			frame:     `{"type": "join"}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:      "Message without payload",
+			frame:     `{"type": "message", "room": "general", "id": "c2"}`,
+			errorCode: ErrCodeMalformed,
+			errorRef:  "c2",
+		},
+		{
+			name:      "Message with empty text",
+			frame:     `{"type": "message", "room": "general", "payload": {"text": ""}}`,
+			errorCode: ErrCodeInvalid,
+		},
+		{
+			name:      "Message with too long text",
+			frame:     `{"type": "message", "room": "general", "payload": {"text": "` + strings.Repeat("a", 2001) + `"}}`,
+			errorCode: ErrCodeInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			env, errPayload := parseEnvelope([]byte(test.frame))
+			if test.errorCode == "" {
+				require.Nil(t, errPayload)
+				require.NotNil(t, env)
+				return
+			}
+
+			require.Nil(t, env)
+			require.NotNil(t, errPayload)
+			require.Equal(t, test.errorCode, errPayload.Code)
+			require.Equal(t, test.errorRef, errPayload.Ref)
+		})
+	}
+}
+
+func TestEnvelopeStamp(t *testing.T) {
+	env, errPayload := parseEnvelope([]byte(`{"type": "message", "room": "general", "id": "client", "sender": "42", "payload": {"text": "hi"}}`))
+	require.Nil(t, errPayload)
+
+	env.stamp("7")
+	require.Empty(t, env.ID)
+	require.Equal(t, "7", env.Sender)
+	require.False(t, env.Timestamp.IsZero())
+
+	var frame Envelope
+	require.NoError(t, json.Unmarshal(errorFrame(ErrorPayload{Code: ErrCodeInvalid, Message: "bad"}), &frame))
+	require.Equal(t, TypeError, frame.Type)
+}
@@ -0,0 +1,155 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"time"
+)
+
+// writesSize is the number of storage writes queued before the goroutines making them wait for the writer.
+const writesSize = 1024
+
+type writeKind int
+
+const (
+	// A direct message the recipient didn't get, queued for the next connection of the recipient.
+	writePending writeKind = iota
+
+	// When the user closed the last connection.
+	writeLastSeen
+
+	// A chat message written to a connection of the user, see saveDeliveries.
+	writeDelivered
+
+	// Contents of an attachment of a deleted message.
+	writeBlobDelete
+
+	// Not a write, closes flushed once the writes queued before are made, see flushWrites.
+	writeFlush
+)
+
+// deliveryBatchSize is the most deliveries saved with one storage call.
+const deliveryBatchSize = 256
+
+// storeWrite is a change of the storage that the goroutine making it doesn't wait for, see persist.
+type storeWrite struct {
+	kind      writeKind
+	userID    int64
+	room      string
+	messageID int64
+	senderID  int64
+	at        time.Time
+	key       string // blob key
+	flushed   chan struct{}
+
+	// Client told about a failed write, with the ID of its envelope.
+	client *Client
+	ref    string
+}
+
+// persist makes the storage writes queued by the hub, the shards and the pumps in order, so neither
+// the routing nor the fan-out of the frames waits for the database. Once Wait asks it to stop,
+// it makes the writes queued until then and closes persisted.
+func (h *Hub) persist() {
+	for {
+		select {
+		case w := <-h.writes:
+			h.writeBatch(w)
+		case <-h.stopWrites:
+			for {
+				select {
+				case w := <-h.writes:
+					h.writeBatch(w)
+				default:
+					close(h.persisted)
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeBatch makes the write and the ones queued behind it. The deliveries among them are saved together
+// once the queue is empty or the batch is full, so a message to a busy room doesn't cost a statement per member.
+func (h *Hub) writeBatch(w storeWrite) {
+	var deliveries []storeWrite
+	for more := true; more; {
+		if w.kind == writeDelivered {
+			deliveries = append(deliveries, w)
+			if len(deliveries) == deliveryBatchSize {
+				break
+			}
+		} else {
+			if w.kind == writeFlush {
+				h.saveDeliveries(deliveries)
+				deliveries = nil
+			}
+			h.write(w)
+		}
+
+		select {
+		case w = <-h.writes:
+		default:
+			more = false
+		}
+	}
+
+	h.saveDeliveries(deliveries)
+}
+
+func (h *Hub) write(w storeWrite) {
+	const op = "websocket.handlers.persist.write"
+
+	log := h.log.With(slog.String("op", op), slog.Int64("userID", w.userID))
+
+	switch w.kind {
+	case writePending:
+		if err := h.store.SavePendingMessage(w.userID, w.messageID); err != nil {
+			log.Error("failed to queue direct message for the next connection", slog.Int64("messageID", w.messageID), sl.Err(err))
+			h.replyFailure(w, ErrorPayload{Code: ErrCodeInternal, Message: "failed to queue message for offline delivery", Ref: w.ref})
+		}
+	case writeLastSeen:
+		if err := h.store.UpdateLastSeen(w.userID, w.at); err != nil {
+			log.Error("failed to update last seen", sl.Err(err))
+		}
+	case writeBlobDelete:
+		if err := h.blobs.Delete(context.Background(), w.key); err != nil {
+			log.Error("failed to delete blob", slog.String("key", w.key), sl.Err(err))
+		}
+	case writeFlush:
+		close(w.flushed)
+	}
+}
+
+// replyFailure sends the error frame to the client of the failed write, if it has one.
+func (h *Hub) replyFailure(w storeWrite, payload ErrorPayload) {
+	if w.client == nil {
+		return
+	}
+
+	// the hub may be waiting for persist to take a write, so it gets the frame asynchronously
+	go enqueue(h, h.reply, clientMessage{client: w.client, data: errorFrame(payload)})
+}
+
+// flushWrites waits until persist made the writes queued before. It returns false if persist returned first.
+func (h *Hub) flushWrites() bool {
+	flushed := make(chan struct{})
+	h.queueWrite(storeWrite{kind: writeFlush, flushed: flushed})
+
+	select {
+	case <-flushed:
+		return true
+	case <-h.persisted:
+		return false
+	}
+}
+
+// queueWrite hands the write to persist, waiting while the queue is full. Writes queued after persist
+// returned are dropped.
+func (h *Hub) queueWrite(w storeWrite) {
+	select {
+	case h.writes <- w:
+	case <-h.persisted:
+	}
+}
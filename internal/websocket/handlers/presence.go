@@ -0,0 +1,202 @@
+package ws
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Presence statuses of the users. Clients set online, away or dnd, offline means the user has no connections.
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusDND     = "dnd"
+	StatusOffline = "offline"
+)
+
+// StatusPayload is the payload of a status envelope, sent by a client to change the status of its connection.
+type StatusPayload struct {
+	Status string `json:"status" validate:"required,oneof=online away dnd"` // New status of the connection
+}
+
+// PresencePayload is the payload of a presence envelope, sent by the server when the status of a user changes.
+// The sender of the envelope is the user whose status changed.
+type PresencePayload struct {
+	Status   string     `json:"status"`             // Status aggregated over every connection of the user
+	LastSeen *time.Time `json:"lastSeen,omitempty"` // Time the user went offline
+}
+
+// statusChange is a request of a client to change the status of its connection.
+type statusChange struct {
+	client *Client
+	status string
+}
+
+// presenceQuery asks the hub for the statuses of the users, the answer is sent to result.
+type presenceQuery struct {
+	userIDs []int64
+	result  chan map[int64]string
+}
+
+// Statuses returns the live status of every user, users without connections are offline.
+func (h *Hub) Statuses(userIDs []int64) map[int64]string {
+	query := presenceQuery{userIDs: userIDs, result: make(chan map[int64]string, 1)}
+	if !enqueue(h, h.presence, query) {
+		statuses := make(map[int64]string, len(userIDs))
+		for _, userID := range userIDs {
+			statuses[userID] = StatusOffline
+		}
+		return statuses
+	}
+
+	return <-query.result
+}
+
+func (h *Hub) answerPresence(query presenceQuery) {
+	statuses := make(map[int64]string, len(query.userIDs))
+	for _, userID := range query.userIDs {
+		statuses[userID] = h.userStatus(userID)
+	}
+
+	query.result <- statuses
+}
+
+// userStatus aggregates the statuses of the user's connections to every instance: do not disturb on any device wins,
+// then online on any device, the user is away only if every device is away.
+func (h *Hub) userStatus(userID int64) string {
+	status := h.localStatus(userID)
+	for _, node := range h.nodes {
+		if s, ok := node.statuses[userID]; ok {
+			status = mergeStatus(status, s)
+		}
+	}
+
+	return status
+}
+
+// localStatus is userStatus over the connections to this instance.
+func (h *Hub) localStatus(userID int64) string {
+	status := StatusOffline
+	for client := range h.users[userID] {
+		status = mergeStatus(status, client.status)
+	}
+
+	return status
+}
+
+// mergeStatus returns the status that wins when a user has both.
+func mergeStatus(a string, b string) string {
+	if statusRank[b] > statusRank[a] {
+		return b
+	}
+
+	return a
+}
+
+var statusRank = map[string]int{StatusOffline: 0, StatusAway: 1, StatusOnline: 2, StatusDND: 3}
+
+func (h *Hub) setStatus(client *Client, status string) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	before := h.userStatus(client.userID)
+	client.status = status
+
+	audience := h.presenceAudience(client.userID, nil)
+	var frame []byte
+	if after := h.userStatus(client.userID); after != before {
+		frame = h.notifyPresence(client.userID, after, nil, audience)
+	}
+	h.publishStatus(client.userID, frame, audience.rooms)
+}
+
+// audience is who is told about the presence of a user: the members of the rooms and the connections of the users,
+// except the skipped connection. The shards find the clients, see shard.presence.
+type audience struct {
+	rooms   []string
+	userIDs []int64
+	skip    *Client
+}
+
+// presenceAudience returns the audience of the user's presence: members of the rooms the user's connections joined,
+// connected users they exchanged direct messages with and the user's own connections, except skip.
+// The hubs of the other instances add the members of the rooms joined by the user's connections to them.
+func (h *Hub) presenceAudience(userID int64, skip *Client) audience {
+	userIDs := []int64{userID}
+	for contact := range h.contacts[userID] {
+		userIDs = append(userIDs, contact)
+	}
+
+	return audience{rooms: h.userRooms(userID), userIDs: userIDs, skip: skip}
+}
+
+// addContact remembers that two users exchanged direct messages, so they are told about each other's presence.
+// Contacts are forgotten when the user goes offline.
+func (h *Hub) addContact(userID int64, otherUserID int64) {
+	if userID == otherUserID {
+		return
+	}
+
+	for _, pair := range [][2]int64{{userID, otherUserID}, {otherUserID, userID}} {
+		contacts, ok := h.contacts[pair[0]]
+		if !ok {
+			contacts = make(map[int64]bool)
+			h.contacts[pair[0]] = contacts
+		}
+		contacts[pair[1]] = true
+	}
+}
+
+func (h *Hub) removeContacts(userID int64) {
+	for contact := range h.contacts[userID] {
+		delete(h.contacts[contact], userID)
+		if len(h.contacts[contact]) == 0 {
+			delete(h.contacts, contact)
+		}
+	}
+	delete(h.contacts, userID)
+}
+
+// wentOffline records when the user closed the last connection to any instance.
+// The time is saved by persist, the hub doesn't wait for it.
+func (h *Hub) wentOffline(userID int64) time.Time {
+	lastSeen := time.Now().UTC()
+	h.queueWrite(storeWrite{kind: writeLastSeen, userID: userID, at: lastSeen})
+
+	return lastSeen
+}
+
+// notifyPresence sends the status of the user to the audience and returns the frame, nil while the hub stops.
+func (h *Hub) notifyPresence(userID int64, status string, lastSeen *time.Time, audience audience) []byte {
+	if h.stopping {
+		return nil
+	}
+
+	frame := presenceFrame(userID, status, lastSeen)
+	h.deliverPresence(frame, audience)
+
+	return frame
+}
+
+// deliverPresence queues the frame for the audience connected to this instance. Like fanOut, every shard gets
+// one task and finds its clients in the rooms, so a user in busy rooms costs the hub no more than one in quiet rooms.
+func (h *Hub) deliverPresence(frame []byte, audience audience) {
+	for _, s := range h.shards {
+		s.tasks <- shardTask{kind: taskPresence, client: audience.skip, rooms: audience.rooms, userIDs: audience.userIDs, frame: outbound{data: frame}}
+	}
+}
+
+// presenceFrame encodes a presence envelope.
+func presenceFrame(userID int64, status string, lastSeen *time.Time) []byte {
+	data, _ := json.Marshal(PresencePayload{Status: status, LastSeen: lastSeen}) // marshaling of a struct with string and time fields can't fail
+
+	frame, _ := json.Marshal(Envelope{
+		Type:      TypePresence,
+		Sender:    strconv.FormatInt(userID, 10),
+		Timestamp: time.Now().UTC(),
+		Payload:   data,
+	})
+
+	return frame
+}
@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"log/slog"
+	"new-websocket-chat/internal/lib/ratelimit"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RateLimits limit the envelopes received from the clients, invalid ones included. Zero limits don't limit anything.
+type RateLimits struct {
+	// Envelopes of a single connection.
+	Connection ratelimit.Limit
+
+	// Envelopes of every connection of a user together.
+	User ratelimit.Limit
+
+	// Connections that exceed the limits MaxViolations times within ViolationWindow are closed
+	// with the policy violation code. Zero MaxViolations never closes them.
+	MaxViolations   int
+	ViolationWindow time.Duration
+}
+
+// clientClose is a request to close a single connection with a close frame.
+type clientClose struct {
+	client *Client
+	code   int
+	reason string
+}
+
+// allow takes a token from the buckets of the connection and of the user. An envelope over the limits is
+// answered with a rate limited error frame, the connection is closed when it keeps exceeding them.
+// It is called from the readPump goroutine.
+func (c *Client) allow(log *slog.Logger) bool {
+	now := time.Now()
+
+	allowed, wait := c.limiter.Allow(now)
+	if allowed {
+		allowed, wait = c.hub.userLimiter.Allow(c.userID, now)
+	}
+	if allowed {
+		return true
+	}
+
+	limits := c.hub.limits
+	if now.Sub(c.violationsSince) > limits.ViolationWindow {
+		c.violations = 0
+		c.violationsSince = now
+	}
+	c.violations++
+
+	if limits.MaxViolations > 0 && c.violations >= limits.MaxViolations {
+		log.Warn("closing connection that keeps exceeding the rate limit", slog.Int64("userID", c.userID), slog.Int("violations", c.violations))
+		c.closing = true
+		enqueue(c.hub, c.hub.kick, clientClose{client: c, code: websocket.ClosePolicyViolation, reason: "rate limit exceeded"})
+		return false
+	}
+
+	if c.violations == 1 {
+		log.Info("connection exceeded the rate limit", slog.Int64("userID", c.userID))
+	}
+	c.replyError(ErrorPayload{
+		Code:       ErrCodeRateLimited,
+		Message:    "too many envelopes, slow down",
+		RetryAfter: max(wait.Milliseconds(), 1),
+	})
+
+	return false
+}
@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"time"
+)
+
+// Actions of a reaction envelope.
+const (
+	ReactionAdd    = "add"
+	ReactionRemove = "remove"
+)
+
+// ReactionPayload is the payload of a reaction envelope. The server forwards the changes to the members
+// of the room with the sender of the envelope set to the user who reacted, clients apply them to the
+// reactions loaded with the history.
+type ReactionPayload struct {
+	MessageID string `json:"messageId" validate:"required,numeric"`       // ID of the message
+	Emoji     string `json:"emoji" validate:"required,max=32"`            // Emoji of the reaction
+	Action    string `json:"action" validate:"required,oneof=add remove"` // add or remove
+}
+
+// handleReaction adds or removes a reaction of the user on a message and tells the members of the room.
+// Adding a reaction twice or removing a missing one changes nothing and isn't forwarded.
+func (c *Client) handleReaction(log *slog.Logger, env *Envelope) {
+	ref := env.ID
+
+	var payload ReactionPayload
+	_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+
+	message, ok := c.loadMessage(log, env, payload.MessageID)
+	if !ok {
+		return
+	}
+
+	var changed bool
+	var err error
+	if payload.Action == ReactionAdd {
+		changed, err = c.hub.store.AddReaction(message.ID, c.userID, payload.Emoji)
+	} else {
+		changed, err = c.hub.store.RemoveReaction(message.ID, c.userID, payload.Emoji)
+	}
+	if errors.Is(err, storage.ErrUserNotFound) {
+		c.replyError(ErrorPayload{Code: ErrCodeNoUser, Message: "user doesn't exist", Ref: ref})
+		return
+	}
+	if err != nil {
+		log.Error("failed to update reaction", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to update reaction", Ref: ref})
+		return
+	}
+	if !changed {
+		return
+	}
+
+	data := updateFrame(TypeReaction, c.userID, message, time.Now().UTC(), payload)
+	enqueue(c.hub, c.hub.updates, messageUpdate{room: message.Room, data: data})
+}
@@ -0,0 +1,193 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+	"time"
+)
+
+// States of a receipt envelope.
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// ReadPayload is the payload of a read envelope, sent by a client when the user has seen the messages
+// of the room up to and including the message.
+type ReadPayload struct {
+	MessageID string `json:"messageId" validate:"required,numeric"` // ID of the newest message the user has seen
+}
+
+// ReceiptPayload is the payload of a receipt envelope. The sender of the envelope is the user
+// the messages were delivered to or who read them.
+type ReceiptPayload struct {
+	State     string `json:"state"`     // delivered or read
+	MessageID string `json:"messageId"` // ID of the delivered message, or of the newest read message of the room
+}
+
+// delivery identifies a chat message written to a client, so the sender can get a delivered receipt.
+type delivery struct {
+	room      string
+	messageID int64
+	senderID  int64
+}
+
+// receipt is an encoded receipt envelope that has to be delivered to every connection of the users.
+type receipt struct {
+	userIDs []int64
+	data    []byte
+}
+
+// reportDelivery hands the delivery to persist, which moves the delivered cursor of the user and tells
+// the sender about the first delivery to any device of the user, see saveDeliveries.
+// It is called from the writePump goroutine once the message is written.
+func (c *Client) reportDelivery(d *delivery) {
+	c.hub.queueWrite(storeWrite{kind: writeDelivered, userID: c.userID, room: d.room, messageID: d.messageID, senderID: d.senderID})
+}
+
+// saveDeliveries moves the delivered cursors of the batch with one storage call, to the newest delivered message
+// per user and room, and sends a delivered receipt for every message of the batch after the previous cursor.
+// It is called from the persist goroutine.
+func (h *Hub) saveDeliveries(deliveries []storeWrite) {
+	if len(deliveries) == 0 {
+		return
+	}
+
+	type roomCursor struct {
+		userID int64
+		room   string
+	}
+
+	index := make(map[roomCursor]int)
+	var cursors []storage.DeliveredCursor
+	for _, d := range deliveries {
+		key := roomCursor{userID: d.userID, room: d.room}
+		if i, ok := index[key]; ok {
+			cursors[i].MessageID = max(cursors[i].MessageID, d.messageID)
+			continue
+		}
+		index[key] = len(cursors)
+		cursors = append(cursors, storage.DeliveredCursor{UserID: d.userID, Room: d.room, MessageID: d.messageID})
+	}
+
+	advanced, err := h.store.UpdateDeliveredCursors(cursors)
+	if err != nil {
+		h.log.Error("failed to update delivered cursors", slog.Int("cursors", len(cursors)), sl.Err(err))
+		return
+	}
+
+	previous := make(map[roomCursor]int64, len(advanced))
+	for _, c := range advanced {
+		previous[roomCursor{userID: c.UserID, room: c.Room}] = c.Previous
+	}
+
+	// several devices of the user may have reported the same message
+	reported := make(map[roomCursor]map[int64]bool)
+	var receipts []receipt
+	for _, d := range deliveries {
+		key := roomCursor{userID: d.userID, room: d.room}
+		cursor, ok := previous[key]
+		if !ok || d.messageID <= cursor || reported[key][d.messageID] {
+			continue
+		}
+		if reported[key] == nil {
+			reported[key] = make(map[int64]bool)
+		}
+		reported[key][d.messageID] = true
+
+		data := receiptFrame(d.userID, d.room, ReceiptDelivered, d.messageID)
+		receipts = append(receipts, receipt{userIDs: []int64{d.senderID}, data: data})
+	}
+	if len(receipts) == 0 {
+		return
+	}
+
+	// the hub may be waiting for persist to take a write, so it gets the receipts asynchronously
+	go func() {
+		for _, r := range receipts {
+			if !enqueue(h, h.receipts, r) {
+				return
+			}
+		}
+	}()
+}
+
+// handleRead moves the read cursor of the user and tells the senders of the newly read messages
+// and the other devices of the user.
+func (c *Client) handleRead(log *slog.Logger, env *Envelope) {
+	ref := env.ID
+
+	if dm.IsDirect(env.Room) {
+		if !dm.IsMember(env.Room, c.userID) {
+			c.replyError(ErrorPayload{Code: ErrCodeNotMember, Message: "direct messages can only be read by their users", Ref: ref})
+			return
+		}
+	} else if !c.joined[env.Room] {
+		c.replyError(ErrorPayload{Code: ErrCodeNotMember, Message: "join the room first", Ref: ref})
+		return
+	}
+
+	var payload ReadPayload
+	_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+	messageID, err := strconv.ParseInt(payload.MessageID, 10, 64)
+	if err != nil {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "field MessageID is not valid", Ref: ref})
+		return
+	}
+
+	previous, err := c.hub.store.UpdateReadCursor(c.userID, env.Room, messageID)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "message is not found in the room", Ref: ref})
+		return
+	}
+	if err != nil {
+		log.Error("failed to update read cursor", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to mark messages as read", Ref: ref})
+		return
+	}
+	if messageID <= previous {
+		return
+	}
+
+	senders, err := c.hub.store.GetMessageSenders(env.Room, previous, messageID)
+	if err != nil {
+		log.Error("failed to get message senders", sl.Err(err))
+		return
+	}
+
+	userIDs := []int64{c.userID}
+	for _, sender := range senders {
+		if sender != c.userID {
+			userIDs = append(userIDs, sender)
+		}
+	}
+
+	data := receiptFrame(c.userID, env.Room, ReceiptRead, messageID)
+	enqueue(c.hub, c.hub.receipts, receipt{userIDs: userIDs, data: data})
+}
+
+func (h *Hub) deliverReceipt(r receipt) {
+	for _, userID := range r.userIDs {
+		h.deliverUser(userID, outbound{data: r.data})
+	}
+}
+
+// receiptFrame encodes a receipt envelope.
+func receiptFrame(userID int64, room string, state string, messageID int64) []byte {
+	data, _ := json.Marshal(ReceiptPayload{State: state, MessageID: strconv.FormatInt(messageID, 10)}) // marshaling of a struct with string fields can't fail
+
+	frame, _ := json.Marshal(Envelope{
+		Type:      TypeReceipt,
+		Room:      room,
+		Sender:    strconv.FormatInt(userID, 10),
+		Timestamp: time.Now().UTC(),
+		Payload:   data,
+	})
+
+	return frame
+}
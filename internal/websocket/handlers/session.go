@@ -0,0 +1,339 @@
+package ws
+
+import (
+	"encoding/json"
+	"log/slog"
+	"new-websocket-chat/internal/lib/dm"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+	"time"
+)
+
+const (
+	// Frames of a shard kept for replaying them to resuming clients, shared by the users of the shard.
+	logSize = 4096
+
+	// Frames replayed to a resuming client, one that missed more gets the messages from the storage.
+	replaySize = 64
+
+	// Messages loaded from the storage for a client resuming after the frames kept for it.
+	missedLimit = 100
+
+	// How long the session of a user and the rooms of its connections are kept after they disconnected.
+	resumeWindow = 2 * time.Minute
+)
+
+// session is the stream of frames of a user on this instance. Every frame of a room or addressed to the user
+// is numbered and kept once in the log of the user's shard, typing events excepted. The numbers are the sequence
+// of the shard, shared by its users, so the frames of a user skip the numbers of the others'. A client that resumes
+// the session after losing its connection gets the frames of the user it missed. The rooms of the connections
+// keep counting for resumeWindow after the connections are gone. Owned by the goroutine of the user's shard.
+type session struct {
+	userID int64
+
+	// Connected clients of the user.
+	clients map[*Client]bool
+
+	// Sequence number of the shard when the session started, frames logged before aren't the user's.
+	floor int64
+
+	// When the last client disconnected, zero while one is connected.
+	detachedAt time.Time
+}
+
+// membership is a session in a room.
+type membership struct {
+	// Sequence number of the shard when the session entered the room, the frames of the room logged after are the user's.
+	since int64
+
+	// When the last client of the session left the room, zero while one of its clients is in the room.
+	left time.Time
+}
+
+// logged is a frame kept in the log of the shard, with who got it.
+type logged struct {
+	frame outbound
+
+	// Room of a room frame, with the user whose connections skipped it.
+	room    string
+	exclude int64
+
+	// User of a frame addressed to the user.
+	userID int64
+}
+
+// record numbers the frame and keeps it in the log of the shard. Typing events are neither numbered nor kept.
+func (s *shard) record(entry logged, now time.Time) outbound {
+	if entry.frame.class == ClassTyping {
+		return entry.frame
+	}
+
+	s.seq = max(s.seq+1, now.UnixMicro())
+	entry.frame.seq = s.seq
+
+	if len(s.log) < logSize {
+		s.log = append(s.log, entry)
+		return entry.frame
+	}
+	s.floor = s.log[s.start].frame.seq
+	s.log[s.start] = entry
+	s.start = (s.start + 1) % logSize
+
+	return entry.frame
+}
+
+// since returns the logged frames of the session with sequence numbers greater than seq, oldest first.
+func (s *shard) since(sess *session, seq int64) []outbound {
+	var frames []outbound
+	for i := range s.log {
+		entry := s.log[(s.start+i)%len(s.log)]
+		if entry.frame.seq > seq && s.gotFrame(sess, entry) {
+			frames = append(frames, entry.frame)
+		}
+	}
+
+	return frames
+}
+
+// gotFrame reports whether the logged frame was the user's.
+func (s *shard) gotFrame(sess *session, entry logged) bool {
+	if entry.room == "" {
+		return entry.userID == sess.userID
+	}
+
+	member, ok := s.rooms[entry.room][sess]
+	return ok && member.since < entry.frame.seq && entry.exclude != sess.userID
+}
+
+// inRoom reports whether a connected client of the session joined the room.
+func (sess *session) inRoom(s *shard, room string) bool {
+	for client := range sess.clients {
+		if s.clients[client][room] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// attach adds the client to the session of its user. A resuming client rejoins the rooms of the session,
+// in the hub as well, and gets the frames it missed, see Client.resumeFrom, before any other frame.
+func (s *shard) attach(client *Client, now time.Time) {
+	sess, ok := s.sessions[client.userID]
+	if !ok {
+		sess = &session{
+			userID:  client.userID,
+			clients: make(map[*Client]bool),
+			floor:   s.seq,
+		}
+		s.sessions[client.userID] = sess
+	}
+	sess.clients[client] = true
+	sess.detachedAt = time.Time{}
+
+	s.clients[client] = make(map[string]bool)
+	if !client.resuming {
+		return
+	}
+
+	var rejoined []string
+	for room, members := range s.rooms {
+		if member, ok := members[sess]; ok {
+			s.join(client, room)
+			member.left = time.Time{}
+			members[sess] = member
+			rejoined = append(rejoined, room)
+		}
+	}
+	go enqueue(s.hub, s.hub.rejoin, rejoin{client: client, rooms: rejoined})
+
+	frames := s.since(sess, client.resumeFrom)
+	if client.resumeFrom >= max(s.floor, sess.floor) && len(frames) <= replaySize {
+		s.replayTo(client, append(frames, outbound{data: resumedFrame(s.seq, true)}))
+		return
+	}
+
+	// the log doesn't reach back to the last frame the client saw or it missed too many, the storage has the messages
+	client.catchingUp = true
+	client.held = frames[max(len(frames)-replaySize, 0):]
+	go s.hub.loadMissed(client, rejoined)
+}
+
+// hold keeps the frame of a client catching up until the missed messages are queued.
+// A client receiving more frames than its send buffer holds meanwhile is disconnected.
+func (s *shard) hold(client *Client, frame outbound) {
+	if len(client.held) >= cap(client.send) {
+		s.lose(client, frame)
+		s.disconnect(client, frame.class)
+		return
+	}
+
+	client.held = append(client.held, frame)
+}
+
+// catchUp queues the missed messages loaded from the storage and the frames held meanwhile,
+// then tells the client whether it got everything it missed.
+func (s *shard) catchUp(client *Client, missed []outbound, complete bool) {
+	if _, ok := s.clients[client]; !ok || !client.catchingUp {
+		return
+	}
+
+	// messages logged by the shard are queued with their sequence numbers
+	held := make(map[int64]bool, len(client.held))
+	for _, frame := range client.held {
+		if frame.delivery != nil {
+			held[frame.delivery.messageID] = true
+		}
+	}
+
+	frames := make([]outbound, 0, len(missed)+len(client.held)+1)
+	replayed := make(map[int64]bool, len(missed))
+	for _, frame := range missed {
+		if !held[frame.delivery.messageID] {
+			frames = append(frames, frame)
+			replayed[frame.delivery.messageID] = true
+		}
+	}
+	frames = append(frames, client.held...)
+	frames = append(frames, outbound{data: resumedFrame(s.seq, complete)})
+
+	client.catchingUp = false
+	client.held = nil
+	s.replayTo(client, frames)
+
+	// the messages loaded while the hub routed them to the client aren't queued twice
+	client.replayed = replayed
+}
+
+// replayTo queues the frames for the client until one of them makes the client's policy disconnect it.
+func (s *shard) replayTo(client *Client, frames []outbound) {
+	for _, frame := range frames {
+		if _, ok := s.clients[client]; !ok {
+			return
+		}
+		s.push(client, frame)
+	}
+}
+
+// expire forgets the rooms and the sessions kept longer than resumeWindow.
+func (s *shard) expire(now time.Time) {
+	for room, members := range s.rooms {
+		for sess, member := range members {
+			if !member.left.IsZero() && now.Sub(member.left) > resumeWindow {
+				delete(members, sess)
+			}
+		}
+		if len(members) == 0 {
+			delete(s.rooms, room)
+		}
+	}
+
+	for userID, sess := range s.sessions {
+		if len(sess.clients) == 0 && now.Sub(sess.detachedAt) > resumeWindow {
+			delete(s.sessions, userID)
+		}
+	}
+}
+
+// loadMissed loads the messages stored after the last one the resuming client got, see Client.resumeAfter,
+// in the rooms of its session and in the direct rooms of its user, and hands them to the hub. It runs in its own goroutine, so the shard keeps serving the other clients.
+func (h *Hub) loadMissed(client *Client, rooms []string) {
+	const op = "websocket.handlers.session.loadMissed"
+
+	log := h.log.With(slog.String("op", op), slog.Int64("userID", client.userID), slog.String("request_id", client.requestID))
+
+	messages, err := h.store.GetMissedMessages(client.userID, rooms, client.resumeAfter, missedLimit+1)
+	if err != nil {
+		log.Error("failed to load missed messages", sl.Err(err))
+	}
+
+	complete := err == nil && len(messages) <= missedLimit
+	if len(messages) > missedLimit {
+		messages = messages[:missedLimit]
+	}
+
+	frames := make([]outbound, 0, len(messages))
+	for _, message := range messages {
+		data, err := missedEnvelope(message)
+		if err != nil {
+			log.Error("failed to encode missed message", sl.Err(err))
+			complete = false
+			continue
+		}
+		frames = append(frames, outbound{
+			data:     data,
+			delivery: &delivery{room: message.Room, messageID: message.ID, senderID: message.SenderID},
+			class:    ClassMessage,
+		})
+	}
+
+	enqueue(h, h.missed, missedMessages{client: client, frames: frames, complete: complete})
+}
+
+// rejoin are the rooms of the session a resuming client is back in, see Hub.rejoinRooms.
+type rejoin struct {
+	client *Client
+	rooms  []string
+}
+
+// missedMessages are the messages loaded for a resuming client.
+type missedMessages struct {
+	client   *Client
+	frames   []outbound
+	complete bool
+}
+
+// missedEnvelope encodes a stored message of a room or a direct message.
+func missedEnvelope(message storage.Message) ([]byte, error) {
+	if first, second, ok := dm.Members(message.Room); ok {
+		recipient := first
+		if recipient == message.SenderID {
+			recipient = second
+		}
+		return directEnvelope(message, recipient)
+	}
+
+	payload, err := json.Marshal(MessagePayload{Text: message.Body, Attachments: attachmentIDs(message.Attachments), Files: message.Attachments, Reactions: message.Reactions})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(Envelope{
+		Type:      TypeMessage,
+		ID:        strconv.FormatInt(message.ID, 10),
+		Room:      message.Room,
+		Sender:    strconv.FormatInt(message.SenderID, 10),
+		Timestamp: message.CreatedAt.UTC(),
+		Payload:   payload,
+	})
+}
+
+// resumedFrame encodes the envelope that ends the replay of a resumed session.
+func resumedFrame(seq int64, complete bool) []byte {
+	data, _ := json.Marshal(ResumedPayload{Seq: seq, Complete: complete}) // marshaling of a struct with int and bool fields can't fail
+
+	frame, _ := json.Marshal(Envelope{
+		Type:      TypeResumed,
+		Timestamp: time.Now().UTC(),
+		Payload:   data,
+	})
+
+	return frame
+}
+
+// withSeq adds the sequence number to the encoded envelope.
+func withSeq(data []byte, seq int64) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+
+	numbered := make([]byte, 0, len(data)+24)
+	numbered = append(numbered, `{"seq":`...)
+	numbered = strconv.AppendInt(numbered, seq, 10)
+	if data[1] != '}' {
+		numbered = append(numbered, ',')
+	}
+
+	return append(numbered, data[1:]...)
+}
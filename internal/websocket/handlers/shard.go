@@ -0,0 +1,300 @@
+package ws
+
+import (
+	"time"
+)
+
+// shardQueueSize is the number of tasks a shard buffers before the hub waits for it.
+const shardQueueSize = 1024
+
+// shard writes the frames to the send buffers of the clients of a part of the users. The hub decides who gets a frame,
+// its shards fan the frames of a room out to their members and handle the clients that don't keep up,
+// so the hub spends the same time on a message to a busy room as on one to a quiet room.
+// Every connection of a user is served by the same shard, which keeps the session of the user, see session.go.
+type shard struct {
+	hub *Hub
+
+	// Tasks from the hub, in the order the hub posted them.
+	tasks chan shardTask
+
+	// Clients of the shard and the rooms they joined. Owned by the shard goroutine.
+	clients map[*Client]map[string]bool
+
+	// Sessions of the users of the shard, by user ID. Owned by the shard goroutine.
+	sessions map[int64]*session
+
+	// Clients in every room with at least one of them. Owned by the shard goroutine.
+	members map[string]map[*Client]bool
+
+	// Sessions in every room with at least one of them. Owned by the shard goroutine.
+	rooms map[string]map[*session]membership
+
+	// Sequence number of the last frame. Sequence numbers are the microseconds since the Unix epoch when the frame
+	// was logged, increased where needed, so they keep growing when the user connects to another instance.
+	// Every frame logged by the shard takes one, whoever gets it. Owned by the shard goroutine.
+	seq int64
+
+	// The last logSize frames for the sessions, a ring starting at start once full. Every frame with a greater
+	// sequence number than floor is in the log. Owned by the shard goroutine.
+	log   []logged
+	start int
+	floor int64
+}
+
+type shardTaskKind int
+
+const (
+	taskAdd shardTaskKind = iota
+	taskRemove
+	taskJoin
+	taskLeave
+	taskDeliver
+	taskUser
+	taskRoom
+	taskPresence
+	taskMissed
+	taskExpire
+)
+
+// shardTask is a change of the clients of the shard or a frame the shard has to queue.
+type shardTask struct {
+	kind   shardTaskKind
+	client *Client
+	userID int64
+	room   string
+	frame  outbound
+
+	// Room frames skip the connections of this user, none if 0.
+	exclude int64
+
+	// Rooms and users whose connections get a presence frame, except the client of the task.
+	rooms   []string
+	userIDs []int64
+
+	// Close frame of a removed client.
+	closeCode   int
+	closeReason string
+
+	// Messages a resuming client missed before the replay buffer of its session, see loadMissed.
+	missed   []outbound
+	complete bool
+
+	// Time of an expiry task.
+	now time.Time
+}
+
+func newShard(h *Hub) *shard {
+	now := time.Now().UnixMicro()
+
+	return &shard{
+		hub:      h,
+		tasks:    make(chan shardTask, shardQueueSize),
+		clients:  make(map[*Client]map[string]bool),
+		sessions: make(map[int64]*session),
+		members:  make(map[string]map[*Client]bool),
+		rooms:    make(map[string]map[*session]membership),
+		seq:      now,
+		floor:    now,
+	}
+}
+
+// run handles the tasks until the hub closes the queue.
+func (s *shard) run() {
+	for task := range s.tasks {
+		s.handle(task)
+	}
+}
+
+func (s *shard) handle(task shardTask) {
+	client := task.client
+
+	switch task.kind {
+	case taskAdd:
+		s.attach(client, time.Now())
+	case taskRemove:
+		if _, ok := s.clients[client]; ok {
+			client.closeCode = task.closeCode
+			client.closeReason = task.closeReason
+			s.detach(client, time.Now())
+		}
+	case taskJoin:
+		if _, ok := s.clients[client]; ok {
+			s.join(client, task.room)
+			s.enter(s.sessions[client.userID], task.room)
+		}
+	case taskLeave:
+		if rooms, ok := s.clients[client]; ok && rooms[task.room] {
+			s.leave(client, task.room)
+			// leaving on purpose doesn't keep the room for resuming
+			if sess := s.sessions[client.userID]; !sess.inRoom(s, task.room) {
+				s.exit(sess, task.room)
+			}
+		}
+	case taskDeliver:
+		if _, ok := s.clients[client]; ok {
+			s.push(client, task.frame)
+		}
+	case taskUser:
+		sess, ok := s.sessions[task.userID]
+		if !ok {
+			return
+		}
+		frame := s.record(logged{frame: task.frame, userID: task.userID}, time.Now())
+		for member := range sess.clients {
+			s.push(member, frame)
+		}
+	case taskRoom:
+		if _, ok := s.rooms[task.room]; !ok {
+			return
+		}
+		// logged once for the sessions in the room, including the ones waiting for a client to resume them
+		frame := s.record(logged{frame: task.frame, room: task.room, exclude: task.exclude}, time.Now())
+		for member := range s.members[task.room] {
+			if member.userID != task.exclude {
+				s.push(member, frame)
+			}
+		}
+	case taskPresence:
+		s.presence(task)
+	case taskMissed:
+		s.catchUp(client, task.missed, task.complete)
+	case taskExpire:
+		s.expire(task.now)
+	}
+}
+
+// presence queues the presence frame once for every client of the shard in the rooms of the task or connected
+// as one of its users, except the client of the task. Presence frames aren't replayed to resuming clients.
+func (s *shard) presence(task shardTask) {
+	audience := make(map[*Client]bool)
+	for _, room := range task.rooms {
+		for member := range s.members[room] {
+			audience[member] = true
+		}
+	}
+	for _, userID := range task.userIDs {
+		if sess, ok := s.sessions[userID]; ok {
+			for member := range sess.clients {
+				audience[member] = true
+			}
+		}
+	}
+	delete(audience, task.client)
+
+	for member := range audience {
+		s.push(member, task.frame)
+	}
+}
+
+// push queues the frame for the client. When the send buffer is full the policy of the frame's class
+// decides what is dropped, see SlowConsumerPolicies.
+func (s *shard) push(client *Client, frame outbound) {
+	if client.catchingUp {
+		s.hold(client, frame)
+		return
+	}
+	if frame.delivery != nil && client.replayed[frame.delivery.messageID] {
+		return
+	}
+	// the devices of the sender don't report deliveries to the sender
+	if frame.delivery != nil && frame.delivery.senderID == client.userID {
+		frame.delivery = nil
+	}
+
+	full := cap(client.send)
+	if frame.class == ClassTyping {
+		full /= 2
+	}
+
+	if len(client.send) < full {
+		select {
+		case client.send <- frame:
+			if client.shedding {
+				s.endShedding(client, "recovered")
+			}
+			return
+		default:
+		}
+	}
+
+	s.overflow(client, frame)
+}
+
+// detach forgets the client and closes its send channel, which makes its writePump send the close frame.
+// The rooms of the client are kept for resuming the session of the user, see session.go.
+func (s *shard) detach(client *Client, now time.Time) {
+	sess := s.sessions[client.userID]
+	delete(sess.clients, client)
+	rooms := s.clients[client]
+
+	for room := range rooms {
+		s.leave(client, room)
+		if member, ok := s.rooms[room][sess]; ok && !sess.inRoom(s, room) {
+			member.left = now
+			s.rooms[room][sess] = member
+		}
+	}
+	delete(s.clients, client)
+	if client.shedding {
+		s.endShedding(client, "disconnected")
+	}
+	if len(sess.clients) == 0 {
+		sess.detachedAt = now
+	}
+
+	// the frames queued in the send buffer are written before the close frame, the held ones are not
+	for _, frame := range client.held {
+		s.lose(client, frame)
+	}
+	client.held = nil
+
+	close(client.send)
+}
+
+// join adds the client to the room.
+func (s *shard) join(client *Client, room string) {
+	members, ok := s.members[room]
+	if !ok {
+		members = make(map[*Client]bool)
+		s.members[room] = members
+	}
+	members[client] = true
+	s.clients[client][room] = true
+}
+
+// leave removes the client from the room.
+func (s *shard) leave(client *Client, room string) {
+	delete(s.clients[client], room)
+	delete(s.members[room], client)
+	if len(s.members[room]) == 0 {
+		delete(s.members, room)
+	}
+}
+
+// enter adds the session to the room.
+func (s *shard) enter(sess *session, room string) {
+	members, ok := s.rooms[room]
+	if !ok {
+		members = make(map[*session]membership)
+		s.rooms[room] = members
+	}
+	member, ok := members[sess]
+	if !ok {
+		member.since = s.seq
+	}
+	member.left = time.Time{}
+	members[sess] = member
+}
+
+// exit removes the session from the room.
+func (s *shard) exit(sess *session, room string) {
+	members, ok := s.rooms[room]
+	if !ok {
+		return
+	}
+
+	delete(members, sess)
+	if len(members) == 0 {
+		delete(s.rooms, room)
+	}
+}
@@ -0,0 +1,217 @@
+package ws
+
+import (
+	"fmt"
+	"log/slog"
+	"new-websocket-chat/internal/lib/dm"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MessageClass groups the frames that are handled alike when a client doesn't keep up with them.
+type MessageClass int
+
+const (
+	// ClassEvent frames are presence changes, receipts, edits, reactions, mentions, thread summaries and errors.
+	ClassEvent MessageClass = iota
+
+	// ClassMessage frames are chat messages, direct messages and thread replies.
+	ClassMessage
+
+	// ClassTyping frames are typing events. They never take more than half of the send buffer,
+	// so the buffer counts as full for them once it is half full.
+	ClassTyping
+
+	classCount
+)
+
+func (c MessageClass) String() string {
+	switch c {
+	case ClassMessage:
+		return "message"
+	case ClassTyping:
+		return "typing"
+	default:
+		return "event"
+	}
+}
+
+// SlowPolicy is what the shard of a client does with a frame that doesn't fit the client's send buffer.
+type SlowPolicy string
+
+const (
+	// PolicyDropOldest drops the oldest queued frame, whatever its class, to make room for the new one.
+	PolicyDropOldest SlowPolicy = "drop_oldest"
+
+	// PolicyDropNewest drops the new frame.
+	PolicyDropNewest SlowPolicy = "drop_newest"
+
+	// PolicyDisconnect closes the connection with the 1013 try again later close code
+	// once the client wrote the frames queued before.
+	PolicyDisconnect SlowPolicy = "disconnect"
+)
+
+// SlowConsumerPolicies are the policies of the message classes. An empty policy uses the default of the class:
+// chat messages and events disconnect the client, typing events are dropped.
+type SlowConsumerPolicies struct {
+	Message SlowPolicy
+	Event   SlowPolicy
+	Typing  SlowPolicy
+}
+
+// Validate reports a policy that doesn't exist.
+func (p SlowConsumerPolicies) Validate() error {
+	const op = "websocket.handlers.slow.Validate"
+
+	for _, policy := range []SlowPolicy{p.Message, p.Event, p.Typing} {
+		switch policy {
+		case "", PolicyDropOldest, PolicyDropNewest, PolicyDisconnect:
+		default:
+			return fmt.Errorf("%s: unknown slow consumer policy %q", op, policy)
+		}
+	}
+
+	return nil
+}
+
+// of returns the policy of the class.
+func (p SlowConsumerPolicies) of(class MessageClass) SlowPolicy {
+	switch class {
+	case ClassMessage:
+		if p.Message != "" {
+			return p.Message
+		}
+	case ClassTyping:
+		if p.Typing != "" {
+			return p.Typing
+		}
+		return PolicyDropNewest
+	default:
+		if p.Event != "" {
+			return p.Event
+		}
+	}
+
+	return PolicyDisconnect
+}
+
+// Metrics counts the frames shed and the clients disconnected because they didn't keep up, by message class.
+type Metrics struct {
+	// Frames dropped by the drop_oldest and drop_newest policies, by the class of the dropped frame.
+	Shed map[string]int64 `json:"shed"`
+
+	// Clients disconnected by the disconnect policy, by the class of the frame that didn't fit.
+	Disconnected map[string]int64 `json:"disconnected"`
+}
+
+// slowCounters are the counters behind Metrics, written by the shards.
+type slowCounters struct {
+	shed         [classCount]atomic.Int64
+	disconnected [classCount]atomic.Int64
+}
+
+// Metrics returns the counters of the slow clients since the hub was created. It is safe to call from any goroutine.
+func (h *Hub) Metrics() Metrics {
+	m := Metrics{
+		Shed:         make(map[string]int64, classCount),
+		Disconnected: make(map[string]int64, classCount),
+	}
+	for class := MessageClass(0); class < classCount; class++ {
+		m.Shed[class.String()] = h.slow.shed[class].Load()
+		m.Disconnected[class.String()] = h.slow.disconnected[class].Load()
+	}
+
+	return m
+}
+
+// overflow applies the policy of the frame's class to a client whose send buffer is full.
+func (s *shard) overflow(client *Client, frame outbound) {
+	policy := s.hub.policies.of(frame.class)
+
+	switch policy {
+	case PolicyDropNewest:
+		s.lose(client, frame)
+		s.shed(client, frame.class, policy)
+	case PolicyDropOldest:
+		select {
+		case oldest := <-client.send:
+			s.lose(client, oldest)
+			s.shed(client, oldest.class, policy)
+		default:
+			// the writePump emptied the buffer meanwhile
+		}
+		// the shard is the only writer of the buffer, so there is room now
+		client.send <- frame
+	default:
+		s.lose(client, frame)
+		s.disconnect(client, frame.class)
+	}
+}
+
+// disconnect closes the connection of a client that doesn't keep up with the frames of the class.
+func (s *shard) disconnect(client *Client, class MessageClass) {
+	s.hub.slow.disconnected[class].Add(1)
+	s.hub.log.Warn("disconnecting slow client",
+		slog.Int64("userID", client.userID),
+		slog.String("request_id", client.requestID),
+		slog.String("class", class.String()),
+		slog.Int("queued", len(client.send)),
+	)
+
+	client.closeCode = websocket.CloseTryAgainLater
+	client.closeReason = "try again later"
+	s.detach(client, time.Now())
+	// the hub may be waiting for this shard, so it learns about the drop asynchronously
+	go enqueue(s.hub, s.hub.unregister, client)
+}
+
+// lose queues a direct message the client won't get for the next connection of its user,
+// like a direct message to an offline user.
+func (s *shard) lose(client *Client, frame outbound) {
+	if frame.delivery == nil || frame.delivery.senderID == client.userID || !dm.IsDirect(frame.delivery.room) {
+		return
+	}
+
+	s.hub.queueWrite(storeWrite{kind: writePending, userID: client.userID, messageID: frame.delivery.messageID})
+}
+
+// shed counts a dropped frame. The first frame dropped since the client last kept up is logged,
+// the frames dropped until then are counted for endShedding.
+func (s *shard) shed(client *Client, class MessageClass, policy SlowPolicy) {
+	s.hub.slow.shed[class].Add(1)
+	client.shed[class]++
+	if client.shedding {
+		return
+	}
+
+	client.shedding = true
+	s.hub.log.Warn("shedding frames of slow client",
+		slog.Int64("userID", client.userID),
+		slog.String("request_id", client.requestID),
+		slog.String("class", class.String()),
+		slog.String("policy", string(policy)),
+	)
+}
+
+// endShedding logs the number of frames of every class dropped since the client last kept up, once it keeps up again
+// or is disconnected, as the outcome says.
+func (s *shard) endShedding(client *Client, outcome string) {
+	for class, count := range client.shed {
+		if count == 0 {
+			continue
+		}
+
+		s.hub.log.Warn("shed frames of slow client",
+			slog.Int64("userID", client.userID),
+			slog.String("request_id", client.requestID),
+			slog.String("class", MessageClass(class).String()),
+			slog.Int("count", count),
+			slog.String("outcome", outcome),
+		)
+	}
+
+	client.shedding = false
+	client.shed = [classCount]int{}
+}
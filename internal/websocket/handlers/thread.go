@@ -0,0 +1,231 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"new-websocket-chat/internal/lib/logger/sl"
+	"new-websocket-chat/internal/storage"
+	"strconv"
+	"time"
+)
+
+// ThreadPayload is the payload of the subscribe and unsubscribe envelopes. Subscribed clients get
+// the replies of the thread, the other members of the room only get thread envelopes with the reply count.
+type ThreadPayload struct {
+	MessageID string `json:"messageId" validate:"required,numeric"` // ID of the first message of the thread
+}
+
+// ThreadSummaryPayload is the payload of a thread envelope, sent to the members of the room when
+// a reply is added to a thread so clients can update the parent message in place.
+type ThreadSummaryPayload struct {
+	MessageID   string    `json:"messageId"`   // ID of the first message of the thread
+	ReplyCount  int       `json:"replyCount"`  // Number of replies in the thread
+	LastReplyAt time.Time `json:"lastReplyAt"` // Time of the newest reply
+}
+
+// threadSubscription is a request of a client to subscribe to or unsubscribe from a thread.
+type threadSubscription struct {
+	client   *Client
+	room     string
+	parentID int64
+}
+
+// threadReply is an encoded reply that has to be delivered to the subscribers of the thread,
+// with the thread envelope for the members of the room.
+type threadReply struct {
+	sender    *Client
+	room      string
+	parentID  int64
+	messageID int64
+	data      []byte
+	summary   []byte
+}
+
+// handleReply persists a reply to a thread and relays it to the subscribers of the thread.
+func (c *Client) handleReply(log *slog.Logger, env *Envelope, payload MessagePayload) {
+	ref := env.ID
+
+	parent, ok := c.loadMessage(log, env, payload.ParentID)
+	if !ok {
+		return
+	}
+	if parent.ParentID != 0 {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "reply to the first message of the thread", Ref: ref})
+		return
+	}
+
+	files, ok := c.checkAttachments(log, ref, parent.Room, payload.Attachments)
+	if !ok {
+		return
+	}
+
+	env.stamp(strconv.FormatInt(c.userID, 10))
+
+	id, parent, err := c.hub.store.SaveReply(parent.ID, c.userID, payload.Text, env.Timestamp)
+	if errors.Is(err, storage.ErrMessageDeleted) {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "message is deleted", Ref: ref})
+		return
+	}
+	if errors.Is(err, storage.ErrNotThreadRoot) {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "reply to the first message of the thread", Ref: ref})
+		return
+	}
+	if err != nil {
+		log.Error("failed to save reply", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to save message", Ref: ref})
+		return
+	}
+	env.ID = strconv.FormatInt(id, 10)
+	if !c.attach(log, ref, id, files) {
+		return
+	}
+	env.Payload, _ = json.Marshal(MessagePayload{Text: payload.Text, ParentID: strconv.FormatInt(parent.ID, 10), Attachments: payload.Attachments, Files: files}) // the server sets the files
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Error("failed to encode envelope", sl.Err(err))
+		c.replyError(ErrorPayload{Code: ErrCodeInternal, Message: "failed to process message", Ref: ref})
+		return
+	}
+
+	sent := enqueue(c.hub, c.hub.replies, threadReply{
+		sender:    c,
+		room:      parent.Room,
+		parentID:  parent.ID,
+		messageID: id,
+		data:      data,
+		summary:   threadFrame(c.userID, parent),
+	})
+	if !sent {
+		return
+	}
+	c.notifyMentions(log, storage.Message{ID: id, Room: parent.Room, SenderID: c.userID, Body: payload.Text, CreatedAt: env.Timestamp, ParentID: parent.ID})
+}
+
+// handleSubscribe subscribes the client to the replies of a thread of a room it joined.
+func (c *Client) handleSubscribe(log *slog.Logger, env *Envelope) {
+	var payload ThreadPayload
+	_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+
+	parent, ok := c.loadMessage(log, env, payload.MessageID)
+	if !ok {
+		return
+	}
+	if parent.ParentID != 0 {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "subscribe to the first message of the thread", Ref: env.ID})
+		return
+	}
+
+	enqueue(c.hub, c.hub.subscribe, threadSubscription{client: c, room: parent.Room, parentID: parent.ID})
+}
+
+// handleUnsubscribe stops the replies of a thread, unsubscribing from a thread the client isn't subscribed to is a no-op.
+func (c *Client) handleUnsubscribe(env *Envelope) {
+	var payload ThreadPayload
+	_ = json.Unmarshal(env.Payload, &payload) // validated by parseEnvelope
+
+	parentID, err := strconv.ParseInt(payload.MessageID, 10, 64)
+	if err != nil {
+		c.replyError(ErrorPayload{Code: ErrCodeInvalid, Message: "field MessageID is not valid", Ref: env.ID})
+		return
+	}
+
+	enqueue(c.hub, c.hub.unsubscribe, threadSubscription{client: c, room: env.Room, parentID: parentID})
+}
+
+func (h *Hub) subscribeThread(s threadSubscription) {
+	if _, ok := h.clients[s.client]; !ok {
+		return
+	}
+	if !h.rooms[s.room][s.client] {
+		h.deliver(s.client, errorFrame(ErrorPayload{
+			Code:    ErrCodeNotMember,
+			Message: "join the room before subscribing to its threads",
+		}))
+		return
+	}
+
+	subscribers, ok := h.threads[s.parentID]
+	if !ok {
+		subscribers = make(map[*Client]bool)
+		h.threads[s.parentID] = subscribers
+	}
+	subscribers[s.client] = true
+	s.client.threads[s.parentID] = s.room
+}
+
+func (h *Hub) unsubscribeThread(client *Client, parentID int64) {
+	subscribers, ok := h.threads[parentID]
+	if !ok {
+		return
+	}
+
+	delete(subscribers, client)
+	delete(client.threads, parentID)
+	if len(subscribers) == 0 {
+		delete(h.threads, parentID)
+	}
+}
+
+// deliverReply sends the reply to the subscribers of the thread and to the sender, and the thread envelope
+// to every member of the room.
+func (h *Hub) deliverReply(reply threadReply) {
+	members, ok := h.rooms[reply.room]
+	if !ok || !members[reply.sender] {
+		// Only members of a room can reply to its threads.
+		if _, ok := h.clients[reply.sender]; ok {
+			h.deliver(reply.sender, errorFrame(ErrorPayload{
+				Code:    ErrCodeNotMember,
+				Message: "join the room before sending messages to it",
+			}))
+		}
+		return
+	}
+	h.clearTyping(reply.sender.userID, reply.room)
+
+	// the sender learns the ID of the reply even without a subscription
+	if _, ok := h.clients[reply.sender]; ok && !h.threads[reply.parentID][reply.sender] {
+		h.push(reply.sender, outbound{data: reply.data, class: ClassMessage})
+	}
+	h.deliverThreadReply(reply.room, reply.parentID, reply.sender.userID, reply.messageID, reply.data, reply.summary)
+	h.publish(clusterEvent{
+		Kind:      eventReply,
+		Room:      reply.room,
+		ParentID:  reply.parentID,
+		UserID:    reply.sender.userID,
+		MessageID: reply.messageID,
+		Data:      reply.data,
+		Summary:   reply.summary,
+	})
+}
+
+// deliverThreadReply sends the reply to the subscribers of the thread and the summary of the thread
+// to the members of the room connected to this instance.
+func (h *Hub) deliverThreadReply(room string, parentID int64, senderID int64, messageID int64, data []byte, summary []byte) {
+	d := &delivery{room: room, messageID: messageID, senderID: senderID}
+	for client := range h.threads[parentID] {
+		h.push(client, outbound{data: data, delivery: d, class: ClassMessage})
+	}
+
+	h.fanOut(room, outbound{data: summary}, 0)
+}
+
+// threadFrame encodes a thread envelope for the parent message, sent by the user who replied.
+func threadFrame(userID int64, parent storage.Message) []byte {
+	payload := ThreadSummaryPayload{MessageID: strconv.FormatInt(parent.ID, 10), ReplyCount: parent.ReplyCount}
+	if parent.LastReplyAt != nil {
+		payload.LastReplyAt = parent.LastReplyAt.UTC()
+	}
+	data, _ := json.Marshal(payload) // marshaling of a struct with string, int and time fields can't fail
+
+	frame, _ := json.Marshal(Envelope{
+		Type:      TypeThread,
+		Room:      parent.Room,
+		Sender:    strconv.FormatInt(userID, 10),
+		Timestamp: time.Now().UTC(),
+		Payload:   data,
+	})
+
+	return frame
+}
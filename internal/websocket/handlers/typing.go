@@ -0,0 +1,147 @@
+package ws
+
+import (
+	"encoding/json"
+	"new-websocket-chat/internal/lib/dm"
+	"strconv"
+	"time"
+)
+
+// Typing states of a typing envelope.
+const (
+	TypingStart = "start"
+	TypingStop  = "stop"
+)
+
+// typingTimeout is how long a user is shown as typing without the client refreshing the start event.
+const typingTimeout = 5 * time.Second
+
+// TypingPayload is the payload of a typing envelope. Clients repeat start while the user keeps typing,
+// the server forwards only the changes and sends stop itself when the starts stop coming.
+type TypingPayload struct {
+	State string `json:"state" validate:"required,oneof=start stop"` // start or stop
+}
+
+// typingEvent is a typing envelope of a client, addressed to a room or, for direct messages, to a user.
+type typingEvent struct {
+	client    *Client
+	room      string
+	recipient int64
+	state     string
+}
+
+// typingKey identifies a user typing in a room, direct messages use the dm room of the two users.
+type typingKey struct {
+	userID int64
+	room   string
+}
+
+func (h *Hub) handleTyping(event typingEvent) {
+	if _, ok := h.clients[event.client]; !ok {
+		return
+	}
+
+	key := typingKey{userID: event.client.userID, room: event.room}
+	recipient := event.recipient
+	if recipient != 0 {
+		key.room = dm.Room(event.client.userID, recipient)
+	} else if !h.rooms[event.room][event.client] {
+		h.deliver(event.client, errorFrame(ErrorPayload{
+			Code:    ErrCodeNotMember,
+			Message: "join the room before sending typing events to it",
+		}))
+		return
+	}
+
+	_, typing := h.typing[key]
+	if event.state == TypingStop {
+		if typing {
+			delete(h.typing, key)
+			h.notifyTyping(key, TypingStop)
+		}
+		return
+	}
+
+	// refreshes only move the expiration, the audience already knows the user is typing
+	h.typing[key] = time.Now().Add(h.typingTimeout)
+	if !typing {
+		h.notifyTyping(key, TypingStart)
+	}
+}
+
+// expireTyping sends stop for the users whose typing wasn't refreshed in time.
+func (h *Hub) expireTyping(now time.Time) {
+	for key, expires := range h.typing {
+		if now.After(expires) {
+			delete(h.typing, key)
+			h.notifyTyping(key, TypingStop)
+		}
+	}
+}
+
+// clearTyping forgets that the user is typing without notifying anyone, the message the user sent ends the typing.
+func (h *Hub) clearTyping(userID int64, room string) {
+	delete(h.typing, typingKey{userID: userID, room: room})
+}
+
+// notifyTyping sends the typing state to the other members of the room, or to the other user of a direct room,
+// on every instance.
+func (h *Hub) notifyTyping(key typingKey, state string) {
+	var to string
+	if recipient, ok := key.recipient(); ok {
+		to = strconv.FormatInt(recipient, 10)
+	}
+
+	frame := typingFrame(key, to, state)
+	h.deliverTyping(key, frame)
+	h.publish(clusterEvent{Kind: eventTyping, Room: key.room, UserID: key.userID, Data: frame})
+}
+
+// deliverTyping sends the typing frame to its audience connected to this instance.
+func (h *Hub) deliverTyping(key typingKey, frame []byte) {
+	recipient, ok := key.recipient()
+	if !ok {
+		h.fanOut(key.room, outbound{data: frame, class: ClassTyping}, key.userID)
+		return
+	}
+
+	if recipient != key.userID {
+		for client := range h.users[recipient] {
+			h.deliverEphemeral(client, frame)
+		}
+	}
+}
+
+// recipient returns the other user of a direct room.
+func (key typingKey) recipient() (int64, bool) {
+	first, second, ok := dm.Members(key.room)
+	if !ok {
+		return 0, false
+	}
+	if first == key.userID {
+		return second, true
+	}
+
+	return first, true
+}
+
+// deliverEphemeral queues a typing frame, see ClassTyping.
+func (h *Hub) deliverEphemeral(client *Client, data []byte) {
+	h.push(client, outbound{data: data, class: ClassTyping})
+}
+
+// typingFrame encodes a typing envelope for the audience of the key.
+func typingFrame(key typingKey, to string, state string) []byte {
+	data, _ := json.Marshal(TypingPayload{State: state}) // marshaling of a struct with string fields can't fail
+
+	frame, _ := json.Marshal(Envelope{
+		Type:      TypeTyping,
+		Room:      key.room,
+		To:        to,
+		Sender:    strconv.FormatInt(key.userID, 10),
+		Timestamp: time.Now().UTC(),
+		Payload:   data,
+	})
+
+	return frame
+}